@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+
+// Package sshsig verifies signatures made by "ssh-keygen -Y sign", as
+// described in OpenSSH's PROTOCOL.sshsig.
+package sshsig
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+const (
+	magic   = "SSHSIG"
+	version = 1
+	pemType = "SSH SIGNATURE"
+)
+
+var (
+	ErrMalformed  = errors.New("sshsig: malformed signature")
+	ErrNamespace  = errors.New("sshsig: wrong namespace")
+	ErrWrongKey   = errors.New("sshsig: signed by a different key")
+	ErrHashAlgo   = errors.New("sshsig: unsupported hash algorithm")
+	ErrBadVersion = errors.New("sshsig: unsupported version")
+)
+
+// blob is the wire format of a signature, after the magic preamble.
+type blob struct {
+	Version   uint32
+	PublicKey []byte
+	Namespace string
+	Reserved  []byte
+	HashAlgo  string
+	Signature []byte
+}
+
+// signedData is what the key actually signs, after the magic preamble.
+type signedData struct {
+	Namespace string
+	Reserved  []byte
+	HashAlgo  string
+	Hash      []byte
+}
+
+// Verify checks that armored, the output of "ssh-keygen -Y sign -n
+// namespace", is a signature of message by key.
+func Verify(key gossh.PublicKey, message, armored []byte, namespace string) error {
+	block, _ := pem.Decode(bytes.TrimSpace(armored))
+	if block == nil || block.Type != pemType {
+		return ErrMalformed
+	}
+	raw, ok := bytes.CutPrefix(block.Bytes, []byte(magic))
+	if !ok {
+		return ErrMalformed
+	}
+
+	var b blob
+	if err := gossh.Unmarshal(raw, &b); err != nil {
+		return fmt.Errorf("%w: %w", ErrMalformed, err)
+	}
+	if b.Version != version {
+		return ErrBadVersion
+	}
+	if b.Namespace != namespace {
+		return ErrNamespace
+	}
+	if !bytes.Equal(b.PublicKey, key.Marshal()) {
+		return ErrWrongKey
+	}
+
+	var hash []byte
+	switch b.HashAlgo {
+	case "sha256":
+		h := sha256.Sum256(message)
+		hash = h[:]
+	case "sha512":
+		h := sha512.Sum512(message)
+		hash = h[:]
+	default:
+		return ErrHashAlgo
+	}
+
+	var sig gossh.Signature
+	if err := gossh.Unmarshal(b.Signature, &sig); err != nil {
+		return fmt.Errorf("%w: %w", ErrMalformed, err)
+	}
+
+	data := append([]byte(magic), gossh.Marshal(signedData{
+		Namespace: namespace,
+		Reserved:  b.Reserved,
+		HashAlgo:  b.HashAlgo,
+		Hash:      hash,
+	})...)
+	return key.Verify(data, &sig)
+}
@@ -0,0 +1,65 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 secret of the RFC 6238 test vectors.
+var rfcSecret = []byte("12345678901234567890")
+
+func TestCode(t *testing.T) {
+	t.Parallel()
+	// The last six digits of the RFC 6238 test vectors.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		if got := code(rfcSecret, tt.unix/period); got != tt.want {
+			t.Errorf("code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+	now := time.Unix(1111111109, 0) // step 37037036, with code 081804
+	const step = 37037036
+	tests := []struct {
+		name     string
+		input    string
+		lastStep int64
+		wantStep int64
+		ok       bool
+	}{
+		{"current", "081804", 0, step, true},
+		{"spaces", " 081 804 ", 0, step, true},
+		{"previous step", code(rfcSecret, step-1), 0, step - 1, true},
+		{"next step", code(rfcSecret, step+1), 0, step + 1, true},
+		{"too old", code(rfcSecret, step-2), 0, 0, false},
+		{"too new", code(rfcSecret, step+2), 0, 0, false},
+		{"replayed", "081804", step, 0, false},
+		{"later than last", code(rfcSecret, step+1), step, step + 1, true},
+		{"wrong", "000000", 0, 0, false},
+		{"short", "08180", 0, 0, false},
+		{"long", "0818040", 0, 0, false},
+		{"empty", "", 0, 0, false},
+	}
+	for _, tt := range tests {
+		gotStep, ok := Validate(rfcSecret, tt.input, now, tt.lastStep)
+		if ok != tt.ok || gotStep != tt.wantStep {
+			t.Errorf("%s: Validate(%q) = %d, %v; want %d, %v", tt.name, tt.input, gotStep, ok, tt.wantStep, tt.ok)
+		}
+	}
+}
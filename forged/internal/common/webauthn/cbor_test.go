@@ -0,0 +1,55 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+
+package webauthn
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeCBOR(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		in   []byte
+		want any
+		rest []byte
+		ok   bool
+	}{
+		{"small int", []byte{0x17}, int64(23), nil, true},
+		{"one byte int", []byte{0x18, 0x18}, int64(24), nil, true},
+		{"two byte int", []byte{0x19, 0x01, 0x00}, int64(256), nil, true},
+		{"four byte int", []byte{0x1a, 0x00, 0x01, 0x00, 0x00}, int64(65536), nil, true},
+		{"max int", []byte{0x1b, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, int64(1<<63 - 1), nil, true},
+		{"int overflow", []byte{0x1b, 0x80, 0, 0, 0, 0, 0, 0, 0}, nil, nil, false},
+		{"negative", []byte{0x26}, int64(-7), nil, true},
+		{"negative two bytes", []byte{0x39, 0x01, 0x00}, int64(-257), nil, true},
+		{"bytes", []byte{0x42, 0x01, 0x02, 0xff}, []byte{0x01, 0x02}, []byte{0xff}, true},
+		{"text", []byte{0x63, 'f', 'o', 'o'}, "foo", nil, true},
+		{"short text", []byte{0x63, 'f', 'o'}, nil, nil, false},
+		{"array", []byte{0x82, 0x01, 0x61, 'a'}, []any{int64(1), "a"}, nil, true},
+		{"map", []byte{0xa2, 0x01, 0x02, 0x61, 'k', 0xf5}, map[any]any{int64(1): int64(2), "k": true}, nil, true},
+		{"map with byte string key", []byte{0xa1, 0x41, 0x00, 0x01}, nil, nil, false},
+		{"huge array", []byte{0x9a, 0xff, 0xff, 0xff, 0xff}, nil, nil, false},
+		{"simple values", []byte{0x83, 0xf4, 0xf5, 0xf6}, []any{false, true, nil}, nil, true},
+		{"undefined", []byte{0xf7}, nil, nil, false},
+		{"float", []byte{0xf9, 0x3c, 0x00}, nil, nil, false},
+		{"tag", []byte{0xc0, 0x60}, nil, nil, false},
+		{"indefinite length", []byte{0x9f, 0xff}, nil, nil, false},
+		{"truncated argument", []byte{0x19, 0x01}, nil, nil, false},
+		{"empty", nil, nil, nil, false},
+		{"too deep", append(bytes.Repeat([]byte{0x81}, maxCBORDepth+1), 0x00), nil, nil, false},
+	}
+	for _, tt := range tests {
+		got, rest, err := decodeCBOR(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: got error %v", tt.name, err)
+			continue
+		}
+		if tt.ok && (!reflect.DeepEqual(got, tt.want) || !bytes.Equal(rest, tt.rest)) {
+			t.Errorf("%s: got %#v with %x left, want %#v with %x", tt.name, got, rest, tt.want, tt.rest)
+		}
+	}
+}
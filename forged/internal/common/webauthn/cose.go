@@ -0,0 +1,113 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+// COSE algorithm identifiers that we accept, in order of preference.
+const (
+	algES256 = -7
+	algEdDSA = -8
+	algRS256 = -257
+)
+
+// COSE key parameters (RFC 9053).
+const (
+	coseKty = 1
+	coseAlg = 3
+	coseCrv = -1 // EC2 and OKP
+	coseX   = -2
+	coseY   = -3
+	coseN   = -1 // RSA
+	coseE   = -2
+
+	ktyOKP = 1
+	ktyEC2 = 2
+	ktyRSA = 3
+
+	crvP256    = 1
+	crvEd25519 = 6
+
+	minRSABits = 2048
+)
+
+var (
+	errUnsupportedKey = errors.New("webauthn: unsupported public key")
+	errSignature      = errors.New("webauthn: signature did not verify")
+)
+
+// verifier checks signatures made by a credential.
+type verifier func(data, sig []byte) error
+
+// parseCOSEKey parses a credential public key.
+func parseCOSEKey(raw []byte) (verifier, error) {
+	v, rest, err := decodeCBOR(raw)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[any]any)
+	if !ok || len(rest) != 0 {
+		return nil, errUnsupportedKey
+	}
+	kty, _ := m[int64(coseKty)].(int64)
+	alg, _ := m[int64(coseAlg)].(int64)
+
+	switch {
+	case kty == ktyEC2 && alg == algES256:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		y, _ := m[int64(coseY)].([]byte)
+		if crv != crvP256 || len(x) != 32 || len(y) != 32 {
+			return nil, errUnsupportedKey
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) { //nolint:staticcheck
+			return nil, errUnsupportedKey
+		}
+		return func(data, sig []byte) error {
+			digest := sha256.Sum256(data)
+			if !ecdsa.VerifyASN1(pub, digest[:], sig) {
+				return errSignature
+			}
+			return nil
+		}, nil
+	case kty == ktyOKP && alg == algEdDSA:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		if crv != crvEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, errUnsupportedKey
+		}
+		pub := ed25519.PublicKey(x)
+		return func(data, sig []byte) error {
+			if !ed25519.Verify(pub, data, sig) {
+				return errSignature
+			}
+			return nil
+		}, nil
+	case kty == ktyRSA && alg == algRS256:
+		n, _ := m[int64(coseN)].([]byte)
+		e, _ := m[int64(coseE)].([]byte)
+		if len(n)*8 < minRSABits || len(e) == 0 || len(e) > 4 {
+			return nil, errUnsupportedKey
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		return func(data, sig []byte) error {
+			digest := sha256.Sum256(data)
+			if rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) != nil {
+				return errSignature
+			}
+			return nil
+		}, nil
+	}
+	return nil, errUnsupportedKey
+}
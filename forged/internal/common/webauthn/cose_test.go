@@ -0,0 +1,156 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"testing"
+)
+
+// encodeCBOR encodes the subset of CBOR that decodeCBOR supports, with
+// integer keys and values as int.
+func encodeCBOR(v any) []byte {
+	head := func(major byte, arg uint64) []byte {
+		switch {
+		case arg < 24:
+			return []byte{major<<5 | byte(arg)}
+		case arg < 1<<8:
+			return []byte{major<<5 | 24, byte(arg)}
+		case arg < 1<<16:
+			return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(arg))
+		case arg < 1<<32:
+			return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(arg))
+		}
+		return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, arg)
+	}
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+		return head(0, uint64(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case map[any]any:
+		out := head(5, uint64(len(v)))
+		for k, item := range v {
+			out = append(out, encodeCBOR(k)...)
+			out = append(out, encodeCBOR(item)...)
+		}
+		return out
+	}
+	panic(fmt.Sprintf("encodeCBOR: unsupported %T", v))
+}
+
+// testKey is a credential key pair.
+type testKey struct {
+	cose []byte
+	sign func(data []byte) []byte
+}
+
+func newES256Key(t *testing.T) testKey {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := priv.X.FillBytes(make([]byte, 32)) //nolint:staticcheck
+	y := priv.Y.FillBytes(make([]byte, 32)) //nolint:staticcheck
+	return testKey{
+		cose: encodeCBOR(map[any]any{coseKty: ktyEC2, coseAlg: algES256, coseCrv: crvP256, coseX: x, coseY: y}),
+		sign: func(data []byte) []byte {
+			digest := sha256.Sum256(data)
+			sig, err := ecdsa.SignASN1(rand.Reader, priv, digest[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			return sig
+		},
+	}
+}
+
+func newEdDSAKey(t *testing.T) testKey {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKey{
+		cose: encodeCBOR(map[any]any{coseKty: ktyOKP, coseAlg: algEdDSA, coseCrv: crvEd25519, coseX: []byte(pub)}),
+		sign: func(data []byte) []byte { return ed25519.Sign(priv, data) },
+	}
+}
+
+func newRS256Key(t *testing.T, bits int) testKey {
+	t.Helper()
+	priv, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := big.NewInt(int64(priv.E)).Bytes()
+	return testKey{
+		cose: encodeCBOR(map[any]any{coseKty: ktyRSA, coseAlg: algRS256, coseN: priv.N.Bytes(), coseE: e}),
+		sign: func(data []byte) []byte {
+			digest := sha256.Sum256(data)
+			sig, err := rsa.SignPKCS1v15(rand.Reader, priv, crypto.SHA256, digest[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			return sig
+		},
+	}
+}
+
+func TestParseCOSEKey(t *testing.T) {
+	t.Parallel()
+	coord := make([]byte, 32)
+	tests := []struct {
+		name string
+		key  testKey
+		ok   bool
+	}{
+		{"ES256", newES256Key(t), true},
+		{"EdDSA", newEdDSAKey(t), true},
+		{"RS256", newRS256Key(t, 2048), true},
+		{"short RSA", testKey{cose: encodeCBOR(map[any]any{coseKty: ktyRSA, coseAlg: algRS256, coseN: make([]byte, 128), coseE: []byte{1, 0, 1}})}, false},
+		{"P-384", testKey{cose: encodeCBOR(map[any]any{coseKty: ktyEC2, coseAlg: algES256, coseCrv: 2, coseX: coord, coseY: coord})}, false},
+		{"off the curve", testKey{cose: encodeCBOR(map[any]any{coseKty: ktyEC2, coseAlg: algES256, coseCrv: crvP256, coseX: coord, coseY: coord})}, false},
+		{"short coordinate", testKey{cose: encodeCBOR(map[any]any{coseKty: ktyEC2, coseAlg: algES256, coseCrv: crvP256, coseX: coord[1:], coseY: coord})}, false},
+		{"short Ed25519", testKey{cose: encodeCBOR(map[any]any{coseKty: ktyOKP, coseAlg: algEdDSA, coseCrv: crvEd25519, coseX: coord[1:]})}, false},
+		{"mismatched type", testKey{cose: encodeCBOR(map[any]any{coseKty: ktyOKP, coseAlg: algES256, coseCrv: crvP256, coseX: coord, coseY: coord})}, false},
+		{"ES384", testKey{cose: encodeCBOR(map[any]any{coseKty: ktyEC2, coseAlg: -35, coseCrv: crvP256, coseX: coord, coseY: coord})}, false},
+		{"not a map", testKey{cose: encodeCBOR("key")}, false},
+		{"trailing bytes", testKey{cose: append(newEdDSAKey(t).cose, 0)}, false},
+		{"malformed", testKey{cose: []byte{0xa1}}, false},
+	}
+	data := []byte("signed data")
+	for _, tt := range tests {
+		verify, err := parseCOSEKey(tt.key.cose)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: got error %v", tt.name, err)
+			continue
+		}
+		if !tt.ok {
+			continue
+		}
+		sig := tt.key.sign(data)
+		if err := verify(data, sig); err != nil {
+			t.Errorf("%s: valid signature: %v", tt.name, err)
+		}
+		if err := verify([]byte("other data"), sig); err == nil {
+			t.Errorf("%s: signature over other data verified", tt.name)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+
+// Package webauthn implements the relying party side of Web Authentication
+// for security keys used as a second factor. Attestation is neither
+// requested nor checked, as any authenticator the user chooses is fine.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// ChallengeSize is the size of challenges from NewChallenge.
+const ChallengeSize = 32
+
+// timeout is how many milliseconds browsers should wait for the user.
+const timeout = 120000
+
+// Authenticator data flags.
+const (
+	flagUserPresent  = 0x01
+	flagAttestedData = 0x40
+	flagExtensions   = 0x80
+)
+
+const (
+	typeCreate = "webauthn.create"
+	typeGet    = "webauthn.get"
+)
+
+var (
+	ErrClientData = errors.New("webauthn: client data does not match the request")
+	ErrAuthData   = errors.New("webauthn: malformed authenticator data")
+	ErrRPID       = errors.New("webauthn: credential is for another site")
+	ErrNoPresence = errors.New("webauthn: user was not present")
+	ErrSignCount  = errors.New("webauthn: signature counter went backwards; the authenticator may have been cloned")
+)
+
+var b64 = base64.RawURLEncoding
+
+// RelyingParty is the site that credentials are scoped to.
+type RelyingParty struct {
+	ID     string
+	Origin string
+	Name   string
+}
+
+// New returns the relying party for a site served at root.
+func New(root, name string) (*RelyingParty, error) {
+	u, err := url.Parse(root)
+	if err != nil {
+		return nil, fmt.Errorf("parse web root: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("web root %q is not an absolute URL", root)
+	}
+	rp := &RelyingParty{
+		ID:     u.Hostname(),
+		Origin: u.Scheme + "://" + u.Host,
+		Name:   name,
+	}
+	return rp, nil
+}
+
+// NewChallenge returns a random challenge, which must be kept by the server
+// until the ceremony finishes and used only once.
+func NewChallenge() []byte {
+	challenge := make([]byte, ChallengeSize)
+	_, _ = rand.Read(challenge)
+	return challenge
+}
+
+// Credential is a registered security key.
+type Credential struct {
+	ID        []byte
+	PublicKey []byte // COSE_Key
+	SignCount uint32
+}
+
+type rpEntity struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+}
+
+type userEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type credParam struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type credDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type authenticatorSelection struct {
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions is a PublicKeyCredentialCreationOptions, with binary
+// fields in unpadded base64url for the page's script to decode.
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     rpEntity               `json:"rp"`
+	User                   userEntity             `json:"user"`
+	PubKeyCredParams       []credParam            `json:"pubKeyCredParams"`
+	ExcludeCredentials     []credDescriptor       `json:"excludeCredentials"`
+	AuthenticatorSelection authenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+	Timeout                int                    `json:"timeout"`
+}
+
+// RequestOptions is a PublicKeyCredentialRequestOptions, encoded like
+// CreationOptions.
+type RequestOptions struct {
+	Challenge        string           `json:"challenge"`
+	RPID             string           `json:"rpId"`
+	AllowCredentials []credDescriptor `json:"allowCredentials"`
+	UserVerification string           `json:"userVerification"`
+	Timeout          int              `json:"timeout"`
+}
+
+func descriptors(ids [][]byte) []credDescriptor {
+	out := make([]credDescriptor, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, credDescriptor{Type: "public-key", ID: b64.EncodeToString(id)})
+	}
+	return out
+}
+
+// CreationOptions returns the options for registering a security key for
+// a user, excluding keys that the user already registered.
+func (rp *RelyingParty) CreationOptions(challenge, userHandle []byte, name, displayName string, exclude [][]byte) CreationOptions {
+	return CreationOptions{
+		Challenge: b64.EncodeToString(challenge),
+		RP:        rpEntity{ID: rp.ID, Name: rp.Name},
+		User: userEntity{
+			ID:          b64.EncodeToString(userHandle),
+			Name:        name,
+			DisplayName: displayName,
+		},
+		PubKeyCredParams: []credParam{
+			{Type: "public-key", Alg: algES256},
+			{Type: "public-key", Alg: algEdDSA},
+			{Type: "public-key", Alg: algRS256},
+		},
+		ExcludeCredentials: descriptors(exclude),
+		// The password is the first factor.
+		AuthenticatorSelection: authenticatorSelection{UserVerification: "discouraged"},
+		Attestation:            "none",
+		Timeout:                timeout,
+	}
+}
+
+// RequestOptions returns the options for authenticating with one of the
+// given credentials.
+func (rp *RelyingParty) RequestOptions(challenge []byte, allow [][]byte) RequestOptions {
+	return RequestOptions{
+		Challenge:        b64.EncodeToString(challenge),
+		RPID:             rp.ID,
+		AllowCredentials: descriptors(allow),
+		UserVerification: "discouraged",
+		Timeout:          timeout,
+	}
+}
+
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+func (rp *RelyingParty) checkClientData(raw []byte, typ string, challenge []byte) error {
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return fmt.Errorf("%w: %w", ErrClientData, err)
+	}
+	got, err := b64.DecodeString(cd.Challenge)
+	if err != nil || cd.Type != typ || cd.Origin != rp.Origin || cd.CrossOrigin ||
+		subtle.ConstantTimeCompare(got, challenge) != 1 {
+		return ErrClientData
+	}
+	return nil
+}
+
+type authData struct {
+	flags     byte
+	signCount uint32
+	credID    []byte
+	publicKey []byte
+}
+
+func (rp *RelyingParty) parseAuthData(b []byte) (authData, error) {
+	var ad authData
+	if len(b) < 37 {
+		return ad, ErrAuthData
+	}
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(b[:32], rpIDHash[:]) {
+		return ad, ErrRPID
+	}
+	ad.flags = b[32]
+	ad.signCount = binary.BigEndian.Uint32(b[33:37])
+	rest := b[37:]
+
+	if ad.flags&flagAttestedData != 0 {
+		// AAGUID, then the length-prefixed credential ID, then the key.
+		if len(rest) < 18 {
+			return ad, ErrAuthData
+		}
+		idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+		if len(rest) < 18+idLen {
+			return ad, ErrAuthData
+		}
+		ad.credID = rest[18 : 18+idLen]
+		rest = rest[18+idLen:]
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return ad, err
+		}
+		ad.publicKey = rest[:len(rest)-len(after)]
+		rest = after
+	}
+	if ad.flags&flagExtensions != 0 {
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return ad, err
+		}
+		rest = after
+	}
+	if len(rest) != 0 {
+		return ad, ErrAuthData
+	}
+	if ad.flags&flagUserPresent == 0 {
+		return ad, ErrNoPresence
+	}
+	return ad, nil
+}
+
+// FinishRegistration checks the browser's response to CreationOptions
+// issued with challenge and returns the new credential.
+func (rp *RelyingParty) FinishRegistration(challenge, clientDataJSON, attestationObject []byte) (Credential, error) {
+	var cred Credential
+	if err := rp.checkClientData(clientDataJSON, typeCreate, challenge); err != nil {
+		return cred, err
+	}
+
+	v, rest, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return cred, err
+	}
+	obj, ok := v.(map[any]any)
+	if !ok || len(rest) != 0 {
+		return cred, ErrAuthData
+	}
+	rawAuthData, ok := obj["authData"].([]byte)
+	if !ok {
+		return cred, ErrAuthData
+	}
+	ad, err := rp.parseAuthData(rawAuthData)
+	if err != nil {
+		return cred, err
+	}
+	if ad.credID == nil {
+		return cred, ErrAuthData
+	}
+	if _, err := parseCOSEKey(ad.publicKey); err != nil {
+		return cred, err
+	}
+
+	cred.ID = bytes.Clone(ad.credID)
+	cred.PublicKey = bytes.Clone(ad.publicKey)
+	cred.SignCount = ad.signCount
+	return cred, nil
+}
+
+// FinishLogin checks the browser's response to RequestOptions issued with
+// challenge, given the credential it names, and returns the credential's
+// new signature counter.
+func (rp *RelyingParty) FinishLogin(challenge []byte, cred Credential, clientDataJSON, authenticatorData, signature []byte) (uint32, error) {
+	if err := rp.checkClientData(clientDataJSON, typeGet, challenge); err != nil {
+		return 0, err
+	}
+	ad, err := rp.parseAuthData(authenticatorData)
+	if err != nil {
+		return 0, err
+	}
+	verify, err := parseCOSEKey(cred.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(bytes.Clone(authenticatorData), clientDataHash[:]...)
+	if err := verify(signed, signature); err != nil {
+		return 0, err
+	}
+	// Authenticators without counters always report zero.
+	if (ad.signCount != 0 || cred.SignCount != 0) && ad.signCount <= cred.SignCount {
+		return 0, ErrSignCount
+	}
+	return ad.signCount, nil
+}
@@ -0,0 +1,158 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+
+package webauthn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func newTestRP(t *testing.T) *RelyingParty {
+	t.Helper()
+	rp, err := New("https://forge.example.org/", "Example Forge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rp
+}
+
+// buildAuthData builds authenticator data for rpID, with the attested
+// credential if credID is not nil, followed by tail.
+func buildAuthData(rpID string, flags byte, signCount uint32, credID, publicKey, tail []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	b := append(rpIDHash[:], flags)
+	b = binary.BigEndian.AppendUint32(b, signCount)
+	if credID != nil {
+		b = append(b, make([]byte, 16)...) // AAGUID
+		b = binary.BigEndian.AppendUint16(b, uint16(len(credID)))
+		b = append(b, credID...)
+		b = append(b, publicKey...)
+	}
+	return append(b, tail...)
+}
+
+func TestParseAuthData(t *testing.T) {
+	t.Parallel()
+	rp := newTestRP(t)
+	key := newEdDSAKey(t).cose
+	credID := []byte("credential")
+	extensions := encodeCBOR(map[any]any{"credProtect": 2})
+	tests := []struct {
+		name string
+		in   []byte
+		want authData
+		err  error
+	}{
+		{
+			"assertion",
+			buildAuthData(rp.ID, flagUserPresent, 7, nil, nil, nil),
+			authData{flags: flagUserPresent, signCount: 7, credID: nil, publicKey: nil},
+			nil,
+		},
+		{
+			"attested",
+			buildAuthData(rp.ID, flagUserPresent|flagAttestedData, 0, credID, key, nil),
+			authData{flags: flagUserPresent | flagAttestedData, signCount: 0, credID: credID, publicKey: key},
+			nil,
+		},
+		{
+			"extensions",
+			buildAuthData(rp.ID, flagUserPresent|flagAttestedData|flagExtensions, 1, credID, key, extensions),
+			authData{flags: flagUserPresent | flagAttestedData | flagExtensions, signCount: 1, credID: credID, publicKey: key},
+			nil,
+		},
+		{"other site", buildAuthData("evil.example.org", flagUserPresent, 0, nil, nil, nil), authData{}, ErrRPID},
+		{"not present", buildAuthData(rp.ID, 0, 0, nil, nil, nil), authData{}, ErrNoPresence},
+		{"short", buildAuthData(rp.ID, flagUserPresent, 0, nil, nil, nil)[:36], authData{}, ErrAuthData},
+		{"trailing bytes", buildAuthData(rp.ID, flagUserPresent, 0, nil, nil, []byte{0}), authData{}, ErrAuthData},
+		{"missing credential", buildAuthData(rp.ID, flagUserPresent|flagAttestedData, 0, nil, nil, nil), authData{}, ErrAuthData},
+		{"short credential ID", buildAuthData(rp.ID, flagUserPresent|flagAttestedData, 0, credID, nil, nil)[:37+18+4], authData{}, ErrAuthData},
+		{"missing key", buildAuthData(rp.ID, flagUserPresent|flagAttestedData, 0, credID, nil, nil), authData{}, errCBOR},
+		{"missing extensions", buildAuthData(rp.ID, flagUserPresent|flagExtensions, 0, nil, nil, nil), authData{}, errCBOR},
+	}
+	for _, tt := range tests {
+		got, err := rp.parseAuthData(tt.in)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: got error %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		if err == nil && (got.flags != tt.want.flags || got.signCount != tt.want.signCount ||
+			!bytes.Equal(got.credID, tt.want.credID) || !bytes.Equal(got.publicKey, tt.want.publicKey)) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCheckClientData(t *testing.T) {
+	t.Parallel()
+	rp := newTestRP(t)
+	challenge := NewChallenge()
+	encode := func(typ, chal, origin string, crossOrigin bool) []byte {
+		raw, _ := json.Marshal(clientData{Type: typ, Challenge: chal, Origin: origin, CrossOrigin: crossOrigin})
+		return raw
+	}
+	tests := []struct {
+		name string
+		raw  []byte
+		ok   bool
+	}{
+		{"valid", encode(typeGet, b64.EncodeToString(challenge), rp.Origin, false), true},
+		{"wrong type", encode(typeCreate, b64.EncodeToString(challenge), rp.Origin, false), false},
+		{"wrong challenge", encode(typeGet, b64.EncodeToString(NewChallenge()), rp.Origin, false), false},
+		{"padded challenge", encode(typeGet, b64.EncodeToString(challenge)+"=", rp.Origin, false), false},
+		{"wrong origin", encode(typeGet, b64.EncodeToString(challenge), "https://evil.example.org", false), false},
+		{"cross origin", encode(typeGet, b64.EncodeToString(challenge), rp.Origin, true), false},
+		{"not JSON", []byte("{"), false},
+	}
+	for _, tt := range tests {
+		if err := rp.checkClientData(tt.raw, typeGet, challenge); (err == nil) != tt.ok {
+			t.Errorf("%s: got error %v", tt.name, err)
+		}
+	}
+}
+
+// TestCeremonies registers a credential and logs in with it.
+func TestCeremonies(t *testing.T) {
+	t.Parallel()
+	rp := newTestRP(t)
+	key := newES256Key(t)
+	credID := []byte("credential")
+	clientDataFor := func(typ string, challenge []byte) []byte {
+		raw, _ := json.Marshal(clientData{Type: typ, Challenge: b64.EncodeToString(challenge), Origin: rp.Origin, CrossOrigin: false})
+		return raw
+	}
+
+	challenge := NewChallenge()
+	attestation := encodeCBOR(map[any]any{
+		"fmt":      "none",
+		"attStmt":  map[any]any{},
+		"authData": buildAuthData(rp.ID, flagUserPresent|flagAttestedData, 1, credID, key.cose, nil),
+	})
+	cred, err := rp.FinishRegistration(challenge, clientDataFor(typeCreate, challenge), attestation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cred.ID, credID) || !bytes.Equal(cred.PublicKey, key.cose) || cred.SignCount != 1 {
+		t.Fatalf("registered %+v", cred)
+	}
+
+	login := func(signCount uint32) (uint32, error) {
+		challenge := NewChallenge()
+		clientDataJSON := clientDataFor(typeGet, challenge)
+		authenticatorData := buildAuthData(rp.ID, flagUserPresent, signCount, nil, nil, nil)
+		clientDataHash := sha256.Sum256(clientDataJSON)
+		sig := key.sign(append(bytes.Clone(authenticatorData), clientDataHash[:]...))
+		return rp.FinishLogin(challenge, cred, clientDataJSON, authenticatorData, sig)
+	}
+	if count, err := login(2); err != nil || count != 2 {
+		t.Errorf("login = %d, %v; want 2", count, err)
+	}
+	if _, err := login(1); !errors.Is(err, ErrSignCount) {
+		t.Errorf("login with an old counter: got %v, want %v", err, ErrSignCount)
+	}
+}
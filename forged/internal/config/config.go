@@ -10,15 +10,20 @@ import (
 )
 
 type Config struct {
-	DB      DB      `scfg:"db"`
-	Web     Web     `scfg:"web"`
-	Hooks   Hooks   `scfg:"hooks"`
-	LMTP    LMTP    `scfg:"lmtp"`
-	SSH     SSH     `scfg:"ssh"`
-	IRC     IRC     `scfg:"irc"`
-	Git     Git     `scfg:"git"`
-	General General `scfg:"general"`
-	Pprof   Pprof   `scfg:"pprof"`
+	DB         DB                       `scfg:"db"`
+	Web        Web                      `scfg:"web"`
+	Hooks      Hooks                    `scfg:"hooks"`
+	LMTP       LMTP                     `scfg:"lmtp"`
+	SSH        SSH                      `scfg:"ssh"`
+	IRC        IRC                      `scfg:"irc"`
+	Git        Git                      `scfg:"git"`
+	ViewCache  ViewCache                `scfg:"view_cache"`
+	General    General                  `scfg:"general"`
+	Auth       Auth                     `scfg:"auth"`
+	SMTP       SMTP                     `scfg:"smtp"`
+	OAuth      map[string]OAuthProvider `scfg:"oauth"`
+	Federation Federation               `scfg:"federation"`
+	Pprof      Pprof                    `scfg:"pprof"`
 }
 
 type DB struct {
@@ -72,12 +77,92 @@ type IRC struct {
 }
 
 type Git struct {
-	RepoDir string `scfg:"repo_dir"`
-	Socket  string `scfg:"socket"`
+	RepoDir             string                 `scfg:"repo_dir"`
+	Socket              string                 `scfg:"socket"`
+	TLSCert             string                 `scfg:"tls_cert"`
+	TLSKey              string                 `scfg:"tls_key"`
+	TLSCA               string                 `scfg:"tls_ca"`
+	Weight              uint                   `scfg:"weight"`
+	Draining            bool                   `scfg:"draining"`
+	MaxConns            int                    `scfg:"max_conns"`
+	MaxIdle             int                    `scfg:"max_idle"`
+	IdleTimeout         uint32                 `scfg:"idle_timeout"`
+	HealthCheckInterval uint32                 `scfg:"health_check_interval"`
+	Nodes               map[string]StorageNode `scfg:"nodes"`
+}
+
+type StorageNode struct {
+	RepoDir  string `scfg:"repo_dir"`
+	Socket   string `scfg:"socket"`
+	TLSCert  string `scfg:"tls_cert"`
+	TLSKey   string `scfg:"tls_key"`
+	TLSCA    string `scfg:"tls_ca"`
+	Weight   uint   `scfg:"weight"`
+	Draining bool   `scfg:"draining"`
+}
+
+type ViewCache struct {
+	MaxSize int64  `scfg:"max_size"`
+	Backing string `scfg:"backing"`
+	Dir     string `scfg:"dir"`
+	MaxAge  uint32 `scfg:"max_age"`
+	RefTTL  uint32 `scfg:"ref_ttl"`
 }
 
 type General struct {
-	Title string `scfg:"title"`
+	Title           string `scfg:"title"`
+	Registration    string `scfg:"registration"`
+	RequireOwner2FA bool   `scfg:"require_owner_2fa"`
+}
+
+// Registration modes.
+const (
+	RegistrationOpen   = "open"
+	RegistrationInvite = "invite"
+	RegistrationClosed = "closed"
+)
+
+// Auth is how passwords are hashed with Argon2id. Hashes made with weaker
+// parameters are upgraded when their users next log in.
+type Auth struct {
+	Argon2Memory      uint32 `scfg:"argon2_memory"`
+	Argon2Iterations  uint32 `scfg:"argon2_iterations"`
+	Argon2Parallelism uint8  `scfg:"argon2_parallelism"`
+}
+
+type SMTP struct {
+	Addr     string `scfg:"addr"`
+	From     string `scfg:"from"`
+	Username string `scfg:"username"`
+	Password string `scfg:"password"`
+}
+
+// OAuthProvider is an external identity provider that users may log in
+// with. OpenID Connect providers have an issuer; plain OAuth 2.0 providers
+// don't, and are asked who the user is at their userinfo endpoint.
+type OAuthProvider struct {
+	Name           string `scfg:"name"`
+	Issuer         string `scfg:"issuer"`
+	AuthURL        string `scfg:"auth_url"`
+	TokenURL       string `scfg:"token_url"`
+	UserinfoURL    string `scfg:"userinfo_url"`
+	ClientID       string `scfg:"client_id"`
+	ClientSecret   string `scfg:"client_secret"`
+	Scopes         string `scfg:"scopes"`
+	SubjectClaim   string `scfg:"subject_claim"`
+	UsernameClaim  string `scfg:"username_claim"`
+	CreateAccounts bool   `scfg:"create_accounts"`
+}
+
+type Federation struct {
+	ReverifyInterval uint32                      `scfg:"reverify_interval"`
+	Lindenii         map[string]LindeniiInstance `scfg:"lindenii"`
+}
+
+// LindeniiInstance is another Lindenii Forge whose users may verify their
+// accounts there.
+type LindeniiInstance struct {
+	Root string `scfg:"root"`
 }
 
 type Pprof struct {
@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+
+// Package dbtest gives tests a PostgreSQL database with forged's schema.
+//
+// Tests using it are skipped unless FORGED_TEST_DATABASE is set to the
+// connection string of a database in which they may create schemas. They
+// also need the queries generated by sqlc, not stand-ins for them.
+package dbtest
+
+import (
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.lindenii.runxiyu.org/forge/forged/internal/database"
+	"go.lindenii.runxiyu.org/forge/forged/internal/database/queries"
+)
+
+// EnvVar names the environment variable holding the connection string.
+const EnvVar = "FORGED_TEST_DATABASE"
+
+// New loads sql/schema.sql into a schema of its own, dropped when the test
+// ends, and returns a database whose connections use it.
+func New(t *testing.T) (*database.Database, *queries.Queries) {
+	t.Helper()
+	connString := os.Getenv(EnvVar)
+	if connString == "" {
+		t.Skip(EnvVar + " is not set")
+	}
+	_, file, _, _ := runtime.Caller(0)
+	schemaSQL, err := os.ReadFile(filepath.Join(filepath.Dir(file), "..", "..", "..", "sql", "schema.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := t.Context()
+	admin, err := pgx.Connect(ctx, connString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = admin.Close(ctx) }()
+	schema := "forged_test_" + rand.Text()
+	if _, err := admin.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx := context.Background()
+		conn, err := pgx.Connect(ctx, connString)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer func() { _ = conn.Close(ctx) }()
+		if _, err := conn.Exec(ctx, "DROP SCHEMA "+schema+" CASCADE"); err != nil {
+			t.Error(err)
+		}
+	})
+
+	cfg, err := pgxpool.ParseConfig(connString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ConnConfig.RuntimeParams["search_path"] = schema
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The simple protocol allows several statements at once.
+	_, err = conn.Conn().PgConn().Exec(ctx, string(schemaSQL)).ReadAll()
+	conn.Release()
+	if err != nil {
+		t.Fatalf("load schema: %v", err)
+	}
+
+	db := &database.Database{Pool: pool}
+	return db, queries.New(pool)
+}
@@ -1,9 +1,15 @@
 package global
 
 import (
+	"go.lindenii.runxiyu.org/forge/forged/internal/common/argon2id"
 	"go.lindenii.runxiyu.org/forge/forged/internal/config"
 	"go.lindenii.runxiyu.org/forge/forged/internal/database"
 	"go.lindenii.runxiyu.org/forge/forged/internal/database/queries"
+	"go.lindenii.runxiyu.org/forge/forged/internal/outgoing/federation"
+	"go.lindenii.runxiyu.org/forge/forged/internal/outgoing/mail"
+	"go.lindenii.runxiyu.org/forge/forged/internal/outgoing/oauth"
+	"go.lindenii.runxiyu.org/forge/forged/internal/storage"
+	"go.lindenii.runxiyu.org/forge/forged/internal/viewcache"
 )
 
 type Global struct {
@@ -12,7 +18,14 @@ type Global struct {
 	SSHPubkey      string
 	SSHFingerprint string
 
-	Config  *config.Config
-	Queries *queries.Queries
-	DB      *database.Database
+	Config     *config.Config
+	Queries    *queries.Queries
+	DB         *database.Database
+	Storage    *storage.Storage
+	ViewCache  *viewcache.Cache
+	Mail       *mail.Sender
+	OAuth      map[string]*oauth.Provider
+	Federation *federation.Federation
+	// PasswordParams are what passwords are hashed with, from auth.
+	PasswordParams *argon2id.Params
 }
@@ -1,9 +1,12 @@
 package hooks
 
 import (
+	"bufio"
 	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"time"
 
@@ -13,6 +16,13 @@ import (
 	"go.lindenii.runxiyu.org/forge/forged/internal/global"
 )
 
+const (
+	// cookieSize is the length of LINDENII_FORGE_HOOKS_COOKIE.
+	cookieSize = 64
+	// maxHookArgs bounds argc; git passes hooks few arguments.
+	maxHookArgs = 64
+)
+
 type Server struct {
 	hookMap         cmap.Map[string, hookInfo]
 	socketPath      string
@@ -78,4 +88,56 @@ func (server *Server) handleConn(ctx context.Context, conn net.Conn) {
 		_ = conn.Close()
 	})
 	defer unblock()
+
+	status, msg := server.handleHook(conn)
+	_, _ = conn.Write([]byte{status})
+	if msg != "" {
+		_, _ = conn.Write([]byte(msg + "\n"))
+	}
+}
+
+// handleHook reads a hook invocation from hookc, returning the hook's exit
+// status and a message for the pusher. As forged doesn't serve pushes yet,
+// nothing adds to hookMap, and every cookie is invalid.
+func (server *Server) handleHook(conn net.Conn) (status byte, msg string) {
+	rd := bufio.NewReader(conn)
+
+	cookie := make([]byte, cookieSize)
+	if _, err := io.ReadFull(rd, cookie); err != nil {
+		return 1, "error reading cookie"
+	}
+	if _, ok := server.hookMap.Load(string(cookie)); !ok {
+		return 1, "invalid cookie"
+	}
+
+	var argc uint64
+	if err := binary.Read(rd, binary.NativeEndian, &argc); err != nil {
+		return 1, "error reading argc"
+	}
+	if argc == 0 || argc > maxHookArgs {
+		return 1, "invalid argc"
+	}
+	for range argc {
+		if _, err := rd.ReadString(0); err != nil {
+			return 1, "error reading argv"
+		}
+	}
+	// GIT_* environment, terminated by an empty string
+	for {
+		env, err := rd.ReadString(0)
+		if err != nil {
+			return 1, "error reading environment"
+		}
+		if env == "\x00" {
+			break
+		}
+	}
+	// Lines of "<old> <new> <ref>"
+	if _, err := io.Copy(io.Discard, rd); err != nil {
+		return 1, "error reading stdin"
+	}
+
+	// Hooks have nothing to do yet, and succeed so as not to refuse
+	// pushes.
+	return 0, ""
 }
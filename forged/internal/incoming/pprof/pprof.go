@@ -0,0 +1,78 @@
+// Package pprof serves profiles from net/http/pprof and variables from
+// expvar, such as the view cache's metrics, on a private listener.
+package pprof
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	httppprof "net/http/pprof"
+	"time"
+
+	"go.lindenii.runxiyu.org/forge/forged/internal/common/misc"
+	"go.lindenii.runxiyu.org/forge/forged/internal/global"
+)
+
+// shutdownTimeout bounds how long in-flight profiles may delay shutdown.
+const shutdownTimeout = 5 * time.Second
+
+type Server struct {
+	net        string
+	addr       string
+	httpServer *http.Server
+	global     *global.Global
+}
+
+func New(global *global.Global) *Server {
+	cfg := global.Config.Pprof
+	return &Server{
+		net:        cfg.Net,
+		addr:       cfg.Addr,
+		httpServer: &http.Server{Handler: handler(), ReadHeaderTimeout: 10 * time.Second}, //exhaustruct:ignore
+		global:     global,
+	}
+}
+
+// handler serves what net/http/pprof and expvar would register on
+// [http.DefaultServeMux], without serving anything else registered there.
+func handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/pprof/", httppprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", httppprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", httppprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", httppprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", httppprof.Trace)
+	return mux
+}
+
+func (server *Server) Run(ctx context.Context) (err error) {
+	listener, err := misc.Listen(ctx, server.net, server.addr)
+	if err != nil {
+		return fmt.Errorf("listen for pprof: %w", err)
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	stop := context.AfterFunc(ctx, func() {
+		shCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shutdownTimeout)
+		defer cancel()
+		_ = server.httpServer.Shutdown(shCtx)
+		_ = listener.Close()
+	})
+	defer stop()
+
+	server.httpServer.BaseContext = func(_ net.Listener) context.Context { return ctx }
+	err = server.httpServer.Serve(listener)
+	if err != nil {
+		if errors.Is(err, http.ErrServerClosed) || ctx.Err() != nil {
+			return nil
+		}
+		return fmt.Errorf("serve pprof: %w", err)
+	}
+	panic("unreachable")
+}
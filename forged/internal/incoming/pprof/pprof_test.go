@@ -0,0 +1,32 @@
+package pprof
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	_ "go.lindenii.runxiyu.org/forge/forged/internal/viewcache"
+)
+
+func TestViewCacheMetrics(t *testing.T) {
+	t.Parallel()
+	w := httptest.NewRecorder()
+	handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/vars", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("/debug/vars: got %d", w.Code)
+	}
+	var vars map[string]json.RawMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &vars); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := vars["view_cache"]; !ok {
+		t.Error("/debug/vars has no view_cache")
+	}
+
+	w = httptest.NewRecorder()
+	handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("/debug/pprof/: got %d", w.Code)
+	}
+}
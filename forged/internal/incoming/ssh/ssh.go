@@ -4,7 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"os"
+	"strings"
 	"time"
 
 	gliderssh "github.com/gliderlabs/ssh"
@@ -49,7 +51,7 @@ func New(global *global.Global) (server *Server, err error) {
 	server.global.SSHFingerprint = gossh.FingerprintSHA256(server.privkey.PublicKey())
 
 	server.gliderServer = &gliderssh.Server{
-		Handler:                    handle,
+		Handler:                    server.handle,
 		PublicKeyHandler:           func(ctx gliderssh.Context, key gliderssh.PublicKey) bool { return true },
 		KeyboardInteractiveHandler: func(ctx gliderssh.Context, challenge gossh.KeyboardInteractiveChallenge) bool { return true },
 	} //exhaustruct:ignore
@@ -85,6 +87,14 @@ func (server *Server) Run(ctx context.Context) (err error) {
 	panic("unreachable")
 }
 
-func handle(session gliderssh.Session) {
-	panic("SSH server handler not implemented yet")
+func (server *Server) handle(session gliderssh.Session) {
+	// Record when keys were last used, which users see in their settings.
+	if key := session.PublicKey(); key != nil {
+		keyString := strings.TrimSpace(misc.BytesToString(gossh.MarshalAuthorizedKey(key)))
+		if err := server.global.Queries.TouchSSHPublicKey(session.Context(), keyString); err != nil {
+			slog.Error("failed to record SSH key use", "error", err)
+		}
+	}
+	fmt.Fprintln(session.Stderr(), "SSH access is not implemented yet")
+	_ = session.Exit(1)
 }
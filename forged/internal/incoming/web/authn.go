@@ -5,21 +5,20 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/jackc/pgx/v5"
+	"go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/cookies"
 	"go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/types"
 )
 
 func userResolver(r *http.Request) (string, string, error) {
-	cookie, err := r.Cookie("session")
-	if err != nil {
-		if errors.Is(err, http.ErrNoCookie) {
-			return "", "", nil
-		}
-		return "", "", err
+	token := cookies.Session(r)
+	if token == "" {
+		return "", "", nil
 	}
 
-	tokenHash := sha256.Sum256([]byte(cookie.Value))
+	tokenHash := sha256.Sum256([]byte(token))
 
 	session, err := types.Base(r).Global.Queries.GetUserFromSession(r.Context(), tokenHash[:])
 	if err != nil {
@@ -28,6 +27,10 @@ func userResolver(r *http.Request) (string, string, error) {
 		}
 		return "", "", err
 	}
+	if !session.ExpiresAt.Time.After(time.Now()) {
+		// Expired sessions are deleted by PruneSessions.
+		return "", "", nil
+	}
 
 	return fmt.Sprint(session.UserID), session.Username, nil
 }
@@ -0,0 +1,107 @@
+// Package cookies sets and reads the cookies of the web interface. On secure
+// requests, as decided by the router in BaseData.Secure, cookies are marked
+// Secure and their names take the __Host- prefix, which browsers only accept
+// for secure, host-only cookies with path "/", so that they can't be set by
+// other hosts or over plain HTTP.
+package cookies
+
+import (
+	"crypto/rand"
+	"net/http"
+	"time"
+
+	wtypes "go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/types"
+)
+
+const (
+	hostPrefix = "__Host-"
+	session    = "session"
+	csrf       = "csrf"
+	login      = "login"
+	oauthState = "oauth"
+)
+
+func name(r *http.Request, base string) string {
+	if wtypes.Base(r).Secure {
+		return hostPrefix + base
+	}
+	return base
+}
+
+func get(r *http.Request, base string) string {
+	cookie, err := r.Cookie(name(r, base))
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+func set(w http.ResponseWriter, r *http.Request, base, value string, expiry time.Time) {
+	cookie := &http.Cookie{
+		Name:     name(r, base),
+		Value:    value,
+		SameSite: http.SameSiteLaxMode,
+		HttpOnly: true,
+		Secure:   wtypes.Base(r).Secure,
+		Path:     "/",
+	} //exhaustruct:ignore
+	if expiry.IsZero() {
+		cookie.MaxAge = -1
+	} else {
+		cookie.Expires = expiry
+	}
+	http.SetCookie(w, cookie)
+}
+
+// Session returns the client's session token, or "" if it has none.
+func Session(r *http.Request) string {
+	return get(r, session)
+}
+
+func SetSession(w http.ResponseWriter, r *http.Request, token string, expiry time.Time) {
+	set(w, r, session, token, expiry)
+}
+
+func ClearSession(w http.ResponseWriter, r *http.Request) {
+	set(w, r, session, "", time.Time{})
+}
+
+// PendingLogin returns the token of the client's login that awaits a second
+// factor, or "" if it has none.
+func PendingLogin(r *http.Request) string {
+	return get(r, login)
+}
+
+func SetPendingLogin(w http.ResponseWriter, r *http.Request, token string, expiry time.Time) {
+	set(w, r, login, token, expiry)
+}
+
+func ClearPendingLogin(w http.ResponseWriter, r *http.Request) {
+	set(w, r, login, "", time.Time{})
+}
+
+// OAuthState returns the state parameter of the client's OAuth login in
+// progress, or "" if it has none.
+func OAuthState(r *http.Request) string {
+	return get(r, oauthState)
+}
+
+func SetOAuthState(w http.ResponseWriter, r *http.Request, state string, expiry time.Time) {
+	set(w, r, oauthState, state, expiry)
+}
+
+func ClearOAuthState(w http.ResponseWriter, r *http.Request) {
+	set(w, r, oauthState, "", time.Time{})
+}
+
+// CSRFSeed returns the random value that the CSRF tokens of a client
+// without a session are derived from, setting a cookie with a new one if
+// the client has none.
+func CSRFSeed(w http.ResponseWriter, r *http.Request) string {
+	if seed := get(r, csrf); seed != "" {
+		return seed
+	}
+	seed := rand.Text()
+	set(w, r, csrf, seed, time.Now().AddDate(1, 0, 0))
+	return seed
+}
@@ -0,0 +1,50 @@
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"go.lindenii.runxiyu.org/forge/forged/internal/common/misc"
+	"go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/cookies"
+)
+
+const csrfHeader = "X-CSRF-Token"
+
+// csrfToken returns the CSRF token for the client's session, or for its
+// CSRF seed cookie if it isn't logged in. Tokens are derived from these
+// secrets rather than stored, so they last exactly as long as the session.
+// The router only calls it through BaseData.CSRFToken, so that the seed
+// cookie is only set on pages with forms and on unsafe requests.
+func csrfToken(w http.ResponseWriter, req *http.Request) string {
+	seed := cookies.Session(req)
+	if seed == "" {
+		seed = cookies.CSRFSeed(w, req)
+	}
+	mac := hmac.New(sha256.New, misc.StringToBytes(seed))
+	mac.Write([]byte("csrf"))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// safeMethod reports whether requests with the method can't change state
+// and thus need no CSRF token.
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+func checkCSRF(req *http.Request, want string) bool {
+	if want == "" {
+		return false
+	}
+	got := req.Header.Get(csrfHeader)
+	if got == "" {
+		got = req.PostFormValue("csrf")
+	}
+	return hmac.Equal([]byte(strings.TrimSpace(got)), []byte(want))
+}
@@ -7,6 +7,7 @@ import (
 	"go.lindenii.runxiyu.org/forge/forged/internal/common/misc"
 	"go.lindenii.runxiyu.org/forge/forged/internal/global"
 	handlers "go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/handlers"
+	apiHandlers "go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/handlers/api"
 	repoHandlers "go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/handlers/repo"
 	specialHandlers "go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/handlers/special"
 	"go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/templates"
@@ -18,7 +19,7 @@ type handler struct {
 
 func NewHandler(global *global.Global) *handler {
 	cfg := global.Config.Web
-	h := &handler{r: NewRouter().ReverseProxy(cfg.ReverseProxy).Global(global).UserResolver(userResolver)}
+	h := &handler{r: NewRouter().ReverseProxy(cfg.ReverseProxy).Root(cfg.Root).Global(global).UserResolver(userResolver)}
 
 	staticFS := http.FileServer(http.Dir(cfg.StaticPath))
 	h.r.ANYHTTP("-/static/*rest",
@@ -38,6 +39,9 @@ func NewHandler(global *global.Global) *handler {
 
 	indexHTTP := handlers.NewIndexHTTP(renderer)
 	loginHTTP := specialHandlers.NewLoginHTTP(renderer, cfg.CookieExpiry)
+	registerHTTP := specialHandlers.NewRegisterHTTP(renderer, cfg.CookieExpiry)
+	settingsHTTP := specialHandlers.NewSettingsHTTP(renderer)
+	userHTTP := handlers.NewUserHTTP(renderer)
 	groupHTTP := handlers.NewGroupHTTP(renderer)
 	repoHTTP := repoHandlers.NewHTTP(renderer)
 	notImpl := handlers.NewNotImplementedHTTP(renderer)
@@ -45,19 +49,64 @@ func NewHandler(global *global.Global) *handler {
 	h.r.GET("/", indexHTTP.Index)
 
 	h.r.ANY("-/login", loginHTTP.Login)
-	h.r.ANY("-/users", notImpl.Handle)
+	h.r.ANY("-/login/2fa", loginHTTP.SecondFactor)
+	h.r.GET("-/login/oauth/:provider", loginHTTP.OAuthLogin)
+	h.r.GET("-/login/oauth/:provider/callback", loginHTTP.OAuthCallback)
+	h.r.POST("-/logout", loginHTTP.Logout)
+	h.r.ANY("-/register", registerHTTP.Register)
+	h.r.GET("-/users/:user/", userHTTP.Profile)
+	h.r.GET("-/keys/:username", userHTTP.Keys)
+	h.r.GET("-/settings/", settingsHTTP.Index)
+	h.r.POST("-/settings/profile", settingsHTTP.Profile)
+	h.r.POST("-/settings/password", settingsHTTP.Password)
+	h.r.POST("-/settings/emails", settingsHTTP.AddEmail)
+	h.r.POST("-/settings/emails/delete", settingsHTTP.DeleteEmail)
+	h.r.POST("-/settings/invites", settingsHTTP.CreateInvite)
+	h.r.POST("-/settings/keys", settingsHTTP.AddKey)
+	h.r.POST("-/settings/keys/delete", settingsHTTP.DeleteKey)
+	h.r.POST("-/settings/totp", settingsHTTP.BeginTOTP)
+	h.r.POST("-/settings/totp/confirm", settingsHTTP.ConfirmTOTP)
+	h.r.POST("-/settings/totp/disable", settingsHTTP.DisableTOTP)
+	h.r.POST("-/settings/recovery-codes", settingsHTTP.RegenerateRecoveryCodes)
+	h.r.POST("-/settings/security-keys", settingsHTTP.BeginSecurityKey)
+	h.r.POST("-/settings/security-keys/finish", settingsHTTP.FinishSecurityKey)
+	h.r.POST("-/settings/security-keys/delete", settingsHTTP.DeleteSecurityKey)
+	h.r.POST("-/settings/identities", settingsHTTP.LinkIdentity)
+	h.r.POST("-/settings/identities/delete", settingsHTTP.UnlinkIdentity)
+	h.r.POST("-/settings/identities/verify", settingsHTTP.VerifyIdentity)
+	h.r.POST("-/settings/sessions/revoke", settingsHTTP.RevokeSession)
+	h.r.GET("-/verify-email/:token", settingsHTTP.VerifyEmail)
+
+	h.r.ErrorsUnder(apiHandlers.Prefix, ErrorRenderers{
+		BadRequest:       apiHandlers.BadRequest,
+		BadRequestColon:  apiHandlers.BadRequestColon,
+		Forbidden:        apiHandlers.Forbidden,
+		NotFound:         apiHandlers.NotFound,
+		ServerError:      apiHandlers.ServerError,
+		MethodNotAllowed: apiHandlers.MethodNotAllowed,
+	})
+	apiHTTP := apiHandlers.NewHTTP()
+	for _, e := range apiHTTP.Endpoints() {
+		var opts []RouteOption
+		if e.Repo {
+			opts = append(opts, WithRepo())
+		}
+		h.r.handle(e.Method, apiHandlers.Prefix+e.Pattern, e.Handler, nil, opts...)
+	}
+	h.r.GET(apiHandlers.Prefix+"openapi.json", apiHTTP.OpenAPI)
 
 	h.r.GET("@group/", groupHTTP.Index)
 	h.r.POST("@group/", groupHTTP.Post)
 
-	h.r.GET("@group/-/repos/:repo/", repoHTTP.Index)
+	h.r.GET("@group/-/repos/:repo/", repoHTTP.Index, WithRepo())
 	h.r.ANY("@group/-/repos/:repo/info", notImpl.Handle)
-	h.r.ANY("@group/-/repos/:repo/git-upload-pack", notImpl.Handle)
-	h.r.GET("@group/-/repos/:repo/branches/", repoHTTP.Branches)
-	h.r.GET("@group/-/repos/:repo/log/", repoHTTP.Log)
-	h.r.GET("@group/-/repos/:repo/commit/:commit", repoHTTP.Commit)
-	h.r.GET("@group/-/repos/:repo/tree/*rest", repoHTTP.Tree, WithDirIfEmpty("rest"))
-	h.r.GET("@group/-/repos/:repo/raw/*rest", repoHTTP.Raw, WithDirIfEmpty("rest"))
+	h.r.ANY("@group/-/repos/:repo/git-upload-pack", notImpl.Handle, WithoutCSRF())
+	h.r.GET("@group/-/repos/:repo/branches/", repoHTTP.Branches, WithRepo())
+	h.r.GET("@group/-/repos/:repo/log/", repoHTTP.Log, WithRepo())
+	h.r.GET("@group/-/repos/:repo/commit/:commit", repoHTTP.Commit, WithRepo())
+	h.r.GET("@group/-/repos/:repo/compare/*rest", repoHTTP.Compare, WithRepo())
+	h.r.GET("@group/-/repos/:repo/tree/*rest", repoHTTP.Tree, WithDirIfEmpty("rest"), WithRepo())
+	h.r.GET("@group/-/repos/:repo/raw/*rest", repoHTTP.Raw, WithDirIfEmpty("rest"), WithRepo())
 	h.r.GET("@group/-/repos/:repo/contrib/", notImpl.Handle)
 	h.r.GET("@group/-/repos/:repo/contrib/:mr", notImpl.Handle)
 
@@ -0,0 +1,113 @@
+// Package api serves a versioned JSON API under [Prefix], for tooling that
+// would otherwise have to scrape the HTML pages. Each endpoint is declared
+// once in [HTTP.Endpoints], which both the router and the OpenAPI
+// description served at openapi.json are built from.
+package api
+
+import (
+	"net/http"
+
+	wtypes "go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/types"
+)
+
+// Prefix is where the API is served, relative to the web root.
+const Prefix = "-/api/v1/"
+
+// Endpoint is an operation of the API.
+type Endpoint struct {
+	Method string
+	// Pattern is the route's pattern relative to Prefix; a :group
+	// parameter is a group's path with each slash escaped as %2F.
+	Pattern string
+	Summary string
+	// Repo is whether Pattern has a :group and :repo that the router
+	// resolves into BaseData.Repo; see web.WithRepo.
+	Repo bool
+	// Ref is whether the ref is selected with ?commit=, ?branch= or ?tag=,
+	// defaulting to HEAD.
+	Ref bool
+	// Paginated is whether the reply is a page of Response values, taking
+	// ?cursor= and ?limit=; see page.
+	Paginated bool
+	// Response is a value of the type replied with, for the OpenAPI
+	// description.
+	Response any
+	Handler  wtypes.HandlerFunc
+}
+
+type HTTP struct {
+	endpoints []Endpoint
+	openAPI   []byte
+}
+
+func NewHTTP() *HTTP {
+	h := &HTTP{} //exhaustruct:ignore
+	h.endpoints = []Endpoint{
+		{
+			Method: http.MethodGet, Pattern: "groups", Summary: "List top-level groups",
+			Paginated: true, Response: Group{}, Handler: h.RootGroups,
+		}, //exhaustruct:ignore
+		{
+			Method: http.MethodGet, Pattern: "groups/:group", Summary: "Get a group",
+			Response: Group{}, Handler: h.Group,
+		}, //exhaustruct:ignore
+		{
+			Method: http.MethodGet, Pattern: "groups/:group/subgroups", Summary: "List a group's subgroups",
+			Paginated: true, Response: Group{}, Handler: h.Subgroups,
+		}, //exhaustruct:ignore
+		{
+			Method: http.MethodGet, Pattern: "groups/:group/repos", Summary: "List a group's repositories",
+			Paginated: true, Response: Repo{}, Handler: h.Repos,
+		}, //exhaustruct:ignore
+		{
+			Method: http.MethodGet, Pattern: "groups/:group/repos/:repo", Summary: "Get a repository",
+			Repo: true, Response: Repo{}, Handler: h.Repo,
+		}, //exhaustruct:ignore
+		{
+			Method: http.MethodGet, Pattern: "groups/:group/repos/:repo/branches", Summary: "List branches",
+			Repo: true, Paginated: true, Response: Ref{}, Handler: h.Branches,
+		}, //exhaustruct:ignore
+		{
+			Method: http.MethodGet, Pattern: "groups/:group/repos/:repo/tags", Summary: "List tags",
+			Repo: true, Paginated: true, Response: Ref{}, Handler: h.Tags,
+		}, //exhaustruct:ignore
+		{
+			Method: http.MethodGet, Pattern: "groups/:group/repos/:repo/commits", Summary: "List the history of a ref, newest first",
+			Repo: true, Ref: true, Paginated: true, Response: CommitSummary{}, Handler: h.Commits,
+		}, //exhaustruct:ignore
+		{
+			Method: http.MethodGet, Pattern: "groups/:group/repos/:repo/commits/:commit", Summary: "Get a commit and the files it changes",
+			Repo: true, Response: Commit{}, Handler: h.Commit,
+		}, //exhaustruct:ignore
+		{
+			Method: http.MethodGet, Pattern: "groups/:group/repos/:repo/tree/*path", Summary: "List a directory",
+			Repo: true, Ref: true, Response: Tree{}, Handler: h.Tree,
+		}, //exhaustruct:ignore
+		{
+			Method: http.MethodGet, Pattern: "groups/:group/repos/:repo/blobs/*path", Summary: "Get a file",
+			Repo: true, Ref: true, Response: Blob{}, Handler: h.Blob,
+		}, //exhaustruct:ignore
+		{
+			Method: http.MethodGet, Pattern: "groups/:group/repos/:repo/merge-requests", Summary: "List merge requests, newest first",
+			Repo: true, Paginated: true, Response: MergeRequest{}, Handler: h.MergeRequests,
+		}, //exhaustruct:ignore
+		{
+			Method: http.MethodGet, Pattern: "groups/:group/repos/:repo/merge-requests/:mr", Summary: "Get a merge request",
+			Repo: true, Response: MergeRequest{}, Handler: h.MergeRequest,
+		}, //exhaustruct:ignore
+	}
+	h.openAPI = openAPIDocument(h.endpoints)
+	return h
+}
+
+// Endpoints returns the API's operations, for registering with the router;
+// the OpenAPI description is served at openapi.json besides them.
+func (h *HTTP) Endpoints() []Endpoint {
+	return h.endpoints
+}
+
+// OpenAPI serves the OpenAPI description of the endpoints.
+func (h *HTTP) OpenAPI(w http.ResponseWriter, _ *http.Request, _ wtypes.Vars) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(h.openAPI)
+}
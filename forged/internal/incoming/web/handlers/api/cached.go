@@ -0,0 +1,82 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	wtypes "go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/types"
+	"go.lindenii.runxiyu.org/forge/forged/internal/ipc/git2c"
+	"go.lindenii.runxiyu.org/forge/forged/internal/viewcache"
+)
+
+// The functions here go through the view cache with the same keys as those
+// in handlers/repo, so that the API and the web pages share cached views;
+// only logs differ, as noted on cachedLog.
+
+var errNotTree = errors.New("not a tree")
+
+// resolveRev resolves the ref selected by the ?commit=, ?branch= or ?tag=
+// query parameter, or HEAD when there is none, to a commit ID.
+func resolveRev(ctx context.Context, base *wtypes.BaseData) (string, error) {
+	refType, refName := base.RefType, base.RefName
+	if refType == "" {
+		refType, refName = "rev", "HEAD"
+	}
+	repo := base.Repo
+	return base.Global.ViewCache.Resolve(ctx, repo.ID, refType, refName, func(ctx context.Context) (string, error) {
+		return repo.Client.ResolveRef(ctx, repo.Path, refType, refName)
+	})
+}
+
+// resolveRequestRev is resolveRev that replies with an error itself,
+// returning false, if the ref can't be resolved.
+func resolveRequestRev(w http.ResponseWriter, r *http.Request) (string, bool) {
+	rev, err := resolveRev(r.Context(), wtypes.Base(r))
+	if git2c.IsNotFound(err) {
+		notFound(w, "Ref not found")
+		return "", false
+	} else if err != nil {
+		internalError(w, "resolve ref", err)
+		return "", false
+	}
+	return rev, true
+}
+
+// cachedLog returns at most the first n commits in the history of commit.
+// Unlike the log pages, the API never needs the whole history at once, so
+// its logs are cached per length.
+func cachedLog(ctx context.Context, base *wtypes.BaseData, commit string, n uint) ([]git2c.Commit, error) {
+	return viewcache.Get(ctx, base.Global.ViewCache, viewcache.Key(base.Repo.ID, "log", commit, strconv.FormatUint(uint64(n), 10)), func() ([]git2c.Commit, error) {
+		return base.Repo.Client.Log(ctx, base.Repo.Path, commit, n)
+	})
+}
+
+func cachedCommitInfo(ctx context.Context, base *wtypes.BaseData, commit string, opts git2c.DiffOptions) (*git2c.CommitInfo, error) {
+	return viewcache.Get(ctx, base.Global.ViewCache, viewcache.Key(base.Repo.ID, "commit", commit, fmt.Sprintf("%+v", opts)), func() (*git2c.CommitInfo, error) {
+		return base.Repo.Client.CommitInfo(ctx, base.Repo.Path, commit, opts)
+	})
+}
+
+// cachedTreeRaw is CmdTreeRaw with directory listings cached; blobs are
+// always streamed from git2d.
+func cachedTreeRaw(ctx context.Context, base *wtypes.BaseData, commit, pathSpec string) ([]git2c.TreeEntry, *git2c.Blob, error) {
+	var blob *git2c.Blob
+	files, err := viewcache.Get(ctx, base.Global.ViewCache, viewcache.Key(base.Repo.ID, "tree", commit, pathSpec), func() ([]git2c.TreeEntry, error) {
+		files, b, err := base.Repo.Client.CmdTreeRaw(ctx, base.Repo.Path, commit, pathSpec)
+		if b != nil {
+			blob = b
+			return nil, errNotTree
+		}
+		if files == nil && err == nil {
+			files = []git2c.TreeEntry{}
+		}
+		return files, err
+	})
+	if errors.Is(err, errNotTree) {
+		return nil, blob, nil
+	}
+	return files, nil, err
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	wtypes "go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/types"
+	"go.lindenii.runxiyu.org/forge/forged/internal/ipc/git2c"
+)
+
+type Signature struct {
+	Name  string    `json:"name"`
+	Email string    `json:"email"`
+	Date  time.Time `json:"date"`
+}
+
+type CommitSummary struct {
+	ID string `json:"id"`
+	// Title is the first line of the commit message.
+	Title  string    `json:"title"`
+	Author Signature `json:"author"`
+}
+
+type Commit struct {
+	ID        string       `json:"id"`
+	Message   string       `json:"message"`
+	Author    Signature    `json:"author"`
+	Committer Signature    `json:"committer"`
+	Parents   []string     `json:"parents"`
+	Additions uint64       `json:"additions"`
+	Deletions uint64       `json:"deletions"`
+	Files     []FileChange `json:"files"`
+}
+
+// FileChange is a file changed by a commit, compared with its first
+// parent.
+type FileChange struct {
+	// Status is "added", "deleted", "modified", "renamed", "copied" or
+	// "type_changed".
+	Status string `json:"status"`
+	// OldPath is empty for added files, and NewPath for deleted ones.
+	OldPath   string `json:"old_path"`
+	NewPath   string `json:"new_path"`
+	Binary    bool   `json:"binary"`
+	Additions uint64 `json:"additions"`
+	Deletions uint64 `json:"deletions"`
+}
+
+// commitsCursor continues a log from the commit it started at, even if the
+// ref has since moved.
+type commitsCursor struct {
+	Tip    string `json:"t"`
+	Offset int    `json:"o"`
+}
+
+// maxLogOffset bounds how deep into a history cursors may reach, so that
+// forged cursors can't overflow the log limit.
+const maxLogOffset = 1 << 30
+
+var deltaStatuses = map[uint64]string{
+	git2c.DeltaAdded:      "added",
+	git2c.DeltaDeleted:    "deleted",
+	git2c.DeltaModified:   "modified",
+	git2c.DeltaRenamed:    "renamed",
+	git2c.DeltaCopied:     "copied",
+	git2c.DeltaTypeChange: "type_changed",
+}
+
+func signature(name, email string, when, tzMin int64) Signature {
+	return Signature{
+		Name:  name,
+		Email: email,
+		Date:  time.Unix(when, 0).In(time.FixedZone("", int(tzMin)*60)),
+	}
+}
+
+func (h *HTTP) Commits(w http.ResponseWriter, r *http.Request, _ wtypes.Vars) {
+	base := wtypes.Base(r)
+	var cursor commitsCursor
+	limit, hasCursor, ok := parsePage(w, r, &cursor)
+	if !ok {
+		return
+	}
+	if hasCursor && cursor.Tip == "" {
+		badRequest(w, "Invalid cursor")
+		return
+	} else if !hasCursor {
+		rev, err := resolveRev(r.Context(), base)
+		if git2c.IsNotFound(err) && base.RefType == "" {
+			writePage(w, []CommitSummary{}, limit, nil) // no commits yet
+			return
+		} else if git2c.IsNotFound(err) {
+			notFound(w, "Ref not found")
+			return
+		} else if err != nil {
+			internalError(w, "resolve ref", err)
+			return
+		}
+		cursor.Tip = rev
+	}
+
+	if cursor.Offset < 0 || cursor.Offset > maxLogOffset {
+		badRequest(w, "Invalid cursor")
+		return
+	}
+	// Walk only as far as this page and one more commit, to tell whether
+	// there is another page.
+	log, err := cachedLog(r.Context(), base, cursor.Tip, uint(cursor.Offset+limit+1))
+	if git2c.IsNotFound(err) {
+		badRequest(w, "Invalid cursor")
+		return
+	} else if err != nil {
+		internalError(w, "log", err)
+		return
+	}
+	if cursor.Offset > len(log) {
+		badRequest(w, "Invalid cursor")
+		return
+	}
+	log = log[cursor.Offset:min(cursor.Offset+limit+1, len(log))]
+
+	commits := make([]CommitSummary, 0, len(log))
+	for _, c := range log {
+		when, _ := time.Parse("2006-01-02 15:04:05", c.Date)
+		commits = append(commits, CommitSummary{
+			ID:     c.Hash,
+			Title:  c.Message,
+			Author: Signature{Name: c.Author, Email: c.Email, Date: when},
+		})
+	}
+	writePage(w, commits, limit, func(CommitSummary) any {
+		return commitsCursor{Tip: cursor.Tip, Offset: cursor.Offset + limit}
+	})
+}
+
+func (h *HTTP) Commit(w http.ResponseWriter, r *http.Request, v wtypes.Vars) {
+	base := wtypes.Base(r)
+	repo := base.Repo
+
+	// Resolve abbreviated IDs and other revisions first, as the view cache
+	// is keyed by full commit IDs.
+	id, err := repo.Client.ResolveRef(r.Context(), repo.Path, "rev", v["commit"])
+	if git2c.IsNotFound(err) {
+		notFound(w, "Commit not found")
+		return
+	} else if err != nil {
+		internalError(w, "resolve commit", err)
+		return
+	}
+	info, err := cachedCommitInfo(r.Context(), base, id, git2c.DiffOptions{IgnoreWhitespace: false})
+	if git2c.IsNotFound(err) {
+		notFound(w, "Commit not found")
+		return
+	} else if err != nil {
+		internalError(w, "commit info", err)
+		return
+	}
+
+	files := make([]FileChange, 0, len(info.Files))
+	for _, f := range info.Files {
+		change := FileChange{
+			Status:    deltaStatuses[f.Status],
+			OldPath:   f.FromPath,
+			NewPath:   f.ToPath,
+			Binary:    f.Binary,
+			Additions: f.Additions,
+			Deletions: f.Deletions,
+		}
+		switch f.Status {
+		case git2c.DeltaAdded:
+			change.OldPath = ""
+		case git2c.DeltaDeleted:
+			change.NewPath = ""
+		}
+		files = append(files, change)
+	}
+	parents := info.Parents
+	if parents == nil {
+		parents = []string{}
+	}
+	writeJSON(w, http.StatusOK, Commit{
+		ID:        info.Hash,
+		Message:   info.Message,
+		Author:    signature(info.AuthorName, info.AuthorEmail, info.AuthorWhen, info.AuthorTZMin),
+		Committer: signature(info.CommitterName, info.CommitterEmail, info.CommitterWhen, info.CommitterTZMin),
+		Parents:   parents,
+		Additions: info.Stats.Additions,
+		Deletions: info.Stats.Deletions,
+		Files:     files,
+	})
+}
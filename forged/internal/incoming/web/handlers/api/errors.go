@@ -0,0 +1,81 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	wtypes "go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/types"
+)
+
+// Error is the body of every reply with an error status.
+type Error struct {
+	Error ErrorDetail `json:"error"`
+}
+
+type ErrorDetail struct {
+	// Code is one of the error codes below, for programs to match on.
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+const (
+	codeBadRequest       = "bad_request"
+	codeForbidden        = "forbidden"
+	codeNotFound         = "not_found"
+	codeMethodNotAllowed = "method_not_allowed"
+	codeInternal         = "internal"
+)
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("failed to write API reply", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, Error{Error: ErrorDetail{Code: code, Message: message}})
+}
+
+func notFound(w http.ResponseWriter, message string) {
+	writeError(w, http.StatusNotFound, codeNotFound, message)
+}
+
+func badRequest(w http.ResponseWriter, message string) {
+	writeError(w, http.StatusBadRequest, codeBadRequest, message)
+}
+
+// internalError logs err and tells the client only that something failed.
+func internalError(w http.ResponseWriter, what string, err error) {
+	slog.Error("API: "+what+" failed", "error", err)
+	writeError(w, http.StatusInternalServerError, codeInternal, "Internal Server Error")
+}
+
+// The functions below render the router's own errors for paths under
+// Prefix; see web.Router.ErrorsUnder.
+
+func BadRequest(w http.ResponseWriter, _ *wtypes.BaseData, msg string) {
+	badRequest(w, msg)
+}
+
+func BadRequestColon(w http.ResponseWriter, _ *wtypes.BaseData) {
+	badRequest(w, "Path segments may not contain colons")
+}
+
+func Forbidden(w http.ResponseWriter, _ *wtypes.BaseData, msg string) {
+	writeError(w, http.StatusForbidden, codeForbidden, msg)
+}
+
+func NotFound(w http.ResponseWriter, _ *wtypes.BaseData) {
+	notFound(w, "Not found")
+}
+
+func ServerError(w http.ResponseWriter, _ *wtypes.BaseData, msg string) {
+	writeError(w, http.StatusInternalServerError, codeInternal, msg)
+}
+
+func MethodNotAllowed(w http.ResponseWriter, _ *wtypes.BaseData) {
+	writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed; see the Allow header")
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"go.lindenii.runxiyu.org/forge/forged/internal/database/queries"
+	wtypes "go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/types"
+)
+
+type Group struct {
+	Name string `json:"name"`
+	// Path is the names of the group's ancestors and then its own.
+	Path        []string `json:"path"`
+	Description string   `json:"description"`
+}
+
+type Repo struct {
+	Name string `json:"name"`
+	// Group is the path of the repository's group.
+	Group       []string `json:"group"`
+	Description string   `json:"description"`
+}
+
+// lookupGroup returns the group at the path of the :group parameter,
+// replying with an error itself and returning false if there is none.
+func lookupGroup(w http.ResponseWriter, r *http.Request) (queries.GetGroupByPathRow, bool) {
+	base := wtypes.Base(r)
+	userID, err := strconv.ParseInt(base.UserID, 10, 64)
+	if err != nil {
+		userID = 0
+	}
+	group, err := base.Global.Queries.GetGroupByPath(r.Context(), queries.GetGroupByPathParams{
+		Column1: base.GroupPath,
+		UserID:  userID,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		notFound(w, "Group not found")
+		return group, false
+	} else if err != nil {
+		internalError(w, "get group by path", err)
+		return group, false
+	}
+	return group, true
+}
+
+// writeGroups replies with a page of the subgroups of parent, or of the
+// top-level groups if parent is nil, whose path is parentPath.
+func writeGroups(w http.ResponseWriter, r *http.Request, parent *int64, parentPath []string) {
+	base := wtypes.Base(r)
+	var cursor afterCursor
+	limit, _, ok := parsePage(w, r, &cursor)
+	if !ok {
+		return
+	}
+	rows, err := base.Global.Queries.GetSubgroupsPage(r.Context(), queries.GetSubgroupsPageParams{
+		ParentGroup: parent,
+		After:       cursor.After,
+		MaxCount:    int32(limit + 1),
+	})
+	if err != nil {
+		internalError(w, "get subgroups", err)
+		return
+	}
+	groups := make([]Group, 0, len(rows))
+	for _, row := range rows {
+		groups = append(groups, Group{
+			Name:        row.Name,
+			Path:        append(slices.Clip(parentPath), row.Name),
+			Description: row.Description,
+		})
+	}
+	writePage(w, groups, limit, func(last Group) any {
+		return afterCursor{After: last.Name}
+	})
+}
+
+func (h *HTTP) RootGroups(w http.ResponseWriter, r *http.Request, _ wtypes.Vars) {
+	writeGroups(w, r, nil, []string{})
+}
+
+func (h *HTTP) Group(w http.ResponseWriter, r *http.Request, _ wtypes.Vars) {
+	group, ok := lookupGroup(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, Group{
+		Name:        group.Name,
+		Path:        wtypes.Base(r).GroupPath,
+		Description: group.Description,
+	})
+}
+
+func (h *HTTP) Subgroups(w http.ResponseWriter, r *http.Request, _ wtypes.Vars) {
+	group, ok := lookupGroup(w, r)
+	if !ok {
+		return
+	}
+	writeGroups(w, r, &group.ID, wtypes.Base(r).GroupPath)
+}
+
+func (h *HTTP) Repos(w http.ResponseWriter, r *http.Request, _ wtypes.Vars) {
+	base := wtypes.Base(r)
+	group, ok := lookupGroup(w, r)
+	if !ok {
+		return
+	}
+	var cursor afterCursor
+	limit, _, ok := parsePage(w, r, &cursor)
+	if !ok {
+		return
+	}
+	rows, err := base.Global.Queries.GetReposInGroupPage(r.Context(), queries.GetReposInGroupPageParams{
+		GroupID:  group.ID,
+		After:    cursor.After,
+		MaxCount: int32(limit + 1),
+	})
+	if err != nil {
+		internalError(w, "get repos in group", err)
+		return
+	}
+	repos := make([]Repo, 0, len(rows))
+	for _, row := range rows {
+		repos = append(repos, Repo{
+			Name:        row.Name,
+			Group:       base.GroupPath,
+			Description: row.Description,
+		})
+	}
+	writePage(w, repos, limit, func(last Repo) any {
+		return afterCursor{After: last.Name}
+	})
+}
+
+func (h *HTTP) Repo(w http.ResponseWriter, r *http.Request, _ wtypes.Vars) {
+	base := wtypes.Base(r)
+	writeJSON(w, http.StatusOK, Repo{
+		Name:        base.Repo.Name,
+		Group:       base.GroupPath,
+		Description: base.Repo.Description,
+	})
+}
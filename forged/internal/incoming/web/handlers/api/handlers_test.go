@@ -0,0 +1,366 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"go.lindenii.runxiyu.org/forge/forged/internal/config"
+	"go.lindenii.runxiyu.org/forge/forged/internal/database/dbtest"
+	"go.lindenii.runxiyu.org/forge/forged/internal/global"
+	wtypes "go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/types"
+	"go.lindenii.runxiyu.org/forge/forged/internal/ipc/git2c"
+	"go.lindenii.runxiyu.org/forge/forged/internal/ipc/git2c/git2dtest"
+	"go.lindenii.runxiyu.org/forge/forged/internal/viewcache"
+)
+
+// server calls the API's handlers like the router would, with the
+// repository "example" served by a fake git2d.
+type server struct {
+	t      *testing.T
+	git    *git2dtest.Repo
+	client *git2c.Client
+	repoID int64
+	global *global.Global
+}
+
+// newServer serves git2dtest.Fixture through a fake git2d.
+func newServer(t *testing.T) *server {
+	t.Helper()
+	srv, err := git2dtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = srv.Close() })
+	repo := git2dtest.Fixture()
+	srv.AddRepo("/repos/example.git", repo)
+	client, err := git2c.NewClient(t.Context(), srv.SocketPath(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	cache, err := viewcache.New(&config.ViewCache{MaxSize: 1 << 24}, nil) //exhaustruct:ignore
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &server{
+		t:      t,
+		git:    repo,
+		client: client,
+		repoID: 1,
+		global: &global.Global{ViewCache: cache}, //exhaustruct:ignore
+	}
+}
+
+// get calls handler for a GET of target under Prefix, from the user with
+// userID if it isn't empty, and decodes the reply into v unless it is an
+// error, which it returns the code of.
+func (s *server) get(handler wtypes.HandlerFunc, target string, groupPath []string, userID string, vars wtypes.Vars, v any) (int, string) {
+	s.t.Helper()
+	req := httptest.NewRequestWithContext(s.t.Context(), http.MethodGet, "/"+Prefix+target, nil)
+	base := &wtypes.BaseData{
+		GroupPath: groupPath,
+		UserID:    userID,
+		Global:    s.global,
+	} //exhaustruct:ignore
+	for _, refType := range []string{"commit", "branch", "tag"} {
+		if name := req.URL.Query().Get(refType); name != "" {
+			base.RefType, base.RefName = refType, name
+		}
+	}
+	base.Repo = &wtypes.Repo{
+		ID:     s.repoID,
+		Name:   "example",
+		Client: s.client,
+		Path:   "/repos/example.git",
+	} //exhaustruct:ignore
+	req = req.WithContext(wtypes.WithBaseData(req.Context(), base))
+	w := httptest.NewRecorder()
+	handler(w, req, vars)
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		s.t.Fatalf("%s: replied with %s", target, ct)
+	}
+	if w.Code != http.StatusOK {
+		var e Error
+		if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil {
+			s.t.Fatalf("%s: error reply %q: %v", target, w.Body.String(), err)
+		}
+		return w.Code, e.Error.Code
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		s.t.Fatalf("%s: %v", target, err)
+	}
+	return w.Code, ""
+}
+
+// repoGet is get for an endpoint under the repository.
+func (s *server) repoGet(handler wtypes.HandlerFunc, target string, vars wtypes.Vars, v any) (int, string) {
+	s.t.Helper()
+	return s.get(handler, "groups/g/repos/example/"+target, []string{"g"}, "", vars, v)
+}
+
+// pages follows the next cursors of a paginated endpoint from target,
+// returning every page.
+func pages[T any](s *server, handler wtypes.HandlerFunc, target string) [][]T {
+	s.t.Helper()
+	var all [][]T
+	cursor := ""
+	for {
+		t := target
+		if cursor != "" {
+			sep := "?"
+			if strings.Contains(t, "?") {
+				sep = "&"
+			}
+			t += sep + "cursor=" + url.QueryEscape(cursor)
+		}
+		var p Page[T]
+		if code, errCode := s.repoGet(handler, t, nil, &p); code != http.StatusOK {
+			s.t.Fatalf("%s: got %d %s", t, code, errCode)
+		}
+		all = append(all, p.Items)
+		if p.NextCursor == "" {
+			return all
+		}
+		if len(all) > 100 {
+			s.t.Fatalf("%s: too many pages", target)
+		}
+		cursor = p.NextCursor
+	}
+}
+
+func TestRefs(t *testing.T) {
+	t.Parallel()
+	s := newServer(t)
+	h := NewHTTP()
+
+	branches := pages[Ref](s, h.Branches, "branches?limit=1")
+	want := [][]Ref{
+		{{Name: "feature", Commit: s.git.Ref("refs/heads/feature")}},
+		{{Name: "master", Commit: s.git.Ref("refs/heads/master")}},
+	}
+	if !slices.EqualFunc(branches, want, slices.Equal) {
+		t.Errorf("got branches %v, want %v", branches, want)
+	}
+	tags := pages[Ref](s, h.Tags, "tags")
+	if len(tags) != 1 || !slices.Equal(tags[0], []Ref{{Name: "v0.1", Commit: s.git.Ref("refs/tags/v0.1")}}) {
+		t.Errorf("got tags %v", tags)
+	}
+
+	var p Page[Ref]
+	if code, errCode := s.repoGet(h.Branches, "branches?limit=0", nil, &p); code != http.StatusBadRequest || errCode != codeBadRequest {
+		t.Errorf("limit=0: got %d %s", code, errCode)
+	}
+}
+
+func TestCommits(t *testing.T) {
+	t.Parallel()
+	s := newServer(t)
+	h := NewHTTP()
+	tip := s.git.Ref("refs/heads/master")
+
+	var first Page[CommitSummary]
+	if code, _ := s.repoGet(h.Commits, "commits?branch=master&limit=3", nil, &first); code != http.StatusOK {
+		t.Fatalf("got %d", code)
+	}
+	if len(first.Items) != 3 || first.Items[0].ID != tip || first.Items[0].Title != "Rename LICENSE to COPYING" || first.NextCursor == "" {
+		t.Fatalf("first page: got %+v", first)
+	}
+
+	// The cursor continues the history it started on, even once the
+	// branch has moved.
+	s.git.CommitFiles("master", "Push a commit\n", map[string][]byte{"pushed": []byte("pushed\n")})
+	var second Page[CommitSummary]
+	if code, _ := s.repoGet(h.Commits, "commits?branch=master&limit=3&cursor="+first.NextCursor, nil, &second); code != http.StatusOK {
+		t.Fatalf("second page: got %d", code)
+	}
+	if len(second.Items) != 1 || second.Items[0].Title != "Initial commit" || second.NextCursor != "" {
+		t.Errorf("second page: got %+v", second)
+	}
+
+	tests := []struct {
+		name, target string
+		code         int
+		errCode      string
+	}{
+		{"missing branch", "commits?branch=missing", http.StatusNotFound, codeNotFound},
+		{"garbled cursor", "commits?cursor=!!", http.StatusBadRequest, codeBadRequest},
+		{"cursor without a tip", "commits?cursor=" + nextCursor(commitsCursor{Offset: 1}), http.StatusBadRequest, codeBadRequest},
+		{"cursor past the end", "commits?cursor=" + nextCursor(commitsCursor{Tip: tip, Offset: 10}), http.StatusBadRequest, codeBadRequest},
+		{"negative offset", "commits?cursor=" + nextCursor(commitsCursor{Tip: tip, Offset: -1}), http.StatusBadRequest, codeBadRequest},
+		{"cursor at a missing commit", "commits?cursor=" + nextCursor(commitsCursor{Tip: strings.Repeat("0", 40)}), http.StatusBadRequest, codeBadRequest},
+	}
+	for _, tt := range tests {
+		var p Page[CommitSummary]
+		if code, errCode := s.repoGet(h.Commits, tt.target, nil, &p); code != tt.code || errCode != tt.errCode {
+			t.Errorf("%s: got %d %s, want %d %s", tt.name, code, errCode, tt.code, tt.errCode)
+		}
+	}
+}
+
+func TestCommit(t *testing.T) {
+	t.Parallel()
+	s := newServer(t)
+	h := NewHTTP()
+	tag := s.git.Ref("refs/tags/v0.1")
+
+	var c Commit
+	if code, _ := s.repoGet(h.Commit, "commits/"+tag[:7], wtypes.Vars{"commit": tag[:7]}, &c); code != http.StatusOK {
+		t.Fatalf("abbreviated ID: got %d", code)
+	}
+	if c.ID != tag || !strings.HasPrefix(c.Message, "Add the program") || len(c.Parents) != 1 || c.Additions != 7 {
+		t.Errorf("got %+v", c)
+	}
+	for _, f := range c.Files {
+		if f.Status != "added" || f.OldPath != "" || f.NewPath == "" {
+			t.Errorf("added file: got %+v", f)
+		}
+	}
+
+	var root Commit
+	s.repoGet(h.Commit, "commits/"+tag+"~1", wtypes.Vars{"commit": tag + "~1"}, &root)
+	if root.Message != "Initial commit\n" || root.Parents == nil || len(root.Parents) != 0 {
+		t.Errorf("root commit: got %+v", root)
+	}
+
+	for _, id := range []string{strings.Repeat("0", 40), "missing"} {
+		if code, errCode := s.repoGet(h.Commit, "commits/"+id, wtypes.Vars{"commit": id}, &c); code != http.StatusNotFound || errCode != codeNotFound {
+			t.Errorf("%s: got %d %s", id, code, errCode)
+		}
+	}
+}
+
+func TestTreeAndBlob(t *testing.T) {
+	t.Parallel()
+	s := newServer(t)
+	h := NewHTTP()
+	big := bytes.Repeat([]byte("x"), maxBlobSize+1)
+	s.git.CommitFiles("master", "Add a big file\n", map[string][]byte{"big": big})
+	tip := s.git.Ref("refs/heads/master")
+
+	var tree Tree
+	if code, _ := s.repoGet(h.Tree, "tree/cmd", wtypes.Vars{"path": "cmd"}, &tree); code != http.StatusOK {
+		t.Fatalf("tree: got %d", code)
+	}
+	if tree.Commit != tip || tree.Path != "cmd" || len(tree.Entries) != 1 || tree.Entries[0] != (TreeEntry{Name: "hello", Type: "tree", Mode: "040000", Size: 0}) {
+		t.Errorf("tree: got %+v", tree)
+	}
+
+	var blob Blob
+	if code, _ := s.repoGet(h.Blob, "blobs/README.md?tag=v0.1", wtypes.Vars{"path": "README.md"}, &blob); code != http.StatusOK {
+		t.Fatalf("blob: got %d", code)
+	}
+	tag := s.git.Ref("refs/tags/v0.1")
+	if blob.Commit != tag || blob.Binary || !bytes.HasPrefix(blob.Content, []byte("# Example")) || int(blob.Size) != len(blob.Content) ||
+		blob.RawURL != "/g/-/repos/example/raw/README.md?commit="+tag {
+		t.Errorf("blob: got %+v", blob)
+	}
+	blob = Blob{} //exhaustruct:ignore
+	s.repoGet(h.Blob, "blobs/big", wtypes.Vars{"path": "big"}, &blob)
+	if blob.Size != uint64(len(big)) || blob.Content != nil {
+		t.Errorf("big blob: got %d bytes of %d", len(blob.Content), blob.Size)
+	}
+
+	tests := []struct {
+		name    string
+		handler wtypes.HandlerFunc
+		target  string
+		path    string
+	}{
+		{"tree of a file", h.Tree, "tree/README.md", "README.md"},
+		{"blob of a directory", h.Blob, "blobs/cmd", "cmd"},
+		{"missing path", h.Blob, "blobs/missing", "missing"},
+		{"missing ref", h.Tree, "tree/?branch=missing", ""},
+	}
+	for _, tt := range tests {
+		if code, errCode := s.repoGet(tt.handler, tt.target, wtypes.Vars{"path": tt.path}, &tree); code != http.StatusNotFound || errCode != codeNotFound {
+			t.Errorf("%s: got %d %s", tt.name, code, errCode)
+		}
+	}
+}
+
+func TestGroupsAndMergeRequests(t *testing.T) {
+	t.Parallel()
+	db, q := dbtest.New(t)
+	s := newServer(t)
+	s.global.Queries, s.global.DB = q, db
+	h := NewHTTP()
+	var repoID int64
+	err := db.QueryRow(t.Context(), `
+		WITH n AS (INSERT INTO storage_nodes (name) VALUES ('default') RETURNING name),
+		a AS (INSERT INTO groups (name, description) VALUES ('a', 'First') RETURNING id),
+		b AS (INSERT INTO groups (name) VALUES ('b') RETURNING id),
+		c AS (INSERT INTO groups (name) VALUES ('c') RETURNING id),
+		sub AS (INSERT INTO groups (name, parent_group) SELECT 'sub', id FROM a RETURNING id)
+		INSERT INTO repos (group_id, name, description, contrib_requirements, storage_node)
+		SELECT a.id, 'example', 'An example', 'open', n.name FROM a, n RETURNING id`).Scan(&repoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(t.Context(), `
+		WITH u AS (INSERT INTO users (username, type) VALUES ('alice', 'registered') RETURNING id)
+		INSERT INTO merge_requests (repo_id, repo_local_id, title, creator, source_repo, source_ref, status)
+		SELECT $1, n, 'Change ' || n, u.id, $1, 'refs/heads/feature', 'open' FROM u, generate_series(1, 3) n`, repoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var roots Page[Group]
+	if code, _ := s.get(h.RootGroups, "groups?limit=2", []string{}, "", nil, &roots); code != http.StatusOK {
+		t.Fatalf("root groups: got %d", code)
+	}
+	if len(roots.Items) != 2 || roots.Items[0].Name != "a" || roots.Items[0].Description != "First" || roots.NextCursor == "" {
+		t.Fatalf("root groups: got %+v", roots)
+	}
+	s.get(h.RootGroups, "groups?limit=2&cursor="+roots.NextCursor, []string{}, "", nil, &roots)
+	if len(roots.Items) != 1 || roots.Items[0].Name != "c" || roots.NextCursor != "" {
+		t.Errorf("second page of root groups: got %+v", roots)
+	}
+
+	var group Group
+	s.get(h.Group, "groups/a", []string{"a"}, "", nil, &group)
+	if group.Name != "a" || !slices.Equal(group.Path, []string{"a"}) {
+		t.Errorf("group: got %+v", group)
+	}
+	var subgroups Page[Group]
+	s.get(h.Subgroups, "groups/a/subgroups", []string{"a"}, "", nil, &subgroups)
+	if len(subgroups.Items) != 1 || !slices.Equal(subgroups.Items[0].Path, []string{"a", "sub"}) {
+		t.Errorf("subgroups: got %+v", subgroups)
+	}
+	var repos Page[Repo]
+	s.get(h.Repos, "groups/a/repos", []string{"a"}, "", nil, &repos)
+	if len(repos.Items) != 1 || repos.Items[0].Name != "example" || repos.Items[0].Description != "An example" {
+		t.Errorf("repos: got %+v", repos)
+	}
+	for _, path := range [][]string{{"missing"}, {"a", "missing"}, {"sub"}} {
+		if code, errCode := s.get(h.Group, "groups/"+strings.Join(path, "%2F"), path, "", nil, &group); code != http.StatusNotFound || errCode != codeNotFound {
+			t.Errorf("%v: got %d %s", path, code, errCode)
+		}
+	}
+
+	s.repoID = repoID
+	var ids []int64
+	for _, page := range pages[MergeRequest](s, h.MergeRequests, "merge-requests?limit=2") {
+		for _, mr := range page {
+			ids = append(ids, mr.ID)
+		}
+	}
+	if !slices.Equal(ids, []int64{3, 2, 1}) {
+		t.Errorf("got merge requests %v, want newest first", ids)
+	}
+	var mr MergeRequest
+	s.repoGet(h.MergeRequest, "merge-requests/2", wtypes.Vars{"mr": "2"}, &mr)
+	if mr.Title != "Change 2" || mr.Status != "open" || mr.Creator == nil || *mr.Creator != "alice" || mr.DestinationBranch != nil ||
+		!slices.Equal(mr.Source.Group, []string{"a"}) || mr.Source.Repo != "example" || mr.Source.Ref != "refs/heads/feature" {
+		t.Errorf("got %+v", mr)
+	}
+	for _, id := range []string{"4", "x"} {
+		if code, errCode := s.repoGet(h.MergeRequest, "merge-requests/"+id, wtypes.Vars{"mr": id}, &mr); code != http.StatusNotFound || errCode != codeNotFound {
+			t.Errorf("merge request %s: got %d %s", id, code, errCode)
+		}
+	}
+}
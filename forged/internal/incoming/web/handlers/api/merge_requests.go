@@ -0,0 +1,106 @@
+package api
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"go.lindenii.runxiyu.org/forge/forged/internal/database/queries"
+	wtypes "go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/types"
+)
+
+type MergeRequest struct {
+	// ID is the merge request's number within its repository.
+	ID    int64  `json:"id"`
+	Title string `json:"title"`
+	// Status is "open", "merged" or "closed".
+	Status string `json:"status"`
+	// Creator is the username of whoever opened the merge request, or null
+	// if they have since been deleted or have no username.
+	Creator *string            `json:"creator"`
+	Source  MergeRequestSource `json:"source"`
+	// DestinationBranch is null if none was chosen.
+	DestinationBranch *string `json:"destination_branch"`
+}
+
+// MergeRequestSource is where the changes of a merge request are.
+type MergeRequestSource struct {
+	Group []string `json:"group"`
+	Repo  string   `json:"repo"`
+	Ref   string   `json:"ref"`
+}
+
+// mergeRequestsCursor is the ID of the last merge request on a page.
+type mergeRequestsCursor struct {
+	Before int64 `json:"b"`
+}
+
+func (h *HTTP) MergeRequests(w http.ResponseWriter, r *http.Request, _ wtypes.Vars) {
+	base := wtypes.Base(r)
+	cursor := mergeRequestsCursor{Before: math.MaxInt64}
+	limit, _, ok := parsePage(w, r, &cursor)
+	if !ok {
+		return
+	}
+	rows, err := base.Global.Queries.GetMergeRequestsPage(r.Context(), queries.GetMergeRequestsPageParams{
+		RepoID:   base.Repo.ID,
+		Before:   cursor.Before,
+		MaxCount: int32(limit + 1),
+	})
+	if err != nil {
+		internalError(w, "get merge requests", err)
+		return
+	}
+	mrs := make([]MergeRequest, 0, len(rows))
+	for _, row := range rows {
+		mrs = append(mrs, MergeRequest{
+			ID:      row.RepoLocalID,
+			Title:   row.Title,
+			Status:  row.Status,
+			Creator: row.Creator,
+			Source: MergeRequestSource{
+				Group: row.SourceGroupPath,
+				Repo:  row.SourceRepoName,
+				Ref:   row.SourceRef,
+			},
+			DestinationBranch: row.DestinationBranch,
+		})
+	}
+	writePage(w, mrs, limit, func(last MergeRequest) any {
+		return mergeRequestsCursor{Before: last.ID}
+	})
+}
+
+func (h *HTTP) MergeRequest(w http.ResponseWriter, r *http.Request, v wtypes.Vars) {
+	base := wtypes.Base(r)
+	id, err := strconv.ParseInt(v["mr"], 10, 64)
+	if err != nil {
+		notFound(w, "Merge request not found")
+		return
+	}
+	row, err := base.Global.Queries.GetMergeRequest(r.Context(), queries.GetMergeRequestParams{
+		RepoID:      base.Repo.ID,
+		RepoLocalID: id,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		notFound(w, "Merge request not found")
+		return
+	} else if err != nil {
+		internalError(w, "get merge request", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, MergeRequest{
+		ID:      row.RepoLocalID,
+		Title:   row.Title,
+		Status:  row.Status,
+		Creator: row.Creator,
+		Source: MergeRequestSource{
+			Group: row.SourceGroupPath,
+			Repo:  row.SourceRepoName,
+			Ref:   row.SourceRef,
+		},
+		DestinationBranch: row.DestinationBranch,
+	})
+}
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"encoding/json"
+	"reflect"
+	"runtime"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// pathParams describes the parameters that patterns may have.
+var pathParams = map[string]string{
+	"group":  "The path of a group, with each slash escaped as %2F",
+	"repo":   "The name of a repository in the group",
+	"commit": "A commit ID, which may be abbreviated, or any other revision",
+	"path":   "A path in the tree, which may contain slashes; omit it with its preceding slash for the root",
+	"mr":     "The number of a merge request within the repository",
+}
+
+// openAPIDocument describes endpoints in OpenAPI 3.0, with the schemas of
+// their replies derived from the types of their Response values.
+func openAPIDocument(endpoints []Endpoint) []byte {
+	schemas := map[string]any{}
+	errorSchema := schemaOf(reflect.TypeOf(Error{}), schemas)
+
+	paths := map[string]map[string]any{}
+	for _, e := range endpoints {
+		segs := strings.Split(e.Pattern, "/")
+		params := []any{}
+		for i, seg := range segs {
+			if seg[0] != ':' && seg[0] != '*' {
+				continue
+			}
+			name := seg[1:]
+			segs[i] = "{" + name + "}"
+			params = append(params, map[string]any{
+				"name":        name,
+				"in":          "path",
+				"required":    true,
+				"description": pathParams[name],
+				"schema":      map[string]any{"type": "string"},
+			})
+		}
+		if e.Ref {
+			for _, name := range []string{"commit", "branch", "tag"} {
+				params = append(params, map[string]any{
+					"name":        name,
+					"in":          "query",
+					"description": "Use this " + name + " rather than HEAD; at most one of commit, branch and tag may be given",
+					"schema":      map[string]any{"type": "string"},
+				})
+			}
+		}
+
+		response := schemaOf(reflect.TypeOf(e.Response), schemas)
+		if e.Paginated {
+			params = append(params, map[string]any{
+				"name":        "cursor",
+				"in":          "query",
+				"description": "The next_cursor of the previous page",
+				"schema":      map[string]any{"type": "string"},
+			}, map[string]any{
+				"name":        "limit",
+				"in":          "query",
+				"description": "How many items to return at most",
+				"schema": map[string]any{
+					"type":    "integer",
+					"minimum": 1,
+					"maximum": maxPageSize,
+					"default": defaultPageSize,
+				},
+			})
+			name := reflect.TypeOf(e.Response).Name() + "Page"
+			schemas[name] = map[string]any{
+				"type":     "object",
+				"required": []string{"items"},
+				"properties": map[string]any{
+					"items":       map[string]any{"type": "array", "items": response},
+					"next_cursor": map[string]any{"type": "string", "description": "Absent on the last page"},
+				},
+			}
+			response = schemaRef(name)
+		}
+
+		path := "/" + Prefix + strings.Join(segs, "/")
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path][strings.ToLower(e.Method)] = map[string]any{
+			"operationId": operationID(e),
+			"summary":     e.Summary,
+			"parameters":  params,
+			"responses": map[string]any{
+				"200": map[string]any{
+					"description": "Success",
+					"content":     map[string]any{"application/json": map[string]any{"schema": response}},
+				},
+				"default": map[string]any{
+					"description": "Error",
+					"content":     map[string]any{"application/json": map[string]any{"schema": errorSchema}},
+				},
+			},
+		}
+	}
+	paths["/"+Prefix+"openapi.json"] = map[string]any{
+		"get": map[string]any{
+			"operationId": "openAPI",
+			"summary":     "Get this description of the API",
+			"responses": map[string]any{
+				"200": map[string]any{
+					"description": "Success",
+					"content":     map[string]any{"application/json": map[string]any{"schema": map[string]any{"type": "object"}}},
+				},
+			},
+		},
+	}
+
+	doc, err := json.Marshal(map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "Lindenii Forge API",
+			"version": "1",
+		},
+		"paths":      paths,
+		"components": map[string]any{"schemas": schemas},
+	})
+	if err != nil {
+		panic(err) // only holds maps, slices, strings and numbers
+	}
+	return doc
+}
+
+// operationID is the name of the endpoint's handler method, in lower camel
+// case.
+func operationID(e Endpoint) string {
+	name := runtime.FuncForPC(reflect.ValueOf(e.Handler).Pointer()).Name()
+	name = strings.TrimSuffix(name[strings.LastIndexByte(name, '.')+1:], "-fm")
+	r, n := utf8.DecodeRuneInString(name)
+	return string(unicode.ToLower(r)) + name[n:]
+}
+
+func schemaRef(name string) map[string]any {
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+// schemaOf returns the schema of the JSON encoding of t, adding the
+// schemas of any named struct types it refers to to schemas.
+func schemaOf(t reflect.Type, schemas map[string]any) map[string]any {
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		elem := schemaOf(t.Elem(), schemas)
+		if _, ok := elem["$ref"]; ok {
+			return map[string]any{"allOf": []any{elem}, "nullable": true}
+		}
+		elem["nullable"] = true
+		return elem
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte", "nullable": true}
+		}
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64", "minimum": 0}
+	case reflect.Struct:
+		name := t.Name()
+		if _, ok := schemas[name]; ok {
+			return schemaRef(name)
+		}
+		schemas[name] = nil // for recursive types
+		properties := map[string]any{}
+		required := []string{}
+		for i := range t.NumField() {
+			field := t.Field(i)
+			tag, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if !field.IsExported() || tag == "-" {
+				continue
+			}
+			if tag == "" {
+				tag = field.Name
+			}
+			properties[tag] = schemaOf(field.Type, schemas)
+			if opts != "omitempty" {
+				required = append(required, tag)
+			}
+		}
+		schemas[name] = map[string]any{
+			"type":       "object",
+			"required":   required,
+			"properties": properties,
+		}
+		return schemaRef(name)
+	default:
+		panic("api: no schema for " + t.String())
+	}
+}
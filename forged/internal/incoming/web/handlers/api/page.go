@@ -0,0 +1,74 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
+)
+
+// Page is the reply of paginated endpoints. NextCursor, which is opaque to
+// clients, is passed as ?cursor= to get the next page, and is empty on the
+// last page.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// parsePage parses the ?cursor= and ?limit= of a request to a paginated
+// endpoint. The cursor, if there is one, is decoded into cursor, which is
+// whatever the endpoint encoded into the last page's NextCursor. It
+// replies with an error itself, returning false, if either is invalid.
+func parsePage(w http.ResponseWriter, r *http.Request, cursor any) (limit int, hasCursor, ok bool) {
+	q := r.URL.Query()
+	limit = defaultPageSize
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxPageSize {
+			badRequest(w, "Limit must be between 1 and "+strconv.Itoa(maxPageSize))
+			return 0, false, false
+		}
+		limit = n
+	}
+	s := q.Get("cursor")
+	if s == "" {
+		return limit, false, true
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(raw, cursor) != nil {
+		badRequest(w, "Invalid cursor")
+		return 0, false, false
+	}
+	return limit, true, true
+}
+
+// nextCursor encodes cursor for Page.NextCursor.
+func nextCursor(cursor any) string {
+	raw, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// afterCursor is the cursor of endpoints listing things by name.
+type afterCursor struct {
+	After string `json:"a"`
+}
+
+// writePage replies with items, of which there may be one more than limit
+// to tell whether there is another page; next returns the cursor for the
+// page after the given last item.
+func writePage[T any](w http.ResponseWriter, items []T, limit int, next func(last T) any) {
+	p := Page[T]{Items: items, NextCursor: ""}
+	if len(items) > limit {
+		p.Items = items[:limit]
+		p.NextCursor = nextCursor(next(p.Items[limit-1]))
+	}
+	if p.Items == nil {
+		p.Items = []T{}
+	}
+	writeJSON(w, http.StatusOK, p)
+}
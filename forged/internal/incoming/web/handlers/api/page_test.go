@@ -0,0 +1,83 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestParsePage(t *testing.T) {
+	t.Parallel()
+	valid := nextCursor(afterCursor{After: "main"})
+	tests := []struct {
+		query     string
+		limit     int
+		hasCursor bool
+		after     string
+		ok        bool
+	}{
+		{"", defaultPageSize, false, "", true},
+		{"limit=1", 1, false, "", true},
+		{"limit=100", maxPageSize, false, "", true},
+		{"limit=101", 0, false, "", false},
+		{"limit=0", 0, false, "", false},
+		{"limit=-1", 0, false, "", false},
+		{"limit=ten", 0, false, "", false},
+		{"cursor=" + valid, defaultPageSize, true, "main", true},
+		{"limit=5&cursor=" + valid, 5, true, "main", true},
+		{"cursor=" + valid + "=", 0, false, "", false},
+		{"cursor=bm90IGpzb24", 0, false, "", false}, // "not json"
+		{"cursor=!!", 0, false, "", false},
+		{"limit=200&cursor=" + valid, 0, false, "", false},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/?"+tt.query, nil)
+		var cursor afterCursor
+		limit, hasCursor, ok := parsePage(w, r, &cursor)
+		if ok != tt.ok || limit != tt.limit || hasCursor != tt.hasCursor || cursor.After != tt.after {
+			t.Errorf("parsePage(%q) = %d, %v, %v with %+v; want %d, %v, %v with after %q",
+				tt.query, limit, hasCursor, ok, cursor, tt.limit, tt.hasCursor, tt.ok, tt.after)
+		}
+		if !ok && w.Code != http.StatusBadRequest {
+			t.Errorf("parsePage(%q) replied %d, want 400", tt.query, w.Code)
+		}
+	}
+}
+
+func TestWritePage(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		items []string
+		limit int
+		want  []string
+		next  string
+	}{
+		{nil, 3, []string{}, ""},
+		{[]string{"a", "b"}, 3, []string{"a", "b"}, ""},
+		{[]string{"a", "b", "c"}, 3, []string{"a", "b", "c"}, ""},
+		{[]string{"a", "b", "c", "d"}, 3, []string{"a", "b", "c"}, "c"},
+		{[]string{"a", "b"}, 1, []string{"a"}, "a"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		writePage(w, tt.items, tt.limit, func(last string) any { return afterCursor{After: last} })
+
+		var page Page[string]
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatalf("writePage(%q, %d) wrote %q: %v", tt.items, tt.limit, w.Body, err)
+		}
+		if page.Items == nil || !slices.Equal(page.Items, tt.want) {
+			t.Errorf("writePage(%q, %d) wrote items %#v, want %q", tt.items, tt.limit, page.Items, tt.want)
+		}
+		wantNext := ""
+		if tt.next != "" {
+			wantNext = nextCursor(afterCursor{After: tt.next})
+		}
+		if page.NextCursor != wantNext {
+			t.Errorf("writePage(%q, %d) wrote cursor %q, want %q", tt.items, tt.limit, page.NextCursor, wantNext)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sort"
+
+	wtypes "go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/types"
+	"go.lindenii.runxiyu.org/forge/forged/internal/ipc/git2c"
+)
+
+type Ref struct {
+	Name string `json:"name"`
+	// Commit is what the ref points to, through any annotated tags.
+	Commit string `json:"commit"`
+}
+
+// pageOfNames returns up to limit of the sorted names after the cursor's,
+// and whether there are more.
+func pageOfNames(names []string, cursor afterCursor, limit int) ([]string, bool) {
+	start := sort.SearchStrings(names, cursor.After)
+	if start < len(names) && names[start] == cursor.After {
+		start++
+	}
+	names = names[start:]
+	if len(names) > limit {
+		return names[:limit], true
+	}
+	return names, false
+}
+
+// writeRefs replies with refs, the page for names; if there are more, the
+// next page is after the last of names.
+func writeRefs(w http.ResponseWriter, refs []Ref, names []string, more bool) {
+	p := Page[Ref]{Items: refs, NextCursor: ""}
+	if more {
+		p.NextCursor = nextCursor(afterCursor{After: names[len(names)-1]})
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+func (h *HTTP) Branches(w http.ResponseWriter, r *http.Request, _ wtypes.Vars) {
+	base := wtypes.Base(r)
+	repo := base.Repo
+	var cursor afterCursor
+	limit, _, ok := parsePage(w, r, &cursor)
+	if !ok {
+		return
+	}
+	names, err := repo.Client.ListBranches(r.Context(), repo.Path)
+	if err != nil {
+		internalError(w, "list branches", err)
+		return
+	}
+	sort.Strings(names)
+	names, more := pageOfNames(names, cursor, limit)
+
+	refs := make([]Ref, 0, len(names))
+	for _, name := range names {
+		commit, err := base.Global.ViewCache.Resolve(r.Context(), repo.ID, "branch", name, func(ctx context.Context) (string, error) {
+			return repo.Client.ResolveRef(ctx, repo.Path, "branch", name)
+		})
+		if git2c.IsNotFound(err) {
+			continue // deleted since it was listed
+		} else if err != nil {
+			internalError(w, "resolve branch", err)
+			return
+		}
+		refs = append(refs, Ref{Name: name, Commit: commit})
+	}
+	writeRefs(w, refs, names, more)
+}
+
+func (h *HTTP) Tags(w http.ResponseWriter, r *http.Request, _ wtypes.Vars) {
+	repo := wtypes.Base(r).Repo
+	var cursor afterCursor
+	limit, _, ok := parsePage(w, r, &cursor)
+	if !ok {
+		return
+	}
+	tags, err := repo.Client.ListTags(r.Context(), repo.Path)
+	if err != nil {
+		internalError(w, "list tags", err)
+		return
+	}
+	commits := make(map[string]string, len(tags))
+	names := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		commits[tag.Name] = tag.ID
+		names = append(names, tag.Name)
+	}
+	sort.Strings(names)
+	names, more := pageOfNames(names, cursor, limit)
+
+	refs := make([]Ref, 0, len(names))
+	for _, name := range names {
+		refs = append(refs, Ref{Name: name, Commit: commits[name]})
+	}
+	writeRefs(w, refs, names, more)
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"go.lindenii.runxiyu.org/forge/forged/internal/common/misc"
+	wtypes "go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/types"
+	"go.lindenii.runxiyu.org/forge/forged/internal/ipc/git2c"
+)
+
+// maxBlobSize is the largest file whose content is included in a Blob;
+// larger ones are only available raw.
+const maxBlobSize = 1 << 20
+
+type Tree struct {
+	Commit  string      `json:"commit"`
+	Path    string      `json:"path"`
+	Entries []TreeEntry `json:"entries"`
+}
+
+type TreeEntry struct {
+	Name string `json:"name"`
+	// Type is "tree", "blob" or, for submodules, "commit".
+	Type string `json:"type"`
+	Mode string `json:"mode"`
+	Size uint64 `json:"size"`
+}
+
+type Blob struct {
+	Commit string `json:"commit"`
+	Path   string `json:"path"`
+	Size   uint64 `json:"size"`
+	Binary bool   `json:"binary"`
+	// Content is null for files larger than 1 MiB, which can be fetched
+	// from RawURL instead.
+	Content []byte `json:"content"`
+	RawURL  string `json:"raw_url"`
+}
+
+// lookupPath returns the directory or file at the :path of a request,
+// replying with an error itself and returning false if there is none.
+func lookupPath(w http.ResponseWriter, r *http.Request, v wtypes.Vars) (rev, pathSpec string, files []git2c.TreeEntry, blob *git2c.Blob, ok bool) {
+	base := wtypes.Base(r)
+	pathSpec = strings.Trim(v["path"], "/")
+	rev, ok = resolveRequestRev(w, r)
+	if !ok {
+		return "", "", nil, nil, false
+	}
+	files, blob, err := cachedTreeRaw(r.Context(), base, rev, pathSpec)
+	if git2c.IsNotFound(err) {
+		notFound(w, "Path not found")
+		return "", "", nil, nil, false
+	} else if err != nil {
+		internalError(w, "tree", err)
+		return "", "", nil, nil, false
+	}
+	return rev, pathSpec, files, blob, true
+}
+
+func (h *HTTP) Tree(w http.ResponseWriter, r *http.Request, v wtypes.Vars) {
+	rev, pathSpec, files, _, ok := lookupPath(w, r, v)
+	if !ok {
+		return
+	}
+	if files == nil {
+		notFound(w, "Path is a file, not a directory")
+		return
+	}
+	entries := make([]TreeEntry, 0, len(files))
+	for _, f := range files {
+		typ := "commit"
+		switch {
+		case f.IsSubtree:
+			typ = "tree"
+		case f.IsFile:
+			typ = "blob"
+		}
+		entries = append(entries, TreeEntry{Name: f.Name, Type: typ, Mode: f.Mode, Size: f.Size})
+	}
+	writeJSON(w, http.StatusOK, Tree{Commit: rev, Path: pathSpec, Entries: entries})
+}
+
+func (h *HTTP) Blob(w http.ResponseWriter, r *http.Request, v wtypes.Vars) {
+	base := wtypes.Base(r)
+	rev, pathSpec, _, blob, ok := lookupPath(w, r, v)
+	if !ok {
+		return
+	}
+	if blob == nil {
+		notFound(w, "Path is a directory, not a file")
+		return
+	}
+	reply := Blob{
+		Commit:  rev,
+		Path:    pathSpec,
+		Size:    blob.Size,
+		Binary:  blob.Binary,
+		Content: nil,
+		RawURL: "/" + misc.SegmentsToURL(slices.Clone(base.GroupPath)) + "/-/repos/" + url.PathEscape(base.Repo.Name) +
+			"/raw/" + misc.SegmentsToURL(strings.Split(pathSpec, "/")) + "?commit=" + rev,
+	}
+	if blob.Size <= maxBlobSize {
+		content, err := io.ReadAll(blob)
+		if err != nil {
+			internalError(w, "read blob", err)
+			return
+		}
+		reply.Content = content
+	}
+	writeJSON(w, http.StatusOK, reply)
+}
@@ -1,17 +1,14 @@
 package handlers
 
 import (
-	"fmt"
 	"log/slog"
 	"net/http"
-	"path/filepath"
 	"strconv"
 
 	"github.com/jackc/pgx/v5"
 	"go.lindenii.runxiyu.org/forge/forged/internal/database/queries"
 	"go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/templates"
 	wtypes "go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/types"
-	"go.lindenii.runxiyu.org/forge/forged/internal/ipc/git2c"
 )
 
 type GroupHTTP struct {
@@ -119,11 +116,18 @@ func (h *GroupHTTP) Post(w http.ResponseWriter, r *http.Request, _ wtypes.Vars)
 	if desc != "" {
 		descPtr = &desc
 	}
+	node, err := base.Global.Storage.Place(r.Context(), txq)
+	if err != nil {
+		slog.Error("place repo failed", "error", err)
+		http.Error(w, "Failed to create repository", http.StatusInternalServerError)
+		return
+	}
 	repoID, err := txq.InsertRepo(r.Context(), queries.InsertRepoParams{
 		GroupID:             p.ID,
 		Name:                name,
 		Description:         descPtr,
 		ContribRequirements: contrib,
+		StorageNode:         node.Name,
 	})
 	if err != nil {
 		slog.Error("insert repo failed", "error", err)
@@ -131,16 +135,16 @@ func (h *GroupHTTP) Post(w http.ResponseWriter, r *http.Request, _ wtypes.Vars)
 		return
 	}
 
-	repoPath := filepath.Join(base.Global.Config.Git.RepoDir, fmt.Sprintf("%d.git", repoID))
+	repoPath := node.RepoPath(repoID)
 
-	gitc, err := git2c.NewClient(r.Context(), base.Global.Config.Git.Socket)
+	gitc, err := node.Client(r.Context())
 	if err != nil {
 		slog.Error("git2d connect failed", "error", err)
 		http.Error(w, "Failed to initialize repository (backend)", http.StatusInternalServerError)
 		return
 	}
 	defer func() { _ = gitc.Close() }()
-	if err = gitc.InitRepo(repoPath, base.Global.Config.Hooks.Execs); err != nil {
+	if err = gitc.InitRepo(r.Context(), repoPath, base.Global.Config.Hooks.Execs); err != nil {
 		slog.Error("git2d init failed", "error", err)
 		http.Error(w, "Failed to initialize repository", http.StatusInternalServerError)
 		return
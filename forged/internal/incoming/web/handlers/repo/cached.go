@@ -0,0 +1,81 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	wtypes "go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/types"
+	"go.lindenii.runxiyu.org/forge/forged/internal/ipc/git2c"
+	"go.lindenii.runxiyu.org/forge/forged/internal/viewcache"
+)
+
+// The functions here wrap git2c calls whose results only depend on the
+// object IDs passed to them, going through the view cache.
+
+// errNotTree is returned from a tree listing's computation when the path
+// turns out to be a blob, which isn't cached.
+var errNotTree = errors.New("not a tree")
+
+func cachedLog(ctx context.Context, base *wtypes.BaseData, commit string) ([]git2c.Commit, error) {
+	return viewcache.Get(ctx, base.Global.ViewCache, viewcache.Key(base.Repo.ID, "log", commit), func() ([]git2c.Commit, error) {
+		return base.Repo.Client.Log(ctx, base.Repo.Path, commit, 0)
+	})
+}
+
+func cachedLogRange(ctx context.Context, base *wtypes.BaseData, baseHex, headHex string) (*git2c.RevRange, error) {
+	return viewcache.Get(ctx, base.Global.ViewCache, viewcache.Key(base.Repo.ID, "log_range", baseHex, headHex), func() (*git2c.RevRange, error) {
+		return base.Repo.Client.LogRange(ctx, base.Repo.Path, baseHex, headHex, 0)
+	})
+}
+
+func cachedFormatPatch(ctx context.Context, base *wtypes.BaseData, commit string) (string, error) {
+	return viewcache.Get(ctx, base.Global.ViewCache, viewcache.Key(base.Repo.ID, "patch", commit), func() (string, error) {
+		return base.Repo.Client.FormatPatch(ctx, base.Repo.Path, commit)
+	})
+}
+
+func cachedCommitInfo(ctx context.Context, base *wtypes.BaseData, commit string, opts git2c.DiffOptions) (*git2c.CommitInfo, error) {
+	return viewcache.Get(ctx, base.Global.ViewCache, viewcache.Key(base.Repo.ID, "commit", commit, fmt.Sprintf("%+v", opts)), func() (*git2c.CommitInfo, error) {
+		return base.Repo.Client.CommitInfo(ctx, base.Repo.Path, commit, opts)
+	})
+}
+
+type treeDiff struct {
+	Files []git2c.FileDiff
+	Stats git2c.DiffStats
+}
+
+func cachedDiffTrees(ctx context.Context, base *wtypes.BaseData, fromHex, toHex string, opts git2c.DiffOptions) (treeDiff, error) {
+	return viewcache.Get(ctx, base.Global.ViewCache, viewcache.Key(base.Repo.ID, "diff", fromHex, toHex, fmt.Sprintf("%+v", opts)), func() (treeDiff, error) {
+		files, stats, err := base.Repo.Client.DiffTrees(ctx, base.Repo.Path, fromHex, toHex, opts)
+		return treeDiff{Files: files, Stats: stats}, err
+	})
+}
+
+func cachedDiffTreesPatch(ctx context.Context, base *wtypes.BaseData, fromHex, toHex string, opts git2c.DiffOptions) (string, error) {
+	return viewcache.Get(ctx, base.Global.ViewCache, viewcache.Key(base.Repo.ID, "diff_patch", fromHex, toHex, fmt.Sprintf("%+v", opts)), func() (string, error) {
+		return base.Repo.Client.DiffTreesPatch(ctx, base.Repo.Path, fromHex, toHex, opts)
+	})
+}
+
+// cachedTreeRaw is CmdTreeRaw with directory listings cached; blobs are
+// always streamed from git2d.
+func cachedTreeRaw(ctx context.Context, base *wtypes.BaseData, commit, pathSpec string) ([]git2c.TreeEntry, *git2c.Blob, error) {
+	var blob *git2c.Blob
+	files, err := viewcache.Get(ctx, base.Global.ViewCache, viewcache.Key(base.Repo.ID, "tree", commit, pathSpec), func() ([]git2c.TreeEntry, error) {
+		files, b, err := base.Repo.Client.CmdTreeRaw(ctx, base.Repo.Path, commit, pathSpec)
+		if b != nil {
+			blob = b
+			return nil, errNotTree
+		}
+		if files == nil && err == nil {
+			files = []git2c.TreeEntry{}
+		}
+		return files, err
+	})
+	if errors.Is(err, errNotTree) {
+		return nil, blob, nil
+	}
+	return files, nil, err
+}
@@ -0,0 +1,165 @@
+package repo
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.lindenii.runxiyu.org/forge/forged/internal/common/misc"
+	wtypes "go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/types"
+	"go.lindenii.runxiyu.org/forge/forged/internal/ipc/git2c"
+)
+
+// compareSpec is a parsed "base...head" or "base..head" path. With three
+// dots the diff is taken from the merge base, like git diff A...B; with two
+// dots it is taken from base itself.
+type compareSpec struct {
+	Base      string
+	Head      string
+	MergeBase bool
+}
+
+func parseCompareSpec(s string) (compareSpec, bool) {
+	if base, head, ok := strings.Cut(s, "..."); ok {
+		return compareSpec{Base: base, Head: head, MergeBase: true}, base != "" && head != ""
+	}
+	if base, head, ok := strings.Cut(s, ".."); ok {
+		return compareSpec{Base: base, Head: head}, base != "" && head != ""
+	}
+	return compareSpec{}, false
+}
+
+func (h *HTTP) Compare(w http.ResponseWriter, r *http.Request, v wtypes.Vars) {
+	base := wtypes.Base(r)
+	rawSpec := v["rest"]
+	wantPatch := strings.HasSuffix(rawSpec, ".patch")
+	wantDiff := strings.HasSuffix(rawSpec, ".diff")
+	rawSpec = strings.TrimSuffix(strings.TrimSuffix(rawSpec, ".patch"), ".diff")
+
+	spec, ok := parseCompareSpec(rawSpec)
+	if !ok {
+		http.Error(w, "Comparisons must be of the form base...head or base..head", http.StatusBadRequest)
+		return
+	}
+
+	repo := base.Repo
+	client, repoPath := repo.Client, repo.Path
+
+	baseHex, err := client.ResolveRef(r.Context(), repoPath, "rev", spec.Base)
+	if git2c.IsNotFound(err) {
+		http.Error(w, "Base revision not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		slog.Error("resolve ref failed", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	headHex, err := client.ResolveRef(r.Context(), repoPath, "rev", spec.Head)
+	if git2c.IsNotFound(err) {
+		http.Error(w, "Head revision not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		slog.Error("resolve ref failed", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	fromHex := baseHex
+	var note string
+	if spec.MergeBase {
+		mb, merr := client.MergeBase(r.Context(), repoPath, baseHex, headHex)
+		switch {
+		case merr == nil:
+			fromHex = mb
+		case errors.Is(merr, git2c.ErrMergeBaseNone):
+			note = "These revisions have no common ancestor; the diff is taken directly from the base."
+		default:
+			slog.Error("merge base failed", "error", merr)
+			http.Error(w, "Failed to find merge base", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	prefs := diffPrefsFromRequest(w, r)
+	if wantDiff {
+		patchStr, derr := cachedDiffTreesPatch(r.Context(), base, fromHex, headHex, prefs.gitOptions())
+		if derr != nil {
+			slog.Error("diff trees failed", "error", derr)
+			http.Error(w, "Failed to diff", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte(patchStr))
+		return
+	}
+
+	revs, err := cachedLogRange(r.Context(), base, baseHex, headHex)
+	if err != nil {
+		slog.Error("log range failed", "error", err)
+		http.Error(w, "Failed to list commits", http.StatusInternalServerError)
+		return
+	}
+
+	if wantPatch {
+		var sb strings.Builder
+		for i := len(revs.Commits) - 1; i >= 0; i-- {
+			patchStr, perr := cachedFormatPatch(r.Context(), base, revs.Commits[i].Hash)
+			if perr != nil {
+				slog.Error("format patch failed", "error", perr)
+				http.Error(w, "Failed to format patch", http.StatusInternalServerError)
+				return
+			}
+			sb.WriteString(patchStr)
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte(sb.String()))
+		return
+	}
+
+	diff, err := cachedDiffTrees(r.Context(), base, fromHex, headHex, prefs.gitOptions())
+	if err != nil {
+		slog.Error("diff trees failed", "error", err)
+		http.Error(w, "Failed to diff", http.StatusInternalServerError)
+		return
+	}
+
+	commits := make([]logCommit, 0, len(revs.Commits))
+	for _, c := range revs.Commits {
+		when, _ := time.Parse("2006-01-02 15:04:05", c.Date)
+		commits = append(commits, logCommit{
+			Hash:    c.Hash,
+			Message: c.Message,
+			Author:  logAuthor{Name: c.Author, Email: c.Email, When: when},
+		})
+	}
+
+	repoURLRoot := "/" + misc.SegmentsToURL(base.GroupPath) + "/-/repos/" + url.PathEscape(repo.Name) + "/"
+	data := map[string]any{
+		"BaseData":         base,
+		"group_path":       base.GroupPath,
+		"repo_name":        repo.Name,
+		"repo_description": repo.Description,
+		"repo_url_root":    repoURLRoot,
+		"compare_spec":     rawSpec,
+		"compare_base":     spec.Base,
+		"compare_head":     spec.Head,
+		"base_hash":        baseHex,
+		"head_hash":        headHex,
+		"from_hash":        fromHex,
+		"compare_note":     note,
+		"ahead":            revs.Ahead,
+		"behind":           revs.Behind,
+		"commits":          commits,
+		"diff_view":        newDiffView(r, prefs, repoURLRoot, fromHex, headHex, diff.Files, diff.Stats),
+		"global": map[string]any{
+			"forge_title": base.Global.ForgeTitle,
+		},
+	}
+	if err := h.r.Render(w, "repo_compare", data); err != nil {
+		slog.Error("render repo compare", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,30 @@
+package repo
+
+import "testing"
+
+func TestParseCompareSpec(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		spec string
+		want compareSpec
+		ok   bool
+	}{
+		{"master...feature", compareSpec{Base: "master", Head: "feature", MergeBase: true}, true},
+		{"master..feature", compareSpec{Base: "master", Head: "feature", MergeBase: false}, true},
+		{"v0.1...HEAD~2", compareSpec{Base: "v0.1", Head: "HEAD~2", MergeBase: true}, true},
+		{"a/b..c/d", compareSpec{Base: "a/b", Head: "c/d", MergeBase: false}, true},
+		{"master....feature", compareSpec{Base: "master", Head: ".feature", MergeBase: true}, true},
+		{"master...", compareSpec{Base: "master", Head: "", MergeBase: true}, false},
+		{"...feature", compareSpec{Base: "", Head: "feature", MergeBase: true}, false},
+		{"master..", compareSpec{Base: "master", Head: "", MergeBase: false}, false},
+		{"..", compareSpec{Base: "", Head: "", MergeBase: false}, false},
+		{"master", compareSpec{Base: "", Head: "", MergeBase: false}, false},
+		{"", compareSpec{Base: "", Head: "", MergeBase: false}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseCompareSpec(tt.spec)
+		if ok != tt.ok || (ok && got != tt.want) {
+			t.Errorf("parseCompareSpec(%q) = %+v, %v; want %+v, %v", tt.spec, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package repo
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	wtypes "go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/types"
+	"go.lindenii.runxiyu.org/forge/forged/internal/ipc/git2c"
+)
+
+type usableLine struct {
+	Operation int
+	OldLine   uint64
+	NewLine   uint64
+	Content   string
+	Segments  []lineSegment // nil unless intra-line changes were found
+}
+
+// splitRow is a row of the side-by-side view. Either side may be nil when
+// a line has no counterpart.
+type splitRow struct {
+	Left  *usableLine
+	Right *usableLine
+}
+
+type usableHunk struct {
+	Header string
+	Lines  []usableLine
+	Rows   []splitRow // only populated in split view
+}
+
+type diffFileMeta struct {
+	Hash string
+	Mode string
+	Path string
+}
+
+type usableFilePatch struct {
+	// Anchor is a stable fragment ID for the file, used as the prefix of
+	// its line anchors: <Anchor>-L<n> for old lines and <Anchor>-R<n> for
+	// new lines.
+	Anchor     string
+	Status     string
+	Binary     bool
+	Similarity uint64
+	From       diffFileMeta
+	To         diffFileMeta
+	Additions  uint64
+	Deletions  uint64
+	Hunks      []usableHunk
+	// Collapsed files start folded, with CollapseReason shown in the
+	// header.
+	Collapsed      bool
+	CollapseReason string
+}
+
+// diffView is what the file_patches template renders. FromCommit and
+// ToCommit are used to link each side of a file to its tree view.
+type diffView struct {
+	RepoURLRoot string
+	FromCommit  string
+	ToCommit    string
+	Patches     []usableFilePatch
+	Stats       git2c.DiffStats
+	Prefs       diffPrefs
+	// Summary is the part of Patches listed in the table of contents up
+	// front; SummaryMore is the rest, behind a "show more" toggle.
+	Summary       []usableFilePatch
+	SummaryMore   []usableFilePatch
+	UnifiedURL    string
+	SplitURL      string
+	WhitespaceURL string // toggles IgnoreWhitespace
+}
+
+// diffPrefs are the per-request diff display options.
+type diffPrefs struct {
+	Split            bool
+	IgnoreWhitespace bool
+}
+
+const diffViewCookie = "diff_view"
+
+// maxExpandedDiffLines is the number of diff lines above which a file
+// starts collapsed.
+const maxExpandedDiffLines = 500
+
+// maxSummaryFiles is the number of files listed in a diff's table of
+// contents before the rest are folded away.
+const maxSummaryFiles = 50
+
+// diffPrefsFromRequest reads ?view=unified|split, falling back to (and
+// remembering it in) a cookie, and ?w=1 for ignoring whitespace.
+func diffPrefsFromRequest(w http.ResponseWriter, r *http.Request) diffPrefs {
+	var prefs diffPrefs
+	view := r.URL.Query().Get("view")
+	switch view {
+	case "unified", "split":
+		http.SetCookie(w, &http.Cookie{
+			Name:     diffViewCookie,
+			Value:    view,
+			SameSite: http.SameSiteLaxMode,
+			Secure:   wtypes.Base(r).Secure,
+			MaxAge:   365 * 24 * 60 * 60,
+			Path:     "/",
+		}) //exhaustruct:ignore
+	default:
+		if cookie, err := r.Cookie(diffViewCookie); err == nil {
+			view = cookie.Value
+		}
+	}
+	prefs.Split = view == "split"
+	prefs.IgnoreWhitespace = r.URL.Query().Get("w") == "1"
+	return prefs
+}
+
+func (p diffPrefs) gitOptions() git2c.DiffOptions {
+	return git2c.DiffOptions{IgnoreWhitespace: p.IgnoreWhitespace}
+}
+
+// withQuery returns a URL to the current page with key set to value, or
+// removed if value is empty.
+func withQuery(r *http.Request, key, value string) string {
+	q := r.URL.Query()
+	if value == "" {
+		q.Del(key)
+	} else {
+		q.Set(key, value)
+	}
+	if len(q) == 0 {
+		return r.URL.EscapedPath()
+	}
+	return r.URL.EscapedPath() + "?" + q.Encode()
+}
+
+func newDiffView(r *http.Request, prefs diffPrefs, repoURLRoot, fromCommit, toCommit string, files []git2c.FileDiff, stats git2c.DiffStats) diffView {
+	whitespace := "1"
+	if prefs.IgnoreWhitespace {
+		whitespace = ""
+	}
+	patches := toUsable(files, prefs.Split)
+	n := min(len(patches), maxSummaryFiles)
+	return diffView{
+		RepoURLRoot:   repoURLRoot,
+		FromCommit:    fromCommit,
+		ToCommit:      toCommit,
+		Patches:       patches,
+		Stats:         stats,
+		Prefs:         prefs,
+		Summary:       patches[:n],
+		SummaryMore:   patches[n:],
+		UnifiedURL:    withQuery(r, "view", "unified"),
+		SplitURL:      withQuery(r, "view", "split"),
+		WhitespaceURL: withQuery(r, "w", whitespace),
+	}
+}
+
+// fileAnchor derives a fragment ID from a file's path, so that links to
+// lines in a file survive across commits that touch it.
+func fileAnchor(path string) string {
+	b := sha1.Sum([]byte(path))
+	return "diff-" + hex.EncodeToString(b[:6])
+}
+
+func deltaStatus(status uint64) string {
+	switch status {
+	case git2c.DeltaAdded:
+		return "added"
+	case git2c.DeltaDeleted:
+		return "deleted"
+	case git2c.DeltaModified:
+		return "modified"
+	case git2c.DeltaRenamed:
+		return "renamed"
+	case git2c.DeltaCopied:
+		return "copied"
+	case git2c.DeltaTypeChange:
+		return "type changed"
+	default:
+		return ""
+	}
+}
+
+// generatedSuffixes and generatedDirs are path patterns for files that are
+// usually machine-written and rarely worth reading in a diff.
+var (
+	generatedSuffixes = []string{
+		".min.js", ".min.css", ".map", ".pb.go", "_generated.go", ".gen.go",
+		"go.sum", "package-lock.json", "yarn.lock", "pnpm-lock.yaml",
+		"Cargo.lock", "poetry.lock", "Gemfile.lock", "composer.lock", "flake.lock",
+	}
+	generatedDirs = []string{"vendor/", "node_modules/", "third_party/"}
+)
+
+func isGeneratedPath(p string) bool {
+	for _, suffix := range generatedSuffixes {
+		if strings.HasSuffix(p, suffix) {
+			return true
+		}
+	}
+	for _, dir := range generatedDirs {
+		if strings.HasPrefix(p, dir) || strings.Contains(p, "/"+dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitRows lays out a hunk's lines side by side, pairing each run of
+// deletions with the run of additions that follows it.
+func splitRows(lines []usableLine) []splitRow {
+	rows := make([]splitRow, 0, len(lines))
+	for i := 0; i < len(lines); {
+		if lines[i].Operation != 1 && lines[i].Operation != 2 {
+			rows = append(rows, splitRow{Left: &lines[i], Right: &lines[i]})
+			i++
+			continue
+		}
+		delStart := i
+		for i < len(lines) && lines[i].Operation == 2 {
+			i++
+		}
+		addStart := i
+		for i < len(lines) && lines[i].Operation == 1 {
+			i++
+		}
+		dels, adds := lines[delStart:addStart], lines[addStart:i]
+		for k := range max(len(dels), len(adds)) {
+			var row splitRow
+			if k < len(dels) {
+				row.Left = &dels[k]
+			}
+			if k < len(adds) {
+				row.Right = &adds[k]
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+func toUsable(files []git2c.FileDiff, split bool) []usableFilePatch {
+	out := make([]usableFilePatch, 0, len(files))
+	for _, f := range files {
+		anchorPath := f.ToPath
+		if f.Status == git2c.DeltaDeleted || anchorPath == "" {
+			anchorPath = f.FromPath
+		}
+		u := usableFilePatch{
+			Anchor:     fileAnchor(anchorPath),
+			Status:     deltaStatus(f.Status),
+			Binary:     f.Binary,
+			Similarity: f.Similarity,
+			From:       diffFileMeta{Path: f.FromPath, Mode: fmt.Sprintf("%06o", f.FromMode), Hash: f.FromOID},
+			To:         diffFileMeta{Path: f.ToPath, Mode: fmt.Sprintf("%06o", f.ToMode), Hash: f.ToOID},
+			Additions:  f.Additions,
+			Deletions:  f.Deletions,
+		}
+		// libgit2 fills in both paths even for additions and deletions
+		if f.Status == git2c.DeltaAdded {
+			u.From.Path = ""
+		} else if f.Status == git2c.DeltaDeleted {
+			u.To.Path = ""
+		}
+		lineCount := 0
+		for _, h := range f.Hunks {
+			uh := usableHunk{Header: h.Header, Lines: make([]usableLine, 0, len(h.Lines))}
+			for _, l := range h.Lines {
+				uh.Lines = append(uh.Lines, usableLine{
+					Operation: int(l.Op),
+					OldLine:   l.OldLine,
+					NewLine:   l.NewLine,
+					Content:   strings.TrimSuffix(l.Content, "\n"),
+				})
+			}
+			highlightIntraline(uh.Lines)
+			if split {
+				uh.Rows = splitRows(uh.Lines)
+			}
+			lineCount += len(uh.Lines)
+			u.Hunks = append(u.Hunks, uh)
+		}
+		switch {
+		case isGeneratedPath(anchorPath):
+			u.Collapsed, u.CollapseReason = true, "generated file"
+		case lineCount > maxExpandedDiffLines:
+			u.Collapsed, u.CollapseReason = true, "large diff"
+		}
+		out = append(out, u)
+	}
+	return out
+}
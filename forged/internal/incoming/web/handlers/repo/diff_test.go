@@ -0,0 +1,50 @@
+package repo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitRows(t *testing.T) {
+	t.Parallel()
+	// Lines are given as their operation followed by their content: ' '
+	// for context, '-' for deletions and '+' for additions. Rows are given
+	// as the contents of their left and right sides, with "" for none.
+	ops := map[byte]int{' ': 0, '+': 1, '-': 2}
+	tests := []struct {
+		name  string
+		lines []string
+		want  [][2]string
+	}{
+		{"empty", nil, [][2]string{}},
+		{"context", []string{" a", " b"}, [][2]string{{"a", "a"}, {"b", "b"}}},
+		{"replacement", []string{" a", "-b", "+B", " c"}, [][2]string{{"a", "a"}, {"b", "B"}, {"c", "c"}}},
+		{"more deletions", []string{"-a", "-b", "-c", "+A"}, [][2]string{{"a", "A"}, {"b", ""}, {"c", ""}}},
+		{"more additions", []string{"-a", "+A", "+B"}, [][2]string{{"a", "A"}, {"", "B"}}},
+		{"only additions", []string{" a", "+b", "+c"}, [][2]string{{"a", "a"}, {"", "b"}, {"", "c"}}},
+		{"only deletions", []string{"-a", " b"}, [][2]string{{"a", ""}, {"b", "b"}}},
+		{"addition before deletion", []string{"+a", "-b"}, [][2]string{{"", "a"}, {"b", ""}}},
+		{"separate runs", []string{"-a", "+A", " b", "-c", "+C"}, [][2]string{{"a", "A"}, {"b", "b"}, {"c", "C"}}},
+	}
+	for _, tt := range tests {
+		lines := make([]usableLine, 0, len(tt.lines))
+		for _, l := range tt.lines {
+			//exhaustruct:ignore
+			lines = append(lines, usableLine{Operation: ops[l[0]], Content: l[1:]})
+		}
+		got := [][2]string{}
+		for _, row := range splitRows(lines) {
+			var r [2]string
+			if row.Left != nil {
+				r[0] = row.Left.Content
+			}
+			if row.Right != nil {
+				r[1] = row.Right.Content
+			}
+			got = append(got, r)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got rows %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
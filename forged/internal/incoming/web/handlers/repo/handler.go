@@ -1,7 +1,10 @@
 package repo
 
 import (
+	"context"
+
 	"go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/templates"
+	wtypes "go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/types"
 )
 
 type HTTP struct {
@@ -13,3 +16,16 @@ func NewHTTP(r templates.Renderer) *HTTP {
 		r: r,
 	}
 }
+
+// resolveRev resolves the ref selected by the ?commit=, ?branch= or ?tag=
+// query parameter, or HEAD when there is none, to a commit ID.
+func resolveRev(ctx context.Context, base *wtypes.BaseData) (string, error) {
+	refType, refName := base.RefType, base.RefName
+	if refType == "" {
+		refType, refName = "rev", "HEAD"
+	}
+	repo := base.Repo
+	return base.Global.ViewCache.Resolve(ctx, repo.ID, refType, refName, func(ctx context.Context) (string, error) {
+		return repo.Client.ResolveRef(ctx, repo.Path, refType, refName)
+	})
+}
@@ -0,0 +1,499 @@
+package repo
+
+import (
+	"bytes"
+	"html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"go.lindenii.runxiyu.org/forge/forged/internal/config"
+	"go.lindenii.runxiyu.org/forge/forged/internal/global"
+	wtypes "go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/types"
+	"go.lindenii.runxiyu.org/forge/forged/internal/ipc/git2c"
+	"go.lindenii.runxiyu.org/forge/forged/internal/ipc/git2c/git2dtest"
+	"go.lindenii.runxiyu.org/forge/forged/internal/viewcache"
+)
+
+// recorder is a templates.Renderer that keeps what it was asked to render.
+type recorder struct {
+	name string
+	data map[string]any
+}
+
+func (r *recorder) Render(w http.ResponseWriter, name string, data any) error {
+	r.name, r.data = name, data.(map[string]any)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// bigFile spans several git2d frames.
+var bigFile = bytes.Repeat([]byte("0123456789abcdef\n"), 10000)
+
+// fakeRepo is git2dtest.Fixture, with an empty file and bigFile added to
+// master, served by a fake git2d to the handlers.
+type fakeRepo struct {
+	t      *testing.T
+	git    *git2dtest.Repo
+	client *git2c.Client
+	global *global.Global
+}
+
+func newFakeRepo(t *testing.T) *fakeRepo {
+	t.Helper()
+	srv, err := git2dtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = srv.Close() })
+	repo := git2dtest.Fixture()
+	repo.CommitFiles("master", "Add some data\n", map[string][]byte{
+		"data/empty": {},
+		"data/big":   bigFile,
+	})
+	srv.AddRepo("/repos/example.git", repo)
+
+	client, err := git2c.NewClient(t.Context(), srv.SocketPath(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	cache, err := viewcache.New(&config.ViewCache{MaxSize: 1 << 24}, nil) //exhaustruct:ignore
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fakeRepo{
+		t:      t,
+		git:    repo,
+		client: client,
+		global: &global.Global{ForgeTitle: "Test forge", ViewCache: cache}, //exhaustruct:ignore
+	}
+}
+
+// serve calls handler like the router would for a GET of target under the
+// repository, with the given query-selected ref and route variables.
+func (f *fakeRepo) serve(handler wtypes.HandlerFunc, target string, header http.Header, refType, refName string, v wtypes.Vars) *httptest.ResponseRecorder {
+	f.t.Helper()
+	req := httptest.NewRequestWithContext(f.t.Context(), http.MethodGet, "/g/-/repos/example/"+target, nil)
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	base := &wtypes.BaseData{
+		GroupPath: []string{"g"},
+		DirMode:   strings.HasSuffix(req.URL.Path, "/"),
+		RefType:   refType,
+		RefName:   refName,
+		Global:    f.global,
+		Repo: &wtypes.Repo{
+			ID:     1,
+			Name:   "example",
+			Client: f.client,
+			Path:   "/repos/example.git",
+		},
+	} //exhaustruct:ignore
+	req = req.WithContext(wtypes.WithBaseData(req.Context(), base))
+	w := httptest.NewRecorder()
+	handler(w, req, v)
+	return w
+}
+
+// render is serve for a handler that renders a template, which it returns
+// along with the reply.
+func (f *fakeRepo) render(handler func(*HTTP) wtypes.HandlerFunc, target, refType, refName string, v wtypes.Vars) (*httptest.ResponseRecorder, *recorder) {
+	f.t.Helper()
+	rec := &recorder{} //exhaustruct:ignore
+	w := f.serve(handler(NewHTTP(rec)), target, nil, refType, refName, v)
+	return w, rec
+}
+
+func TestTreeHandler(t *testing.T) {
+	t.Parallel()
+	f := newFakeRepo(t)
+	tree := func(h *HTTP) wtypes.HandlerFunc { return h.Tree }
+
+	w, rec := f.render(tree, "tree/", "", "", wtypes.Vars{"rest": ""})
+	if w.Code != http.StatusOK || rec.name != "repo_tree_dir" {
+		t.Fatalf("root: got %d rendering %q", w.Code, rec.name)
+	}
+	names := []string{}
+	for _, e := range rec.data["files"].([]git2c.TreeEntry) {
+		names = append(names, e.Name)
+	}
+	if got := strings.Join(names, " "); got != "COPYING README.md cmd data logo.png notes.txt" {
+		t.Errorf("root lists %s", got)
+	}
+
+	w, rec = f.render(tree, "tree/cmd/hello/main.go", "tag", "v0.1", wtypes.Vars{"rest": "cmd/hello/main.go"})
+	if w.Code != http.StatusOK || rec.name != "repo_tree_file" {
+		t.Fatalf("file: got %d rendering %q", w.Code, rec.name)
+	}
+	if got := string(rec.data["file_contents"].(template.HTML)); !strings.Contains(got, "fmt.Println(&#34;hello&#34;)") {
+		t.Errorf("main.go at v0.1 renders as %s", got)
+	}
+
+	w, _ = f.render(tree, "tree/cmd", "", "", wtypes.Vars{"rest": "cmd"})
+	if w.Code != http.StatusSeeOther && w.Code != http.StatusFound && w.Code != http.StatusMovedPermanently {
+		t.Errorf("directory without a slash: got %d, want a redirect", w.Code)
+	}
+	w, _ = f.render(tree, "tree/missing", "", "", wtypes.Vars{"rest": "missing"})
+	if w.Code != http.StatusNotFound {
+		t.Errorf("missing path: got %d", w.Code)
+	}
+	w, _ = f.render(tree, "tree/", "branch", "missing", wtypes.Vars{"rest": ""})
+	if w.Code != http.StatusNotFound {
+		t.Errorf("missing branch: got %d", w.Code)
+	}
+}
+
+func TestRawHandler(t *testing.T) {
+	t.Parallel()
+	f := newFakeRepo(t)
+	raw := NewHTTP(&recorder{}).Raw //exhaustruct:ignore
+	get := func(path string, header http.Header) *httptest.ResponseRecorder {
+		t.Helper()
+		return f.serve(raw, "raw/"+path, header, "", "", wtypes.Vars{"rest": path})
+	}
+
+	w := get("README.md", nil)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), "# Example") {
+		t.Errorf("README.md: got %d %q", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/markdown; charset=utf-8" {
+		t.Errorf("README.md is served as %s", ct)
+	}
+
+	w = get("data/empty", nil)
+	if w.Code != http.StatusOK || w.Body.Len() != 0 || w.Header().Get("Content-Length") != "0" {
+		t.Errorf("empty file: got %d with %d bytes, Content-Length %q", w.Code, w.Body.Len(), w.Header().Get("Content-Length"))
+	}
+	// net/http ignores ranges of empty content.
+	w = get("data/empty", http.Header{"Range": {"bytes=0-9"}})
+	if w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Errorf("range of an empty file: got %d with %d bytes", w.Code, w.Body.Len())
+	}
+
+	w = get("data/big", nil)
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), bigFile) {
+		t.Errorf("big file: got %d with %d bytes", w.Code, w.Body.Len())
+	}
+	w = get("data/big", http.Header{"Range": {"bytes=100000-100016"}})
+	if w.Code != http.StatusPartialContent || !bytes.Equal(w.Body.Bytes(), bigFile[100000:100017]) {
+		t.Errorf("single range: got %d %q", w.Code, w.Body.String())
+	}
+	w = get("data/big", http.Header{"Range": {"bytes=-5"}})
+	if w.Code != http.StatusPartialContent || !bytes.Equal(w.Body.Bytes(), bigFile[len(bigFile)-5:]) {
+		t.Errorf("suffix range: got %d %q", w.Code, w.Body.String())
+	}
+
+	w = get("data/big", http.Header{"Range": {"bytes=0-3, 100000-100016"}})
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("ascending ranges: got %d", w.Code)
+	}
+	_, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := multipart.NewReader(w.Body, params["boundary"])
+	for _, want := range [][]byte{bigFile[:4], bigFile[100000:100017]} {
+		part, err := parts.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := io.ReadAll(part); err != nil || !bytes.Equal(got, want) {
+			t.Errorf("part: got %q, want %q", got, want)
+		}
+	}
+
+	w = get("data/big", http.Header{"Range": {"bytes=100000-100016, 0-3"}})
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), bigFile) {
+		t.Errorf("descending ranges: got %d with %d bytes, want the whole file", w.Code, w.Body.Len())
+	}
+
+	w = get("missing", nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("missing file: got %d", w.Code)
+	}
+}
+
+func TestViewCache(t *testing.T) {
+	t.Parallel()
+	f := newFakeRepo(t)
+	cache, err := viewcache.New(&config.ViewCache{MaxSize: 1 << 24, RefTTL: 1}, nil) //exhaustruct:ignore
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.global.ViewCache = cache
+	log := func(h *HTTP) wtypes.HandlerFunc { return h.Log }
+	head := func() string {
+		t.Helper()
+		w, rec := f.render(log, "log/", "branch", "master", nil)
+		commits := rec.data["commits"].([]logCommit)
+		if w.Code != http.StatusOK || len(commits) == 0 || *rec.data["commits_err"].(*error) != nil {
+			t.Fatalf("got %d with %d commits, %v", w.Code, len(commits), *rec.data["commits_err"].(*error))
+		}
+		return commits[0].Hash
+	}
+
+	before := head()
+	pushed := f.git.CommitFiles("master", "Push a commit\n", map[string][]byte{"pushed": []byte("pushed\n")})
+	if got := head(); got != before {
+		t.Errorf("master moved to %s within ref_ttl", got)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if got := head(); got != pushed {
+		t.Errorf("master is at %s after ref_ttl, want %s", got, pushed)
+	}
+
+	// Until ref_ttl passes again, the view needs nothing from git2d.
+	_ = f.client.Close()
+	if got := head(); got != pushed {
+		t.Errorf("from the cache, master is at %s", got)
+	}
+	if _, rec := f.render(log, "log/", "branch", "feature", nil); *rec.data["commits_err"].(*error) == nil {
+		t.Error("a branch never viewed was listed without git2d")
+	}
+}
+
+func TestRawCaching(t *testing.T) {
+	t.Parallel()
+	f := newFakeRepo(t)
+	raw := NewHTTP(&recorder{}).Raw //exhaustruct:ignore
+	master := f.git.Ref("refs/heads/master")
+	f.git.SetRef("refs/tags/v0.2", master)
+	blob := f.git.WriteBlob([]byte("# Example\n\nAn example repository.\n\nRun `go run ./cmd/hello`.\n"))
+
+	tests := []struct {
+		name             string
+		refType, refName string
+		cacheControl     string
+	}{
+		{"default branch", "", "", "no-cache"},
+		{"branch", "branch", "master", "no-cache"},
+		{"tag", "tag", "v0.2", "no-cache"},
+		{"commit", "commit", master, "public, max-age=31536000, immutable"},
+	}
+	for _, tt := range tests {
+		w := f.serve(raw, "raw/README.md", nil, tt.refType, tt.refName, wtypes.Vars{"rest": "README.md"})
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: got %d", tt.name, w.Code)
+			continue
+		}
+		if cc := w.Header().Get("Cache-Control"); cc != tt.cacheControl {
+			t.Errorf("%s: Cache-Control %q, want %q", tt.name, cc, tt.cacheControl)
+		}
+		etag := w.Header().Get("ETag")
+		if etag != `"`+blob+`"` {
+			t.Errorf("%s: ETag %s, want the blob ID %s", tt.name, etag, blob)
+		}
+
+		// Revalidating with the ETag sends nothing again.
+		w = f.serve(raw, "raw/README.md", http.Header{"If-None-Match": {etag}}, tt.refType, tt.refName, wtypes.Vars{"rest": "README.md"})
+		if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+			t.Errorf("%s: revalidating got %d with %d bytes", tt.name, w.Code, w.Body.Len())
+		}
+		w = f.serve(raw, "raw/README.md", http.Header{"If-None-Match": {`"` + strings.Repeat("0", 40) + `"`}}, tt.refType, tt.refName, wtypes.Vars{"rest": "README.md"})
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: revalidating a stale copy got %d", tt.name, w.Code)
+		}
+	}
+}
+
+func TestRawActiveContent(t *testing.T) {
+	t.Parallel()
+	f := newFakeRepo(t)
+	raw := NewHTTP(&recorder{}).Raw //exhaustruct:ignore
+	f.git.CommitFiles("master", "Add a website\n", map[string][]byte{
+		"site/index.html": []byte("<script>alert(document.cookie)</script>\n"),
+		"site/logo.svg":   []byte(`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>` + "\n"),
+		"site/page":       []byte("<!DOCTYPE html><html><script>alert(1)</script></html>\n"),
+		"site/report.pdf": []byte("%PDF-1.7\n\x00\x01\x02\x03"),
+	})
+
+	tests := []struct {
+		path        string
+		contentType string
+		attachment  bool
+	}{
+		{"site/index.html", "text/plain; charset=utf-8", false},
+		{"site/logo.svg", "text/plain; charset=utf-8", false},
+		// Sniffed as HTML.
+		{"site/page", "text/plain; charset=utf-8", false},
+		{"site/report.pdf", "application/octet-stream", true},
+	}
+	for _, tt := range tests {
+		w := f.serve(raw, "raw/"+tt.path, nil, "", "", wtypes.Vars{"rest": tt.path})
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: got %d", tt.path, w.Code)
+			continue
+		}
+		if ct := w.Header().Get("Content-Type"); ct != tt.contentType {
+			t.Errorf("%s: served as %s, want %s", tt.path, ct, tt.contentType)
+		}
+		if cd := w.Header().Get("Content-Disposition"); strings.HasPrefix(cd, "attachment") != tt.attachment {
+			t.Errorf("%s: Content-Disposition %q", tt.path, cd)
+		}
+		if w.Header().Get("X-Content-Type-Options") != "nosniff" || !strings.Contains(w.Header().Get("Content-Security-Policy"), "sandbox") {
+			t.Errorf("%s: may be sniffed or run: %v", tt.path, w.Header())
+		}
+	}
+}
+
+func TestCommitHandler(t *testing.T) {
+	t.Parallel()
+	f := newFakeRepo(t)
+	commit := func(h *HTTP) wtypes.HandlerFunc { return h.Commit }
+	tag := f.git.Ref("refs/tags/v0.1")
+
+	w, _ := f.render(commit, "commit/"+tag[:7], "", "", wtypes.Vars{"commit": tag[:7]})
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/g/-/repos/example/commit/"+tag {
+		t.Errorf("abbreviated ID: got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+
+	w, rec := f.render(commit, "commit/"+tag, "", "", wtypes.Vars{"commit": tag})
+	if w.Code != http.StatusOK || rec.name != "repo_commit" {
+		t.Fatalf("got %d rendering %q", w.Code, rec.name)
+	}
+	if co := rec.data["commit_object"].(commitObject); co.Hash != tag || !strings.HasPrefix(co.Message, "Add the program") {
+		t.Errorf("got commit %+v", co)
+	}
+	view := rec.data["diff_view"].(diffView)
+	if view.Stats.Additions != 7 || len(view.Patches) != 2 {
+		t.Errorf("got %+v over %d files, want 7 additions over 2", view.Stats, len(view.Patches))
+	}
+
+	w, _ = f.render(commit, "commit/"+tag+".patch", "", "", wtypes.Vars{"commit": tag + ".patch"})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Subject: [PATCH] Add the program") {
+		t.Errorf("patch: got %d\n%s", w.Code, w.Body.String())
+	}
+
+	missing := strings.Repeat("0", 40)
+	w, _ = f.render(commit, "commit/"+missing, "", "", wtypes.Vars{"commit": missing})
+	if w.Code != http.StatusNotFound {
+		t.Errorf("missing commit: got %d", w.Code)
+	}
+}
+
+func TestCommitDiffView(t *testing.T) {
+	t.Parallel()
+	f := newFakeRepo(t)
+	commit := func(h *HTTP) wtypes.HandlerFunc { return h.Commit }
+	data := f.git.Ref("refs/heads/master")
+	id := f.git.CommitFiles("master", "Vendor a library and reindent\n", map[string][]byte{
+		"vendor/lib/lib.go": []byte("package lib\n"),
+		"cmd/hello/main.go": []byte("package main\n\nimport \"fmt\"\n\nfunc main() {\n    fmt.Println(\"hello, world\")\n}\n"),
+	})
+	patches := func(rec *recorder) map[string]usableFilePatch {
+		m := map[string]usableFilePatch{}
+		for _, p := range rec.data["diff_view"].(diffView).Patches {
+			m[p.To.Path] = p
+		}
+		return m
+	}
+
+	w, rec := f.render(commit, "commit/"+id, "", "", wtypes.Vars{"commit": id})
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d", w.Code)
+	}
+	p := patches(rec)
+	if lib := p["vendor/lib/lib.go"]; !lib.Collapsed || lib.CollapseReason != "generated file" {
+		t.Errorf("vendored file: collapsed %v for %q", lib.Collapsed, lib.CollapseReason)
+	}
+	main := p["cmd/hello/main.go"]
+	if main.Collapsed || main.Additions != 1 || main.Deletions != 1 || main.Hunks[0].Rows != nil {
+		t.Errorf("reindented file: got %+v", main)
+	}
+	if len(w.Result().Cookies()) != 0 {
+		t.Errorf("set %v without being asked to", w.Result().Cookies())
+	}
+
+	// Asking for the split view pairs lines up and remembers the choice.
+	w, rec = f.render(commit, "commit/"+id+"?view=split", "", "", wtypes.Vars{"commit": id})
+	if !rec.data["diff_view"].(diffView).Prefs.Split {
+		t.Fatal("?view=split isn't split")
+	}
+	if rows := patches(rec)["cmd/hello/main.go"].Hunks[0].Rows; !slices.ContainsFunc(rows, func(r splitRow) bool {
+		return r.Left != nil && r.Right != nil && r.Left.OldLine == 6 && r.Right.NewLine == 6 && r.Left.Operation != r.Right.Operation
+	}) {
+		t.Errorf("the changed line isn't paired with its replacement: %+v", rows)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != diffViewCookie || cookies[0].Value != "split" {
+		t.Fatalf("got cookies %v", cookies)
+	}
+	rec = &recorder{} //exhaustruct:ignore
+	f.serve(commit(NewHTTP(rec)), "commit/"+id, http.Header{"Cookie": {cookies[0].String()}}, "", "", wtypes.Vars{"commit": id})
+	if !rec.data["diff_view"].(diffView).Prefs.Split {
+		t.Error("the split view isn't remembered")
+	}
+
+	// Ignoring whitespace leaves nothing of the reindentation.
+	_, rec = f.render(commit, "commit/"+id+"?w=1", "", "", wtypes.Vars{"commit": id})
+	if main := patches(rec)["cmd/hello/main.go"]; main.Additions != 0 || main.Deletions != 0 {
+		t.Errorf("ignoring whitespace: got %+v", main)
+	}
+
+	// Files with too many lines changed start collapsed.
+	_, rec = f.render(commit, "commit/"+data, "", "", wtypes.Vars{"commit": data})
+	if big := patches(rec)["data/big"]; !big.Collapsed || big.CollapseReason != "large diff" {
+		t.Errorf("big file: collapsed %v for %q", big.Collapsed, big.CollapseReason)
+	}
+}
+
+func TestCompareHandler(t *testing.T) {
+	t.Parallel()
+	f := newFakeRepo(t)
+	compare := func(h *HTTP) wtypes.HandlerFunc { return h.Compare }
+
+	w, rec := f.render(compare, "compare/master...feature", "", "", wtypes.Vars{"rest": "master...feature"})
+	if w.Code != http.StatusOK || rec.name != "repo_compare" {
+		t.Fatalf("got %d rendering %q", w.Code, rec.name)
+	}
+	if from := rec.data["from_hash"]; from != f.git.Ref("refs/tags/v0.1") {
+		t.Errorf("three dots diff from %v, want the merge base", from)
+	}
+	if ahead, behind := rec.data["ahead"], rec.data["behind"]; ahead != uint64(1) || behind != uint64(3) {
+		t.Errorf("got %v ahead and %v behind, want 1 and 3", ahead, behind)
+	}
+	view := rec.data["diff_view"].(diffView)
+	if len(view.Patches) != 1 || view.Patches[0].To.Path != "feature.go" {
+		t.Errorf("got patches %+v, want feature.go", view.Patches)
+	}
+	if commits := rec.data["commits"].([]logCommit); len(commits) != 1 || !strings.HasPrefix(commits[0].Message, "Add a feature") {
+		t.Errorf("got commits %+v, want the feature's", commits)
+	}
+
+	w, rec = f.render(compare, "compare/master..feature", "", "", wtypes.Vars{"rest": "master..feature"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("two dots: got %d", w.Code)
+	}
+	if from := rec.data["from_hash"]; from != f.git.Ref("refs/heads/master") {
+		t.Errorf("two dots diff from %v, want master", from)
+	}
+
+	w, _ = f.render(compare, "compare/master...feature.diff", "", "", wtypes.Vars{"rest": "master...feature.diff"})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "+const feature = true") {
+		t.Errorf("diff: got %d\n%s", w.Code, w.Body.String())
+	}
+
+	// A patch download is the whole series, oldest first.
+	w, _ = f.render(compare, "compare/v0.1...master.patch", "", "", wtypes.Vars{"rest": "v0.1...master.patch"})
+	if body := w.Body.String(); w.Code != http.StatusOK || strings.Count(body, "Subject: [PATCH]") != 3 ||
+		strings.Index(body, "Greet the world") > strings.Index(body, "Rename LICENSE") {
+		t.Errorf("patch series: got %d\n%s", w.Code, body)
+	}
+
+	w, _ = f.render(compare, "compare/master", "", "", wtypes.Vars{"rest": "master"})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("malformed spec: got %d", w.Code)
+	}
+	w, _ = f.render(compare, "compare/master...missing", "", "", wtypes.Vars{"rest": "master...missing"})
+	if w.Code != http.StatusNotFound {
+		t.Errorf("missing head: got %d", w.Code)
+	}
+}
@@ -2,89 +2,89 @@ package repo
 
 import (
 	"bytes"
-	"fmt"
+	"context"
 	"html/template"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/url"
-	"path/filepath"
 	"strings"
 
 	"github.com/yuin/goldmark"
 	"github.com/yuin/goldmark/extension"
 	"go.lindenii.runxiyu.org/forge/forged/internal/common/misc"
-	"go.lindenii.runxiyu.org/forge/forged/internal/database/queries"
 	wtypes "go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/types"
 	"go.lindenii.runxiyu.org/forge/forged/internal/ipc/git2c"
+	"go.lindenii.runxiyu.org/forge/forged/internal/viewcache"
 )
 
-func (h *HTTP) Index(w http.ResponseWriter, r *http.Request, v wtypes.Vars) {
-	base := wtypes.Base(r)
-	repoName := v["repo"]
-	slog.Info("repo index", "group_path", base.GroupPath, "repo", repoName)
+// indexCommits is how many recent commits the index shows.
+const indexCommits = 3
 
-	var userID int64
-	if base.UserID != "" {
-		_, _ = fmt.Sscan(base.UserID, &userID)
-	}
-	grp, err := base.Global.Queries.GetGroupByPath(r.Context(), queries.GetGroupByPathParams{
-		Column1: base.GroupPath,
-		UserID:  userID,
-	})
+const readmeFilename = "README.md"
+
+// indexView is the part of a repository's index that depends on the
+// commit shown.
+type indexView struct {
+	Commits []git2c.Commit
+	Readme  template.HTML
+}
+
+func computeIndex(ctx context.Context, repo *wtypes.Repo, commit string) (indexView, error) {
+	var view indexView
+	var err error
+	view.Commits, err = repo.Client.Log(ctx, repo.Path, commit, indexCommits)
 	if err != nil {
-		slog.Error("get group by path", "error", err)
-		http.Error(w, "Group not found", http.StatusNotFound)
-		return
+		return view, err
 	}
 
-	repoRow, err := base.Global.Queries.GetRepoByGroupAndName(r.Context(), queries.GetRepoByGroupAndNameParams{
-		GroupID: grp.ID,
-		Name:    repoName,
-	})
-	if err != nil {
-		slog.Error("get repo by name", "error", err)
-		http.Error(w, "Repository not found", http.StatusNotFound)
-		return
+	_, blob, err := repo.Client.CmdTreeRaw(ctx, repo.Path, commit, readmeFilename)
+	switch {
+	case git2c.IsNotFound(err):
+	case err != nil:
+		return view, err
+	case blob == nil || blob.Binary || blob.Size > maxRenderedBlobSize:
+	default:
+		content, err := io.ReadAll(blob)
+		if err != nil {
+			return view, err
+		}
+		md := goldmark.New(
+			goldmark.WithExtensions(extension.GFM),
+		)
+		var buf bytes.Buffer
+		if err := md.Convert(content, &buf); err == nil {
+			view.Readme = template.HTML(buf.String())
+		} else {
+			view.Readme = template.HTML(template.HTMLEscapeString(string(content)))
+		}
 	}
+	return view, nil
+}
 
-	repoPath := filepath.Join(base.Global.Config.Git.RepoDir, fmt.Sprintf("%d.git", repoRow.ID))
+func (h *HTTP) Index(w http.ResponseWriter, r *http.Request, _ wtypes.Vars) {
+	base := wtypes.Base(r)
+	repo := base.Repo
 
-	var commits []git2c.Commit
-	var readme template.HTML
-	var commitsErr error
-	var readmeFile *git2c.FilenameContents
-	var cerr error
-	client, err := git2c.NewClient(r.Context(), base.Global.Config.Git.Socket)
-	if err == nil {
-		defer func() { _ = client.Close() }()
-		commits, readmeFile, cerr = client.CmdIndex(repoPath)
-		if cerr != nil {
-			commitsErr = cerr
-			slog.Error("git2d CmdIndex failed", "error", cerr, "path", repoPath)
-		} else if readmeFile != nil {
-			nameLower := strings.ToLower(readmeFile.Filename)
-			if strings.HasSuffix(nameLower, ".md") || strings.HasSuffix(nameLower, ".markdown") || nameLower == "readme" {
-				md := goldmark.New(
-					goldmark.WithExtensions(extension.GFM),
-				)
-				var buf bytes.Buffer
-				if err := md.Convert(readmeFile.Content, &buf); err == nil {
-					readme = template.HTML(buf.String())
-				} else {
-					readme = template.HTML(template.HTMLEscapeString(string(readmeFile.Content)))
-				}
-			} else {
-				readme = template.HTML(template.HTMLEscapeString(string(readmeFile.Content)))
-			}
-		}
+	var view indexView
+	head, commitsErr := resolveRev(r.Context(), base)
+	if git2c.IsNotFound(commitsErr) {
+		commitsErr = nil // no commits yet
+	} else if commitsErr != nil {
+		slog.Error("resolve ref failed", "error", commitsErr, "path", repo.Path)
 	} else {
-		commitsErr = err
-		slog.Error("git2d connect failed", "error", err)
+		view, commitsErr = viewcache.Get(r.Context(), base.Global.ViewCache, viewcache.Key(repo.ID, "index", head), func() (indexView, error) {
+			return computeIndex(r.Context(), repo, head)
+		})
+		if commitsErr != nil {
+			slog.Error("computing repo index failed", "error", commitsErr, "path", repo.Path)
+		}
 	}
+	commits, readme := view.Commits, view.Readme
 
 	sshRoot := strings.TrimSuffix(base.Global.Config.SSH.Root, "/")
 	httpRoot := strings.TrimSuffix(base.Global.Config.Web.Root, "/")
-	pathPart := misc.SegmentsToURL(base.GroupPath) + "/-/repos/" + url.PathEscape(repoRow.Name)
+	pathPart := misc.SegmentsToURL(base.GroupPath) + "/-/repos/" + url.PathEscape(repo.Name)
 	sshURL := ""
 	httpURL := ""
 	if sshRoot != "" {
@@ -113,8 +113,8 @@ func (h *HTTP) Index(w http.ResponseWriter, r *http.Request, v wtypes.Vars) {
 	data := map[string]any{
 		"BaseData":         base,
 		"group_path":       base.GroupPath,
-		"repo_name":        repoRow.Name,
-		"repo_description": repoRow.Description,
+		"repo_name":        repo.Name,
+		"repo_description": repo.Description,
 		"ssh_clone_url":    cloneURL,
 		"ref_name":         base.RefName,
 		"commits":          commits,
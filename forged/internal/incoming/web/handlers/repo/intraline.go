@@ -0,0 +1,132 @@
+package repo
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// lineSegment is a run of text within a diff line. Changed segments are
+// the words that differ from the paired line on the other side.
+type lineSegment struct {
+	Text    string
+	Changed bool
+}
+
+// maxIntralineCells bounds the LCS table so that very long lines don't
+// cost quadratic time; such lines are simply not highlighted.
+const maxIntralineCells = 1 << 16
+
+// tokenizeWords splits s into runs of word characters, runs of whitespace,
+// and single other characters.
+func tokenizeWords(s string) []string {
+	var tokens []string
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		end := size
+		switch {
+		case isWordRune(r):
+			for end < len(s) {
+				r2, size2 := utf8.DecodeRuneInString(s[end:])
+				if !isWordRune(r2) {
+					break
+				}
+				end += size2
+			}
+		case unicode.IsSpace(r):
+			for end < len(s) {
+				r2, size2 := utf8.DecodeRuneInString(s[end:])
+				if !unicode.IsSpace(r2) {
+					break
+				}
+				end += size2
+			}
+		}
+		tokens = append(tokens, s[:end])
+		s = s[end:]
+	}
+	return tokens
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// intralineSegments computes word-level differences between a deleted
+// line and the added line that replaced it. It returns nil for both when
+// the lines are too long or have nothing in common, in which case
+// highlighting would not help.
+func intralineSegments(oldLine, newLine string) (oldSegs, newSegs []lineSegment) {
+	a := tokenizeWords(oldLine)
+	b := tokenizeWords(newLine)
+	if len(a) == 0 || len(b) == 0 || (len(a)+1)*(len(b)+1) > maxIntralineCells {
+		return nil, nil
+	}
+
+	// lcs[i][j] is the LCS length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	if lcs[0][0] == 0 {
+		return nil, nil
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			oldSegs = appendSegment(oldSegs, a[i], false)
+			newSegs = appendSegment(newSegs, b[j], false)
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			oldSegs = appendSegment(oldSegs, a[i], true)
+			i++
+		default:
+			newSegs = appendSegment(newSegs, b[j], true)
+			j++
+		}
+	}
+	return oldSegs, newSegs
+}
+
+func appendSegment(segs []lineSegment, text string, changed bool) []lineSegment {
+	if n := len(segs); n > 0 && segs[n-1].Changed == changed {
+		segs[n-1].Text += text
+		return segs
+	}
+	return append(segs, lineSegment{Text: text, Changed: changed})
+}
+
+// highlightIntraline pairs each run of deletions with the run of additions
+// that directly follows it, line by line, and fills in their segments.
+func highlightIntraline(lines []usableLine) {
+	for i := 0; i < len(lines); {
+		if lines[i].Operation != 2 {
+			i++
+			continue
+		}
+		delStart := i
+		for i < len(lines) && lines[i].Operation == 2 {
+			i++
+		}
+		addStart := i
+		for i < len(lines) && lines[i].Operation == 1 {
+			i++
+		}
+		n := min(addStart-delStart, i-addStart)
+		for k := range n {
+			del, add := &lines[delStart+k], &lines[addStart+k]
+			del.Segments, add.Segments = intralineSegments(del.Content, add.Content)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package repo
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIntralineSegments(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		old, new         string
+		wantOld, wantNew []lineSegment
+	}{
+		{
+			"return x + 1", "return y + 1",
+			[]lineSegment{{"return ", false}, {"x", true}, {" + 1", false}},
+			[]lineSegment{{"return ", false}, {"y", true}, {" + 1", false}},
+		},
+		{
+			"foo(a, b)", "foo(a, b, c)",
+			[]lineSegment{{"foo(a, b)", false}},
+			[]lineSegment{{"foo(a, b", false}, {", c", true}, {")", false}},
+		},
+		{
+			"same", "same",
+			[]lineSegment{{"same", false}},
+			[]lineSegment{{"same", false}},
+		},
+		{
+			"naïve_name := 1", "naïve_name := 2",
+			[]lineSegment{{"naïve_name := ", false}, {"1", true}},
+			[]lineSegment{{"naïve_name := ", false}, {"2", true}},
+		},
+		{"alpha", "beta", nil, nil},
+		{"", "anything", nil, nil},
+		{"anything", "", nil, nil},
+		{strings.Repeat("a ", 200), strings.Repeat("a ", 200) + "b", nil, nil},
+	}
+	for _, tt := range tests {
+		gotOld, gotNew := intralineSegments(tt.old, tt.new)
+		if !reflect.DeepEqual(gotOld, tt.wantOld) || !reflect.DeepEqual(gotNew, tt.wantNew) {
+			t.Errorf("intralineSegments(%q, %q) = %v, %v; want %v, %v", tt.old, tt.new, gotOld, gotNew, tt.wantOld, tt.wantNew)
+		}
+	}
+}
@@ -1,15 +1,12 @@
 package repo
 
 import (
-	"fmt"
 	"log/slog"
 	"net/http"
 	"net/url"
-	"path/filepath"
 	"time"
 
 	"go.lindenii.runxiyu.org/forge/forged/internal/common/misc"
-	"go.lindenii.runxiyu.org/forge/forged/internal/database/queries"
 	wtypes "go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/types"
 	"go.lindenii.runxiyu.org/forge/forged/internal/ipc/git2c"
 )
@@ -26,55 +23,19 @@ type logCommit struct {
 	Author  logAuthor
 }
 
-func (h *HTTP) Log(w http.ResponseWriter, r *http.Request, v wtypes.Vars) {
+func (h *HTTP) Log(w http.ResponseWriter, r *http.Request, _ wtypes.Vars) {
 	base := wtypes.Base(r)
-	repoName := v["repo"]
 
-	var userID int64
-	if base.UserID != "" {
-		_, _ = fmt.Sscan(base.UserID, &userID)
-	}
-	grp, err := base.Global.Queries.GetGroupByPath(r.Context(), queries.GetGroupByPathParams{Column1: base.GroupPath, UserID: userID})
-	if err != nil {
-		slog.Error("get group by path", "error", err)
-		http.Error(w, "Group not found", http.StatusNotFound)
-		return
-	}
-	repoRow, err := base.Global.Queries.GetRepoByGroupAndName(r.Context(), queries.GetRepoByGroupAndNameParams{GroupID: grp.ID, Name: repoName})
-	if err != nil {
-		slog.Error("get repo by name", "error", err)
-		http.Error(w, "Repository not found", http.StatusNotFound)
-		return
-	}
-
-	repoPath := filepath.Join(base.Global.Config.Git.RepoDir, fmt.Sprintf("%d.git", repoRow.ID))
-	client, err := git2c.NewClient(r.Context(), base.Global.Config.Git.Socket)
-	if err != nil {
-		slog.Error("git2d connect failed", "error", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
-	}
-	defer func() { _ = client.Close() }()
-
-	var refspec string
-	if base.RefType == "" {
-		refspec = ""
-	} else {
-		hex, rerr := client.ResolveRef(repoPath, base.RefType, base.RefName)
-		if rerr != nil {
-			slog.Error("resolve ref failed", "error", rerr)
-			refspec = ""
-		} else {
-			refspec = hex
-		}
-	}
+	repo := base.Repo
 
 	var rawCommits []git2c.Commit
-	rawCommits, err = client.Log(repoPath, refspec, 0)
-	var commitsErr error
-	if err != nil {
-		commitsErr = err
-		slog.Error("git2d log failed", "error", err)
+	rev, commitsErr := resolveRev(r.Context(), base)
+	if git2c.IsNotFound(commitsErr) {
+		commitsErr = nil // no commits yet
+	} else if commitsErr != nil {
+		slog.Error("resolve ref failed", "error", commitsErr)
+	} else if rawCommits, commitsErr = cachedLog(r.Context(), base, rev); commitsErr != nil {
+		slog.Error("git2d log failed", "error", commitsErr)
 	}
 	commits := make([]logCommit, 0, len(rawCommits))
 	for _, c := range rawCommits {
@@ -86,12 +47,12 @@ func (h *HTTP) Log(w http.ResponseWriter, r *http.Request, v wtypes.Vars) {
 		})
 	}
 
-	repoURLRoot := "/" + misc.SegmentsToURL(base.GroupPath) + "/-/repos/" + url.PathEscape(repoRow.Name) + "/"
+	repoURLRoot := "/" + misc.SegmentsToURL(base.GroupPath) + "/-/repos/" + url.PathEscape(repo.Name) + "/"
 	data := map[string]any{
 		"BaseData":         base,
 		"group_path":       base.GroupPath,
-		"repo_name":        repoRow.Name,
-		"repo_description": repoRow.Description,
+		"repo_name":        repo.Name,
+		"repo_description": repo.Description,
 		"repo_url_root":    repoURLRoot,
 		"ref_name":         base.RefName,
 		"commits":          commits,
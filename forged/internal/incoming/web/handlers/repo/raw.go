@@ -1,60 +1,49 @@
 package repo
 
 import (
-	"fmt"
 	"log/slog"
+	"mime"
 	"net/http"
 	"net/url"
-	"path/filepath"
+	"path"
+	"strconv"
 	"strings"
+	"time"
 
 	"go.lindenii.runxiyu.org/forge/forged/internal/common/misc"
-	"go.lindenii.runxiyu.org/forge/forged/internal/database/queries"
 	wtypes "go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/types"
 	"go.lindenii.runxiyu.org/forge/forged/internal/ipc/git2c"
 )
 
 func (h *HTTP) Raw(w http.ResponseWriter, r *http.Request, v wtypes.Vars) {
 	base := wtypes.Base(r)
-	repoName := v["repo"]
 	rawPathSpec := v["rest"]
 	pathSpec := strings.TrimSuffix(rawPathSpec, "/")
 
-	var userID int64
-	if base.UserID != "" {
-		_, _ = fmt.Sscan(base.UserID, &userID)
-	}
-	grp, err := base.Global.Queries.GetGroupByPath(r.Context(), queries.GetGroupByPathParams{Column1: base.GroupPath, UserID: userID})
-	if err != nil {
-		slog.Error("get group by path", "error", err)
-		http.Error(w, "Group not found", http.StatusNotFound)
-		return
-	}
-	repoRow, err := base.Global.Queries.GetRepoByGroupAndName(r.Context(), queries.GetRepoByGroupAndNameParams{GroupID: grp.ID, Name: repoName})
-	if err != nil {
-		slog.Error("get repo by name", "error", err)
-		http.Error(w, "Repository not found", http.StatusNotFound)
-		return
-	}
+	repo := base.Repo
+	repoPath := repo.Path
 
-	repoPath := filepath.Join(base.Global.Config.Git.RepoDir, fmt.Sprintf("%d.git", repoRow.ID))
-
-	client, err := git2c.NewClient(r.Context(), base.Global.Config.Git.Socket)
-	if err != nil {
-		slog.Error("git2d connect failed", "error", err)
+	rev, err := resolveRev(r.Context(), base)
+	if git2c.IsNotFound(err) {
+		http.Error(w, "Ref not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		slog.Error("git2d ResolveRef failed", "error", err, "path", repoPath)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-	defer func() { _ = client.Close() }()
 
-	files, content, err := client.CmdTreeRaw(repoPath, pathSpec)
-	if err != nil {
+	files, blob, err := cachedTreeRaw(r.Context(), base, rev, pathSpec)
+	if git2c.IsNotFound(err) {
+		http.Error(w, "Path not found", http.StatusNotFound)
+		return
+	} else if err != nil {
 		slog.Error("git2d CmdTreeRaw failed", "error", err, "path", repoPath, "spec", pathSpec)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	repoURLRoot := "/" + misc.SegmentsToURL(base.GroupPath) + "/-/repos/" + url.PathEscape(repoRow.Name) + "/"
+	repoURLRoot := "/" + misc.SegmentsToURL(base.GroupPath) + "/-/repos/" + url.PathEscape(repo.Name) + "/"
 
 	switch {
 	case files != nil:
@@ -64,8 +53,8 @@ func (h *HTTP) Raw(w http.ResponseWriter, r *http.Request, v wtypes.Vars) {
 		data := map[string]any{
 			"BaseData":         base,
 			"group_path":       base.GroupPath,
-			"repo_name":        repoRow.Name,
-			"repo_description": repoRow.Description,
+			"repo_name":        repo.Name,
+			"repo_description": repo.Description,
 			"repo_url_root":    repoURLRoot,
 			"ref_name":         base.RefName,
 			"path_spec":        pathSpec,
@@ -78,13 +67,122 @@ func (h *HTTP) Raw(w http.ResponseWriter, r *http.Request, v wtypes.Vars) {
 			slog.Error("render repo raw dir", "error", err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		}
-	case content != "":
+	case blob != nil:
 		if base.DirMode && misc.RedirectNoDir(w, r) {
 			return
 		}
-		w.Header().Set("Content-Type", "application/octet-stream")
-		_, _ = w.Write([]byte(content))
+		name := path.Base(pathSpec)
+		header := w.Header()
+		sniff, err := blob.Peek(512)
+		if err != nil {
+			slog.Error("reading blob failed", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		contentType, attachment := rawContentType(name, sniff, blob.Binary)
+		header.Set("Content-Type", contentType)
+		if attachment {
+			header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+		}
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("Content-Security-Policy", "default-src 'none'; sandbox")
+		header.Set("ETag", `"`+blob.OID+`"`)
+		// Only a full commit ID pins the content; branches and tags move.
+		if base.RefType == "commit" {
+			header.Set("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			header.Set("Cache-Control", "no-cache")
+		}
+		// The blob is streamed and can't seek backwards, so ranges it
+		// can't serve in one pass are ignored in favour of the whole blob.
+		if rng := r.Header.Get("Range"); rng != "" && !ascendingRanges(rng, blob.Size) {
+			r.Header.Del("Range")
+		}
+		http.ServeContent(w, r, name, time.Time{}, blob)
 	default:
 		http.Error(w, "Unknown object type", http.StatusInternalServerError)
 	}
 }
+
+// ascendingRanges reports whether a Range header is a valid list of byte
+// ranges in ascending order that don't overlap, for a file of size bytes.
+func ascendingRanges(header string, size uint64) bool {
+	specs, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return false
+	}
+	next := uint64(0) // the first byte that the next range may start at
+	for spec := range strings.SplitSeq(specs, ",") {
+		first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+		if !ok {
+			return false
+		}
+		var start, end uint64
+		var err error
+		if first == "" {
+			n, err := strconv.ParseUint(last, 10, 64)
+			if err != nil {
+				return false
+			}
+			start, end = size-min(n, size), size
+		} else {
+			if start, err = strconv.ParseUint(first, 10, 64); err != nil {
+				return false
+			}
+			end = size
+			if last != "" {
+				if end, err = strconv.ParseUint(last, 10, 64); err != nil || end < start {
+					return false
+				}
+				end = min(end+1, size)
+			}
+		}
+		if start < next {
+			return false
+		}
+		next = max(end, start+1)
+	}
+	return true
+}
+
+// activeContentTypes may run script when rendered by a browser, so
+// they're never served as themselves from the forge's origin.
+var activeContentTypes = map[string]bool{
+	"text/html":              true,
+	"application/xhtml+xml":  true,
+	"image/svg+xml":          true,
+	"text/xml":               true,
+	"application/xml":        true,
+	"text/javascript":        true,
+	"application/javascript": true,
+	"application/pdf":        true,
+}
+
+// rawContentType picks the Content-Type for a raw blob from its name,
+// falling back to sniffing the start of its contents. Active content is
+// downgraded to text/plain if it's text, and otherwise served as an
+// attachment.
+func rawContentType(name string, sniff []byte, binary bool) (contentType string, attachment bool) {
+	contentType = mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = http.DetectContentType(sniff)
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "application/octet-stream", true
+	}
+	isText := !binary
+	if activeContentTypes[mediaType] {
+		if isText {
+			return "text/plain; charset=utf-8", false
+		}
+		return "application/octet-stream", true
+	}
+	if strings.HasPrefix(mediaType, "text/") {
+		if isText {
+			return mediaType + "; charset=utf-8", false
+		}
+		return mediaType, false
+	}
+	return contentType, false
+}
@@ -0,0 +1,63 @@
+package repo
+
+import "testing"
+
+func TestAscendingRanges(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"bytes=0-99", true},
+		{"bytes=100-", true},
+		{"bytes=-50", true},
+		{"bytes=0-9, 20-29", true},
+		{"bytes=0-9,10-19,-5", true},
+		{"bytes=0-0,1-1", true},
+		{"bytes=20-29, 0-9", false},
+		{"bytes=0-9, 5-14", false},
+		{"bytes=0-9, -995", false},
+		{"bytes=-10, 0-5", false},
+		{"bytes=9-0", false},
+		{"bytes=a-b", false},
+		{"bytes=5", false},
+		{"items=0-9", false},
+	}
+	for _, tt := range tests {
+		if got := ascendingRanges(tt.header, 1000); got != tt.want {
+			t.Errorf("ascendingRanges(%q, 1000) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestRawContentType(t *testing.T) {
+	t.Parallel()
+	// Names use only extensions from mime's built-in table, so that the
+	// system's MIME types can't change the results.
+	tests := []struct {
+		name       string
+		sniff      string
+		binary     bool
+		want       string
+		attachment bool
+	}{
+		{"logo.png", "\x89PNG\r\n\x1a\n", true, "image/png", false},
+		{"style.css", "body {}", false, "text/css; charset=utf-8", false},
+		{"style.css", "\x00\x01", true, "text/css", false},
+		{"data.json", "{}", false, "application/json", false},
+		{"index.html", "<html>", false, "text/plain; charset=utf-8", false},
+		{"icon.svg", "<svg>", false, "text/plain; charset=utf-8", false},
+		{"app.js", "alert(1)", false, "text/plain; charset=utf-8", false},
+		{"paper.pdf", "%PDF-1.4\x00", true, "application/octet-stream", true},
+		{"README", "plain words\n", false, "text/plain; charset=utf-8", false},
+		{"page", "<!DOCTYPE html><html>", false, "text/plain; charset=utf-8", false},
+		{"blob", "\x00\x01\x02\x03", true, "application/octet-stream", false},
+		{"picture", "GIF89a", true, "image/gif", false},
+	}
+	for _, tt := range tests {
+		got, attachment := rawContentType(tt.name, []byte(tt.sniff), tt.binary)
+		if got != tt.want || attachment != tt.attachment {
+			t.Errorf("rawContentType(%q, %q, %v) = %q, %v; want %q, %v", tt.name, tt.sniff, tt.binary, got, attachment, tt.want, tt.attachment)
+		}
+	}
+}
@@ -1,61 +1,51 @@
 package repo
 
 import (
-	"fmt"
 	"html/template"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/url"
-	"path/filepath"
 	"strings"
 
 	"go.lindenii.runxiyu.org/forge/forged/internal/common/misc"
-	"go.lindenii.runxiyu.org/forge/forged/internal/database/queries"
 	wtypes "go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/types"
 	"go.lindenii.runxiyu.org/forge/forged/internal/ipc/git2c"
 )
 
+// maxRenderedBlobSize is the largest file the tree view shows inline;
+// larger ones are only available raw.
+const maxRenderedBlobSize = 1 << 20
+
 func (h *HTTP) Tree(w http.ResponseWriter, r *http.Request, v wtypes.Vars) {
 	base := wtypes.Base(r)
-	repoName := v["repo"]
 	rawPathSpec := v["rest"]
 	pathSpec := strings.TrimSuffix(rawPathSpec, "/")
 
-	var userID int64
-	if base.UserID != "" {
-		_, _ = fmt.Sscan(base.UserID, &userID)
-	}
-	grp, err := base.Global.Queries.GetGroupByPath(r.Context(), queries.GetGroupByPathParams{Column1: base.GroupPath, UserID: userID})
-	if err != nil {
-		slog.Error("get group by path", "error", err)
-		http.Error(w, "Group not found", http.StatusNotFound)
-		return
-	}
-	repoRow, err := base.Global.Queries.GetRepoByGroupAndName(r.Context(), queries.GetRepoByGroupAndNameParams{GroupID: grp.ID, Name: repoName})
-	if err != nil {
-		slog.Error("get repo by name", "error", err)
-		http.Error(w, "Repository not found", http.StatusNotFound)
-		return
-	}
-
-	repoPath := filepath.Join(base.Global.Config.Git.RepoDir, fmt.Sprintf("%d.git", repoRow.ID))
+	repo := base.Repo
+	repoPath := repo.Path
 
-	client, err := git2c.NewClient(r.Context(), base.Global.Config.Git.Socket)
-	if err != nil {
-		slog.Error("git2d connect failed", "error", err)
+	rev, err := resolveRev(r.Context(), base)
+	if git2c.IsNotFound(err) {
+		http.Error(w, "Ref not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		slog.Error("git2d ResolveRef failed", "error", err, "path", repoPath)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-	defer func() { _ = client.Close() }()
 
-	files, content, err := client.CmdTreeRaw(repoPath, pathSpec)
-	if err != nil {
+	files, blob, err := cachedTreeRaw(r.Context(), base, rev, pathSpec)
+	if git2c.IsNotFound(err) {
+		http.Error(w, "Path not found", http.StatusNotFound)
+		return
+	} else if err != nil {
 		slog.Error("git2d CmdTreeRaw failed", "error", err, "path", repoPath, "spec", pathSpec)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	repoURLRoot := "/" + misc.SegmentsToURL(base.GroupPath) + "/-/repos/" + url.PathEscape(repoRow.Name) + "/"
+	repoURLRoot := "/" + misc.SegmentsToURL(base.GroupPath) + "/-/repos/" + url.PathEscape(repo.Name) + "/"
 
 	switch {
 	case files != nil:
@@ -65,8 +55,8 @@ func (h *HTTP) Tree(w http.ResponseWriter, r *http.Request, v wtypes.Vars) {
 		data := map[string]any{
 			"BaseData":         base,
 			"group_path":       base.GroupPath,
-			"repo_name":        repoRow.Name,
-			"repo_description": repoRow.Description,
+			"repo_name":        repo.Name,
+			"repo_description": repo.Description,
 			"repo_url_root":    repoURLRoot,
 			"ref_name":         base.RefName,
 			"path_spec":        pathSpec,
@@ -81,17 +71,31 @@ func (h *HTTP) Tree(w http.ResponseWriter, r *http.Request, v wtypes.Vars) {
 			slog.Error("render repo tree dir", "error", err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		}
-	case content != "":
+	case blob != nil:
 		if base.DirMode && misc.RedirectNoDir(w, r) {
 			return
 		}
-		escaped := template.HTMLEscapeString(content)
-		rendered := template.HTML("<pre class=\"chroma\"><code>" + escaped + "</code></pre>")
+		var rendered template.HTML
+		switch {
+		case blob.Binary:
+			rendered = template.HTML("<p>Binary file not shown.</p>")
+		case blob.Size > maxRenderedBlobSize:
+			rendered = template.HTML("<p>File too large to display.</p>")
+		default:
+			content, err := io.ReadAll(blob)
+			if err != nil {
+				slog.Error("reading blob failed", "error", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			escaped := template.HTMLEscapeString(string(content))
+			rendered = template.HTML("<pre class=\"chroma\"><code>" + escaped + "</code></pre>")
+		}
 		data := map[string]any{
 			"BaseData":         base,
 			"group_path":       base.GroupPath,
-			"repo_name":        repoRow.Name,
-			"repo_description": repoRow.Description,
+			"repo_name":        repo.Name,
+			"repo_description": repo.Description,
 			"repo_url_root":    repoURLRoot,
 			"ref_name":         base.RefName,
 			"path_spec":        pathSpec,
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	netmail "net/mail"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.lindenii.runxiyu.org/forge/forged/internal/common/misc"
+	wtypes "go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/types"
+)
+
+const (
+	maxUsernameLength    = 32
+	minPasswordLength    = 8
+	maxDisplayNameLength = 100
+
+	// PostgreSQL's SQLSTATEs for unique_violation and exclusion_violation.
+	uniqueViolation    = "23505"
+	exclusionViolation = "23P01"
+)
+
+func pgErrorCode(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	return ""
+}
+
+func isUniqueViolation(err error) bool {
+	return pgErrorCode(err) == uniqueViolation
+}
+
+// checkUsername returns why a username can't be registered, or "" if it
+// can. Usernames appear in URLs and SSH commands, so they are kept to ASCII
+// letters, digits and a little punctuation.
+func checkUsername(username string) string {
+	if username == "" || len(username) > maxUsernameLength {
+		return fmt.Sprintf("Usernames must be between 1 and %d characters long", maxUsernameLength)
+	}
+	if username[0] == '-' || username[0] == '.' {
+		return "Usernames must not begin with a hyphen or period"
+	}
+	for _, c := range username {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return "Usernames may only contain letters, digits, hyphens, underscores and periods"
+		}
+	}
+	return ""
+}
+
+// checkNewPassword returns why a password can't be set, or "" if it can.
+func checkNewPassword(password, confirm string) string {
+	if password != confirm {
+		return "The passwords do not match"
+	}
+	if utf8.RuneCountInString(password) < minPasswordLength {
+		return fmt.Sprintf("Passwords must be at least %d characters long", minPasswordLength)
+	}
+	return ""
+}
+
+// checkEmail reports whether s is a bare email address.
+func checkEmail(s string) bool {
+	addr, err := netmail.ParseAddress(s)
+	return err == nil && addr.Address == s
+}
+
+// newToken returns a random token for a link, and the hash it is stored
+// under.
+func newToken() (string, []byte) {
+	token := rand.Text()
+	return token, hashToken(token)
+}
+
+func hashToken(token string) []byte {
+	hash := sha256.Sum256(misc.StringToBytes(token))
+	return hash[:]
+}
+
+// mailVerification sends the link that verifies an email address.
+func mailVerification(base *wtypes.BaseData, username, email, token string) error {
+	link := strings.TrimSuffix(base.Global.Config.Web.Root, "/") + "/-/verify-email/" + token
+	body := "Someone, hopefully you, added this address to the account " + username +
+		" on " + base.Global.ForgeTitle + ".\n\n" +
+		"To verify it, visit:\n\n" + link + "\n\n" +
+		"The link expires in a day. If you did not expect this message, you may ignore it.\n"
+	return base.Global.Mail.Send(email, "Verify your email address", body)
+}
+
+// currentUser returns the ID of the logged-in user, redirecting to the
+// login page if there isn't one.
+func currentUser(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	userID, err := strconv.ParseInt(wtypes.Base(r).UserID, 10, 64)
+	if err != nil {
+		http.Redirect(w, r, "/-/login", http.StatusSeeOther)
+		return 0, false
+	}
+	return userID, true
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"go.lindenii.runxiyu.org/forge/forged/internal/common/misc"
+	"go.lindenii.runxiyu.org/forge/forged/internal/database/queries"
+	wtypes "go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/types"
+	"go.lindenii.runxiyu.org/forge/forged/internal/outgoing/federation"
+)
+
+// identityClaim asks the user to prove that an account on another forge is
+// theirs by signing a challenge with one of the SSH keys that the forge
+// publishes for it.
+type identityClaim struct {
+	Service   string
+	Name      string
+	Username  string
+	Challenge string
+	Namespace string
+}
+
+func identityChallenge(base *wtypes.BaseData, userID int64, service, username string) string {
+	return fmt.Sprintf("Verify %s account %s for user %d on %s", service, username, userID, base.Global.Config.Web.Root)
+}
+
+// federationServices returns the services that accounts may be verified on,
+// for the settings page.
+func federationServices(base *wtypes.BaseData) []providerLink {
+	names := base.Global.Federation.Services()
+	services := make([]providerLink, 0, len(names))
+	for _, name := range names {
+		v, _ := base.Global.Federation.Verifier(name)
+		services = append(services, providerLink{ID: name, Name: v.Name()})
+	}
+	return services
+}
+
+func (h *SettingsHTTP) VerifyIdentity(w http.ResponseWriter, r *http.Request, _ wtypes.Vars) {
+	userID, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	base := wtypes.Base(r)
+
+	service := r.PostFormValue("service")
+	verifier, ok := base.Global.Federation.Verifier(service)
+	if !ok {
+		http.Error(w, "Unknown service", http.StatusNotFound)
+		return
+	}
+	username := strings.TrimSpace(r.PostFormValue("username"))
+	claim := &identityClaim{
+		Service:   service,
+		Name:      verifier.Name(),
+		Username:  username,
+		Challenge: identityChallenge(base, userID, service, username),
+		Namespace: keyNamespace,
+	}
+
+	signature := r.PostFormValue("signature")
+	if signature == "" {
+		// Fail early rather than after the user has signed something.
+		keys, err := verifier.Keys(r.Context(), username)
+		if msg := identityError(err); msg != "" {
+			h.message(w, r, userID, msg)
+			return
+		}
+		if len(keys) == 0 {
+			h.message(w, r, userID, verifier.Name()+" has no SSH keys for "+username+"; add one there first")
+			return
+		}
+		h.render(w, r, userID, settingsPage{
+			Message:  "Sign the text below with one of the SSH keys on your " + verifier.Name() + " account.",
+			Identity: claim,
+		}) //exhaustruct:ignore
+		return
+	}
+
+	key, err := federation.Verify(r.Context(), verifier, username, misc.StringToBytes(claim.Challenge), misc.StringToBytes(signature), keyNamespace)
+	if msg := identityError(err); msg != "" {
+		h.render(w, r, userID, settingsPage{
+			Message:  msg,
+			Identity: claim,
+		}) //exhaustruct:ignore
+		return
+	}
+	keyString := authorizedKeyString(key)
+	err = base.Global.Queries.UpsertVerifiedIdentity(r.Context(), queries.UpsertVerifiedIdentityParams{
+		UserID:         userID,
+		Service:        service,
+		RemoteUsername: username,
+		KeyString:      &keyString,
+	})
+	if isUniqueViolation(err) {
+		h.message(w, r, userID, "This "+verifier.Name()+" account is already verified by another account here")
+		return
+	} else if err != nil {
+		slog.Error("failed to insert verified identity", "error", err)
+		http.Error(w, "Failed to verify account", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("verified federated identity", "user", userID, "service", service, "remote", username)
+	http.Redirect(w, r, "/-/settings/", http.StatusSeeOther)
+}
+
+// identityError returns what to tell the user about an error from
+// verifying an account, or "" if there was none.
+func identityError(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, federation.ErrUsername):
+		return "Invalid username"
+	case errors.Is(err, federation.ErrNoAccount):
+		return "There is no such account"
+	case errors.Is(err, federation.ErrNotVerified):
+		return "The signature is not by any of the SSH keys on that account"
+	default:
+		slog.Warn("failed to fetch federated keys", "error", err)
+		return "The other forge could not be reached; please try again later"
+	}
+}
@@ -0,0 +1,475 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.lindenii.runxiyu.org/forge/forged/internal/common/argon2id"
+	"go.lindenii.runxiyu.org/forge/forged/internal/common/webauthn"
+	"go.lindenii.runxiyu.org/forge/forged/internal/config"
+	"go.lindenii.runxiyu.org/forge/forged/internal/database/dbtest"
+	"go.lindenii.runxiyu.org/forge/forged/internal/global"
+	wtypes "go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/types"
+	"go.lindenii.runxiyu.org/forge/forged/internal/outgoing/federation"
+	"go.lindenii.runxiyu.org/forge/forged/internal/outgoing/mail"
+	"go.lindenii.runxiyu.org/forge/forged/internal/outgoing/oauth"
+	"go.lindenii.runxiyu.org/forge/forged/internal/outgoing/oauth/oauthtest"
+)
+
+// recorder is a templates.Renderer that keeps what it was asked to render.
+type recorder struct {
+	name string
+	data any
+}
+
+func (r *recorder) Render(w http.ResponseWriter, name string, data any) error {
+	r.name, r.data = name, data
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// newGlobal returns what the handlers need to run against a fresh
+// database, with idp as the identity provider named "example".
+func newGlobal(t *testing.T) (*global.Global, *oauthtest.Server) {
+	t.Helper()
+	db, q := dbtest.New(t)
+	fed, err := federation.New(&config.Federation{}, q) //exhaustruct:ignore
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := oauthtest.NewServer()
+	t.Cleanup(idp.Close)
+	idpConfig := idp.Config()
+	provider, err := oauth.New("example", &idpConfig, "https://forge.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &global.Global{
+		ForgeTitle: "Test forge",
+		Config:     &config.Config{}, //exhaustruct:ignore
+		Queries:    q,
+		DB:         db,
+		Mail:       mail.New(&config.SMTP{}), //exhaustruct:ignore
+		OAuth:      map[string]*oauth.Provider{"example": provider},
+		Federation: fed,
+		// Cheap, to keep tests fast.
+		PasswordParams: &argon2id.Params{Memory: 8 * 1024, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+	} //exhaustruct:ignore
+	return g, idp
+}
+
+// exec runs SQL that sets up a test, returning the first column of the
+// first row if there is one.
+func exec(t *testing.T, g *global.Global, sql string, args ...any) int64 {
+	t.Helper()
+	var id int64
+	rows, err := g.DB.Query(t.Context(), sql, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	if rows.Next() {
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// settingsMessage is the message on the settings page rec rendered.
+func settingsMessage(rec *recorder) string {
+	return reflect.ValueOf(rec.data).FieldByName("Page").Interface().(settingsPage).Message
+}
+
+// user is who a request is from, as the router found from its session
+// and address; the zero user is logged out, from an unknown address.
+type user struct {
+	id   int64
+	name string
+	ip   netip.Addr
+}
+
+// do calls handler like the router would for req from u.
+func do(t *testing.T, g *global.Global, handler wtypes.HandlerFunc, req *http.Request, u user, v wtypes.Vars) *httptest.ResponseRecorder {
+	t.Helper()
+	base := &wtypes.BaseData{
+		Global:   g,
+		ClientIP: u.ip,
+	} //exhaustruct:ignore
+	if u.id != 0 {
+		base.UserID, base.Username = strconv.FormatInt(u.id, 10), u.name
+	}
+	req = req.WithContext(wtypes.WithBaseData(req.Context(), base))
+	w := httptest.NewRecorder()
+	handler(w, req, v)
+	return w
+}
+
+// serve is do for a form posted to target.
+func serve(t *testing.T, g *global.Global, handler wtypes.HandlerFunc, target string, u user, form url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return do(t, g, handler, req, u, nil)
+}
+
+func TestUnlinkIdentity(t *testing.T) {
+	t.Parallel()
+	g, _ := newGlobal(t)
+	rec := &recorder{} //exhaustruct:ignore
+	h := NewSettingsHTTP(rec)
+
+	// A user created by logging in with the identity provider, who has
+	// since verified a SourceHut account by its SSH key.
+	userID := exec(t, g, `INSERT INTO users (username, type) VALUES ('alice', 'federated') RETURNING id`)
+	alice := user{id: userID, name: "alice"}
+	exec(t, g, `INSERT INTO federated_identities (user_id, service, remote_username, remote_subject) VALUES ($1, 'example', 'alice', 'alice-subject')`, userID)
+	exec(t, g, `INSERT INTO federated_identities (user_id, service, remote_username, key_string) VALUES ($1, 'sourcehut', 'alice', 'ssh-ed25519 AAAA')`, userID)
+	linked := func(service string) bool {
+		return exec(t, g, `SELECT COUNT(*) FROM federated_identities WHERE user_id = $1 AND service = $2`, userID, service) == 1
+	}
+
+	// The SourceHut account can't be logged in with, so it doesn't count.
+	w := serve(t, g, h.UnlinkIdentity, "/-/settings/identities/delete", alice, url.Values{"service": {"example"}})
+	if w.Code != http.StatusOK || rec.name != "settings" || !linked("example") {
+		t.Fatalf("unlinking the only login: got %d rendering %q", w.Code, rec.name)
+	}
+	if msg := settingsMessage(rec); !strings.Contains(msg, "Set a password") {
+		t.Errorf("unlinking the only login says %q", msg)
+	}
+
+	w = serve(t, g, h.UnlinkIdentity, "/-/settings/identities/delete", alice, url.Values{"service": {"sourcehut"}})
+	if w.Code != http.StatusSeeOther || linked("sourcehut") {
+		t.Fatalf("unlinking a verified account: got %d", w.Code)
+	}
+
+	// With a password, the last identity may go too.
+	exec(t, g, `UPDATE users SET password_hash = 'x' WHERE id = $1`, userID)
+	w = serve(t, g, h.UnlinkIdentity, "/-/settings/identities/delete", alice, url.Values{"service": {"example"}})
+	if w.Code != http.StatusSeeOther || linked("example") {
+		t.Fatalf("unlinking with a password: got %d", w.Code)
+	}
+}
+
+// oauthLogin starts logging in or linking with the identity provider
+// through start, authorizes at the provider, and returns the request with
+// which the provider sends the browser back.
+func oauthLogin(t *testing.T, idp *oauthtest.Server, start func() *httptest.ResponseRecorder) *http.Request {
+	t.Helper()
+	w := start()
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("starting to log in: got %d", w.Code)
+	}
+	callback, err := idp.Authorize(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, callback, nil)
+	for _, c := range w.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	return req
+}
+
+func TestOAuthCallback(t *testing.T) {
+	t.Parallel()
+	g, idp := newGlobal(t)
+	rec := &recorder{} //exhaustruct:ignore
+	h := NewLoginHTTP(rec, 3600)
+	settings := NewSettingsHTTP(rec)
+	vars := wtypes.Vars{"provider": "example"}
+	alice := user{id: exec(t, g, `INSERT INTO users (username, type, password_hash) VALUES ('alice', 'registered', 'x') RETURNING id`), name: "alice"}
+	idp.LogIn(oauthtest.User{Subject: "1234", Username: "alice-there"})
+	logIn := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/-/login/oauth/example", nil)
+		return do(t, g, h.OAuthLogin, req, user{}, vars)
+	}
+	link := func() *httptest.ResponseRecorder {
+		return serve(t, g, settings.LinkIdentity, "/-/settings/identities", alice, url.Values{"provider": {"example"}})
+	}
+	loginError := func() string {
+		return reflect.ValueOf(rec.data).FieldByName("LoginError").String()
+	}
+
+	// The identity isn't linked to anyone yet, and the provider doesn't
+	// create accounts.
+	w := do(t, g, h.OAuthCallback, oauthLogin(t, idp, logIn), user{}, vars)
+	if w.Code != http.StatusOK || !strings.Contains(loginError(), "not linked") {
+		t.Fatalf("logging in unlinked: got %d, %q", w.Code, loginError())
+	}
+
+	// Only the browser that started the login may finish it.
+	for name, mangle := range map[string]func(*http.Request){
+		"no cookie": func(r *http.Request) { r.Header.Del("Cookie") },
+		"other state": func(r *http.Request) {
+			q := r.URL.Query()
+			q.Set("state", "forged")
+			r.URL.RawQuery = q.Encode()
+		},
+	} {
+		req := oauthLogin(t, idp, link)
+		mangle(req)
+		w = do(t, g, h.OAuthCallback, req, alice, vars)
+		if w.Code != http.StatusOK || !strings.Contains(loginError(), "expired") {
+			t.Errorf("%s: got %d, %q", name, w.Code, loginError())
+		}
+	}
+	if exec(t, g, `SELECT COUNT(*) FROM federated_identities`) != 0 {
+		t.Fatal("an identity was linked with a mismatched state")
+	}
+
+	// Nor may someone else.
+	req := oauthLogin(t, idp, link)
+	bob := user{id: exec(t, g, `INSERT INTO users (username, type) VALUES ('bob', 'registered') RETURNING id`), name: "bob"}
+	w = do(t, g, h.OAuthCallback, req, bob, vars)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("finishing another user's link: got %d", w.Code)
+	}
+
+	w = do(t, g, h.OAuthCallback, oauthLogin(t, idp, link), alice, vars)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/-/settings/" {
+		t.Fatalf("linking: got %d to %s", w.Code, w.Header().Get("Location"))
+	}
+	if linked := exec(t, g, `SELECT user_id FROM federated_identities WHERE service = 'example' AND remote_subject = '1234' AND remote_username = 'alice-there'`); linked != alice.id {
+		t.Fatalf("the identity is linked to user %d, want %d", linked, alice.id)
+	}
+
+	// Now it logs alice in.
+	w = do(t, g, h.OAuthCallback, oauthLogin(t, idp, logIn), user{}, vars)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/" {
+		t.Fatalf("logging in: got %d to %s", w.Code, w.Header().Get("Location"))
+	}
+	if sessions := exec(t, g, `SELECT COUNT(*) FROM sessions WHERE user_id = $1`, alice.id); sessions != 1 {
+		t.Errorf("alice has %d sessions", sessions)
+	}
+
+	// A login can't be finished twice.
+	req = oauthLogin(t, idp, logIn)
+	do(t, g, h.OAuthCallback, req.Clone(t.Context()), user{}, vars)
+	w = do(t, g, h.OAuthCallback, req, user{}, vars)
+	if w.Code != http.StatusOK || !strings.Contains(loginError(), "expired") {
+		t.Errorf("replaying a callback: got %d, %q", w.Code, loginError())
+	}
+}
+
+func TestLoginRehash(t *testing.T) {
+	t.Parallel()
+	g, _ := newGlobal(t)
+	rec := &recorder{} //exhaustruct:ignore
+	h := NewLoginHTTP(rec, 3600)
+	weak := *g.PasswordParams
+	weak.Memory, weak.Iterations = 4*1024, 1
+	weakHash, err := argon2id.CreateHash("correct horse", &weak)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID := exec(t, g, `INSERT INTO users (username, type, password_hash) VALUES ('alice', 'registered', $1) RETURNING id`, weakHash)
+	storedHash := func() string {
+		t.Helper()
+		var hash string
+		if err := g.DB.QueryRow(t.Context(), `SELECT password_hash FROM users WHERE id = $1`, userID).Scan(&hash); err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+	logIn := func(password string) *httptest.ResponseRecorder {
+		return serve(t, g, h.Login, "/-/login", user{}, url.Values{"username": {"alice"}, "password": {password}})
+	}
+
+	// A wrong password changes nothing.
+	if w := logIn("wrong"); w.Code != http.StatusOK || storedHash() != weakHash {
+		t.Fatalf("wrong password: got %d", w.Code)
+	}
+
+	if w := logIn("correct horse"); w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/" {
+		t.Fatalf("logging in: got %d to %s", w.Code, w.Header().Get("Location"))
+	}
+	rehashed := storedHash()
+	ok, params, err := argon2id.CheckHash("correct horse", rehashed)
+	if err != nil || !ok || *params != *g.PasswordParams {
+		t.Fatalf("after logging in, the hash is %q with %+v, %v, %v", rehashed, params, ok, err)
+	}
+
+	// A hash as strong as configured is left alone.
+	if w := logIn("correct horse"); w.Code != http.StatusSeeOther || storedHash() != rehashed {
+		t.Errorf("logging in again: got %d, rehashed %v", w.Code, storedHash() != rehashed)
+	}
+	if sessions := exec(t, g, `SELECT COUNT(*) FROM sessions WHERE user_id = $1`, userID); sessions != 2 {
+		t.Errorf("alice has %d sessions", sessions)
+	}
+}
+
+func TestLoginThrottle(t *testing.T) {
+	t.Parallel()
+	g, _ := newGlobal(t)
+	rec := &recorder{} //exhaustruct:ignore
+	h := NewLoginHTTP(rec, 3600)
+	exec(t, g, `INSERT INTO users (username, type, password_hash) VALUES ('alice', 'registered', $1)`, mustHash(t, g, "correct horse"))
+	logIn := func(ip, username, password string) *httptest.ResponseRecorder {
+		t.Helper()
+		from := user{ip: netip.MustParseAddr(ip)} //exhaustruct:ignore
+		return serve(t, g, h.Login, "/-/login", from, url.Values{"username": {username}, "password": {password}})
+	}
+
+	// Guessing alice's password is slowed down after a few failures, even
+	// from elsewhere and with the right password.
+	for i := range freeUsernameFailures {
+		if w := logIn("192.0.2.1", "alice", "guess"+strconv.Itoa(i)); w.Code != http.StatusOK {
+			t.Fatalf("failure %d: got %d", i, w.Code)
+		}
+	}
+	w := logIn("198.51.100.1", "alice", "correct horse")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+		t.Fatalf("after %d failures: got %d, Retry-After %q", freeUsernameFailures, w.Code, w.Header().Get("Retry-After"))
+	}
+	if throttled := exec(t, g, `SELECT COUNT(*) FROM login_attempts WHERE outcome = 'throttled'`); throttled != 1 {
+		t.Errorf("%d throttled attempts recorded", throttled)
+	}
+
+	// Guessing from one /64 is slowed down after more failures, whichever
+	// usernames they are against.
+	for i := range freeNetworkFailures - 1 {
+		ip := "2001:db8::" + strconv.FormatInt(int64(i+1), 16)
+		if w := logIn(ip, "user"+strconv.Itoa(i), "guess"); w.Code != http.StatusOK {
+			t.Fatalf("failure %d: got %d", i, w.Code)
+		}
+	}
+	if w := logIn("2001:db8::ffff", "bob", "guess"); w.Code != http.StatusOK {
+		t.Fatalf("with %d failures from the network: got %d", freeNetworkFailures-1, w.Code)
+	}
+	if w := logIn("2001:db8::1:2", "carol", "guess"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("after %d failures from the network: got %d", freeNetworkFailures, w.Code)
+	}
+	if w := logIn("2001:db8:0:1::1", "carol", "guess"); w.Code != http.StatusOK {
+		t.Errorf("from the next /64: got %d", w.Code)
+	}
+}
+
+// Logins in parallel count each other, so no more fail unthrottled than
+// would one after another.
+func TestLoginThrottleConcurrent(t *testing.T) {
+	t.Parallel()
+	g, _ := newGlobal(t)
+	exec(t, g, `INSERT INTO users (username, type, password_hash) VALUES ('alice', 'registered', $1)`, mustHash(t, g, "correct horse"))
+	// parallel tries n logins at once from one /64, as username(i), and
+	// returns how many weren't throttled.
+	parallel := func(n int, username func(i int) string) int {
+		t.Helper()
+		codes := make([]int, n)
+		var wg sync.WaitGroup
+		for i := range n {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				h := NewLoginHTTP(&recorder{}, 3600)                                                    //exhaustruct:ignore
+				from := user{ip: netip.MustParseAddr("2001:db8::" + strconv.FormatInt(int64(i+1), 16))} //exhaustruct:ignore
+				codes[i] = serve(t, g, h.Login, "/-/login", from, url.Values{"username": {username(i)}, "password": {"guess"}}).Code
+			}()
+		}
+		wg.Wait()
+		unthrottled := 0
+		for _, code := range codes {
+			switch code {
+			case http.StatusOK:
+				unthrottled++
+			case http.StatusTooManyRequests:
+			default:
+				t.Fatalf("got %d", code)
+			}
+		}
+		return unthrottled
+	}
+
+	if n := parallel(4*freeUsernameFailures, func(int) string { return "alice" }); n != freeUsernameFailures {
+		t.Errorf("%d guesses at alice's password weren't throttled, want %d", n, freeUsernameFailures)
+	}
+	// alice's guesses were from the /64 too.
+	want := freeNetworkFailures - freeUsernameFailures
+	if n := parallel(2*freeNetworkFailures, func(i int) string { return "user" + strconv.Itoa(i) }); n != want {
+		t.Errorf("%d guesses from the network weren't throttled, want %d", n, want)
+	}
+}
+
+// mustHash hashes password with the configured parameters.
+func mustHash(t *testing.T, g *global.Global, password string) string {
+	t.Helper()
+	hash, err := argon2id.CreateHash(password, g.PasswordParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func TestSecondFactorChallenge(t *testing.T) {
+	t.Parallel()
+	g, _ := newGlobal(t)
+	g.Config.Web.Root = "https://forge.example.org/"
+	rec := &recorder{} //exhaustruct:ignore
+	h := NewLoginHTTP(rec, 3600)
+	userID := exec(t, g, `INSERT INTO users (username, type) VALUES ('alice', 'registered') RETURNING id`)
+	exec(t, g, `INSERT INTO webauthn_credentials (user_id, credential_id, public_key, sign_count, name) VALUES ($1, 'key', 'not a key', 0, 'Key')`, userID)
+	token, tokenHash := newToken()
+	exec(t, g, `INSERT INTO pending_logins (token_hash, user_id, challenge, expires_at) VALUES ($1, $2, $3, NOW() + INTERVAL '5 minutes')`,
+		tokenHash, userID, webauthn.NewChallenge())
+
+	stored := func() string {
+		t.Helper()
+		var challenge []byte
+		if err := g.DB.QueryRow(t.Context(), `SELECT challenge FROM pending_logins WHERE token_hash = $1`, tokenHash).Scan(&challenge); err != nil {
+			t.Fatal(err)
+		}
+		return b64.EncodeToString(challenge)
+	}
+	offered := func() string {
+		t.Helper()
+		var options webauthn.RequestOptions
+		if err := json.Unmarshal([]byte(reflect.ValueOf(rec.data).FieldByName("WebAuthnOptions").String()), &options); err != nil {
+			t.Fatal(err)
+		}
+		return options.Challenge
+	}
+	secondFactor := func(method string, form url.Values) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequestWithContext(t.Context(), method, "/-/login/2fa", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: "login", Value: token}) //exhaustruct:ignore
+		return do(t, g, h.SecondFactor, req, user{}, nil)
+	}
+
+	secondFactor(http.MethodGet, nil)
+	challenge := offered()
+	if challenge != stored() {
+		t.Fatalf("offered challenge %s, stored %s", challenge, stored())
+	}
+	// Each assertion, even one that fails, uses up the challenge it was
+	// checked against, and the page offers the one that replaced it.
+	seen := map[string]bool{challenge: true}
+	for i := range maxSecondFactorAttempts {
+		w := secondFactor(http.MethodPost, url.Values{
+			"credential_id":      {b64.EncodeToString([]byte("key"))},
+			"client_data":        {b64.EncodeToString([]byte("{}"))},
+			"authenticator_data": {b64.EncodeToString([]byte("data"))},
+			"signature":          {b64.EncodeToString([]byte("signature"))},
+		})
+		if w.Code != http.StatusOK || rec.name != "login_2fa" {
+			t.Fatalf("attempt %d: got %d rendering %s", i, w.Code, rec.name)
+		}
+		challenge = offered()
+		if seen[challenge] || challenge != stored() {
+			t.Fatalf("attempt %d: offered %s, stored %s, seen %v", i, challenge, stored(), seen)
+		}
+		seen[challenge] = true
+	}
+}
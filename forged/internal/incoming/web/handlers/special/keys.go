@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"go.lindenii.runxiyu.org/forge/forged/internal/common/misc"
+	"go.lindenii.runxiyu.org/forge/forged/internal/common/sshsig"
+	"go.lindenii.runxiyu.org/forge/forged/internal/database/queries"
+	wtypes "go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/types"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// keyNamespace is the ssh-keygen -Y namespace of key claim signatures.
+const keyNamespace = "lindenii-forge"
+
+type sshKey struct {
+	ID          int64
+	Type        string
+	Fingerprint string
+	Comment     string
+	Added       time.Time
+	LastUsed    time.Time // zero if never used
+}
+
+func sshKeys(ctx context.Context, q *queries.Queries, userID int64) ([]sshKey, error) {
+	rows, err := q.GetSSHPublicKeysByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]sshKey, 0, len(rows))
+	for _, row := range rows {
+		key := sshKey{
+			ID:       row.ID,
+			Comment:  row.Comment,
+			Added:    row.CreatedAt.Time,
+			LastUsed: row.LastUsedAt.Time,
+		} //exhaustruct:ignore
+		if pub, _, _, _, err := gossh.ParseAuthorizedKey(misc.StringToBytes(row.KeyString)); err == nil {
+			key.Type = pub.Type()
+			key.Fingerprint = gossh.FingerprintSHA256(pub)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// authorizedKeyString is how keys are stored in ssh_public_keys.key_string:
+// the type and base64 blob, without options or comment.
+func authorizedKeyString(pub gossh.PublicKey) string {
+	return strings.TrimSpace(misc.BytesToString(gossh.MarshalAuthorizedKey(pub)))
+}
+
+// keyClaim asks the user to prove that they hold a key that belongs to a
+// pubkey_only account, i.e. one that has only been used over SSH, before
+// that account's history is moved to theirs.
+type keyClaim struct {
+	Key       string
+	Challenge string
+	Namespace string
+}
+
+func claimChallenge(base *wtypes.BaseData, userID int64, pub gossh.PublicKey) string {
+	return fmt.Sprintf("Claim %s for user %d on %s", gossh.FingerprintSHA256(pub), userID, base.Global.Config.Web.Root)
+}
+
+func (h *SettingsHTTP) AddKey(w http.ResponseWriter, r *http.Request, _ wtypes.Vars) {
+	userID, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	base := wtypes.Base(r)
+
+	input := strings.TrimSpace(r.PostFormValue("key"))
+	pub, comment, options, rest, err := gossh.ParseAuthorizedKey(misc.StringToBytes(input))
+	if err != nil || len(options) != 0 || len(bytes.TrimSpace(rest)) != 0 {
+		h.message(w, r, userID, "Invalid SSH public key; paste a single line from a .pub file")
+		return
+	}
+	keyString := authorizedKeyString(pub)
+
+	owner, err := base.Global.Queries.GetSSHPublicKeyOwner(r.Context(), keyString)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		var commentPtr *string
+		if comment != "" {
+			commentPtr = &comment
+		}
+		err = base.Global.Queries.InsertSSHPublicKey(r.Context(), queries.InsertSSHPublicKeyParams{
+			UserID:    userID,
+			KeyString: keyString,
+			Comment:   commentPtr,
+		})
+		if pgErrorCode(err) == exclusionViolation {
+			h.message(w, r, userID, "This key has just been added to an account")
+			return
+		} else if err != nil {
+			slog.Error("failed to insert SSH key", "error", err)
+			http.Error(w, "Failed to add SSH key", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/-/settings/", http.StatusSeeOther)
+		return
+	case err != nil:
+		slog.Error("failed to get SSH key owner", "error", err)
+		http.Error(w, "Failed to add SSH key", http.StatusInternalServerError)
+		return
+	case owner.UserID == userID:
+		h.message(w, r, userID, "You have already added this key")
+		return
+	case owner.UserType != "pubkey_only":
+		h.message(w, r, userID, "This key belongs to another account")
+		return
+	}
+
+	// Anyone may paste a public key, so claiming the history of the
+	// account behind it requires a signature made with the private key.
+	claim := &keyClaim{
+		Key:       input,
+		Challenge: claimChallenge(base, userID, pub),
+		Namespace: keyNamespace,
+	}
+	signature := r.PostFormValue("signature")
+	if signature == "" {
+		h.render(w, r, userID, settingsPage{
+			Message: "This key has been used over SSH without an account. To add it and move its history to your account, sign the text below with it.",
+			Claim:   claim,
+		}) //exhaustruct:ignore
+		return
+	}
+	if err := sshsig.Verify(pub, misc.StringToBytes(claim.Challenge), misc.StringToBytes(signature), keyNamespace); err != nil {
+		h.render(w, r, userID, settingsPage{
+			Message: "The signature could not be verified: " + err.Error(),
+			Claim:   claim,
+		}) //exhaustruct:ignore
+		return
+	}
+
+	if err := claimPubkeyOnlyUser(r.Context(), base, owner.UserID, userID); err != nil {
+		slog.Error("failed to claim pubkey-only user", "error", err, "from", owner.UserID, "to", userID)
+		http.Error(w, "Failed to claim SSH key", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("claimed pubkey-only user", "from", owner.UserID, "to", userID)
+	http.Redirect(w, r, "/-/settings/", http.StatusSeeOther)
+}
+
+// claimPubkeyOnlyUser moves everything a pubkey_only user did, along with
+// its keys, to another user, and deletes it.
+func claimPubkeyOnlyUser(ctx context.Context, base *wtypes.BaseData, from, to int64) error {
+	tx, err := base.Global.DB.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	txq := base.Global.Queries.WithTx(tx)
+
+	if err := txq.ReassignMergeRequests(ctx, queries.ReassignMergeRequestsParams{ToUser: &to, FromUser: &from}); err != nil {
+		return fmt.Errorf("reassign merge requests: %w", err)
+	}
+	if err := txq.ReassignSSHPublicKeys(ctx, queries.ReassignSSHPublicKeysParams{ToUser: to, FromUser: from}); err != nil {
+		return fmt.Errorf("reassign SSH keys: %w", err)
+	}
+	if err := txq.DeletePubkeyOnlyUser(ctx, from); err != nil {
+		return fmt.Errorf("delete user: %w", err)
+	}
+	return tx.Commit(ctx)
+}
+
+func (h *SettingsHTTP) DeleteKey(w http.ResponseWriter, r *http.Request, _ wtypes.Vars) {
+	userID, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	keyID, err := strconv.ParseInt(r.PostFormValue("key_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid key ID", http.StatusBadRequest)
+		return
+	}
+	err = wtypes.Base(r).Global.Queries.DeleteSSHPublicKey(r.Context(), queries.DeleteSSHPublicKeyParams{
+		ID:     keyID,
+		UserID: userID,
+	})
+	if err != nil {
+		slog.Error("failed to delete SSH key", "error", err)
+		http.Error(w, "Failed to delete SSH key", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/-/settings/", http.StatusSeeOther)
+}
@@ -6,6 +6,9 @@ import (
 	"errors"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/jackc/pgx/v5"
@@ -13,6 +16,7 @@ import (
 	"go.lindenii.runxiyu.org/forge/forged/internal/common/argon2id"
 	"go.lindenii.runxiyu.org/forge/forged/internal/common/misc"
 	"go.lindenii.runxiyu.org/forge/forged/internal/database/queries"
+	"go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/cookies"
 	"go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/templates"
 	wtypes "go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/types"
 )
@@ -29,91 +33,243 @@ func NewLoginHTTP(r templates.Renderer, cookieExpiry int) *LoginHTTP {
 	}
 }
 
-func (h *LoginHTTP) Login(w http.ResponseWriter, r *http.Request, _ wtypes.Vars) {
-	renderLoginPage := func(loginError string) bool {
-		err := h.r.Render(w, "login", struct {
-			BaseData   *wtypes.BaseData
-			LoginError string
-		}{
-			BaseData:   wtypes.Base(r),
-			LoginError: loginError,
-		})
-		if err != nil {
-			log.Println("failed to render login page", "error", err)
-			http.Error(w, "Failed to render login page", http.StatusInternalServerError)
-			return true
-		}
-		return false
+func (h *LoginHTTP) renderLogin(w http.ResponseWriter, r *http.Request, loginError string) {
+	base := wtypes.Base(r)
+	err := h.r.Render(w, "login", struct {
+		BaseData   *wtypes.BaseData
+		LoginError string
+		Providers  []providerLink
+	}{
+		BaseData:   base,
+		LoginError: loginError,
+		Providers:  providerLinks(base, nil),
+	})
+	if err != nil {
+		log.Println("failed to render login page", "error", err)
+		http.Error(w, "Failed to render login page", http.StatusInternalServerError)
 	}
+}
+
+// loginFailed is shown for every wrong username or password alike, so that
+// it doesn't reveal which usernames exist.
+const loginFailed = "Invalid username or password"
+
+var (
+	dummyHashOnce  sync.Once
+	dummyHashValue string
+	dummyHashErr   error
+)
+
+// dummyHash is compared against when there is no hash to compare against,
+// so that logins take as long whether or not the user exists. It is made
+// once, with the configured parameters.
+func dummyHash(params *argon2id.Params) (string, error) {
+	dummyHashOnce.Do(func() {
+		dummyHashValue, dummyHashErr = argon2id.CreateHash(rand.Text(), params)
+	})
+	return dummyHashValue, dummyHashErr
+}
+
+// weakerParams reports whether a hash made with have is weaker than one
+// made with want. Parallelism is left alone, as fewer threads take longer
+// rather than less memory.
+func weakerParams(have, want *argon2id.Params) bool {
+	return have.Memory < want.Memory || have.Iterations < want.Iterations ||
+		have.SaltLength < want.SaltLength || have.KeyLength < want.KeyLength
+}
+
+// upgradeHash rehashes a user's password with the configured parameters if
+// their hash was made with weaker ones, now that the password is at hand.
+func upgradeHash(r *http.Request, userID int64, password, oldHash string, params *argon2id.Params) error {
+	base := wtypes.Base(r)
+	if !weakerParams(params, base.Global.PasswordParams) {
+		return nil
+	}
+	newHash, err := argon2id.CreateHash(password, base.Global.PasswordParams)
+	if err != nil {
+		return err
+	}
+	// The password may have been changed since it was checked.
+	return base.Global.Queries.RehashPassword(r.Context(), queries.RehashPasswordParams{
+		ID:      userID,
+		NewHash: newHash,
+		OldHash: oldHash,
+	})
+}
 
+func (h *LoginHTTP) Login(w http.ResponseWriter, r *http.Request, _ wtypes.Vars) {
 	if r.Method == http.MethodGet {
-		renderLoginPage("")
+		h.renderLogin(w, r, "")
 		return
 	}
 
 	username := r.PostFormValue("username")
 	password := r.PostFormValue("password")
 
-	userCreds, err := wtypes.Base(r).Global.Queries.GetUserCreds(r.Context(), &username)
+	attemptID, delay, err := reserveLogin(r, username)
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			renderLoginPage("User not found")
-			return
-		}
-		log.Println("failed to get user credentials", "error", err)
-		http.Error(w, "Failed to get user credentials", http.StatusInternalServerError)
+		log.Println("failed to check login attempts", "error", err)
+		http.Error(w, "Failed to check login attempts", http.StatusInternalServerError)
+		return
+	}
+	if delay > 0 {
+		seconds := int64((delay + time.Second - 1) / time.Second)
+		w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusTooManyRequests)
+		h.renderLogin(w, r, "Too many failed logins; please try again in "+(time.Duration(seconds)*time.Second).String())
 		return
 	}
 
-	if userCreds.PasswordHash == "" {
-		renderLoginPage("No password set for this user")
+	base := wtypes.Base(r)
+	hash, err := dummyHash(base.Global.PasswordParams)
+	if err != nil {
+		log.Println("failed to create dummy hash", "error", err)
+		http.Error(w, "Failed to verify password", http.StatusInternalServerError)
 		return
 	}
+	var userID *int64
+	outcome := loginNoUser
+	userCreds, err := base.Global.Queries.GetUserCreds(r.Context(), &username)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+	case err != nil:
+		log.Println("failed to get user credentials", "error", err)
+		http.Error(w, "Failed to get user credentials", http.StatusInternalServerError)
+		return
+	case userCreds.PasswordHash == "":
+		userID = &userCreds.ID
+		outcome = loginNoPassword
+	default:
+		userID = &userCreds.ID
+		outcome = loginBadPassword
+		hash = userCreds.PasswordHash
+	}
 
-	passwordMatches, err := argon2id.ComparePasswordAndHash(password, userCreds.PasswordHash)
+	passwordMatches, params, err := argon2id.CheckHash(password, hash)
 	if err != nil {
 		log.Println("failed to compare password and hash", "error", err)
 		http.Error(w, "Failed to verify password", http.StatusInternalServerError)
 		return
 	}
+	if passwordMatches && outcome == loginBadPassword {
+		if err := settleLogin(r, attemptID, username, userID, loginSuccess); err != nil {
+			log.Println("failed to record login attempt", "error", err)
+		}
+		if err := upgradeHash(r, userCreds.ID, password, hash, params); err != nil {
+			log.Println("failed to rehash password", "error", err)
+		}
+		h.finishLogin(w, r, userCreds.ID)
+		return
+	}
 
-	if !passwordMatches {
-		renderLoginPage("Invalid password")
+	// Unsettled, the attempt still counts as failed.
+	if err := settleLogin(r, attemptID, username, userID, outcome); err != nil {
+		log.Println("failed to record login attempt", "error", err)
+	}
+	h.renderLogin(w, r, loginFailed)
+}
+
+// finishLogin logs in a user who has proven who they are, after asking for
+// their second factor if they have one.
+func (h *LoginHTTP) finishLogin(w http.ResponseWriter, r *http.Request, userID int64) {
+	base := wtypes.Base(r)
+	hasSecondFactor, err := base.Global.Queries.UserHasSecondFactor(r.Context(), userID)
+	if err != nil {
+		log.Println("failed to check second factors", "error", err)
+		http.Error(w, "Failed to check second factors", http.StatusInternalServerError)
+		return
+	}
+	if hasSecondFactor {
+		if err := beginSecondFactor(w, r, userID); err != nil {
+			log.Println("failed to insert pending login", "error", err)
+			http.Error(w, "Failed to create session", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/-/login/2fa", http.StatusSeeOther)
 		return
 	}
 
-	cookieValue := rand.Text()
+	if err := startSession(w, r, userID, h.cookieExpiry); err != nil {
+		log.Println("failed to insert session", "error", err)
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+	if err := auditUserLogin(r, userID, loginSuccess); err != nil {
+		log.Println("failed to record login attempt", "error", err)
+	}
 
-	now := time.Now()
-	expiry := now.Add(time.Duration(h.cookieExpiry) * time.Second)
+	// Send owners whom general.require_owner_2fa applies to straight to
+	// setting up a second factor.
+	missing2FA, err := wtypes.Missing2FA(r.Context(), base, userID)
+	if err != nil {
+		log.Println("failed to check second factors", "error", err)
+	}
+	if missing2FA {
+		http.Redirect(w, r, "/-/settings/", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
 
-	cookie := &http.Cookie{
-		Name:     "session",
-		Value:    cookieValue,
-		SameSite: http.SameSiteLaxMode,
-		HttpOnly: true,
-		Secure:   false, // TODO
-		Expires:  expiry,
-		Path:     "/",
-	} //exhaustruct:ignore
+// maxUserAgentLength bounds the user agents stored with sessions, which are
+// only shown to help users tell their sessions apart.
+const maxUserAgentLength = 256
 
-	http.SetCookie(w, cookie)
+// startSession logs the client in as the given user.
+func startSession(w http.ResponseWriter, r *http.Request, userID int64, cookieExpiry int) error {
+	cookieValue := rand.Text()
+
+	now := time.Now()
+	expiry := now.Add(time.Duration(cookieExpiry) * time.Second)
 
 	tokenHash := sha256.Sum256(misc.StringToBytes(cookieValue))
 
-	err = wtypes.Base(r).Global.Queries.InsertSession(r.Context(), queries.InsertSessionParams{
-		UserID:    userCreds.ID,
+	var userAgent *string
+	if ua := r.UserAgent(); ua != "" {
+		if len(ua) > maxUserAgentLength {
+			ua = strings.ToValidUTF8(ua[:maxUserAgentLength], "")
+		}
+		userAgent = &ua
+	}
+
+	err := wtypes.Base(r).Global.Queries.InsertSession(r.Context(), queries.InsertSessionParams{
+		UserID:    userID,
 		TokenHash: tokenHash[:],
 		ExpiresAt: pgtype.Timestamptz{
 			Time:  expiry,
 			Valid: true,
 		},
+		UserAgent: userAgent,
 	})
 	if err != nil {
-		log.Println("failed to insert session", "error", err)
-		http.Error(w, "Failed to create session", http.StatusInternalServerError)
-		return
+		return err
+	}
+
+	cookies.SetSession(w, r, cookieValue, expiry)
+	return nil
+}
+
+// sessionTokenHash returns the hash of the client's session cookie, or nil
+// if it has none.
+func sessionTokenHash(r *http.Request) []byte {
+	token := cookies.Session(r)
+	if token == "" {
+		return nil
+	}
+	tokenHash := sha256.Sum256(misc.StringToBytes(token))
+	return tokenHash[:]
+}
+
+func (h *LoginHTTP) Logout(w http.ResponseWriter, r *http.Request, _ wtypes.Vars) {
+	if tokenHash := sessionTokenHash(r); tokenHash != nil {
+		if err := wtypes.Base(r).Global.Queries.DeleteSessionByToken(r.Context(), tokenHash); err != nil {
+			log.Println("failed to delete session", "error", err)
+			http.Error(w, "Failed to log out", http.StatusInternalServerError)
+			return
+		}
 	}
 
+	cookies.ClearSession(w, r)
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"testing"
+
+	"go.lindenii.runxiyu.org/forge/forged/internal/common/argon2id"
+)
+
+func TestWeakerParams(t *testing.T) {
+	t.Parallel()
+	want := argon2id.Params{Memory: 64 * 1024, Iterations: 3, Parallelism: 2, SaltLength: 16, KeyLength: 32}
+	tests := []struct {
+		name   string
+		change func(p *argon2id.Params)
+		weaker bool
+	}{
+		{"same", func(*argon2id.Params) {}, false},
+		{"less memory", func(p *argon2id.Params) { p.Memory = 32 * 1024 }, true},
+		{"fewer iterations", func(p *argon2id.Params) { p.Iterations = 1 }, true},
+		{"shorter salt", func(p *argon2id.Params) { p.SaltLength = 8 }, true},
+		{"shorter key", func(p *argon2id.Params) { p.KeyLength = 16 }, true},
+		{"fewer threads", func(p *argon2id.Params) { p.Parallelism = 1 }, false},
+		{"more threads", func(p *argon2id.Params) { p.Parallelism = 8 }, false},
+		{"stronger", func(p *argon2id.Params) { p.Memory, p.Iterations = 128*1024, 4 }, false},
+		{"mixed", func(p *argon2id.Params) { p.Memory, p.Iterations = 128*1024, 2 }, true},
+	}
+	for _, tt := range tests {
+		have := want
+		tt.change(&have)
+		if got := weakerParams(&have, &want); got != tt.weaker {
+			t.Errorf("%s: weakerParams(%+v, %+v) = %v, want %v", tt.name, have, want, got, tt.weaker)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"go.lindenii.runxiyu.org/forge/forged/internal/database/queries"
+	"go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/cookies"
+	wtypes "go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/types"
+	"go.lindenii.runxiyu.org/forge/forged/internal/outgoing/oauth"
+)
+
+// providerLink is an identity provider offered on the login and settings
+// pages.
+type providerLink struct {
+	ID   string
+	Name string
+}
+
+// providerLinks returns the configured identity providers, except for
+// those in linked.
+func providerLinks(base *wtypes.BaseData, linked []queries.GetFederatedIdentitiesByUserRow) []providerLink {
+	links := make([]providerLink, 0, len(base.Global.OAuth))
+	for id, provider := range base.Global.OAuth {
+		if slices.ContainsFunc(linked, func(l queries.GetFederatedIdentitiesByUserRow) bool { return l.Service == id }) {
+			continue
+		}
+		links = append(links, providerLink{ID: id, Name: provider.Name()})
+	}
+	slices.SortFunc(links, func(a, b providerLink) int { return strings.Compare(a.ID, b.ID) })
+	return links
+}
+
+// beginOAuth sends the user to an identity provider, which sends them back
+// to OAuthCallback. If linkUser is set, the identity is linked to that
+// user's account instead of logged in with.
+func beginOAuth(w http.ResponseWriter, r *http.Request, name string, provider *oauth.Provider, linkUser *int64) {
+	login := oauth.NewLogin()
+	expiry := time.Now().Add(pendingLoginExpiry)
+	err := wtypes.Base(r).Global.Queries.InsertOAuthLogin(r.Context(), queries.InsertOAuthLoginParams{
+		StateHash:    hashToken(login.State),
+		Provider:     name,
+		CodeVerifier: login.Verifier,
+		Nonce:        login.Nonce,
+		LinkUser:     linkUser,
+		ExpiresAt: pgtype.Timestamptz{
+			Time:  expiry,
+			Valid: true,
+		},
+	})
+	if err != nil {
+		slog.Error("failed to insert OAuth login", "error", err)
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+	// The state is also kept in a cookie so that only the browser that
+	// started the login can finish it.
+	cookies.SetOAuthState(w, r, login.State, expiry)
+	http.Redirect(w, r, provider.AuthURL(login), http.StatusSeeOther)
+}
+
+func (h *LoginHTTP) OAuthLogin(w http.ResponseWriter, r *http.Request, v wtypes.Vars) {
+	base := wtypes.Base(r)
+	provider, ok := base.Global.OAuth[v["provider"]]
+	if !ok {
+		http.Error(w, "Unknown identity provider", http.StatusNotFound)
+		return
+	}
+	if base.UserID != "" {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	beginOAuth(w, r, v["provider"], provider, nil)
+}
+
+// OAuthCallback is where identity providers send users back to, to be
+// logged in, to have a new account created for them, or to have the
+// identity linked to their account.
+func (h *LoginHTTP) OAuthCallback(w http.ResponseWriter, r *http.Request, v wtypes.Vars) {
+	base := wtypes.Base(r)
+	name := v["provider"]
+	provider, ok := base.Global.OAuth[name]
+	if !ok {
+		http.Error(w, "Unknown identity provider", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	state := query.Get("state")
+	cookieState := cookies.OAuthState(r)
+	cookies.ClearOAuthState(w, r)
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(cookieState)) != 1 {
+		h.renderLogin(w, r, "Your login has expired; please try again")
+		return
+	}
+	pending, err := base.Global.Queries.TakeOAuthLogin(r.Context(), hashToken(state))
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && pending.Provider != name) {
+		h.renderLogin(w, r, "Your login has expired; please try again")
+		return
+	} else if err != nil {
+		slog.Error("failed to get OAuth login", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if query.Get("error") != "" {
+		h.renderLogin(w, r, "Logging in with "+provider.Name()+" was cancelled or failed")
+		return
+	}
+	id, err := provider.Exchange(r.Context(), query.Get("code"), oauth.Login{
+		State:    state,
+		Verifier: pending.CodeVerifier,
+		Nonce:    pending.Nonce,
+	})
+	if err != nil {
+		slog.Warn("OAuth login failed", "provider", name, "error", err)
+		h.renderLogin(w, r, "Logging in with "+provider.Name()+" failed")
+		return
+	}
+	remoteUsername := id.Username
+	if remoteUsername == "" {
+		remoteUsername = id.Subject
+	}
+
+	if pending.LinkUser != nil {
+		if base.UserID == "" || base.UserID != strconv.FormatInt(*pending.LinkUser, 10) {
+			http.Error(w, "You have logged out since starting to link this account", http.StatusForbidden)
+			return
+		}
+		err := base.Global.Queries.InsertFederatedIdentity(r.Context(), queries.InsertFederatedIdentityParams{
+			UserID:         *pending.LinkUser,
+			Service:        name,
+			RemoteUsername: remoteUsername,
+			RemoteSubject:  &id.Subject,
+		})
+		if isUniqueViolation(err) {
+			http.Error(w, "This "+provider.Name()+" account is already linked to an account here", http.StatusConflict)
+			return
+		} else if err != nil {
+			slog.Error("failed to insert federated identity", "error", err)
+			http.Error(w, "Failed to link account", http.StatusInternalServerError)
+			return
+		}
+		slog.Info("linked federated identity", "user", *pending.LinkUser, "service", name, "remote", remoteUsername)
+		http.Redirect(w, r, "/-/settings/", http.StatusSeeOther)
+		return
+	}
+
+	userID, err := base.Global.Queries.GetFederatedUser(r.Context(), queries.GetFederatedUserParams{
+		Service:       name,
+		RemoteSubject: &id.Subject,
+	})
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		if !provider.CreateAccounts() {
+			h.renderLogin(w, r, "This "+provider.Name()+" account is not linked to an account here; log in and link it from your settings first")
+			return
+		}
+		userID, err = createFederatedUser(r, name, id)
+		if err != nil {
+			var msg federatedUserError
+			if errors.As(err, &msg) {
+				h.renderLogin(w, r, string(msg))
+				return
+			}
+			slog.Error("failed to create federated user", "error", err)
+			http.Error(w, "Failed to create account", http.StatusInternalServerError)
+			return
+		}
+		slog.Info("created federated user", "user", userID, "service", name, "remote", remoteUsername)
+	case err != nil:
+		slog.Error("failed to get federated user", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	default:
+		// Usernames at the provider may change; they are only shown.
+		err := base.Global.Queries.UpdateFederatedUsername(r.Context(), queries.UpdateFederatedUsernameParams{
+			Service:        name,
+			RemoteSubject:  &id.Subject,
+			RemoteUsername: remoteUsername,
+		})
+		if err != nil {
+			slog.Warn("failed to update federated username", "error", err)
+		}
+	}
+
+	h.finishLogin(w, r, userID)
+}
+
+// federatedUserError is why an account couldn't be created, to be shown to
+// the user.
+type federatedUserError string
+
+func (e federatedUserError) Error() string {
+	return string(e)
+}
+
+// createFederatedUser creates an account for an identity on its first
+// login, with the username it has at the provider.
+func createFederatedUser(r *http.Request, service string, id oauth.Identity) (int64, error) {
+	base := wtypes.Base(r)
+	ctx := r.Context()
+	if msg := checkUsername(id.Username); msg != "" {
+		return 0, federatedUserError("Your username there can't be used here: " + msg)
+	}
+
+	tx, err := base.Global.DB.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	txq := base.Global.Queries.WithTx(tx)
+
+	userID, err := txq.InsertFederatedUser(ctx, &id.Username)
+	if isUniqueViolation(err) {
+		return 0, federatedUserError("The username " + id.Username + " is taken here; log in to that account and link this one from its settings if it is yours")
+	} else if err != nil {
+		return 0, err
+	}
+	err = txq.InsertFederatedIdentity(ctx, queries.InsertFederatedIdentityParams{
+		UserID:         userID,
+		Service:        service,
+		RemoteUsername: id.Username,
+		RemoteSubject:  &id.Subject,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return userID, tx.Commit(ctx)
+}
+
+// logsIn reports whether an identity can be logged in with: it must have
+// come from a configured identity provider, not from verifying an SSH key
+// that another forge publishes.
+func logsIn(base *wtypes.BaseData, id queries.GetFederatedIdentitiesByUserRow) bool {
+	_, ok := base.Global.OAuth[id.Service]
+	return ok && id.HasSubject
+}
+
+// LinkIdentity starts linking an identity provider's account to the user's.
+func (h *SettingsHTTP) LinkIdentity(w http.ResponseWriter, r *http.Request, _ wtypes.Vars) {
+	userID, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	name := r.PostFormValue("provider")
+	provider, ok := wtypes.Base(r).Global.OAuth[name]
+	if !ok {
+		http.Error(w, "Unknown identity provider", http.StatusNotFound)
+		return
+	}
+	beginOAuth(w, r, name, provider, &userID)
+}
+
+func (h *SettingsHTTP) UnlinkIdentity(w http.ResponseWriter, r *http.Request, _ wtypes.Vars) {
+	userID, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	base := wtypes.Base(r)
+
+	// Users without passwords must keep some way to log in.
+	userCreds, err := base.Global.Queries.GetUserCreds(r.Context(), &base.Username)
+	if err != nil {
+		slog.Error("failed to get user credentials", "error", err)
+		http.Error(w, "Failed to get user credentials", http.StatusInternalServerError)
+		return
+	}
+	identities, err := base.Global.Queries.GetFederatedIdentitiesByUser(r.Context(), userID)
+	if err != nil {
+		slog.Error("failed to get federated identities", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	service := r.PostFormValue("service")
+	if userCreds.PasswordHash == "" && !slices.ContainsFunc(identities, func(id queries.GetFederatedIdentitiesByUserRow) bool {
+		return id.Service != service && logsIn(base, id)
+	}) {
+		h.message(w, r, userID, "Set a password before unlinking the only account you log in with")
+		return
+	}
+
+	err = base.Global.Queries.DeleteFederatedIdentity(r.Context(), queries.DeleteFederatedIdentityParams{
+		UserID:  userID,
+		Service: service,
+	})
+	if err != nil {
+		slog.Error("failed to delete federated identity", "error", err)
+		http.Error(w, "Failed to unlink account", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/-/settings/", http.StatusSeeOther)
+}
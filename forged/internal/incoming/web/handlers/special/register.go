@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"go.lindenii.runxiyu.org/forge/forged/internal/common/argon2id"
+	"go.lindenii.runxiyu.org/forge/forged/internal/config"
+	"go.lindenii.runxiyu.org/forge/forged/internal/database/queries"
+	"go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/templates"
+	wtypes "go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/types"
+)
+
+type RegisterHTTP struct {
+	r            templates.Renderer
+	cookieExpiry int
+}
+
+func NewRegisterHTTP(r templates.Renderer, cookieExpiry int) *RegisterHTTP {
+	return &RegisterHTTP{
+		r:            r,
+		cookieExpiry: cookieExpiry,
+	}
+}
+
+func (h *RegisterHTTP) Register(w http.ResponseWriter, r *http.Request, _ wtypes.Vars) {
+	base := wtypes.Base(r)
+	mode := base.Global.Config.General.Registration
+	if mode == config.RegistrationClosed {
+		http.Error(w, "Registration is closed on this forge", http.StatusForbidden)
+		return
+	}
+	if base.UserID != "" {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	username := r.PostFormValue("username")
+	email := strings.TrimSpace(r.PostFormValue("email"))
+	renderRegisterPage := func(registerError string) {
+		err := h.r.Render(w, "register", struct {
+			BaseData      *wtypes.BaseData
+			RegisterError string
+			InviteOnly    bool
+			MailEnabled   bool
+			Username      string
+			Email         string
+		}{
+			BaseData:      base,
+			RegisterError: registerError,
+			InviteOnly:    mode == config.RegistrationInvite,
+			MailEnabled:   base.Global.Mail.Enabled(),
+			Username:      username,
+			Email:         email,
+		})
+		if err != nil {
+			slog.Error("failed to render register page", "error", err)
+			http.Error(w, "Failed to render register page", http.StatusInternalServerError)
+		}
+	}
+
+	if r.Method == http.MethodGet {
+		renderRegisterPage("")
+		return
+	}
+
+	password := r.PostFormValue("password")
+	if msg := checkUsername(username); msg != "" {
+		renderRegisterPage(msg)
+		return
+	}
+	if msg := checkNewPassword(password, r.PostFormValue("password_confirm")); msg != "" {
+		renderRegisterPage(msg)
+		return
+	}
+	if email != "" && (!checkEmail(email) || !base.Global.Mail.Enabled()) {
+		renderRegisterPage("Invalid email address")
+		return
+	}
+
+	passwordHash, err := argon2id.CreateHash(password, base.Global.PasswordParams)
+	if err != nil {
+		slog.Error("failed to hash password", "error", err)
+		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := base.Global.DB.BeginTx(r.Context(), pgx.TxOptions{})
+	if err != nil {
+		slog.Error("begin tx failed", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer func() { _ = tx.Rollback(r.Context()) }()
+	txq := base.Global.Queries.WithTx(tx)
+
+	if mode == config.RegistrationInvite {
+		codeHash := hashToken(strings.TrimSpace(r.PostFormValue("invite")))
+		if _, err := txq.UseInvite(r.Context(), codeHash); errors.Is(err, pgx.ErrNoRows) {
+			renderRegisterPage("Invalid or already used invite code")
+			return
+		} else if err != nil {
+			slog.Error("failed to use invite", "error", err)
+			http.Error(w, "Failed to check invite code", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	userID, err := txq.InsertUser(r.Context(), queries.InsertUserParams{
+		Username:     &username,
+		PasswordHash: &passwordHash,
+	})
+	if isUniqueViolation(err) {
+		renderRegisterPage("That username is taken")
+		return
+	} else if err != nil {
+		slog.Error("failed to insert user", "error", err)
+		http.Error(w, "Failed to create account", http.StatusInternalServerError)
+		return
+	}
+
+	var token string
+	if email != "" {
+		var tokenHash []byte
+		token, tokenHash = newToken()
+		err = txq.InsertUserEmail(r.Context(), queries.InsertUserEmailParams{
+			UserID:    userID,
+			Email:     email,
+			TokenHash: tokenHash,
+		})
+		if err != nil {
+			slog.Error("failed to insert email", "error", err)
+			http.Error(w, "Failed to create account", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(r.Context()); err != nil {
+		slog.Error("commit tx failed", "error", err)
+		http.Error(w, "Failed to create account", http.StatusInternalServerError)
+		return
+	}
+
+	if email != "" {
+		// The account exists either way; the link can be resent from the
+		// settings page.
+		if err := mailVerification(base, username, email, token); err != nil {
+			slog.Error("failed to send verification mail", "error", err)
+		}
+	}
+
+	if err := startSession(w, r, userID, h.cookieExpiry); err != nil {
+		slog.Error("failed to insert session", "error", err)
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/-/settings/", http.StatusSeeOther)
+}
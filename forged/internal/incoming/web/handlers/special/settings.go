@@ -0,0 +1,356 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5"
+	"go.lindenii.runxiyu.org/forge/forged/internal/common/argon2id"
+	"go.lindenii.runxiyu.org/forge/forged/internal/common/misc"
+	"go.lindenii.runxiyu.org/forge/forged/internal/config"
+	"go.lindenii.runxiyu.org/forge/forged/internal/database/queries"
+	"go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/templates"
+	wtypes "go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/types"
+)
+
+// SettingsHTTP serves the logged-in user's account settings. Each form on
+// the settings page posts to its own route, which redirects back on success
+// and renders the page with a message otherwise.
+type SettingsHTTP struct {
+	r templates.Renderer
+}
+
+func NewSettingsHTTP(r templates.Renderer) *SettingsHTTP {
+	return &SettingsHTTP{
+		r: r,
+	}
+}
+
+// settingsPage is what a settings route shows besides the account's
+// current settings.
+type settingsPage struct {
+	Message string
+	Claim   *keyClaim
+	TOTP    *totpEnrollment
+	// RecoveryCodes are only ever shown right after they are generated.
+	RecoveryCodes []string
+	SecurityKey   *keyRegistration
+	Identity      *identityClaim
+}
+
+func (h *SettingsHTTP) render(w http.ResponseWriter, r *http.Request, userID int64, page settingsPage) {
+	base := wtypes.Base(r)
+	profile, err := base.Global.Queries.GetUserProfile(r.Context(), userID)
+	if err != nil {
+		slog.Error("failed to get user profile", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	emails, err := base.Global.Queries.GetUserEmails(r.Context(), userID)
+	if err != nil {
+		slog.Error("failed to get user emails", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	keys, err := sshKeys(r.Context(), base.Global.Queries, userID)
+	if err != nil {
+		slog.Error("failed to get SSH keys", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	secret, err := base.Global.Queries.GetTOTPSecret(r.Context(), userID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		slog.Error("failed to get TOTP secret", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	securityKeys, err := base.Global.Queries.GetWebAuthnCredentials(r.Context(), userID)
+	if err != nil {
+		slog.Error("failed to get security keys", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	recoveryCodes, err := base.Global.Queries.CountRecoveryCodes(r.Context(), userID)
+	if err != nil {
+		slog.Error("failed to count recovery codes", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	identities, err := base.Global.Queries.GetFederatedIdentitiesByUser(r.Context(), userID)
+	if err != nil {
+		slog.Error("failed to get federated identities", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	missing2FA, err := wtypes.Missing2FA(r.Context(), base, userID)
+	if err != nil {
+		slog.Error("failed to check second factors", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	sessions, err := base.Global.Queries.GetSessionsByUser(r.Context(), queries.GetSessionsByUserParams{
+		UserID:    userID,
+		TokenHash: sessionTokenHash(r),
+	})
+	if err != nil {
+		slog.Error("failed to get sessions", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	err = h.r.Render(w, "settings", struct {
+		BaseData    *wtypes.BaseData
+		Page        settingsPage
+		Profile     queries.GetUserProfileRow
+		Emails      []queries.GetUserEmailsRow
+		Keys        []sshKey
+		TOTPEnabled bool
+		// SecurityKeys are WebAuthn credentials.
+		SecurityKeys  []queries.GetWebAuthnCredentialsRow
+		RecoveryCodes int64
+		Missing2FA    bool
+		Identities    []queries.GetFederatedIdentitiesByUserRow
+		Providers     []providerLink
+		Services      []providerLink
+		Sessions      []queries.GetSessionsByUserRow
+		MailEnabled   bool
+		CanInvite     bool
+	}{
+		BaseData:      base,
+		Page:          page,
+		Profile:       profile,
+		Emails:        emails,
+		Keys:          keys,
+		TOTPEnabled:   secret.Confirmed,
+		SecurityKeys:  securityKeys,
+		RecoveryCodes: recoveryCodes,
+		Missing2FA:    missing2FA,
+		Identities:    identities,
+		Providers:     providerLinks(base, identities),
+		Services:      federationServices(base),
+		Sessions:      sessions,
+		MailEnabled:   base.Global.Mail.Enabled(),
+		CanInvite:     profile.Type == "admin" && base.Global.Config.General.Registration == config.RegistrationInvite,
+	})
+	if err != nil {
+		slog.Error("failed to render settings page", "error", err)
+	}
+}
+
+func (h *SettingsHTTP) message(w http.ResponseWriter, r *http.Request, userID int64, message string) {
+	h.render(w, r, userID, settingsPage{Message: message}) //exhaustruct:ignore
+}
+
+func (h *SettingsHTTP) Index(w http.ResponseWriter, r *http.Request, _ wtypes.Vars) {
+	userID, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	h.message(w, r, userID, "")
+}
+
+func (h *SettingsHTTP) Profile(w http.ResponseWriter, r *http.Request, _ wtypes.Vars) {
+	userID, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	displayName := strings.TrimSpace(r.PostFormValue("display_name"))
+	if utf8.RuneCountInString(displayName) > maxDisplayNameLength || misc.SliceContainsNewlines([]string{displayName}) {
+		h.message(w, r, userID, "Invalid display name")
+		return
+	}
+	err := wtypes.Base(r).Global.Queries.UpdateDisplayName(r.Context(), queries.UpdateDisplayNameParams{
+		ID:      userID,
+		Column2: displayName,
+	})
+	if err != nil {
+		slog.Error("failed to update display name", "error", err)
+		http.Error(w, "Failed to update profile", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/-/settings/", http.StatusSeeOther)
+}
+
+func (h *SettingsHTTP) Password(w http.ResponseWriter, r *http.Request, _ wtypes.Vars) {
+	userID, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	base := wtypes.Base(r)
+
+	userCreds, err := base.Global.Queries.GetUserCreds(r.Context(), &base.Username)
+	if err != nil {
+		slog.Error("failed to get user credentials", "error", err)
+		http.Error(w, "Failed to get user credentials", http.StatusInternalServerError)
+		return
+	}
+	if userCreds.PasswordHash != "" {
+		passwordMatches, err := argon2id.ComparePasswordAndHash(r.PostFormValue("current_password"), userCreds.PasswordHash)
+		if err != nil {
+			slog.Error("failed to compare password and hash", "error", err)
+			http.Error(w, "Failed to verify password", http.StatusInternalServerError)
+			return
+		}
+		if !passwordMatches {
+			h.message(w, r, userID, "Your current password is incorrect")
+			return
+		}
+	}
+
+	password := r.PostFormValue("password")
+	if msg := checkNewPassword(password, r.PostFormValue("password_confirm")); msg != "" {
+		h.message(w, r, userID, msg)
+		return
+	}
+	passwordHash, err := argon2id.CreateHash(password, base.Global.PasswordParams)
+	if err != nil {
+		slog.Error("failed to hash password", "error", err)
+		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+		return
+	}
+	err = base.Global.Queries.UpdatePasswordHash(r.Context(), queries.UpdatePasswordHashParams{
+		ID:           userID,
+		PasswordHash: &passwordHash,
+	})
+	if err != nil {
+		slog.Error("failed to update password", "error", err)
+		http.Error(w, "Failed to update password", http.StatusInternalServerError)
+		return
+	}
+	h.message(w, r, userID, "Your password has been changed")
+}
+
+func (h *SettingsHTTP) AddEmail(w http.ResponseWriter, r *http.Request, _ wtypes.Vars) {
+	userID, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	base := wtypes.Base(r)
+
+	email := strings.TrimSpace(r.PostFormValue("email"))
+	if !checkEmail(email) {
+		h.message(w, r, userID, "Invalid email address")
+		return
+	}
+	if !base.Global.Mail.Enabled() {
+		h.message(w, r, userID, "This forge cannot send verification mail")
+		return
+	}
+
+	token, tokenHash := newToken()
+	err := base.Global.Queries.InsertUserEmail(r.Context(), queries.InsertUserEmailParams{
+		UserID:    userID,
+		Email:     email,
+		TokenHash: tokenHash,
+	})
+	if err != nil {
+		slog.Error("failed to insert email", "error", err)
+		http.Error(w, "Failed to add email address", http.StatusInternalServerError)
+		return
+	}
+	if err := mailVerification(base, base.Username, email, token); err != nil {
+		slog.Error("failed to send verification mail", "error", err)
+		h.message(w, r, userID, "Failed to send verification mail; please try again later")
+		return
+	}
+	h.message(w, r, userID, "A verification link has been sent to "+email)
+}
+
+func (h *SettingsHTTP) DeleteEmail(w http.ResponseWriter, r *http.Request, _ wtypes.Vars) {
+	userID, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	emailID, err := strconv.ParseInt(r.PostFormValue("email_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid email ID", http.StatusBadRequest)
+		return
+	}
+	err = wtypes.Base(r).Global.Queries.DeleteUserEmail(r.Context(), queries.DeleteUserEmailParams{
+		ID:     emailID,
+		UserID: userID,
+	})
+	if err != nil {
+		slog.Error("failed to delete email", "error", err)
+		http.Error(w, "Failed to delete email address", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/-/settings/", http.StatusSeeOther)
+}
+
+// VerifyEmail handles the link in verification mails. It doesn't require
+// being logged in, as the link may be opened elsewhere.
+func (h *SettingsHTTP) VerifyEmail(w http.ResponseWriter, r *http.Request, v wtypes.Vars) {
+	tokenHash := hashToken(v["token"])
+	row, err := wtypes.Base(r).Global.Queries.VerifyUserEmail(r.Context(), tokenHash)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "This verification link is invalid or has expired", http.StatusNotFound)
+		return
+	} else if isUniqueViolation(err) {
+		http.Error(w, "This address has already been verified by another account", http.StatusConflict)
+		return
+	} else if err != nil {
+		slog.Error("failed to verify email", "error", err)
+		http.Error(w, "Failed to verify email address", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("verified email", "user", row.UserID, "email", row.Email)
+	http.Redirect(w, r, "/-/settings/", http.StatusSeeOther)
+}
+
+func (h *SettingsHTTP) CreateInvite(w http.ResponseWriter, r *http.Request, _ wtypes.Vars) {
+	userID, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	base := wtypes.Base(r)
+	profile, err := base.Global.Queries.GetUserProfile(r.Context(), userID)
+	if err != nil {
+		slog.Error("failed to get user profile", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if profile.Type != "admin" || base.Global.Config.General.Registration != config.RegistrationInvite {
+		http.Error(w, "You may not create invites", http.StatusForbidden)
+		return
+	}
+
+	code, codeHash := newToken()
+	err = base.Global.Queries.InsertInvite(r.Context(), queries.InsertInviteParams{
+		CodeHash:  codeHash,
+		CreatedBy: userID,
+	})
+	if err != nil {
+		slog.Error("failed to insert invite", "error", err)
+		http.Error(w, "Failed to create invite", http.StatusInternalServerError)
+		return
+	}
+	h.message(w, r, userID, "New invite code, which can be used once: "+code)
+}
+
+// RevokeSession logs out one of the user's sessions, which may be on
+// another device.
+func (h *SettingsHTTP) RevokeSession(w http.ResponseWriter, r *http.Request, _ wtypes.Vars) {
+	userID, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	sessionID, err := strconv.ParseInt(r.PostFormValue("session_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+	err = wtypes.Base(r).Global.Queries.DeleteSession(r.Context(), queries.DeleteSessionParams{
+		SessionID: sessionID,
+		UserID:    userID,
+	})
+	if err != nil {
+		slog.Error("failed to delete session", "error", err)
+		http.Error(w, "Failed to revoke session", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/-/settings/", http.StatusSeeOther)
+}
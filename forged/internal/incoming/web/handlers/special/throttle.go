@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"go.lindenii.runxiyu.org/forge/forged/internal/database/queries"
+	wtypes "go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/types"
+)
+
+const (
+	// loginFailureWindow is how far back failed logins are counted.
+	loginFailureWindow = 24 * time.Hour
+	// freeUsernameFailures and freeNetworkFailures are how many logins may
+	// fail before each further attempt must wait twice as long as the
+	// last. Networks get more, as many users may share an address.
+	freeUsernameFailures = 5
+	freeNetworkFailures  = 20
+	minLoginDelay        = time.Second
+	// maxLoginDelay bounds how long anyone, including a user whose
+	// username is being guessed against, is locked out.
+	maxLoginDelay = 15 * time.Minute
+)
+
+// Outcomes of login attempts in login_attempts.
+const (
+	loginSuccess         = "success"
+	loginBadPassword     = "bad_password"
+	loginNoUser          = "no_user"
+	loginNoPassword      = "no_password"
+	loginBadSecondFactor = "bad_second_factor"
+	loginThrottled       = "throttled"
+)
+
+// clientNetwork is what failed logins from addr are counted across: the
+// address itself for IPv4, or the /64 it is in for IPv6, as hosts are
+// commonly given a whole /64.
+func clientNetwork(addr netip.Addr) netip.Prefix {
+	if addr.Is4() {
+		return netip.PrefixFrom(addr, 32)
+	}
+	prefix, _ := addr.Prefix(64)
+	return prefix
+}
+
+// nextAttempt returns when a login may next be attempted after the given
+// failures, the last of which was at last.
+func nextAttempt(failures, free int64, last pgtype.Timestamptz) time.Time {
+	if failures < free || !last.Valid {
+		return time.Time{}
+	}
+	delay := maxLoginDelay
+	if n := failures - free; n < 20 {
+		delay = min(minLoginDelay<<n, maxLoginDelay)
+	}
+	return last.Time.Add(delay)
+}
+
+// reserveLogin checks whether the client may try to log in as username
+// and, if it may, records the attempt as checking, to be settled with
+// settleLogin once the password has been checked. Both happen under locks
+// on the username and the client's network, so that concurrent attempts
+// count each other rather than all getting through before any has failed.
+// If the client must wait, the attempt is recorded as throttled and the
+// delay returned instead.
+func reserveLogin(r *http.Request, username string) (attemptID int64, delay time.Duration, err error) {
+	base := wtypes.Base(r)
+	ctx := r.Context()
+	tx, err := base.Global.DB.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	txq := base.Global.Queries.WithTx(tx)
+
+	if err := txq.LockLoginUsername(ctx, username); err != nil {
+		return 0, 0, err
+	}
+	if base.ClientIP.IsValid() {
+		if err := txq.LockLoginNetwork(ctx, clientNetwork(base.ClientIP)); err != nil {
+			return 0, 0, err
+		}
+	}
+	if delay, err = loginDelay(ctx, base, txq, username); err != nil {
+		return 0, 0, err
+	}
+	if delay > 0 {
+		slog.Warn("login failed", "username", username, "ip", base.ClientIP, "outcome", loginThrottled)
+		err = txq.InsertLoginAttempt(ctx, queries.InsertLoginAttemptParams{
+			Username: username,
+			Ip:       clientIP(base),
+			Outcome:  loginThrottled,
+		})
+		if err != nil {
+			return 0, 0, err
+		}
+		return 0, delay, tx.Commit(ctx)
+	}
+	attemptID, err = txq.ReserveLoginAttempt(ctx, queries.ReserveLoginAttemptParams{
+		Username: username,
+		Ip:       clientIP(base),
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return attemptID, 0, tx.Commit(ctx)
+}
+
+// loginDelay returns how long the client must wait before trying to log
+// in as username, which is counted whether or not such a user exists so
+// that the delay doesn't reveal which do. Failures against a username stop
+// counting once it logs in successfully; those from a network don't.
+func loginDelay(ctx context.Context, base *wtypes.BaseData, q *queries.Queries, username string) (time.Duration, error) {
+	since := pgtype.Timestamptz{
+		Time:  time.Now().Add(-loginFailureWindow),
+		Valid: true,
+	}
+
+	byUsername, err := q.GetUsernameLoginFailures(ctx, queries.GetUsernameLoginFailuresParams{
+		Username:    username,
+		AttemptedAt: since,
+	})
+	if err != nil {
+		return 0, err
+	}
+	next := nextAttempt(byUsername.Failures, freeUsernameFailures, byUsername.LastFailure)
+
+	if base.ClientIP.IsValid() {
+		byNetwork, err := q.GetNetworkLoginFailures(ctx, queries.GetNetworkLoginFailuresParams{
+			Network: clientNetwork(base.ClientIP),
+			Since:   since,
+		})
+		if err != nil {
+			return 0, err
+		}
+		if n := nextAttempt(byNetwork.Failures, freeNetworkFailures, byNetwork.LastFailure); n.After(next) {
+			next = n
+		}
+	}
+	return time.Until(next), nil
+}
+
+// clientIP returns the client's address for login_attempts, which is NULL
+// if it is unknown.
+func clientIP(base *wtypes.BaseData) *netip.Addr {
+	if !base.ClientIP.IsValid() {
+		return nil
+	}
+	return &base.ClientIP
+}
+
+// settleLogin records the outcome of an attempt reserved with reserveLogin
+// to log in as username, with userID set if such a user exists. A right
+// password's attempt is dropped instead, as the login's outcome is only
+// recorded once any second factor has been checked too. Failed attempts
+// are also logged, for tools such as fail2ban.
+func settleLogin(r *http.Request, attemptID int64, username string, userID *int64, outcome string) error {
+	base := wtypes.Base(r)
+	if outcome == loginSuccess {
+		return base.Global.Queries.DeleteLoginAttempt(r.Context(), attemptID)
+	}
+	slog.Warn("login failed", "username", username, "ip", base.ClientIP, "outcome", outcome)
+	return base.Global.Queries.SettleLoginAttempt(r.Context(), queries.SettleLoginAttemptParams{
+		ID:      attemptID,
+		UserID:  userID,
+		Outcome: outcome,
+	})
+}
+
+// auditUserLogin records an attempt to log in as a user, for when only
+// their ID is at hand, after their password. Failed attempts are also
+// logged.
+func auditUserLogin(r *http.Request, userID int64, outcome string) error {
+	base := wtypes.Base(r)
+	if outcome != loginSuccess {
+		slog.Warn("login failed", "user", userID, "ip", base.ClientIP, "outcome", outcome)
+	}
+	return base.Global.Queries.InsertLoginAttemptByUser(r.Context(), queries.InsertLoginAttemptByUserParams{
+		ID:      userID,
+		Ip:      clientIP(base),
+		Outcome: outcome,
+	})
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func TestNextAttempt(t *testing.T) {
+	t.Parallel()
+	last := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := pgtype.Timestamptz{Time: last, Valid: true}
+	tests := []struct {
+		failures, free int64
+		last           pgtype.Timestamptz
+		want           time.Duration // after last, or -1 for none
+	}{
+		{0, 5, pgtype.Timestamptz{}, -1},
+		{4, 5, at, -1},
+		{5, 5, at, time.Second},
+		{6, 5, at, 2 * time.Second},
+		{10, 5, at, 32 * time.Second},
+		{14, 5, at, 512 * time.Second},
+		{15, 5, at, maxLoginDelay},
+		{24, 5, at, maxLoginDelay},
+		{25, 5, at, maxLoginDelay},
+		{1 << 40, 5, at, maxLoginDelay},
+		{20, 20, at, time.Second},
+		{19, 20, at, -1},
+		{10, 5, pgtype.Timestamptz{}, -1},
+	}
+	for _, tt := range tests {
+		want := time.Time{}
+		if tt.want >= 0 {
+			want = last.Add(tt.want)
+		}
+		if got := nextAttempt(tt.failures, tt.free, tt.last); !got.Equal(want) {
+			t.Errorf("nextAttempt(%d, %d, %v) = %v, want %v", tt.failures, tt.free, tt.last.Valid, got, want)
+		}
+	}
+}
+
+func TestClientNetwork(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		addr, want string
+	}{
+		{"192.0.2.1", "192.0.2.1/32"},
+		{"2001:db8:1:2:3:4:5:6", "2001:db8:1:2::/64"},
+		{"2001:db8:1:2::", "2001:db8:1:2::/64"},
+		{"::1", "::/64"},
+		{"fe80::1%eth0", "fe80::/64"},
+	}
+	for _, tt := range tests {
+		if got := clientNetwork(netip.MustParseAddr(tt.addr)); got != netip.MustParsePrefix(tt.want) {
+			t.Errorf("clientNetwork(%s) = %s, want %s", tt.addr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"go.lindenii.runxiyu.org/forge/forged/internal/common/misc"
+	"go.lindenii.runxiyu.org/forge/forged/internal/common/totp"
+	"go.lindenii.runxiyu.org/forge/forged/internal/common/webauthn"
+	"go.lindenii.runxiyu.org/forge/forged/internal/database/queries"
+	"go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/cookies"
+	wtypes "go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/types"
+)
+
+const (
+	// pendingLoginExpiry is how long users have to present a second factor
+	// after their password, and to finish registering a security key.
+	pendingLoginExpiry = 5 * time.Minute
+	// maxSecondFactorAttempts is how many codes may be tried for each
+	// correct password.
+	maxSecondFactorAttempts = 5
+
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10
+)
+
+var b64 = base64.RawURLEncoding
+
+// relyingParty returns what security keys are registered with: the host
+// in web.root.
+func relyingParty(base *wtypes.BaseData) (*webauthn.RelyingParty, error) {
+	return webauthn.New(base.Global.Config.Web.Root, base.Global.ForgeTitle)
+}
+
+// userHandle is the opaque user ID that security keys store.
+func userHandle(userID int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(userID)) //#nosec G115
+}
+
+// formBytes decodes a base64url form field set by webauthn.js.
+func formBytes(r *http.Request, name string) ([]byte, bool) {
+	b, err := b64.DecodeString(r.PostFormValue(name))
+	return b, err == nil && len(b) > 0
+}
+
+// newRecoveryCodes replaces the user's recovery codes, returning the new
+// ones to be shown once.
+func newRecoveryCodes(ctx context.Context, base *wtypes.BaseData, userID int64) ([]string, error) {
+	tx, err := base.Global.DB.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	txq := base.Global.Queries.WithTx(tx)
+
+	if err := txq.DeleteRecoveryCodes(ctx, userID); err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, recoveryCodeCount)
+	for range recoveryCodeCount {
+		code := rand.Text()[:recoveryCodeLength]
+		err := txq.InsertRecoveryCode(ctx, queries.InsertRecoveryCodeParams{
+			UserID:   userID,
+			CodeHash: hashToken(code),
+		})
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code[:recoveryCodeLength/2]+"-"+code[recoveryCodeLength/2:])
+	}
+	return codes, tx.Commit(ctx)
+}
+
+// firstRecoveryCodes gives the user recovery codes if they have none, as
+// when they set up their first second factor.
+func firstRecoveryCodes(ctx context.Context, base *wtypes.BaseData, userID int64) ([]string, error) {
+	n, err := base.Global.Queries.CountRecoveryCodes(ctx, userID)
+	if err != nil || n > 0 {
+		return nil, err
+	}
+	return newRecoveryCodes(ctx, base, userID)
+}
+
+// dropRecoveryCodes deletes the user's recovery codes once they have no
+// second factor left for them to stand in for.
+func dropRecoveryCodes(ctx context.Context, base *wtypes.BaseData, userID int64) error {
+	has, err := base.Global.Queries.UserHasSecondFactor(ctx, userID)
+	if err != nil || has {
+		return err
+	}
+	return base.Global.Queries.DeleteRecoveryCodes(ctx, userID)
+}
+
+// beginSecondFactor holds a login whose password was right until the user
+// presents a second factor at /-/login/2fa.
+func beginSecondFactor(w http.ResponseWriter, r *http.Request, userID int64) error {
+	token, tokenHash := newToken()
+	expiry := time.Now().Add(pendingLoginExpiry)
+	err := wtypes.Base(r).Global.Queries.InsertPendingLogin(r.Context(), queries.InsertPendingLoginParams{
+		TokenHash: tokenHash,
+		UserID:    userID,
+		Challenge: webauthn.NewChallenge(),
+		ExpiresAt: pgtype.Timestamptz{
+			Time:  expiry,
+			Valid: true,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cookies.SetPendingLogin(w, r, token, expiry)
+	return nil
+}
+
+func (h *LoginHTTP) renderSecondFactor(w http.ResponseWriter, r *http.Request, userID int64, challenge []byte, loginError string) {
+	base := wtypes.Base(r)
+	secret, err := base.Global.Queries.GetTOTPSecret(r.Context(), userID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		slog.Error("failed to get TOTP secret", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	creds, err := base.Global.Queries.GetWebAuthnCredentials(r.Context(), userID)
+	if err != nil {
+		slog.Error("failed to get security keys", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	var options string
+	if len(creds) > 0 {
+		rp, err := relyingParty(base)
+		if err != nil {
+			slog.Error("failed to set up WebAuthn", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		allow := make([][]byte, 0, len(creds))
+		for _, cred := range creds {
+			allow = append(allow, cred.CredentialID)
+		}
+		j, err := json.Marshal(rp.RequestOptions(challenge, allow))
+		if err != nil {
+			slog.Error("failed to encode WebAuthn options", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		options = misc.BytesToString(j)
+	}
+
+	err = h.r.Render(w, "login_2fa", struct {
+		BaseData        *wtypes.BaseData
+		LoginError      string
+		TOTP            bool
+		WebAuthnOptions string
+	}{
+		BaseData:        base,
+		LoginError:      loginError,
+		TOTP:            secret.Confirmed,
+		WebAuthnOptions: options,
+	})
+	if err != nil {
+		slog.Error("failed to render second factor page", "error", err)
+	}
+}
+
+// SecondFactor asks for a code or security key after the password of a
+// user who has set either up, and logs them in once one is right.
+func (h *LoginHTTP) SecondFactor(w http.ResponseWriter, r *http.Request, _ wtypes.Vars) {
+	base := wtypes.Base(r)
+	token := cookies.PendingLogin(r)
+	if token == "" {
+		http.Redirect(w, r, "/-/login", http.StatusSeeOther)
+		return
+	}
+	tokenHash := hashToken(token)
+
+	expired := func() {
+		cookies.ClearPendingLogin(w, r)
+		h.renderLogin(w, r, "Your login has expired; please log in again")
+	}
+
+	if r.Method == http.MethodGet {
+		pending, err := base.Global.Queries.GetPendingLogin(r.Context(), tokenHash)
+		if errors.Is(err, pgx.ErrNoRows) {
+			expired()
+			return
+		} else if err != nil {
+			slog.Error("failed to get pending login", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		h.renderSecondFactor(w, r, pending.UserID, pending.Challenge, "")
+		return
+	}
+
+	// Attempts are counted before checking so that concurrent guesses
+	// can't exceed the limit, and each takes the challenge with it so that
+	// no assertion can be checked against it twice.
+	nextChallenge := webauthn.NewChallenge()
+	pending, err := base.Global.Queries.CountPendingLoginAttempt(r.Context(), queries.CountPendingLoginAttemptParams{
+		NextChallenge: nextChallenge,
+		TokenHash:     tokenHash,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		expired()
+		return
+	} else if err != nil {
+		slog.Error("failed to count second factor attempt", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if pending.Attempts > maxSecondFactorAttempts {
+		if err := base.Global.Queries.DeletePendingLogin(r.Context(), tokenHash); err != nil {
+			slog.Error("failed to delete pending login", "error", err)
+		}
+		cookies.ClearPendingLogin(w, r)
+		h.renderLogin(w, r, "Too many incorrect codes; please log in again")
+		return
+	}
+
+	ok, err := checkSecondFactor(r, pending.UserID, pending.Challenge)
+	if err != nil {
+		slog.Error("failed to check second factor", "error", err)
+		http.Error(w, "Failed to check second factor", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		// These count against the username like wrong passwords, or
+		// anyone with the password could keep starting over.
+		if err := auditUserLogin(r, pending.UserID, loginBadSecondFactor); err != nil {
+			slog.Error("failed to record login attempt", "error", err)
+			http.Error(w, "Failed to record login attempt", http.StatusInternalServerError)
+			return
+		}
+		h.renderSecondFactor(w, r, pending.UserID, nextChallenge, "Incorrect code or security key")
+		return
+	}
+
+	if err := base.Global.Queries.DeletePendingLogin(r.Context(), tokenHash); err != nil {
+		slog.Error("failed to delete pending login", "error", err)
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+	cookies.ClearPendingLogin(w, r)
+	if err := startSession(w, r, pending.UserID, h.cookieExpiry); err != nil {
+		slog.Error("failed to insert session", "error", err)
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+	if err := auditUserLogin(r, pending.UserID, loginSuccess); err != nil {
+		slog.Error("failed to record login attempt", "error", err)
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// checkSecondFactor checks the security key assertion, TOTP code or
+// recovery code submitted for a pending login, using up the code if it is
+// right.
+func checkSecondFactor(r *http.Request, userID int64, challenge []byte) (bool, error) {
+	base := wtypes.Base(r)
+	ctx := r.Context()
+
+	if credentialID, ok := formBytes(r, "credential_id"); ok {
+		cred, err := base.Global.Queries.GetWebAuthnCredential(ctx, queries.GetWebAuthnCredentialParams{
+			UserID:       userID,
+			CredentialID: credentialID,
+		})
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		clientData, ok1 := formBytes(r, "client_data")
+		authData, ok2 := formBytes(r, "authenticator_data")
+		signature, ok3 := formBytes(r, "signature")
+		if !ok1 || !ok2 || !ok3 {
+			return false, nil
+		}
+		rp, err := relyingParty(base)
+		if err != nil {
+			return false, err
+		}
+		signCount, err := rp.FinishLogin(challenge, webauthn.Credential{
+			ID:        credentialID,
+			PublicKey: cred.PublicKey,
+			SignCount: uint32(cred.SignCount), //#nosec G115
+		}, clientData, authData, signature)
+		if err != nil {
+			slog.Info("security key rejected", "user", userID, "error", err)
+			return false, nil
+		}
+		return true, base.Global.Queries.UpdateWebAuthnSignCount(ctx, queries.UpdateWebAuthnSignCountParams{
+			ID:        cred.ID,
+			SignCount: int64(signCount),
+		})
+	}
+
+	code := strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(r.PostFormValue("code")))
+	if len(code) == recoveryCodeLength {
+		n, err := base.Global.Queries.UseRecoveryCode(ctx, queries.UseRecoveryCodeParams{
+			UserID:   userID,
+			CodeHash: hashToken(strings.ToUpper(code)),
+		})
+		if n == 1 {
+			slog.Info("recovery code used", "user", userID)
+		}
+		return n == 1, err
+	}
+
+	secret, err := base.Global.Queries.GetTOTPSecret(ctx, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if !secret.Confirmed {
+		return false, nil
+	}
+	step, ok := totp.Validate(secret.Secret, code, time.Now(), secret.LastStep)
+	if !ok {
+		return false, nil
+	}
+	// Only one request may use each step.
+	n, err := base.Global.Queries.UseTOTPStep(ctx, queries.UseTOTPStepParams{
+		UserID:   userID,
+		LastStep: step,
+	})
+	return n == 1, err
+}
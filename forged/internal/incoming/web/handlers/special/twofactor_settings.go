@@ -0,0 +1,321 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"go.lindenii.runxiyu.org/forge/forged/internal/common/misc"
+	"go.lindenii.runxiyu.org/forge/forged/internal/common/totp"
+	"go.lindenii.runxiyu.org/forge/forged/internal/common/webauthn"
+	"go.lindenii.runxiyu.org/forge/forged/internal/database/queries"
+	wtypes "go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/types"
+)
+
+// maxSecurityKeyNameLength bounds the names users give their security keys
+// to tell them apart.
+const maxSecurityKeyNameLength = 64
+
+// totpEnrollment is a TOTP secret shown to the user until they confirm it
+// with a code from their app.
+type totpEnrollment struct {
+	Secret string
+	URI    string
+}
+
+// keyRegistration is a security key registration for webauthn.js to
+// complete in the browser.
+type keyRegistration struct {
+	Name    string
+	Options string
+}
+
+// BeginTOTP generates a TOTP secret for the user to add to their app.
+func (h *SettingsHTTP) BeginTOTP(w http.ResponseWriter, r *http.Request, _ wtypes.Vars) {
+	userID, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	base := wtypes.Base(r)
+
+	current, err := base.Global.Queries.GetTOTPSecret(r.Context(), userID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		slog.Error("failed to get TOTP secret", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if current.Confirmed {
+		h.message(w, r, userID, "You have already set up an authenticator app")
+		return
+	}
+
+	secret := totp.NewSecret()
+	err = base.Global.Queries.UpsertTOTPSecret(r.Context(), queries.UpsertTOTPSecretParams{
+		UserID: userID,
+		Secret: secret,
+	})
+	if err != nil {
+		slog.Error("failed to insert TOTP secret", "error", err)
+		http.Error(w, "Failed to set up authenticator app", http.StatusInternalServerError)
+		return
+	}
+	h.render(w, r, userID, settingsPage{
+		TOTP: &totpEnrollment{
+			Secret: totp.Encode(secret),
+			URI:    totp.URI(secret, base.Global.ForgeTitle, base.Username),
+		},
+	}) //exhaustruct:ignore
+}
+
+// ConfirmTOTP enables the user's TOTP secret once they enter a code from
+// it, which shows that their app has it.
+func (h *SettingsHTTP) ConfirmTOTP(w http.ResponseWriter, r *http.Request, _ wtypes.Vars) {
+	userID, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	base := wtypes.Base(r)
+
+	secret, err := base.Global.Queries.GetTOTPSecret(r.Context(), userID)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && secret.Confirmed) {
+		http.Redirect(w, r, "/-/settings/", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		slog.Error("failed to get TOTP secret", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	step, valid := totp.Validate(secret.Secret, r.PostFormValue("code"), time.Now(), secret.LastStep)
+	if !valid {
+		h.render(w, r, userID, settingsPage{
+			Message: "Incorrect code; check that your device's clock is right",
+			TOTP: &totpEnrollment{
+				Secret: totp.Encode(secret.Secret),
+				URI:    totp.URI(secret.Secret, base.Global.ForgeTitle, base.Username),
+			},
+		}) //exhaustruct:ignore
+		return
+	}
+	_, err = base.Global.Queries.ConfirmTOTPSecret(r.Context(), queries.ConfirmTOTPSecretParams{
+		UserID:   userID,
+		LastStep: step,
+	})
+	if err != nil {
+		slog.Error("failed to confirm TOTP secret", "error", err)
+		http.Error(w, "Failed to set up authenticator app", http.StatusInternalServerError)
+		return
+	}
+	codes, err := firstRecoveryCodes(r.Context(), base, userID)
+	if err != nil {
+		slog.Error("failed to create recovery codes", "error", err)
+		http.Error(w, "Failed to create recovery codes", http.StatusInternalServerError)
+		return
+	}
+	h.render(w, r, userID, settingsPage{
+		Message:       "Your authenticator app will be asked for when you log in",
+		RecoveryCodes: codes,
+	}) //exhaustruct:ignore
+}
+
+func (h *SettingsHTTP) DisableTOTP(w http.ResponseWriter, r *http.Request, _ wtypes.Vars) {
+	userID, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	base := wtypes.Base(r)
+	if err := base.Global.Queries.DeleteTOTPSecret(r.Context(), userID); err != nil {
+		slog.Error("failed to delete TOTP secret", "error", err)
+		http.Error(w, "Failed to remove authenticator app", http.StatusInternalServerError)
+		return
+	}
+	if err := dropRecoveryCodes(r.Context(), base, userID); err != nil {
+		slog.Error("failed to delete recovery codes", "error", err)
+	}
+	http.Redirect(w, r, "/-/settings/", http.StatusSeeOther)
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes, such as when
+// they have used some or lost them.
+func (h *SettingsHTTP) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request, _ wtypes.Vars) {
+	userID, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	base := wtypes.Base(r)
+	has, err := base.Global.Queries.UserHasSecondFactor(r.Context(), userID)
+	if err != nil {
+		slog.Error("failed to check second factors", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !has {
+		h.message(w, r, userID, "Recovery codes are only needed once you have set up a second factor")
+		return
+	}
+	codes, err := newRecoveryCodes(r.Context(), base, userID)
+	if err != nil {
+		slog.Error("failed to create recovery codes", "error", err)
+		http.Error(w, "Failed to create recovery codes", http.StatusInternalServerError)
+		return
+	}
+	h.render(w, r, userID, settingsPage{
+		Message:       "Your old recovery codes no longer work",
+		RecoveryCodes: codes,
+	}) //exhaustruct:ignore
+}
+
+// BeginSecurityKey starts registering a security key, which webauthn.js
+// finishes by posting to FinishSecurityKey.
+func (h *SettingsHTTP) BeginSecurityKey(w http.ResponseWriter, r *http.Request, _ wtypes.Vars) {
+	userID, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	base := wtypes.Base(r)
+
+	name := strings.TrimSpace(r.PostFormValue("name"))
+	if name == "" || utf8.RuneCountInString(name) > maxSecurityKeyNameLength || misc.SliceContainsNewlines([]string{name}) {
+		h.message(w, r, userID, "Security keys need a name of up to "+strconv.Itoa(maxSecurityKeyNameLength)+" characters")
+		return
+	}
+	rp, err := relyingParty(base)
+	if err != nil {
+		slog.Error("failed to set up WebAuthn", "error", err)
+		http.Error(w, "Security keys are unavailable on this forge", http.StatusInternalServerError)
+		return
+	}
+	creds, err := base.Global.Queries.GetWebAuthnCredentials(r.Context(), userID)
+	if err != nil {
+		slog.Error("failed to get security keys", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	exclude := make([][]byte, 0, len(creds))
+	for _, cred := range creds {
+		exclude = append(exclude, cred.CredentialID)
+	}
+
+	challenge := webauthn.NewChallenge()
+	err = base.Global.Queries.UpsertWebAuthnRegistration(r.Context(), queries.UpsertWebAuthnRegistrationParams{
+		UserID:    userID,
+		Challenge: challenge,
+		Name:      name,
+		ExpiresAt: pgtype.Timestamptz{
+			Time:  time.Now().Add(pendingLoginExpiry),
+			Valid: true,
+		},
+	})
+	if err != nil {
+		slog.Error("failed to insert WebAuthn registration", "error", err)
+		http.Error(w, "Failed to add security key", http.StatusInternalServerError)
+		return
+	}
+	options, err := json.Marshal(rp.CreationOptions(challenge, userHandle(userID), base.Username, base.Username, exclude))
+	if err != nil {
+		slog.Error("failed to encode WebAuthn options", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	h.render(w, r, userID, settingsPage{
+		SecurityKey: &keyRegistration{
+			Name:    name,
+			Options: misc.BytesToString(options),
+		},
+	}) //exhaustruct:ignore
+}
+
+func (h *SettingsHTTP) FinishSecurityKey(w http.ResponseWriter, r *http.Request, _ wtypes.Vars) {
+	userID, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	base := wtypes.Base(r)
+
+	reg, err := base.Global.Queries.TakeWebAuthnRegistration(r.Context(), userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		h.message(w, r, userID, "The security key took too long to respond; please try again")
+		return
+	} else if err != nil {
+		slog.Error("failed to get WebAuthn registration", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	clientData, ok1 := formBytes(r, "client_data")
+	attestation, ok2 := formBytes(r, "attestation")
+	if !ok1 || !ok2 {
+		h.message(w, r, userID, "The security key could not be added")
+		return
+	}
+	rp, err := relyingParty(base)
+	if err != nil {
+		slog.Error("failed to set up WebAuthn", "error", err)
+		http.Error(w, "Security keys are unavailable on this forge", http.StatusInternalServerError)
+		return
+	}
+	cred, err := rp.FinishRegistration(reg.Challenge, clientData, attestation)
+	if err != nil {
+		slog.Info("security key registration rejected", "user", userID, "error", err)
+		h.message(w, r, userID, "The security key could not be added")
+		return
+	}
+
+	err = base.Global.Queries.InsertWebAuthnCredential(r.Context(), queries.InsertWebAuthnCredentialParams{
+		UserID:       userID,
+		CredentialID: cred.ID,
+		PublicKey:    cred.PublicKey,
+		SignCount:    int64(cred.SignCount),
+		Name:         reg.Name,
+	})
+	if isUniqueViolation(err) {
+		h.message(w, r, userID, "This security key has already been added")
+		return
+	} else if err != nil {
+		slog.Error("failed to insert security key", "error", err)
+		http.Error(w, "Failed to add security key", http.StatusInternalServerError)
+		return
+	}
+	codes, err := firstRecoveryCodes(r.Context(), base, userID)
+	if err != nil {
+		slog.Error("failed to create recovery codes", "error", err)
+		http.Error(w, "Failed to create recovery codes", http.StatusInternalServerError)
+		return
+	}
+	h.render(w, r, userID, settingsPage{
+		Message:       "Added security key " + reg.Name,
+		RecoveryCodes: codes,
+	}) //exhaustruct:ignore
+}
+
+func (h *SettingsHTTP) DeleteSecurityKey(w http.ResponseWriter, r *http.Request, _ wtypes.Vars) {
+	userID, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	base := wtypes.Base(r)
+	keyID, err := strconv.ParseInt(r.PostFormValue("key_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid security key ID", http.StatusBadRequest)
+		return
+	}
+	err = base.Global.Queries.DeleteWebAuthnCredential(r.Context(), queries.DeleteWebAuthnCredentialParams{
+		ID:     keyID,
+		UserID: userID,
+	})
+	if err != nil {
+		slog.Error("failed to delete security key", "error", err)
+		http.Error(w, "Failed to remove security key", http.StatusInternalServerError)
+		return
+	}
+	if err := dropRecoveryCodes(r.Context(), base, userID); err != nil {
+		slog.Error("failed to delete recovery codes", "error", err)
+	}
+	http.Redirect(w, r, "/-/settings/", http.StatusSeeOther)
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"go.lindenii.runxiyu.org/forge/forged/internal/common/misc"
+	"go.lindenii.runxiyu.org/forge/forged/internal/database/queries"
+	"go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/templates"
+	wtypes "go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/types"
+)
+
+type UserHTTP struct {
+	r templates.Renderer
+}
+
+func NewUserHTTP(r templates.Renderer) *UserHTTP {
+	return &UserHTTP{
+		r: r,
+	}
+}
+
+type userRepo struct {
+	Path        string
+	URL         string
+	Description string
+}
+
+type userMR struct {
+	Repo   string
+	URL    string
+	ID     int64
+	Title  string
+	Status string
+}
+
+func repoURL(groupPath []string, name string) string {
+	return "/" + misc.SegmentsToURL(groupPath) + "/-/repos/" + url.PathEscape(name) + "/"
+}
+
+func (h *UserHTTP) Profile(w http.ResponseWriter, r *http.Request, v wtypes.Vars) {
+	base := wtypes.Base(r)
+	userID, err := strconv.ParseInt(v["user"], 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	profile, err := base.Global.Queries.GetUserProfile(r.Context(), userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		slog.Error("failed to get user profile", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	repoRows, err := base.Global.Queries.GetReposByUser(r.Context(), userID)
+	if err != nil {
+		slog.Error("failed to get repos by user", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	repos := make([]userRepo, 0, len(repoRows))
+	for _, row := range repoRows {
+		repos = append(repos, userRepo{
+			Path:        strings.Join(row.GroupPath, "/") + "/" + row.Name,
+			URL:         repoURL(row.GroupPath, row.Name),
+			Description: row.Description,
+		})
+	}
+
+	mrRows, err := base.Global.Queries.GetMergeRequestsByUser(r.Context(), &userID)
+	if err != nil {
+		slog.Error("failed to get merge requests by user", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	mrs := make([]userMR, 0, len(mrRows))
+	for _, row := range mrRows {
+		mrs = append(mrs, userMR{
+			Repo:   strings.Join(row.GroupPath, "/") + "/" + row.RepoName,
+			URL:    repoURL(row.GroupPath, row.RepoName) + "contrib/" + strconv.FormatInt(row.RepoLocalID, 10) + "/",
+			ID:     row.RepoLocalID,
+			Title:  row.Title,
+			Status: row.Status,
+		})
+	}
+
+	err = h.r.Render(w, "user", struct {
+		BaseData *wtypes.BaseData
+		Profile  queries.GetUserProfileRow
+		IsSelf   bool
+		Repos    []userRepo
+		MRs      []userMR
+	}{
+		BaseData: base,
+		Profile:  profile,
+		IsSelf:   base.UserID == v["user"],
+		Repos:    repos,
+		MRs:      mrs,
+	})
+	if err != nil {
+		slog.Error("failed to render user page", "error", err)
+	}
+}
+
+// Keys serves a user's SSH public keys as an authorized_keys file, which
+// other forges fetch to verify that accounts there belong to the user.
+func (h *UserHTTP) Keys(w http.ResponseWriter, r *http.Request, v wtypes.Vars) {
+	base := wtypes.Base(r)
+	username := v["username"]
+	if _, err := base.Global.Queries.GetUserCreds(r.Context(), &username); errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		slog.Error("failed to get user", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	keys, err := base.Global.Queries.GetSSHPublicKeysByUsername(r.Context(), &username)
+	if err != nil {
+		slog.Error("failed to get SSH keys by username", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, key := range keys {
+		_, _ = w.Write(misc.StringToBytes(key + "\n"))
+	}
+}
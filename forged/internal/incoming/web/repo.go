@@ -0,0 +1,118 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"go.lindenii.runxiyu.org/forge/forged/internal/database/queries"
+	wtypes "go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/types"
+)
+
+// resolveRepo sets bd.Repo to the repository named in the URL, with a
+// client for its storage node, or responds with an error and returns false.
+// Anyone may read a repository, but other methods need a role in its group.
+func (r *Router) resolveRepo(w http.ResponseWriter, req *http.Request, bd *wtypes.BaseData, name string) bool {
+	userID, err := strconv.ParseInt(bd.UserID, 10, 64)
+	if err != nil {
+		userID = 0
+	}
+
+	repo, err := r.lookupRepo(req.Context(), bd.GroupPath, name, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		r.err404(w, bd)
+		return false
+	} else if err != nil {
+		slog.Error("resolve repo", "error", err, "group_path", bd.GroupPath, "repo", name)
+		r.err500(w, bd, "Error resolving repository")
+		return false
+	}
+
+	if req.Method != http.MethodGet && req.Method != http.MethodHead && !repo.HasRole {
+		r.err403(w, bd, "You do not have the necessary permissions for this repository.")
+		return false
+	}
+
+	repo.Client, repo.Path, err = r.global.Storage.Client(req.Context(), repo.StorageNode, repo.ID)
+	if err != nil {
+		slog.Error("git2d connect failed", "error", err, "node", repo.StorageNode)
+		r.err500(w, bd, "Error connecting to the repository's storage node")
+		return false
+	}
+	bd.Repo = repo
+	return true
+}
+
+// lookupRepo finds a repository by its group path and name, going through
+// r.repoIDs. A cached ID is checked against the full group path and name
+// of the repository it now refers to, so repositories and groups renamed or
+// moved without invalidating the cache are looked up again rather than
+// served under their old path.
+func (r *Router) lookupRepo(ctx context.Context, groupPath []string, name string, userID int64) (*wtypes.Repo, error) {
+	q := r.global.Queries
+
+	if ids, ok := r.repoIDs.Load(groupPath, name); ok {
+		row, err := q.GetRepoByIDForUser(ctx, queries.GetRepoByIDForUserParams{ID: ids.RepoID, UserID: userID})
+		switch {
+		case err == nil && slices.Equal(row.GroupPath, groupPath) && row.Name == name:
+			repo := &wtypes.Repo{
+				ID:          ids.RepoID,
+				GroupID:     row.GroupID,
+				Name:        row.Name,
+				Description: row.Description,
+				StorageNode: row.StorageNode,
+				HasRole:     row.HasRole,
+			} //exhaustruct:ignore
+			return repo, nil
+		case err == nil || errors.Is(err, pgx.ErrNoRows):
+			r.repoIDs.InvalidateRepo(groupPath, name)
+		default:
+			return nil, err
+		}
+	}
+
+	grp, err := q.GetGroupByPath(ctx, queries.GetGroupByPathParams{Column1: groupPath, UserID: userID})
+	if err != nil {
+		return nil, err
+	}
+	row, err := q.GetRepoByGroupAndName(ctx, queries.GetRepoByGroupAndNameParams{GroupID: grp.ID, Name: name})
+	if err != nil {
+		return nil, err
+	}
+	r.repoIDs.Store(groupPath, name, wtypes.RepoIDs{GroupID: grp.ID, RepoID: row.ID})
+	repo := &wtypes.Repo{
+		ID:          row.ID,
+		GroupID:     grp.ID,
+		Name:        row.Name,
+		Description: row.Description,
+		StorageNode: row.StorageNode,
+		HasRole:     grp.HasRole,
+	} //exhaustruct:ignore
+	return repo, nil
+}
+
+// checkOwner2FA keeps logged-in users whom general.require_owner_2fa applies
+// to from changing anything under groups until they set up a second factor,
+// responding with an error and returning false if so. Their settings lie
+// outside of groups and so stay reachable.
+func (r *Router) checkOwner2FA(w http.ResponseWriter, req *http.Request, bd *wtypes.BaseData) bool {
+	userID, err := strconv.ParseInt(bd.UserID, 10, 64)
+	if err != nil {
+		return true
+	}
+	missing, err := wtypes.Missing2FA(req.Context(), bd, userID)
+	if err != nil {
+		slog.Error("failed to check second factors", "error", err)
+		r.err500(w, bd, "Error checking second factors")
+		return false
+	}
+	if missing {
+		r.err403(w, bd, "Group owners must set up two-factor authentication in their settings first.")
+		return false
+	}
+	return true
+}
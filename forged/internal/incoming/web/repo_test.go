@@ -0,0 +1,69 @@
+package web
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"go.lindenii.runxiyu.org/forge/forged/internal/database/dbtest"
+	"go.lindenii.runxiyu.org/forge/forged/internal/global"
+)
+
+func TestLookupRenamedRepo(t *testing.T) {
+	t.Parallel()
+	db, q := dbtest.New(t)
+	ctx := t.Context()
+	r := NewRouter().Global(&global.Global{Queries: q, DB: db}) //exhaustruct:ignore
+
+	var repoID int64
+	err := db.QueryRow(ctx, `
+		WITH n AS (INSERT INTO storage_nodes (name) VALUES ('default') RETURNING name),
+		g AS (INSERT INTO groups (name) VALUES ('g') RETURNING id)
+		INSERT INTO repos (group_id, name, contrib_requirements, storage_node)
+		SELECT g.id, 'r', 'open', n.name FROM g, n RETURNING id`).Scan(&repoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exec := func(sql string) {
+		t.Helper()
+		if _, err := db.Exec(ctx, sql); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// lookup checks that path resolves to the repository, or to nothing
+	// if want is zero, twice so that the second goes through the cache.
+	lookup := func(groupPath []string, name string, want int64) {
+		t.Helper()
+		for range 2 {
+			repo, err := r.lookupRepo(ctx, groupPath, name, 0)
+			switch {
+			case want == 0 && !errors.Is(err, pgx.ErrNoRows):
+				t.Fatalf("%v/%s: got %v, want no rows", groupPath, name, err)
+			case want != 0 && (err != nil || repo.ID != want):
+				t.Fatalf("%v/%s: got %+v, %v, want repository %d", groupPath, name, repo, err, want)
+			}
+		}
+	}
+
+	lookup([]string{"g"}, "r", repoID)
+	exec(`UPDATE repos SET name = 's'`)
+	lookup([]string{"g"}, "r", 0)
+	if _, ok := r.repoIDs.Load([]string{"g"}, "r"); ok {
+		t.Error("the old name is still cached")
+	}
+	lookup([]string{"g"}, "s", repoID)
+
+	// Moving it into another group.
+	exec(`INSERT INTO groups (name) VALUES ('h')`)
+	exec(`UPDATE repos SET group_id = (SELECT id FROM groups WHERE name = 'h')`)
+	lookup([]string{"g"}, "s", 0)
+	lookup([]string{"h"}, "s", repoID)
+
+	// Renaming the group.
+	exec(`UPDATE groups SET name = 'i' WHERE name = 'h'`)
+	lookup([]string{"h"}, "s", 0)
+	lookup([]string{"i"}, "s", repoID)
+
+	exec(`DELETE FROM repos`)
+	lookup([]string{"i"}, "s", 0)
+}
@@ -3,7 +3,9 @@ package web
 import (
 	"fmt"
 	"net/http"
+	"net/netip"
 	"net/url"
+	"slices"
 	"sort"
 	"strings"
 
@@ -16,8 +18,17 @@ type UserResolver func(*http.Request) (id string, username string, err error)
 type ErrorRenderers struct {
 	BadRequest      func(http.ResponseWriter, *wtypes.BaseData, string)
 	BadRequestColon func(http.ResponseWriter, *wtypes.BaseData)
+	Forbidden       func(http.ResponseWriter, *wtypes.BaseData, string)
 	NotFound        func(http.ResponseWriter, *wtypes.BaseData)
 	ServerError     func(http.ResponseWriter, *wtypes.BaseData, string)
+	// MethodNotAllowed is called after the Allow header is set.
+	MethodNotAllowed func(http.ResponseWriter, *wtypes.BaseData)
+}
+
+// prefixErrors are the error renderers for paths under prefix.
+type prefixErrors struct {
+	prefix []string
+	errors ErrorRenderers
 }
 
 type dirPolicy int
@@ -49,6 +60,8 @@ type route struct {
 	rawPattern string
 	wantDir    dirPolicy
 	ifEmptyKey string
+	repo       bool
+	noCSRF     bool
 	segs       []patSeg
 	h          wtypes.HandlerFunc
 	hh         http.Handler
@@ -58,9 +71,12 @@ type route struct {
 type Router struct {
 	routes       []route
 	errors       ErrorRenderers
+	prefixErrors []prefixErrors
 	user         UserResolver
 	global       *global.Global
 	reverseProxy bool
+	rootHTTPS    bool
+	repoIDs      wtypes.RepoIDCache
 }
 
 func NewRouter() *Router { return &Router{} }
@@ -73,6 +89,24 @@ func (r *Router) ReverseProxy(enabled bool) *Router   { r.reverseProxy = enabled
 func (r *Router) Errors(e ErrorRenderers) *Router     { r.errors = e; return r }
 func (r *Router) UserResolver(u UserResolver) *Router { r.user = u; return r }
 
+// ErrorsUnder renders errors for paths under prefix, such as "-/api/", with
+// e rather than the renderers set with Errors, as for an API whose clients
+// expect errors in its own format.
+func (r *Router) ErrorsUnder(prefix string, e ErrorRenderers) *Router {
+	r.prefixErrors = append(r.prefixErrors, prefixErrors{
+		prefix: strings.Split(strings.Trim(prefix, "/"), "/"),
+		errors: e,
+	})
+	return r
+}
+
+// Root sets the canonical URL of the web root; if it is an HTTPS URL, all
+// cookies are Secure.
+func (r *Router) Root(root string) *Router {
+	r.rootHTTPS = strings.HasPrefix(root, "https://")
+	return r
+}
+
 type RouteOption func(*route)
 
 func WithDir() RouteOption    { return func(rt *route) { rt.wantDir = dirRequire } }
@@ -81,6 +115,14 @@ func WithDirIfEmpty(param string) RouteOption {
 	return func(rt *route) { rt.wantDir = dirRequireIfEmpty; rt.ifEmptyKey = param }
 }
 
+// WithRepo resolves the route's @group and :repo into BaseData.Repo before
+// calling its handler; see resolveRepo.
+func WithRepo() RouteOption { return func(rt *route) { rt.repo = true } }
+
+// WithoutCSRF exempts the route from CSRF checks, for clients such as Git
+// that authenticate every request themselves.
+func WithoutCSRF() RouteOption { return func(rt *route) { rt.noCSRF = true } }
+
 func (r *Router) GET(pattern string, f wtypes.HandlerFunc, opts ...RouteOption) {
 	r.handle("GET", pattern, f, nil, opts...)
 }
@@ -133,7 +175,7 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 	for _, s := range segments {
 		if strings.Contains(s, ":") {
-			r.err400Colon(w, &wtypes.BaseData{Global: r.global})
+			r.err400Colon(w, &wtypes.BaseData{Global: r.global, URLSegments: segments})
 			return
 		}
 	}
@@ -142,6 +184,9 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		Global:      r.global,
 		URLSegments: segments,
 		DirMode:     dirMode,
+		RepoIDs:     &r.repoIDs,
+		Secure:      r.secure(req),
+		ClientIP:    r.clientIP(req),
 	}
 	req = req.WithContext(wtypes.WithBaseData(req.Context(), bd))
 
@@ -199,6 +244,22 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 			continue
 		}
 
+		bd.SetCSRFToken(func() string { return csrfToken(w, req) })
+		if !safeMethod(method) && !rt.noCSRF && !checkCSRF(req, bd.CSRFToken()) {
+			r.err403(w, bd, "Invalid or missing CSRF token; reload the page and try again.")
+			return
+		}
+		if _, grouped := vars["group"]; grouped && !safeMethod(method) && !r.checkOwner2FA(w, req, bd) {
+			return
+		}
+
+		if rt.repo {
+			if !r.resolveRepo(w, req, bd, vars["repo"]) {
+				return
+			}
+			defer func() { _ = bd.Repo.Client.Close() }()
+		}
+
 		if rt.h != nil {
 			rt.h(w, req, wtypes.Vars(vars))
 		} else if rt.hh != nil {
@@ -211,12 +272,42 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 	if pathMatched {
 		w.Header().Set("Allow", allowForPattern(r.routes, matchedRaw))
-		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		r.err405(w, bd)
 		return
 	}
 	r.err404(w, bd)
 }
 
+// secure reports whether cookies sent in reply to req should be Secure.
+func (r *Router) secure(req *http.Request) bool {
+	if r.rootHTTPS || req.TLS != nil {
+		return true
+	}
+	return r.reverseProxy && req.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// clientIP returns the address that req came from. Behind a reverse proxy,
+// that is the last address in X-Forwarded-For, which the proxy appended;
+// any before it were sent by the client and can't be trusted.
+func (r *Router) clientIP(req *http.Request) netip.Addr {
+	if r.reverseProxy {
+		if fwd := req.Header.Values("X-Forwarded-For"); len(fwd) > 0 {
+			last := fwd[len(fwd)-1]
+			if i := strings.LastIndexByte(last, ','); i >= 0 {
+				last = last[i+1:]
+			}
+			if addr, err := netip.ParseAddr(strings.TrimSpace(last)); err == nil {
+				return addr.Unmap()
+			}
+		}
+	}
+	addrPort, err := netip.ParseAddrPort(req.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addrPort.Addr().Unmap()
+}
+
 func compilePattern(pat string) ([]patSeg, int) {
 	if pat == "" || pat == "/" {
 		return nil, 1000
@@ -353,33 +444,59 @@ func allowForPattern(routes []route, raw string) string {
 	return strings.Join(out, ", ")
 }
 
+// errorsFor returns the error renderers for the path that b is about.
+func (r *Router) errorsFor(b *wtypes.BaseData) ErrorRenderers {
+	for _, pe := range r.prefixErrors {
+		if len(b.URLSegments) >= len(pe.prefix) && slices.Equal(b.URLSegments[:len(pe.prefix)], pe.prefix) {
+			return pe.errors
+		}
+	}
+	return r.errors
+}
+
 func (r *Router) err400(w http.ResponseWriter, b *wtypes.BaseData, msg string) {
-	if r.errors.BadRequest != nil {
-		r.errors.BadRequest(w, b, msg)
+	if e := r.errorsFor(b); e.BadRequest != nil {
+		e.BadRequest(w, b, msg)
 		return
 	}
 	http.Error(w, msg, http.StatusBadRequest)
 }
 
 func (r *Router) err400Colon(w http.ResponseWriter, b *wtypes.BaseData) {
-	if r.errors.BadRequestColon != nil {
-		r.errors.BadRequestColon(w, b)
+	if e := r.errorsFor(b); e.BadRequestColon != nil {
+		e.BadRequestColon(w, b)
 		return
 	}
 	http.Error(w, "bad request", http.StatusBadRequest)
 }
 
+func (r *Router) err403(w http.ResponseWriter, b *wtypes.BaseData, msg string) {
+	if e := r.errorsFor(b); e.Forbidden != nil {
+		e.Forbidden(w, b, msg)
+		return
+	}
+	http.Error(w, msg, http.StatusForbidden)
+}
+
 func (r *Router) err404(w http.ResponseWriter, b *wtypes.BaseData) {
-	if r.errors.NotFound != nil {
-		r.errors.NotFound(w, b)
+	if e := r.errorsFor(b); e.NotFound != nil {
+		e.NotFound(w, b)
 		return
 	}
 	http.NotFound(w, nil)
 }
 
+func (r *Router) err405(w http.ResponseWriter, b *wtypes.BaseData) {
+	if e := r.errorsFor(b); e.MethodNotAllowed != nil {
+		e.MethodNotAllowed(w, b)
+		return
+	}
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+}
+
 func (r *Router) err500(w http.ResponseWriter, b *wtypes.BaseData, msg string) {
-	if r.errors.ServerError != nil {
-		r.errors.ServerError(w, b, msg)
+	if e := r.errorsFor(b); e.ServerError != nil {
+		e.ServerError(w, b, msg)
 		return
 	}
 	http.Error(w, msg, http.StatusInternalServerError)
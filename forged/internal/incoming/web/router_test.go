@@ -0,0 +1,219 @@
+package web
+
+import (
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.lindenii.runxiyu.org/forge/forged/internal/config"
+	"go.lindenii.runxiyu.org/forge/forged/internal/database/dbtest"
+	"go.lindenii.runxiyu.org/forge/forged/internal/global"
+	wtypes "go.lindenii.runxiyu.org/forge/forged/internal/incoming/web/types"
+)
+
+// newTestRouter returns a router with routes that reply with who they were
+// requested by, and GET -/form with the CSRF token for a form.
+func newTestRouter(g *global.Global) *Router {
+	r := NewRouter().Global(g)
+	reply := func(w http.ResponseWriter, req *http.Request, _ wtypes.Vars) {
+		_, _ = io.WriteString(w, "user "+wtypes.Base(req).UserID)
+	}
+	r.GET("-/form", func(w http.ResponseWriter, req *http.Request, _ wtypes.Vars) {
+		_, _ = io.WriteString(w, wtypes.Base(req).CSRFToken())
+	})
+	r.GET("-/whoami", reply)
+	r.POST("-/action", reply)
+	r.POST("-/hook", reply, WithoutCSRF())
+	r.GET("@group/", reply)
+	r.POST("@group/", reply)
+	return r
+}
+
+// client is a browser, which keeps the cookies it is sent.
+type client struct {
+	t       *testing.T
+	r       *Router
+	cookies map[string]*http.Cookie
+}
+
+func newClient(t *testing.T, r *Router) *client {
+	return &client{t: t, r: r, cookies: map[string]*http.Cookie{}}
+}
+
+func (c *client) do(req *http.Request) *httptest.ResponseRecorder {
+	c.t.Helper()
+	for _, cookie := range c.cookies {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	c.r.ServeHTTP(w, req)
+	for _, cookie := range w.Result().Cookies() {
+		c.cookies[cookie.Name] = cookie
+	}
+	return w
+}
+
+func (c *client) get(target string) *httptest.ResponseRecorder {
+	c.t.Helper()
+	return c.do(httptest.NewRequestWithContext(c.t.Context(), http.MethodGet, target, nil))
+}
+
+// post posts form to target, with token in the X-CSRF-Token header unless
+// it is empty.
+func (c *client) post(target string, form url.Values, token string) *httptest.ResponseRecorder {
+	c.t.Helper()
+	req := httptest.NewRequestWithContext(c.t.Context(), http.MethodPost, target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if token != "" {
+		req.Header.Set(csrfHeader, token)
+	}
+	return c.do(req)
+}
+
+// token loads a form and returns its CSRF token.
+func (c *client) token() string {
+	c.t.Helper()
+	w := c.get("/-/form")
+	if w.Code != http.StatusOK || w.Body.Len() == 0 {
+		c.t.Fatalf("loading a form: got %d %q", w.Code, w.Body.String())
+	}
+	return w.Body.String()
+}
+
+func TestCSRF(t *testing.T) {
+	t.Parallel()
+	r := newTestRouter(nil)
+	alice, mallory := newClient(t, r), newClient(t, r)
+
+	// Pages without forms don't set the seed cookie.
+	alice.get("/-/whoami")
+	if len(alice.cookies) != 0 {
+		t.Errorf("got cookies %v from a page without forms", alice.cookies)
+	}
+	token := alice.token()
+	if alice.token() != token {
+		t.Error("the token changed between forms")
+	}
+
+	tests := []struct {
+		name string
+		c    *client
+		form url.Values
+		hdr  string
+		code int
+	}{
+		{"header", alice, nil, token, http.StatusOK},
+		{"form field", alice, url.Values{"csrf": {token}}, "", http.StatusOK},
+		{"no token", alice, nil, "", http.StatusForbidden},
+		{"wrong token", alice, nil, mallory.token(), http.StatusForbidden},
+		// The token is only good with the cookie it came from.
+		{"someone else's token", mallory, nil, token, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if w := tt.c.post("/-/action", tt.form, tt.hdr); w.Code != tt.code {
+			t.Errorf("%s: got %d, want %d", tt.name, w.Code, tt.code)
+		}
+	}
+
+	stranger := newClient(t, r)
+	if w := stranger.post("/-/action", nil, token); w.Code != http.StatusForbidden {
+		t.Errorf("token without its cookie: got %d", w.Code)
+	}
+	if w := stranger.post("/-/hook", nil, ""); w.Code != http.StatusOK {
+		t.Errorf("route without CSRF checks: got %d", w.Code)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	t.Parallel()
+	db, q := dbtest.New(t)
+	g := &global.Global{
+		Config:  &config.Config{General: config.General{RequireOwner2FA: true}}, //exhaustruct:ignore
+		Queries: q,
+		DB:      db,
+	} //exhaustruct:ignore
+	r := newTestRouter(g).UserResolver(userResolver)
+	exec := func(sql string, args ...any) {
+		t.Helper()
+		if _, err := db.Exec(t.Context(), sql, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	userID := func(name string) (id int64) {
+		t.Helper()
+		if err := db.QueryRow(t.Context(), `INSERT INTO users (username, type) VALUES ($1, 'registered') RETURNING id`, name).Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	// logIn gives a client the session with token, which expires at expiry.
+	logIn := func(id int64, token string, expiry time.Time) *client {
+		t.Helper()
+		hash := sha256.Sum256([]byte(token))
+		exec(`INSERT INTO sessions (user_id, token_hash, expires_at) VALUES ($1, $2, $3)`, id, hash[:], expiry)
+		c := newClient(t, r)
+		c.cookies["session"] = &http.Cookie{Name: "session", Value: token} //exhaustruct:ignore
+		return c
+	}
+	whoami := func(c *client) string {
+		t.Helper()
+		return c.get("/-/whoami").Body.String()
+	}
+
+	alice, bob := userID("alice"), userID("bob")
+	aliceClient := logIn(alice, "alice-token", time.Now().Add(time.Hour))
+	if got, want := whoami(aliceClient), "user "+strconv.FormatInt(alice, 10); got != want {
+		t.Errorf("with a session: got %q, want %q", got, want)
+	}
+	expired := logIn(alice, "expired-token", time.Now().Add(-time.Hour))
+	if got := whoami(expired); got != "user " {
+		t.Errorf("with an expired session: got %q", got)
+	}
+	forged := newClient(t, r)
+	forged.cookies["session"] = &http.Cookie{Name: "session", Value: "made-up"} //exhaustruct:ignore
+	if got := whoami(forged); got != "user " {
+		t.Errorf("with a made-up session: got %q", got)
+	}
+
+	// Logged in, the token is derived from the session, and needs no
+	// seed cookie.
+	token := aliceClient.token()
+	if _, ok := aliceClient.cookies["csrf"]; ok {
+		t.Error("a CSRF seed cookie was set for a session")
+	}
+	if w := aliceClient.post("/-/action", nil, token); w.Code != http.StatusOK || w.Body.String() != "user "+strconv.FormatInt(alice, 10) {
+		t.Errorf("posting with a session: got %d %q", w.Code, w.Body.String())
+	}
+	if w := expired.post("/-/action", nil, token); w.Code != http.StatusForbidden {
+		t.Errorf("another session's token: got %d", w.Code)
+	}
+
+	// alice owns g and has no second factor, so may look at it but not
+	// change anything under it.
+	exec(`INSERT INTO groups (name) VALUES ('g')`)
+	exec(`INSERT INTO user_group_roles (group_id, user_id, role) SELECT id, $1, 'owner' FROM groups WHERE name = 'g'`, alice)
+	if w := aliceClient.get("/g/"); w.Code != http.StatusOK {
+		t.Errorf("owner without 2FA viewing: got %d", w.Code)
+	}
+	w := aliceClient.post("/g/", nil, token)
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "two-factor") {
+		t.Errorf("owner without 2FA posting: got %d %q", w.Code, w.Body.String())
+	}
+	bobClient := logIn(bob, "bob-token", time.Now().Add(time.Hour))
+	if w := bobClient.post("/g/", nil, bobClient.token()); w.Code != http.StatusOK {
+		t.Errorf("non-owner posting: got %d", w.Code)
+	}
+	if w := aliceClient.post("/-/action", nil, token); w.Code != http.StatusOK {
+		t.Errorf("owner without 2FA posting outside groups: got %d", w.Code)
+	}
+	exec(`INSERT INTO totp_secrets (user_id, secret, confirmed) VALUES ($1, 'secret', TRUE)`, alice)
+	if w := aliceClient.post("/g/", nil, token); w.Code != http.StatusOK {
+		t.Errorf("owner with 2FA posting: got %d", w.Code)
+	}
+}
@@ -0,0 +1,53 @@
+package web
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// sessionPruneInterval is how often expired sessions, pending logins,
+// OAuth logins and security key registrations are deleted. They are already rejected when
+// looked up, so this only bounds the tables' sizes.
+const sessionPruneInterval = time.Hour
+
+// loginAttemptRetention is how long login attempts are kept for auditing.
+const loginAttemptRetention = 90 * 24 * time.Hour
+
+// PruneSessions periodically deletes expired sessions and login challenges,
+// and old login attempts, until ctx is done.
+func (server *Server) PruneSessions(ctx context.Context) error {
+	ticker := time.NewTicker(sessionPruneInterval)
+	defer ticker.Stop()
+	for {
+		n, err := server.global.Queries.PruneSessions(ctx)
+		if err != nil && ctx.Err() == nil {
+			slog.Warn("session prune failed", "error", err)
+		} else if n > 0 {
+			slog.Debug("pruned expired sessions", "count", n)
+		}
+		if _, err := server.global.Queries.PrunePendingLogins(ctx); err != nil && ctx.Err() == nil {
+			slog.Warn("pending login prune failed", "error", err)
+		}
+		if _, err := server.global.Queries.PruneWebAuthnRegistrations(ctx); err != nil && ctx.Err() == nil {
+			slog.Warn("security key registration prune failed", "error", err)
+		}
+		if _, err := server.global.Queries.PruneOAuthLogins(ctx); err != nil && ctx.Err() == nil {
+			slog.Warn("OAuth login prune failed", "error", err)
+		}
+		cutoff := pgtype.Timestamptz{
+			Time:  time.Now().Add(-loginAttemptRetention),
+			Valid: true,
+		}
+		if _, err := server.global.Queries.PruneLoginAttempts(ctx, cutoff); err != nil && ctx.Err() == nil {
+			slog.Warn("login attempt prune failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package types
+
+import (
+	"strings"
+
+	"go.lindenii.runxiyu.org/forge/forged/internal/common/cmap"
+	"go.lindenii.runxiyu.org/forge/forged/internal/ipc/git2c"
+)
+
+// Repo is the repository a route is about, resolved by the router before
+// its handler runs.
+type Repo struct {
+	ID          int64
+	GroupID     int64
+	Name        string
+	Description string
+	StorageNode string
+	// HasRole is whether the user has a role in the repository's group.
+	HasRole bool
+	// Client is connected to the repository's storage node, and is closed
+	// by the router once the handler returns.
+	Client *git2c.Client
+	// Path is where the repository is on its storage node.
+	Path string
+}
+
+type repoKey struct {
+	group string // path segments joined with NUL, which names cannot contain
+	name  string
+}
+
+func newRepoKey(groupPath []string, name string) repoKey {
+	return repoKey{group: strings.Join(groupPath, "\x00"), name: name}
+}
+
+// RepoIDs are what a repository's URL path resolves to.
+type RepoIDs struct {
+	GroupID int64
+	RepoID  int64
+}
+
+// RepoIDCache remembers which repository a group path and repository name
+// refer to, saving the lookup of each group along the path on every
+// request. Hits are checked against the repository's current path and
+// dropped if it has changed, so repositories and groups may be renamed,
+// moved or deleted, which forged has no routes for and is done in the
+// database, without telling the cache.
+type RepoIDCache struct {
+	m cmap.Map[repoKey, RepoIDs]
+}
+
+func (c *RepoIDCache) Load(groupPath []string, name string) (RepoIDs, bool) {
+	return c.m.Load(newRepoKey(groupPath, name))
+}
+
+func (c *RepoIDCache) Store(groupPath []string, name string, ids RepoIDs) {
+	c.m.Store(newRepoKey(groupPath, name), ids)
+}
+
+// InvalidateRepo forgets the repository at the given path.
+func (c *RepoIDCache) InvalidateRepo(groupPath []string, name string) {
+	c.m.Delete(newRepoKey(groupPath, name))
+}
@@ -0,0 +1,17 @@
+package types
+
+import "context"
+
+// Missing2FA reports whether general.require_owner_2fa applies to the user,
+// as the owner of a group, and they have yet to set up a second factor.
+func Missing2FA(ctx context.Context, base *BaseData, userID int64) (bool, error) {
+	if !base.Global.Config.General.RequireOwner2FA {
+		return false, nil
+	}
+	owns, err := base.Global.Queries.UserOwnsGroups(ctx, userID)
+	if err != nil || !owns {
+		return false, err
+	}
+	has, err := base.Global.Queries.UserHasSecondFactor(ctx, userID)
+	return !has, err
+}
@@ -3,6 +3,7 @@ package types
 import (
 	"context"
 	"net/http"
+	"net/netip"
 
 	"go.lindenii.runxiyu.org/forge/forged/internal/global"
 )
@@ -17,6 +18,36 @@ type BaseData struct {
 	RefType        string
 	RefName        string
 	Global         *global.Global
+	// Secure is whether the client reached us over HTTPS, directly or
+	// through the reverse proxy, or web.root says it must have.
+	Secure bool
+	// ClientIP is the client's address, as reported by the reverse proxy
+	// if there is one, or the zero Addr if it is unknown, as over a UNIX
+	// socket without one.
+	ClientIP netip.Addr
+	// csrfToken computes what CSRFToken returns, on first use.
+	csrfToken  func() string
+	csrfCached string
+	// Repo is set on routes registered with WithRepo.
+	Repo *Repo
+	// RepoIDs is shared by all requests; see RepoIDCache.
+	RepoIDs *RepoIDCache
+}
+
+// CSRFToken must be submitted as the "csrf" form field or X-CSRF-Token
+// header with every request that isn't GET or HEAD; see csrf_field. It is
+// only computed when asked for, as that may set a cookie, which must then
+// happen before the response is written.
+func (b *BaseData) CSRFToken() string {
+	if b.csrfCached == "" && b.csrfToken != nil {
+		b.csrfCached = b.csrfToken()
+	}
+	return b.csrfCached
+}
+
+// SetCSRFToken sets how CSRFToken computes the token.
+func (b *BaseData) SetCSRFToken(compute func() string) {
+	b.csrfToken, b.csrfCached = compute, ""
 }
 
 type ctxKey struct{}
@@ -0,0 +1,125 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+
+package git2c
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"go.lindenii.runxiyu.org/forge/forged/internal/common/bare"
+)
+
+var errSeekBackward = errors.New("git2c: blob stream cannot seek backwards")
+
+// Blob streams a blob's content from git2d, which sends it as a series of
+// data chunks terminated by an empty one.
+//
+// A Blob is an io.ReadSeeker so that it can be passed to http.ServeContent,
+// but it can only seek forwards; seeking to the end only reports the size.
+// Issuing another command on the client it came from abandons the rest of
+// the blob, which must then no longer be read.
+type Blob struct {
+	OID    string // hex
+	Size   uint64
+	Binary bool
+
+	reader *bare.Reader
+	buf    []byte // received but not yet consumed
+	pos    int64  // bytes consumed
+	target int64  // position requested by Seek, >= pos
+	recvd  uint64 // bytes received
+	done   bool   // terminating chunk received
+}
+
+func (b *Blob) fill() error {
+	chunk, err := b.reader.ReadData()
+	if err != nil {
+		return fmt.Errorf("reading blob chunk failed: %w", err)
+	}
+	if len(chunk) == 0 {
+		b.done = true
+		if b.recvd != b.Size {
+			return fmt.Errorf("git2c: blob ended after %d of %d bytes", b.recvd, b.Size)
+		}
+		return nil
+	}
+	b.recvd += uint64(len(chunk))
+	if b.recvd > b.Size {
+		return fmt.Errorf("git2c: blob is longer than its declared %d bytes", b.Size)
+	}
+	b.buf = append(b.buf, chunk...)
+	return nil
+}
+
+// skip discards content up to the position requested by Seek.
+func (b *Blob) skip() error {
+	for b.pos < b.target {
+		if len(b.buf) == 0 {
+			if b.done {
+				return nil
+			}
+			if err := b.fill(); err != nil {
+				return err
+			}
+			continue
+		}
+		n := int(min(int64(len(b.buf)), b.target-b.pos))
+		b.buf = b.buf[n:]
+		b.pos += int64(n)
+	}
+	return nil
+}
+
+func (b *Blob) Read(p []byte) (int, error) {
+	if err := b.skip(); err != nil {
+		return 0, err
+	}
+	for len(b.buf) == 0 {
+		if b.done {
+			return 0, io.EOF
+		}
+		if err := b.fill(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, b.buf)
+	b.buf = b.buf[n:]
+	b.pos += int64(n)
+	b.target = b.pos
+	return n, nil
+}
+
+// Peek returns up to n bytes from the current position without consuming
+// them. It returns fewer only at the end of the blob.
+func (b *Blob) Peek(n int) ([]byte, error) {
+	if err := b.skip(); err != nil {
+		return nil, err
+	}
+	for len(b.buf) < n && !b.done {
+		if err := b.fill(); err != nil {
+			return nil, err
+		}
+	}
+	return b.buf[:min(n, len(b.buf))], nil
+}
+
+func (b *Blob) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = b.target + offset
+	case io.SeekEnd:
+		abs = int64(b.Size) + offset
+	default:
+		return 0, fmt.Errorf("git2c: invalid whence %d", whence)
+	}
+	if abs < b.pos {
+		return 0, errSeekBackward
+	}
+	b.target = abs
+	return abs, nil
+}
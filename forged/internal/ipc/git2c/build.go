@@ -4,6 +4,7 @@
 package git2c
 
 import (
+	"context"
 	"encoding/hex"
 	"fmt"
 	"path"
@@ -11,11 +12,11 @@ import (
 	"strings"
 )
 
-func (c *Client) BuildTreeRecursive(repoPath, baseTreeHex string, updates map[string]string) (string, error) {
+func (c *Client) BuildTreeRecursive(ctx context.Context, repoPath, baseTreeHex string, updates map[string]string) (string, error) {
 	treeCache := make(map[string][]TreeEntryRaw)
 	var walk func(prefix, hexid string) error
 	walk = func(prefix, hexid string) error {
-		ents, err := c.TreeListByOID(repoPath, hexid)
+		ents, err := c.TreeListByOID(ctx, repoPath, hexid)
 		if err != nil {
 			return err
 		}
@@ -104,7 +105,7 @@ func (c *Client) BuildTreeRecursive(repoPath, baseTreeHex string, updates map[st
 			}
 			wr = append(wr, TreeEntryRaw{Mode: e.Mode, Name: e.Name, OID: e.OID})
 		}
-		id, err := c.WriteTree(repoPath, wr)
+		id, err := c.WriteTree(ctx, repoPath, wr)
 		if err != nil {
 			return "", err
 		}
@@ -5,43 +5,166 @@ package git2c
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
+	"strings"
+	"time"
 
 	"go.lindenii.runxiyu.org/forge/forged/internal/common/bare"
 )
 
+// Client issues commands to git2d one at a time. Clients obtained from a
+// Pool share connections with other clients; Close returns them to the
+// pool rather than closing the connection.
 type Client struct {
-	socketPath string
-	conn       net.Conn
-	writer     *bare.Writer
-	reader     *bare.Reader
+	address string
+	mux     *muxConn
+	pool    *Pool
+	stream  *stream
+	writer  *bare.Writer
+	reader  *bare.Reader
 }
 
-func NewClient(ctx context.Context, socketPath string) (*Client, error) {
-	dialer := &net.Dialer{} //exhaustruct:ignore
-	conn, err := dialer.DialContext(ctx, "unix", socketPath)
+// NewClient dials a connection to git2d for the sole use of the returned
+// client. See dial for the address format.
+func NewClient(ctx context.Context, address string, tlsConfig *tls.Config) (*Client, error) {
+	mux, err := dial(ctx, address, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return newClient(address, mux, nil), nil
+}
+
+// TLSScheme prefixes the host:port addresses of git2d instances reached
+// over TCP with TLS, like it does in git2d's command line.
+const TLSScheme = "tcp+tls://"
+
+var errNoTLSConfig = errors.New("git2d address requires TLS but no certificate is configured")
+
+// dial connects to git2d at address, which is either a UNIX domain socket
+// path or TLSScheme followed by host:port, in which case tlsConfig must
+// hold the client certificate git2d expects.
+func dial(ctx context.Context, address string, tlsConfig *tls.Config) (*muxConn, error) {
+	var conn net.Conn
+	var err error
+	if hostport, ok := strings.CutPrefix(address, TLSScheme); ok {
+		if tlsConfig == nil {
+			return nil, errNoTLSConfig
+		}
+		dialer := &tls.Dialer{Config: tlsConfig} //exhaustruct:ignore
+		conn, err = dialer.DialContext(ctx, "tcp", hostport)
+	} else {
+		dialer := &net.Dialer{} //exhaustruct:ignore
+		conn, err = dialer.DialContext(ctx, "unix", address)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("git2d connection failed: %w", err)
 	}
+	if err := handshake(ctx, conn); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return newMuxConn(conn), nil
+}
 
-	writer := bare.NewWriter(conn)
-	reader := bare.NewReader(conn)
+// handshakeTimeout bounds the version exchange when ctx has no deadline.
+const handshakeTimeout = 10 * time.Second
 
+// ErrProtocolVersion is returned when git2d speaks a different protocol
+// version than forged, usually because only one of them was upgraded.
+var ErrProtocolVersion = errors.New("git2d protocol version mismatch")
+
+// handshake exchanges protocol versions with git2d before any frames are
+// sent.
+func handshake(ctx context.Context, conn net.Conn) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(handshakeTimeout)
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return fmt.Errorf("set handshake deadline: %w", err)
+	}
+	if err := bare.NewWriter(conn).WriteUint(protocolVersion); err != nil {
+		return fmt.Errorf("sending protocol version failed: %w", err)
+	}
+	version, err := bare.NewReader(conn).ReadUint()
+	if err != nil {
+		return fmt.Errorf("reading protocol version failed: %w", err)
+	}
+	if version != protocolVersion {
+		return fmt.Errorf("%w: git2d speaks version %d, forged expects %d", ErrProtocolVersion, version, protocolVersion)
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return fmt.Errorf("clear handshake deadline: %w", err)
+	}
+	return nil
+}
+
+func newClient(address string, mux *muxConn, pool *Pool) *Client {
+	s := &stream{mux: mux, ctx: context.Background()} //exhaustruct:ignore
 	return &Client{
-		socketPath: socketPath,
-		conn:       conn,
-		writer:     writer,
-		reader:     reader,
-	}, nil
+		address: address,
+		mux:     mux,
+		pool:    pool,
+		stream:  s,
+		writer:  bare.NewWriter(s),
+		reader:  bare.NewReader(s),
+	}
 }
 
 func (c *Client) Close() (err error) {
-	if c.conn != nil {
-		err = c.conn.Close()
-		if err != nil {
-			return fmt.Errorf("close underlying socket: %w", err)
+	if c.mux == nil {
+		return nil
+	}
+	c.stream.reset()
+	if c.pool != nil {
+		c.pool.release(c.mux)
+	} else {
+		err = c.mux.Close()
+	}
+	c.mux = nil
+	if err != nil {
+		return fmt.Errorf("close underlying socket: %w", err)
+	}
+	return nil
+}
+
+// Ping checks that git2d is responsive.
+func (c *Client) Ping(ctx context.Context) error {
+	return c.call(ctx, "", &pingCommand{}, nil)
+}
+
+// call sends cmd for the repository at repoPath and decodes the reply
+// that follows a zero status into reply, which may be nil. Other statuses
+// are returned as an *Error.
+func (c *Client) call(ctx context.Context, repoPath string, cmd command, reply any) error {
+	c.stream.begin(ctx)
+	if err := bare.MarshalWriter(c.writer, &envelope{Repo: repoPath, Command: cmd}); err != nil {
+		return fmt.Errorf("sending request failed: %w", err)
+	}
+	status, err := c.reader.ReadUint()
+	if err != nil {
+		return fmt.Errorf("reading status failed: %w", err)
+	}
+	if status != 0 {
+		var detail errorReply
+		if err := bare.UnmarshalBareReader(c.reader, &detail); err != nil {
+			return fmt.Errorf("%w (reading details failed: %w)", Perror(status), err)
+		}
+		return &Error{
+			Status:  status,
+			Class:   int(detail.Class),
+			Message: string(detail.Message),
+			Input:   string(detail.Input),
 		}
 	}
+	if reply == nil {
+		return nil
+	}
+	if err := bare.UnmarshalBareReader(c.reader, reply); err != nil {
+		return fmt.Errorf("reading reply failed: %w", err)
+	}
 	return nil
 }
@@ -0,0 +1,301 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+
+package git2c_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"go.lindenii.runxiyu.org/forge/forged/internal/ipc/git2c"
+	"go.lindenii.runxiyu.org/forge/forged/internal/ipc/git2c/git2dtest"
+)
+
+const repoPath = "/repos/example.git"
+
+// bigFile spans several frames.
+var bigFile = bytes.Repeat([]byte("0123456789abcdef\n"), 10000)
+
+// newClient serves git2dtest.Fixture, with an empty file and bigFile added
+// to master, and returns a client connected to it.
+func newClient(t *testing.T) (*git2c.Client, *git2dtest.Repo) {
+	t.Helper()
+	srv, err := git2dtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = srv.Close() })
+	repo := git2dtest.Fixture()
+	repo.CommitFiles("master", "Add some data\n", map[string][]byte{
+		"data/empty": {},
+		"data/big":   bigFile,
+	})
+	srv.AddRepo(repoPath, repo)
+
+	client, err := git2c.NewClient(t.Context(), srv.SocketPath(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client, repo
+}
+
+func TestTreeRaw(t *testing.T) {
+	t.Parallel()
+	client, _ := newClient(t)
+	ctx := t.Context()
+
+	files, blob, err := client.CmdTreeRaw(ctx, repoPath, "master", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blob != nil {
+		t.Fatal("root is a blob")
+	}
+	kinds := map[string]bool{}
+	for _, f := range files {
+		kinds[f.Name] = f.IsSubtree
+	}
+	want := map[string]bool{"COPYING": false, "README.md": false, "cmd": true, "data": true, "logo.png": false, "notes.txt": false}
+	if len(kinds) != len(want) {
+		t.Errorf("root has %v, want %v", kinds, want)
+	}
+	for name, subtree := range want {
+		if got, ok := kinds[name]; !ok || got != subtree {
+			t.Errorf("root entry %q: subtree %v, present %v; want subtree %v", name, got, ok, subtree)
+		}
+	}
+
+	_, blob, err = client.CmdTreeRaw(ctx, repoPath, "master", "logo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blob == nil || !blob.Binary {
+		t.Fatalf("logo.png: got %+v, want a binary blob", blob)
+	}
+
+	_, _, err = client.CmdTreeRaw(ctx, repoPath, "master", "missing")
+	if !git2c.IsNotFound(err) {
+		t.Errorf("missing path: got %v, want not found", err)
+	}
+	_, _, err = client.CmdTreeRaw(ctx, repoPath, "no-such-branch", "")
+	if !git2c.IsNotFound(err) {
+		t.Errorf("missing ref: got %v, want not found", err)
+	}
+}
+
+func TestBlob(t *testing.T) {
+	t.Parallel()
+	client, _ := newClient(t)
+	ctx := t.Context()
+
+	_, blob, err := client.CmdTreeRaw(ctx, repoPath, "master", "data/empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blob.Size != 0 {
+		t.Errorf("empty file has size %d", blob.Size)
+	}
+	if content, err := io.ReadAll(blob); err != nil || len(content) != 0 {
+		t.Errorf("reading empty file: got %q, %v", content, err)
+	}
+
+	_, blob, err = client.CmdTreeRaw(ctx, repoPath, "master", "data/big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blob.Size != uint64(len(bigFile)) || blob.Binary {
+		t.Fatalf("big file: size %d, binary %v", blob.Size, blob.Binary)
+	}
+	if head, err := blob.Peek(4); err != nil || string(head) != "0123" {
+		t.Errorf("Peek(4) = %q, %v", head, err)
+	}
+	if end, err := blob.Seek(0, io.SeekEnd); err != nil || end != int64(len(bigFile)) {
+		t.Errorf("Seek to end = %d, %v", end, err)
+	}
+	if _, err := blob.Seek(100000, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	part := make([]byte, 17)
+	if _, err := io.ReadFull(blob, part); err != nil || !bytes.Equal(part, bigFile[100000:100017]) {
+		t.Errorf("read at 100000: %q, %v", part, err)
+	}
+	if _, err := blob.Seek(0, io.SeekStart); err == nil {
+		t.Error("seeking backwards succeeded")
+	}
+	rest, err := io.ReadAll(blob)
+	if err != nil || !bytes.Equal(rest, bigFile[100017:]) {
+		t.Errorf("reading the rest: %d bytes, %v", len(rest), err)
+	}
+}
+
+// TestAbandon checks that a command issued before a blob is fully read
+// still gets its own reply.
+func TestAbandon(t *testing.T) {
+	t.Parallel()
+	client, repo := newClient(t)
+	ctx := t.Context()
+
+	_, blob, err := client.CmdTreeRaw(ctx, repoPath, "master", "data/big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := blob.Peek(10); err != nil {
+		t.Fatal(err)
+	}
+	id, err := client.ResolveRef(ctx, repoPath, "branch", "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := repo.Ref("refs/heads/master"); id != want {
+		t.Errorf("master resolved to %s, want %s", id, want)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := client.ListBranches(cancelled, repoPath); err == nil {
+		t.Error("command with a cancelled context succeeded")
+	}
+	if _, err := client.ListBranches(ctx, repoPath); err != nil {
+		t.Errorf("command after a cancelled one: %v", err)
+	}
+}
+
+func TestCommitInfo(t *testing.T) {
+	t.Parallel()
+	client, repo := newClient(t)
+	ctx := t.Context()
+
+	// The fixture's last commit on master before the data renames LICENSE.
+	master := repo.Ref("refs/heads/master")
+	renamed, _ := repo.Commit(master)
+	info, err := client.CommitInfo(ctx, repoPath, renamed.Parents[0], git2c.DiffOptions{IgnoreWhitespace: false})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Message != "Rename LICENSE to COPYING\n" || info.AuthorName != git2dtest.FixtureAuthor.Name {
+		t.Errorf("got message %q by %q", info.Message, info.AuthorName)
+	}
+	if len(info.Parents) != 1 {
+		t.Errorf("got parents %v, want one", info.Parents)
+	}
+	if len(info.Files) != 1 {
+		t.Fatalf("got %d files, want 1", len(info.Files))
+	}
+	f := info.Files[0]
+	if f.Status != git2c.DeltaRenamed || f.FromPath != "LICENSE" || f.ToPath != "COPYING" || f.Similarity != 100 {
+		t.Errorf("got %+v, want LICENSE renamed to COPYING", f)
+	}
+
+	info, err = client.CommitInfo(ctx, repoPath, master, git2c.DiffOptions{IgnoreWhitespace: false})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Stats.Additions != 10000 || info.Stats.Deletions != 0 || len(info.Files) != 2 {
+		t.Errorf("got stats %+v over %d files, want 10000 additions over 2", info.Stats, len(info.Files))
+	}
+
+	_, err = client.CommitInfo(ctx, repoPath, "0000000000000000000000000000000000000000", git2c.DiffOptions{IgnoreWhitespace: false})
+	if !git2c.IsNotFound(err) {
+		t.Errorf("missing commit: got %v, want not found", err)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	t.Parallel()
+	client, repo := newClient(t)
+	ctx := t.Context()
+
+	master, feature, tag := repo.Ref("refs/heads/master"), repo.Ref("refs/heads/feature"), repo.Ref("refs/tags/v0.1")
+	base, err := client.MergeBase(ctx, repoPath, master, feature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base != tag {
+		t.Errorf("merge base is %s, want v0.1 (%s)", base, tag)
+	}
+
+	revs, err := client.LogRange(ctx, repoPath, master, feature, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revs.Ahead != 1 || revs.Behind != 3 || len(revs.Commits) != 1 || revs.Commits[0].Hash != feature {
+		t.Errorf("got %d ahead, %d behind, commits %+v; want 1 ahead, 3 behind", revs.Ahead, revs.Behind, revs.Commits)
+	}
+
+	files, stats, err := client.DiffTrees(ctx, repoPath, base, feature, git2c.DiffOptions{IgnoreWhitespace: false})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Status != git2c.DeltaAdded || files[0].ToPath != "feature.go" {
+		t.Fatalf("got %+v, want feature.go added", files)
+	}
+	if stats.Additions != 3 || files[0].Additions != 3 || len(files[0].Hunks) != 1 {
+		t.Errorf("got stats %+v and file %+v, want 3 additions in one hunk", stats, files[0])
+	}
+
+	patch, err := client.DiffTreesPatch(ctx, repoPath, base, feature, git2c.DiffOptions{IgnoreWhitespace: false})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(patch, "+const feature = true\n") {
+		t.Errorf("patch lacks the added line:\n%s", patch)
+	}
+}
+
+// TestStalledReply checks that a reply nobody reads holds up neither other
+// requests on the same connection nor, once reading resumes, itself.
+func TestStalledReply(t *testing.T) {
+	t.Parallel()
+	srv, err := git2dtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = srv.Close() })
+	huge := bytes.Repeat([]byte("0123456789abcdef\n"), 4<<20/17)
+	repo := git2dtest.Fixture()
+	repo.CommitFiles("master", "Add a huge file\n", map[string][]byte{"huge": huge})
+	srv.AddRepo(repoPath, repo)
+
+	//exhaustruct:ignore
+	pool := git2c.NewPool(git2c.PoolConfig{Address: srv.SocketPath(), MaxConns: 1})
+	t.Cleanup(pool.Close)
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Second)
+	defer cancel()
+
+	stalled, err := pool.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = stalled.Close() }()
+	_, blob, err := stalled.CmdTreeRaw(ctx, repoPath, "master", "huge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := blob.Peek(10); err != nil {
+		t.Fatal(err)
+	}
+	// Give git2d time to fill the stalled reply's window.
+	time.Sleep(100 * time.Millisecond)
+
+	// With a single connection, this client shares the stalled one's.
+	other, err := pool.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = other.Close() }()
+	for range 3 {
+		if _, err := other.ListBranches(ctx, repoPath); err != nil {
+			t.Fatalf("request alongside a stalled reply: %v", err)
+		}
+	}
+
+	content, err := io.ReadAll(blob)
+	if err != nil || !bytes.Equal(content, huge) {
+		t.Errorf("reading the stalled reply: %d of %d bytes, %v", len(content), len(huge), err)
+	}
+}
@@ -0,0 +1,65 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+
+package git2c
+
+import (
+	"context"
+)
+
+// RevRange describes the commits reachable from a head but not from a base.
+type RevRange struct {
+	Ahead   uint64
+	Behind  uint64
+	Commits []Commit
+}
+
+// DiffOptions controls how git2d computes diffs.
+type DiffOptions struct {
+	IgnoreWhitespace bool
+}
+
+func (o DiffOptions) flags() uint {
+	var flags uint
+	if o.IgnoreWhitespace {
+		flags |= 1
+	}
+	return flags
+}
+
+// DiffTrees diffs the trees of two commits (or trees). An empty oldSpec
+// diffs against the empty tree.
+func (c *Client) DiffTrees(ctx context.Context, repoPath, oldSpec, newSpec string, opts DiffOptions) ([]FileDiff, DiffStats, error) {
+	var reply diffReply
+	cmd := &diffTreesCommand{Old: oldSpec, New: newSpec, Format: diffFormatStructured, DiffFlags: opts.flags()}
+	if err := c.call(ctx, repoPath, cmd, &reply); err != nil {
+		return nil, DiffStats{}, err
+	}
+	files, stats := reply.files()
+	return files, stats, nil
+}
+
+// DiffTreesPatch is like DiffTrees but returns a unified diff.
+func (c *Client) DiffTreesPatch(ctx context.Context, repoPath, oldSpec, newSpec string, opts DiffOptions) (string, error) {
+	var reply patchReply
+	cmd := &diffTreesCommand{Old: oldSpec, New: newSpec, Format: diffFormatPatch, DiffFlags: opts.flags()}
+	if err := c.call(ctx, repoPath, cmd, &reply); err != nil {
+		return "", err
+	}
+	return string(reply.Patch), nil
+}
+
+// LogRange lists up to n commits reachable from headHex but not from
+// baseHex, newest first, along with ahead/behind counts. n == 0 means no
+// limit.
+func (c *Client) LogRange(ctx context.Context, repoPath, baseHex, headHex string, n uint) (*RevRange, error) {
+	var reply logRangeReply
+	if err := c.call(ctx, repoPath, &logRangeCommand{Base: baseHex, Head: headHex, Limit: n}, &reply); err != nil {
+		return nil, err
+	}
+	return &RevRange{
+		Ahead:   uint64(reply.Ahead),
+		Behind:  uint64(reply.Behind),
+		Commits: commitsFromWire(reply.Commits),
+	}, nil
+}
@@ -4,62 +4,17 @@
 package git2c
 
 import (
-	"encoding/hex"
-	"errors"
-	"fmt"
-	"io"
+	"context"
 )
 
-func (c *Client) CmdIndex(repoPath string) ([]Commit, *FilenameContents, error) {
-	err := c.writer.WriteData([]byte(repoPath))
-	if err != nil {
-		return nil, nil, fmt.Errorf("sending repo path failed: %w", err)
-	}
-	err = c.writer.WriteUint(1)
-	if err != nil {
-		return nil, nil, fmt.Errorf("sending command failed: %w", err)
-	}
-
-	status, err := c.reader.ReadUint()
-	if err != nil {
-		return nil, nil, fmt.Errorf("reading status failed: %w", err)
-	}
-	if status != 0 {
-		return nil, nil, fmt.Errorf("git2d error: %d", status)
-	}
-
-	// README
-	readmeRaw, err := c.reader.ReadData()
-	if err != nil {
-		readmeRaw = nil
+func (c *Client) CmdIndex(ctx context.Context, repoPath string) ([]Commit, *FilenameContents, error) {
+	var reply indexReply
+	if err := c.call(ctx, repoPath, &indexCommand{}, &reply); err != nil {
+		return nil, nil, err
 	}
 
 	readmeFilename := "README.md" // TODO
-	readme := &FilenameContents{Filename: readmeFilename, Content: readmeRaw}
-
-	// Commits
-	var commits []Commit
-	for {
-		id, err := c.reader.ReadData()
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				break
-			}
-			return nil, nil, fmt.Errorf("reading commit ID failed: %w", err)
-		}
-		title, _ := c.reader.ReadData()
-		authorName, _ := c.reader.ReadData()
-		authorEmail, _ := c.reader.ReadData()
-		authorDate, _ := c.reader.ReadData()
-
-		commits = append(commits, Commit{
-			Hash:    hex.EncodeToString(id),
-			Author:  string(authorName),
-			Email:   string(authorEmail),
-			Date:    string(authorDate),
-			Message: string(title),
-		})
-	}
+	readme := &FilenameContents{Filename: readmeFilename, Content: reply.Readme}
 
-	return commits, readme, nil
+	return commitsFromWire(reply.Commits), readme, nil
 }
@@ -3,24 +3,10 @@
 
 package git2c
 
-import "fmt"
+import (
+	"context"
+)
 
-func (c *Client) InitRepo(repoPath, hooksPath string) error {
-	if err := c.writer.WriteData([]byte(repoPath)); err != nil {
-		return fmt.Errorf("sending repo path failed: %w", err)
-	}
-	if err := c.writer.WriteUint(15); err != nil {
-		return fmt.Errorf("sending command failed: %w", err)
-	}
-	if err := c.writer.WriteData([]byte(hooksPath)); err != nil {
-		return fmt.Errorf("sending hooks path failed: %w", err)
-	}
-	status, err := c.reader.ReadUint()
-	if err != nil {
-		return fmt.Errorf("reading status failed: %w", err)
-	}
-	if status != 0 {
-		return Perror(status)
-	}
-	return nil
+func (c *Client) InitRepo(ctx context.Context, repoPath, hooksPath string) error {
+	return c.call(ctx, repoPath, &initRepoCommand{HooksPath: hooksPath}, nil)
 }
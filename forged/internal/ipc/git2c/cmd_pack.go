@@ -0,0 +1,120 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+
+package git2c
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"go.lindenii.runxiyu.org/forge/forged/internal/common/bare"
+)
+
+// Ref is a direct reference and the object it points to.
+type Ref struct {
+	Name string
+	ID   string // hex
+}
+
+// PackReader streams a packfile from git2d, which sends it as a series of
+// data chunks terminated by an empty one. Like a Blob, it must be read to
+// EOF before the client it came from is reused.
+type PackReader struct {
+	// Head is the full name of the branch HEAD points to, or empty if
+	// HEAD is detached.
+	Head string
+	Refs []Ref
+
+	reader *bare.Reader
+	buf    []byte
+	done   bool
+}
+
+func (p *PackReader) Read(b []byte) (int, error) {
+	for len(p.buf) == 0 {
+		if p.done {
+			return 0, io.EOF
+		}
+		chunk, err := p.reader.ReadData()
+		if err != nil {
+			return 0, fmt.Errorf("reading pack chunk failed: %w", err)
+		}
+		if len(chunk) == 0 {
+			p.done = true
+		}
+		p.buf = chunk
+	}
+	n := copy(b, p.buf)
+	p.buf = p.buf[n:]
+	return n, nil
+}
+
+// Pack returns the references of the repository at repoPath and a pack of
+// the objects reachable from them, leaving out the history of exclude, a
+// list of object IDs the receiver already has along with their history.
+func (c *Client) Pack(ctx context.Context, repoPath string, exclude []string) (*PackReader, error) {
+	ids := make([]oid, 0, len(exclude))
+	for _, id := range exclude {
+		ids = append(ids, oid(id))
+	}
+	var reply packReply
+	if err := c.call(ctx, repoPath, &packCommand{Exclude: ids}, &reply); err != nil {
+		return nil, err
+	}
+	refs := make([]Ref, 0, len(reply.Refs))
+	for _, r := range reply.Refs {
+		refs = append(refs, Ref{Name: string(r.Name), ID: string(r.ID)})
+	}
+	return &PackReader{
+		Head:   string(reply.Head),
+		Refs:   refs,
+		reader: c.reader,
+	}, nil
+}
+
+// importChunkSize is how much of a pack Import sends per request; it must
+// not exceed IMPORT_CHUNK_MAX_SIZE in git2d/x.h.
+const importChunkSize = 1 << 20
+
+// packHeaderSize covers the signature, version and object count.
+const packHeaderSize = 12
+
+// Import copies pack, which may come from another git2d, into the
+// repository at repoPath, which must already exist. It then points the
+// repository's references and HEAD where they were in pack's repository,
+// deleting references that it doesn't have.
+func (c *Client) Import(ctx context.Context, repoPath string, pack *PackReader) error {
+	buf := make([]byte, importChunkSize)
+	var offset uint
+	for {
+		n, err := io.ReadFull(pack, buf)
+		if offset == 0 && n >= packHeaderSize && binary.BigEndian.Uint32(buf[8:packHeaderSize]) == 0 {
+			// Nothing new; libgit2 won't index packs without objects.
+			if _, err := io.Copy(io.Discard, pack); err != nil {
+				return err
+			}
+			break
+		}
+		if n > 0 {
+			if err := c.call(ctx, repoPath, &importChunkCommand{Offset: offset, Data: buf[:n]}, nil); err != nil {
+				return err
+			}
+			offset += uint(n)
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	refs := make([]refWire, 0, len(pack.Refs))
+	for _, r := range pack.Refs {
+		refs = append(refs, refWire{Name: text(r.Name), ID: oid(r.ID)})
+	}
+	return c.call(ctx, repoPath, &importFinishCommand{Head: text(pack.Head), Refs: refs}, nil)
+}
@@ -4,92 +4,40 @@
 package git2c
 
 import (
-	"errors"
+	"context"
 	"fmt"
-	"io"
 )
 
-func (c *Client) CmdTreeRaw(repoPath, pathSpec string) ([]TreeEntry, string, error) {
-	err := c.writer.WriteData([]byte(repoPath))
-	if err != nil {
-		return nil, "", fmt.Errorf("sending repo path failed: %w", err)
+// CmdTreeRaw looks up pathSpec in the tree of rev (HEAD if empty). Exactly
+// one of the returned entries and blob is non-nil on success; a blob must be
+// read to EOF before the client is reused.
+func (c *Client) CmdTreeRaw(ctx context.Context, repoPath, rev, pathSpec string) ([]TreeEntry, *Blob, error) {
+	var obj treeRawObject
+	if err := c.call(ctx, repoPath, &treeRawCommand{Path: pathSpec, Rev: rev}, &obj); err != nil {
+		return nil, nil, err
 	}
-	err = c.writer.WriteUint(2)
-	if err != nil {
-		return nil, "", fmt.Errorf("sending command failed: %w", err)
-	}
-	err = c.writer.WriteData([]byte(pathSpec))
-	if err != nil {
-		return nil, "", fmt.Errorf("sending path failed: %w", err)
-	}
-
-	status, err := c.reader.ReadUint()
-	if err != nil {
-		return nil, "", fmt.Errorf("reading status failed: %w", err)
-	}
-
-	switch status {
-	case 0:
-		kind, err := c.reader.ReadUint()
-		if err != nil {
-			return nil, "", fmt.Errorf("reading object kind failed: %w", err)
-		}
-
-		switch kind {
-		case 1:
-			// Tree
-			count, err := c.reader.ReadUint()
-			if err != nil {
-				return nil, "", fmt.Errorf("reading entry count failed: %w", err)
-			}
 
-			var files []TreeEntry
-			for range count {
-				typeCode, err := c.reader.ReadUint()
-				if err != nil {
-					return nil, "", fmt.Errorf("error reading entry type: %w", err)
-				}
-				mode, err := c.reader.ReadUint()
-				if err != nil {
-					return nil, "", fmt.Errorf("error reading entry mode: %w", err)
-				}
-				size, err := c.reader.ReadUint()
-				if err != nil {
-					return nil, "", fmt.Errorf("error reading entry size: %w", err)
-				}
-				name, err := c.reader.ReadData()
-				if err != nil {
-					return nil, "", fmt.Errorf("error reading entry name: %w", err)
-				}
-
-				files = append(files, TreeEntry{
-					Name:      string(name),
-					Mode:      fmt.Sprintf("%06o", mode),
-					Size:      size,
-					IsFile:    typeCode == 2,
-					IsSubtree: typeCode == 1,
-				})
-			}
-
-			return files, "", nil
-
-		case 2:
-			// Blob
-			content, err := c.reader.ReadData()
-			if err != nil && !errors.Is(err, io.EOF) {
-				return nil, "", fmt.Errorf("error reading file content: %w", err)
-			}
-
-			return nil, string(content), nil
-
-		default:
-			return nil, "", fmt.Errorf("unknown kind: %d", kind)
+	switch obj := obj.(type) {
+	case *treeRawTree:
+		files := make([]TreeEntry, 0, len(obj.Entries))
+		for _, e := range obj.Entries {
+			files = append(files, TreeEntry{
+				Name:      string(e.Name),
+				Mode:      fmt.Sprintf("%06o", e.Mode),
+				Size:      uint64(e.Size),
+				IsFile:    e.Type == 2,
+				IsSubtree: e.Type == 1,
+			})
 		}
-
-	case 3:
-		return nil, "", fmt.Errorf("path not found: %s", pathSpec)
-
+		return files, nil, nil
+	case *treeRawBlob:
+		return nil, &Blob{
+			OID:    string(obj.ID),
+			Size:   uint64(obj.Size),
+			Binary: obj.Binary,
+			reader: c.reader,
+		}, nil
 	default:
-		return nil, "", fmt.Errorf("unknown status code: %d", status)
+		return nil, nil, fmt.Errorf("unknown object kind %T", obj)
 	}
 }
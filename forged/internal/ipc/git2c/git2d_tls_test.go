@@ -0,0 +1,236 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+
+//go:build git2d
+
+// This file runs a real git2d, built with "make dist/git2d", over TLS:
+//
+//	go test -tags git2d -run TLS ./forged/internal/ipc/git2c
+//
+// GIT2D names the binary if it is not at dist/git2d.
+
+package git2c_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"go.lindenii.runxiyu.org/forge/forged/internal/ipc/git2c"
+)
+
+// ca issues certificates for a test.
+type ca struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	dir  string
+}
+
+func newCA(t *testing.T, dir, name string) *ca {
+	t.Helper()
+	c := &ca{dir: dir} //exhaustruct:ignore
+	c.cert, c.key = c.issue(t, name, &x509.Certificate{
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}) //exhaustruct:ignore
+	return c
+}
+
+// issue signs template, completed with name and a new key, by c, or by
+// the certificate itself if c has none yet, and writes both to files
+// named after name.
+func (c *ca) issue(t *testing.T, name string, template *x509.Certificate) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.Subject = pkix.Name{CommonName: name} //exhaustruct:ignore
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	parent, parentKey := template, key
+	if c.cert != nil {
+		parent, parentKey = c.cert, c.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, filepath.Join(c.dir, name+".crt"), "CERTIFICATE", der)
+	writePEM(t, filepath.Join(c.dir, name+".key"), "PRIVATE KEY", keyDER)
+	return cert, key
+}
+
+func (c *ca) server(t *testing.T, name string) {
+	t.Helper()
+	c.issue(t, name, &x509.Certificate{
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+	}) //exhaustruct:ignore
+}
+
+func (c *ca) client(t *testing.T, name string) {
+	t.Helper()
+	c.issue(t, name, &x509.Certificate{
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+	}) //exhaustruct:ignore
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil { //exhaustruct:ignore
+		t.Fatal(err)
+	}
+}
+
+// startGit2d runs git2d on a free port of the loopback interface, trusting
+// clients with certificates from clientCA, and returns its address.
+func startGit2d(t *testing.T, cert, key, clientCA string) string {
+	t.Helper()
+	bin := os.Getenv("GIT2D")
+	if bin == "" {
+		_, file, _, _ := runtime.Caller(0)
+		bin = filepath.Join(filepath.Dir(file), "..", "..", "..", "..", "dist", "git2d")
+	}
+	if _, err := os.Stat(bin); err != nil {
+		t.Skipf("git2d is not built: %v", err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostport := l.Addr().String()
+	_ = l.Close()
+
+	cmd := exec.Command(bin, "-c", cert, "-k", key, "-a", clientCA, git2c.TLSScheme+hostport)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		conn, err := net.Dial("tcp", hostport)
+		if err == nil {
+			_ = conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("git2d did not start listening: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return git2c.TLSScheme + hostport
+}
+
+func TestTLS(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	file := func(name string) string { return filepath.Join(dir, name) }
+	trusted := newCA(t, dir, "ca")
+	trusted.server(t, "git2d")
+	trusted.client(t, "forged")
+	rogue := newCA(t, dir, "rogue-ca")
+	rogue.client(t, "rogue-forged")
+
+	address := startGit2d(t, file("git2d.crt"), file("git2d.key"), file("ca.crt"))
+	ctx, cancel := context.WithTimeout(t.Context(), 30*time.Second)
+	defer cancel()
+
+	// forged with a certificate from the CA that git2d trusts.
+	cfg, err := git2c.LoadTLSConfig(file("forged.crt"), file("forged.key"), file("ca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := git2c.NewPool(git2c.PoolConfig{Address: address, TLS: cfg, MaxConns: 2}) //exhaustruct:ignore
+	t.Cleanup(pool.Close)
+	client, err := pool.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := filepath.Join(dir, "example.git")
+	if err := client.InitRepo(ctx, repo, filepath.Join(dir, "hooks")); err != nil {
+		t.Fatal(err)
+	}
+	if branches, err := client.ListBranches(ctx, repo); err != nil || len(branches) != 0 {
+		t.Fatalf("new repository has branches %v, %v", branches, err)
+	}
+	_ = client.Close()
+
+	rejected := map[string]*tls.Config{}
+	// Without a certificate, or with one from another CA, git2d rejects
+	// forged.
+	noCert := cfg.Clone()
+	noCert.Certificates = nil
+	rejected["no client certificate"] = noCert
+	rejected["client certificate from another CA"], err = git2c.LoadTLSConfig(file("rogue-forged.crt"), file("rogue-forged.key"), file("ca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, cfg := range rejected {
+		if client, err := git2c.NewClient(ctx, address, cfg); err == nil {
+			_ = client.Close()
+			t.Errorf("%s: connected", name)
+		}
+	}
+
+	// Rejecting them doesn't affect forged.
+	client, err = pool.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+	if err := client.Ping(ctx); err != nil {
+		t.Fatalf("after rejecting others: %v", err)
+	}
+}
+
+// A git2d whose certificate is from a CA forged doesn't trust is rejected
+// even when it would accept forged.
+func TestTLSRogueServer(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	file := func(name string) string { return filepath.Join(dir, name) }
+	trusted := newCA(t, dir, "ca")
+	trusted.client(t, "forged")
+	rogue := newCA(t, dir, "rogue-ca")
+	rogue.server(t, "rogue-git2d")
+
+	address := startGit2d(t, file("rogue-git2d.crt"), file("rogue-git2d.key"), file("ca.crt"))
+	cfg, err := git2c.LoadTLSConfig(file("forged.crt"), file("forged.key"), file("ca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client, err := git2c.NewClient(t.Context(), address, cfg); err == nil {
+		_ = client.Close()
+		t.Error("connected to a git2d with a certificate from another CA")
+	}
+}
@@ -0,0 +1,690 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+
+package git2dtest
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.lindenii.runxiyu.org/forge/forged/internal/common/bare"
+)
+
+// Commands, as dispatched on in git2d/session.c.
+const (
+	cmdIndex         = 1
+	cmdTreeRaw       = 2
+	cmdResolveRef    = 3
+	cmdListBranches  = 4
+	cmdFormatPatch   = 5
+	cmdCommitInfo    = 6
+	cmdMergeBase     = 7
+	cmdLog           = 8
+	cmdTreeListByOID = 9
+	cmdWriteTree     = 10
+	cmdWriteBlob     = 11
+	cmdCommitTreeOID = 12
+	cmdCommitCreate  = 13
+	cmdUpdateRef     = 14
+	cmdInitRepo      = 15
+	cmdDiffTrees     = 16
+	cmdLogRange      = 17
+	cmdPing          = 18
+	cmdPack          = 19
+	cmdImportChunk   = 20
+	cmdImportFinish  = 21
+	cmdListTags      = 22
+)
+
+// Statuses; see Perror in git2c/perror.go.
+const (
+	statusRepoOpen       = 1
+	statusEnvelope       = 2
+	statusUnknownCommand = 3
+	statusPath           = 3
+	statusRevparse       = 4
+	statusReadme         = 5
+	statusBlobExpected   = 6
+	statusRevwalk        = 9
+	statusProtocol       = 11
+	statusRefResolve     = 12
+	statusCommitLookup   = 14
+	statusDiff           = 15
+	statusMergeBaseNone  = 16
+	statusMergeBase      = 17
+	statusUpdateRef      = 18
+	statusPack           = 25
+	statusImport         = 26
+)
+
+// Error classes, matching libgit2's git_error_t.
+const (
+	errorClassOS        = 2
+	errorClassInvalid   = 3
+	errorClassReference = 4
+	errorClassODB       = 9
+	errorClassObject    = 11
+	errorClassTree      = 14
+)
+
+// diffFlagIgnoreWhitespace is DIFF_FLAG_IGNORE_WHITESPACE in git2d/x.h.
+const diffFlagIgnoreWhitespace = 1
+
+// cmdError is a failed command, written as a non-zero status followed by
+// the details git2d's write_error sends.
+type cmdError struct {
+	status  uint64
+	class   uint64
+	message string
+	input   string
+}
+
+func (e *cmdError) Error() string {
+	return fmt.Sprintf("status %d: %s", e.status, e.message)
+}
+
+var errProtocol = &cmdError{status: statusProtocol} //exhaustruct:ignore
+
+// fail returns err, which may come from the object store, with the given
+// status and input.
+func fail(err error, status uint64, input string) error {
+	cerr := &cmdError{status: status, input: input} //exhaustruct:ignore
+	var from *cmdError
+	if errors.As(err, &from) {
+		cerr.class, cerr.message = from.class, from.message
+	}
+	return cerr
+}
+
+func writeError(w *bare.Writer, e *cmdError) {
+	_ = w.WriteUint(e.status)
+	_ = w.WriteUint(e.class)
+	_ = w.WriteData([]byte(e.message))
+	_ = w.WriteData([]byte(e.input))
+}
+
+type command func(r *Repo, req *bare.Reader, w *bare.Writer) error
+
+var commands = map[uint64]command{
+	cmdIndex:         (*Repo).cmdIndex,
+	cmdTreeRaw:       (*Repo).cmdTreeRaw,
+	cmdResolveRef:    (*Repo).cmdResolveRef,
+	cmdListBranches:  (*Repo).cmdListBranches,
+	cmdFormatPatch:   (*Repo).cmdFormatPatch,
+	cmdCommitInfo:    (*Repo).cmdCommitInfo,
+	cmdMergeBase:     (*Repo).cmdMergeBase,
+	cmdLog:           (*Repo).cmdLog,
+	cmdTreeListByOID: (*Repo).cmdTreeListByOID,
+	cmdWriteTree:     (*Repo).cmdWriteTree,
+	cmdWriteBlob:     (*Repo).cmdWriteBlob,
+	cmdCommitTreeOID: (*Repo).cmdCommitTreeOID,
+	cmdCommitCreate:  (*Repo).cmdCommitCreate,
+	cmdUpdateRef:     (*Repo).cmdUpdateRef,
+	cmdDiffTrees:     (*Repo).cmdDiffTrees,
+	cmdLogRange:      (*Repo).cmdLogRange,
+	cmdPack:          (*Repo).cmdPack,
+	cmdImportChunk:   (*Repo).cmdImportChunk,
+	cmdImportFinish:  (*Repo).cmdImportFinish,
+	cmdListTags:      (*Repo).cmdListTags,
+}
+
+// readStrings reads BARE data fields as strings.
+func readStrings(req *bare.Reader, out ...*string) error {
+	for _, s := range out {
+		b, err := req.ReadData()
+		if err != nil {
+			return errProtocol
+		}
+		*s = string(b)
+	}
+	return nil
+}
+
+func isOID(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil && len(s) == 40
+}
+
+func writeOID(w *bare.Writer, id string) {
+	raw, _ := hex.DecodeString(id)
+	_ = w.WriteData(raw)
+}
+
+// summary is the first paragraph of a commit message on one line, like
+// git_commit_summary.
+func summary(message string) string {
+	para, _, _ := strings.Cut(strings.TrimLeft(message, "\n"), "\n\n")
+	return strings.Join(strings.Fields(para), " ")
+}
+
+func (r *Repo) writeCommitList(w *bare.Writer, ids []string) {
+	_ = w.WriteUint(uint64(len(ids)))
+	for _, id := range ids {
+		c := r.objects[id].commit
+		writeOID(w, id)
+		_ = w.WriteData([]byte(summary(c.Message)))
+		_ = w.WriteData([]byte(c.Author.Name))
+		_ = w.WriteData([]byte(c.Author.Email))
+		_ = w.WriteData([]byte(c.Committer.When.Local().Format("2006-01-02 15:04:05")))
+	}
+}
+
+func (r *Repo) cmdIndex(_ *bare.Reader, w *bare.Writer) error {
+	tree, err := r.revparse("HEAD^{tree}")
+	if err != nil {
+		return fail(err, statusRevparse, "HEAD")
+	}
+	entry, err := r.treeEntry(tree, "README.md")
+	if err != nil {
+		return fail(err, statusReadme, "README.md")
+	}
+	readme, err := r.lookup(entry.ID, "blob")
+	if err != nil {
+		return fail(err, statusBlobExpected, "README.md")
+	}
+	_ = w.WriteUint(0)
+	_ = w.WriteData(readme.blob)
+	r.writeCommitList(w, r.walk(r.refs[r.head], "", 3))
+	return nil
+}
+
+func (r *Repo) cmdTreeRaw(req *bare.Reader, w *bare.Writer) error {
+	var path, rev string
+	if err := readStrings(req, &path, &rev); err != nil {
+		return err
+	}
+	if rev == "" {
+		rev = "HEAD"
+	}
+	treeID, err := r.revparse(rev + "^{tree}")
+	if err != nil {
+		return fail(err, statusRevparse, rev)
+	}
+	entry := TreeEntry{Mode: ModeTree, ID: treeID} //exhaustruct:ignore
+	if path != "" {
+		if entry, err = r.treeEntry(treeID, path); err != nil {
+			return fail(err, statusPath, path)
+		}
+	}
+
+	if entry.Mode == ModeTree {
+		tree, err := r.lookup(entry.ID, "tree")
+		if err != nil {
+			return fail(err, statusPath, path)
+		}
+		_ = w.WriteUint(0)
+		_ = w.WriteUint(1)
+		_ = w.WriteUint(uint64(len(tree.tree)))
+		for _, e := range tree.tree {
+			var kind, size uint64
+			switch obj := r.objects[e.ID]; {
+			case e.Mode == ModeTree:
+				kind = 1
+			case obj != nil && obj.kind == "blob":
+				kind, size = 2, uint64(len(obj.blob))
+			}
+			_ = w.WriteUint(kind)
+			_ = w.WriteUint(uint64(e.Mode))
+			_ = w.WriteUint(size)
+			_ = w.WriteData([]byte(e.Name))
+		}
+		return nil
+	}
+
+	blob, err := r.lookup(entry.ID, "blob")
+	if err != nil {
+		return fail(err, statusPath, path)
+	}
+	_ = w.WriteUint(0)
+	_ = w.WriteUint(2)
+	writeOID(w, entry.ID)
+	_ = w.WriteUint(uint64(len(blob.blob)))
+	_ = w.WriteBool(isBinary(blob.blob))
+	for data := blob.blob; len(data) > 0; {
+		n := min(len(data), frameSize)
+		_ = w.WriteData(data[:n])
+		data = data[n:]
+	}
+	_ = w.WriteData(nil)
+	return nil
+}
+
+func (r *Repo) cmdResolveRef(req *bare.Reader, w *bare.Writer) error {
+	var kind, name string
+	if err := readStrings(req, &kind, &name); err != nil {
+		return err
+	}
+	var id string
+	var err error
+	switch kind {
+	case "":
+		if id, err = r.revparse("HEAD^{commit}"); err != nil {
+			return fail(err, statusRefResolve, "HEAD")
+		}
+	case "commit":
+		if !isOID(name) {
+			return fail(nil, statusRefResolve, name)
+		}
+		id = name
+	case "branch":
+		id, err = r.revparse("refs/heads/" + name)
+	case "tag":
+		id, err = r.revparse("refs/tags/" + name + "^{commit}")
+	case "rev":
+		id, err = r.revparse(name + "^{commit}")
+	default:
+		return fail(nil, statusRefResolve, name)
+	}
+	if err != nil {
+		return fail(err, statusRefResolve, name)
+	}
+	_ = w.WriteUint(0)
+	writeOID(w, id)
+	return nil
+}
+
+func (r *Repo) cmdListBranches(_ *bare.Reader, w *bare.Writer) error {
+	var names []string
+	for ref := range r.refs {
+		if name, ok := strings.CutPrefix(ref, "refs/heads/"); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	_ = w.WriteUint(0)
+	_ = w.WriteUint(uint64(len(names)))
+	for _, name := range names {
+		_ = w.WriteData([]byte(name))
+	}
+	return nil
+}
+
+// cmdListTags lists every tag, as refs only ever point to commits here.
+func (r *Repo) cmdListTags(_ *bare.Reader, w *bare.Writer) error {
+	var names []string
+	for ref := range r.refs {
+		if name, ok := strings.CutPrefix(ref, "refs/tags/"); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	_ = w.WriteUint(0)
+	_ = w.WriteUint(uint64(len(names)))
+	for _, name := range names {
+		_ = w.WriteData([]byte(name))
+		writeOID(w, r.refs["refs/tags/"+name])
+	}
+	return nil
+}
+
+// commitDiff diffs a commit against its first parent, or the empty tree.
+func (r *Repo) commitDiff(c *Commit, ignoreWhitespace bool) ([]fileDiff, error) {
+	parentTree := ""
+	if len(c.Parents) > 0 {
+		parent, err := r.lookup(c.Parents[0], "commit")
+		if err != nil {
+			return nil, fail(err, statusDiff, c.Parents[0])
+		}
+		parentTree = parent.commit.Tree
+	}
+	files, err := r.diffTrees(parentTree, c.Tree, ignoreWhitespace)
+	if err != nil {
+		return nil, fail(err, statusDiff, "")
+	}
+	return files, nil
+}
+
+func (r *Repo) cmdFormatPatch(req *bare.Reader, w *bare.Writer) error {
+	var id string
+	if err := readStrings(req, &id); err != nil {
+		return err
+	}
+	obj, err := r.lookup(id, "commit")
+	if err != nil {
+		return fail(err, statusCommitLookup, id)
+	}
+	c := obj.commit
+	files, err := r.commitDiff(c, false)
+	if err != nil {
+		return err
+	}
+
+	title, body, _ := strings.Cut(c.Message, "\n")
+	body = strings.TrimPrefix(body, "\n")
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "From %s Mon Sep 17 00:00:00 2001\nFrom: %s <%s>\nDate: %s\nSubject: [PATCH] %s\n\n",
+		id, c.Author.Name, c.Author.Email, c.Committer.When.Local().Format("Mon, 02 Jan 2006 15:04:05 -0700"), title)
+	if body != "" {
+		sb.WriteString(body + "\n")
+	}
+	sb.WriteString("---\n" + statText(files) + "\n" + patchText(files) + "\n-- \n2.48.1\n")
+	_ = w.WriteUint(0)
+	_ = w.WriteData([]byte(sb.String()))
+	return nil
+}
+
+func writeSignature(w *bare.Writer, s Signature) {
+	_, offset := s.When.Zone()
+	_ = w.WriteData([]byte(s.Name))
+	_ = w.WriteData([]byte(s.Email))
+	_ = w.WriteI64(s.When.Unix())
+	_ = w.WriteI64(int64(offset / 60))
+}
+
+func writeDiffFiles(w *bare.Writer, files []fileDiff) {
+	var additions, deletions uint64
+	for _, f := range files {
+		additions += f.additions
+		deletions += f.deletions
+	}
+	_ = w.WriteUint(additions)
+	_ = w.WriteUint(deletions)
+	_ = w.WriteUint(uint64(len(files)))
+	for _, f := range files {
+		var flags uint64
+		if f.binary {
+			flags |= 1
+		}
+		_ = w.WriteUint(f.status)
+		_ = w.WriteUint(flags)
+		_ = w.WriteUint(f.similarity)
+		writeOID(w, f.from.ID)
+		writeOID(w, f.to.ID)
+		_ = w.WriteUint(uint64(f.from.Mode))
+		_ = w.WriteUint(uint64(f.to.Mode))
+		_ = w.WriteData([]byte(f.fromPath))
+		_ = w.WriteData([]byte(f.toPath))
+		_ = w.WriteUint(f.additions)
+		_ = w.WriteUint(f.deletions)
+		_ = w.WriteUint(uint64(len(f.hunks)))
+		for _, h := range f.hunks {
+			_ = w.WriteUint(h.oldStart)
+			_ = w.WriteUint(h.oldLines)
+			_ = w.WriteUint(h.newStart)
+			_ = w.WriteUint(h.newLines)
+			_ = w.WriteData([]byte(h.header))
+			_ = w.WriteUint(uint64(len(h.lines)))
+			for _, l := range h.lines {
+				_ = w.WriteUint(l.op)
+				_ = w.WriteUint(l.oldLine)
+				_ = w.WriteUint(l.newLine)
+				_ = w.WriteData([]byte(l.content))
+			}
+		}
+	}
+}
+
+func (r *Repo) cmdCommitInfo(req *bare.Reader, w *bare.Writer) error {
+	var id string
+	if err := readStrings(req, &id); err != nil {
+		return err
+	}
+	flags, err := req.ReadUint()
+	if err != nil {
+		return errProtocol
+	}
+	obj, err := r.lookup(id, "commit")
+	if err != nil {
+		return fail(err, statusCommitLookup, id)
+	}
+	c := obj.commit
+	files, err := r.commitDiff(c, flags&diffFlagIgnoreWhitespace != 0)
+	if err != nil {
+		return err
+	}
+
+	_ = w.WriteUint(0)
+	writeOID(w, id)
+	writeSignature(w, c.Author)
+	writeSignature(w, c.Committer)
+	_ = w.WriteData([]byte(c.Message))
+	_ = w.WriteUint(uint64(len(c.Parents)))
+	for _, p := range c.Parents {
+		writeOID(w, p)
+	}
+	writeDiffFiles(w, files)
+	return nil
+}
+
+func (r *Repo) cmdMergeBase(req *bare.Reader, w *bare.Writer) error {
+	var a, b string
+	if err := readStrings(req, &a, &b); err != nil {
+		return err
+	}
+	if !isOID(a) || !isOID(b) {
+		return fail(nil, statusMergeBase, "")
+	}
+	base := r.mergeBase(a, b)
+	if base == "" {
+		return &cmdError{status: statusMergeBaseNone, class: errorClassReference, message: "no merge base found"} //exhaustruct:ignore
+	}
+	_ = w.WriteUint(0)
+	writeOID(w, base)
+	return nil
+}
+
+func (r *Repo) cmdLog(req *bare.Reader, w *bare.Writer) error {
+	var spec string
+	if err := readStrings(req, &spec); err != nil {
+		return err
+	}
+	limit, err := req.ReadUint()
+	if err != nil {
+		return errProtocol
+	}
+	if spec == "" {
+		spec = "HEAD"
+	}
+	start, err := r.revparse(spec + "^{commit}")
+	if err != nil {
+		return fail(err, statusRevparse, spec)
+	}
+	_ = w.WriteUint(0)
+	r.writeCommitList(w, r.walk(start, "", limit))
+	return nil
+}
+
+func (r *Repo) cmdTreeListByOID(req *bare.Reader, w *bare.Writer) error {
+	var id string
+	if err := readStrings(req, &id); err != nil {
+		return err
+	}
+	tree, err := r.lookup(id, "tree")
+	if err != nil {
+		return fail(err, statusRevparse, id)
+	}
+	_ = w.WriteUint(0)
+	_ = w.WriteUint(uint64(len(tree.tree)))
+	for _, e := range tree.tree {
+		_ = w.WriteUint(uint64(e.Mode))
+		_ = w.WriteData([]byte(e.Name))
+		writeOID(w, e.ID)
+	}
+	return nil
+}
+
+func (r *Repo) cmdWriteTree(req *bare.Reader, w *bare.Writer) error {
+	count, err := req.ReadUint()
+	if err != nil {
+		return errProtocol
+	}
+	entries := make([]TreeEntry, 0, count)
+	for range count {
+		mode, err := req.ReadUint()
+		if err != nil {
+			return errProtocol
+		}
+		name, err := req.ReadData()
+		if err != nil {
+			return errProtocol
+		}
+		raw, err := req.ReadData()
+		if err != nil || len(raw) != 20 {
+			return errProtocol
+		}
+		entries = append(entries, TreeEntry{Mode: uint32(mode), Name: string(name), ID: hex.EncodeToString(raw)})
+	}
+	_ = w.WriteUint(0)
+	writeOID(w, r.writeTree(entries))
+	return nil
+}
+
+func (r *Repo) cmdWriteBlob(req *bare.Reader, w *bare.Writer) error {
+	content, err := req.ReadData()
+	if err != nil {
+		return errProtocol
+	}
+	_ = w.WriteUint(0)
+	writeOID(w, r.writeBlob(content))
+	return nil
+}
+
+func (r *Repo) cmdCommitTreeOID(req *bare.Reader, w *bare.Writer) error {
+	var id string
+	if err := readStrings(req, &id); err != nil {
+		return err
+	}
+	obj, err := r.lookup(id, "commit")
+	if err != nil {
+		return fail(err, statusCommitLookup, id)
+	}
+	_ = w.WriteUint(0)
+	writeOID(w, obj.commit.Tree)
+	return nil
+}
+
+func (r *Repo) cmdCommitCreate(req *bare.Reader, w *bare.Writer) error {
+	var tree string
+	if err := readStrings(req, &tree); err != nil {
+		return err
+	}
+	if _, err := r.lookup(tree, "tree"); err != nil {
+		return fail(err, statusDiff, tree)
+	}
+	count, err := req.ReadUint()
+	if err != nil {
+		return errProtocol
+	}
+	parents := make([]string, count)
+	for i := range parents {
+		if err := readStrings(req, &parents[i]); err != nil {
+			return err
+		}
+		if _, err := r.lookup(parents[i], "commit"); err != nil {
+			return fail(err, statusDiff, parents[i])
+		}
+	}
+	var name, email, message string
+	if err := readStrings(req, &name, &email); err != nil {
+		return err
+	}
+	when, err := req.ReadI64()
+	if err != nil {
+		return errProtocol
+	}
+	offset, err := req.ReadI64()
+	if err != nil {
+		return errProtocol
+	}
+	if err := readStrings(req, &message); err != nil {
+		return err
+	}
+
+	sig := Signature{Name: name, Email: email, When: time.Unix(when, 0).In(time.FixedZone("", int(offset)*60))}
+	id := r.writeCommit(Commit{Tree: tree, Parents: parents, Author: sig, Committer: sig, Message: message})
+	_ = w.WriteUint(0)
+	writeOID(w, id)
+	return nil
+}
+
+func (r *Repo) cmdUpdateRef(req *bare.Reader, w *bare.Writer) error {
+	var ref, id string
+	if err := readStrings(req, &ref, &id); err != nil {
+		return err
+	}
+	if !isOID(id) {
+		return fail(nil, statusUpdateRef, id)
+	}
+	if _, err := r.lookup(id, "commit"); err != nil {
+		return fail(err, statusUpdateRef, ref)
+	}
+	r.refs[ref] = id
+	_ = w.WriteUint(0)
+	return nil
+}
+
+// treeFromSpec resolves spec to a tree, or the empty tree if it is "".
+func (r *Repo) treeFromSpec(spec string) (string, error) {
+	if spec == "" {
+		return "", nil
+	}
+	return r.revparse(spec + "^{tree}")
+}
+
+func (r *Repo) cmdDiffTrees(req *bare.Reader, w *bare.Writer) error {
+	var oldSpec, newSpec string
+	if err := readStrings(req, &oldSpec, &newSpec); err != nil {
+		return err
+	}
+	format, err := req.ReadUint()
+	if err != nil {
+		return errProtocol
+	}
+	flags, err := req.ReadUint()
+	if err != nil {
+		return errProtocol
+	}
+	oldTree, err := r.treeFromSpec(oldSpec)
+	if err != nil {
+		return fail(err, statusRevparse, oldSpec)
+	}
+	if newSpec == "" {
+		return fail(nil, statusRevparse, newSpec)
+	}
+	newTree, err := r.treeFromSpec(newSpec)
+	if err != nil {
+		return fail(err, statusRevparse, newSpec)
+	}
+	files, err := r.diffTrees(oldTree, newTree, flags&diffFlagIgnoreWhitespace != 0)
+	if err != nil {
+		return fail(err, statusDiff, "")
+	}
+	_ = w.WriteUint(0)
+	if format == 1 {
+		_ = w.WriteData([]byte(patchText(files)))
+	} else {
+		writeDiffFiles(w, files)
+	}
+	return nil
+}
+
+func (r *Repo) cmdLogRange(req *bare.Reader, w *bare.Writer) error {
+	var base, head string
+	if err := readStrings(req, &base, &head); err != nil {
+		return err
+	}
+	limit, err := req.ReadUint()
+	if err != nil {
+		return errProtocol
+	}
+	if !isOID(base) {
+		return fail(nil, statusRevparse, base)
+	}
+	if !isOID(head) {
+		return fail(nil, statusRevparse, head)
+	}
+	for _, id := range []string{base, head} {
+		if _, err := r.lookup(id, "commit"); err != nil {
+			return fail(err, statusRevwalk, id)
+		}
+	}
+	_ = w.WriteUint(0)
+	_ = w.WriteUint(uint64(len(r.walk(head, base, 0))))
+	_ = w.WriteUint(uint64(len(r.walk(base, head, 0))))
+	r.writeCommitList(w, r.walk(head, base, limit))
+	return nil
+}
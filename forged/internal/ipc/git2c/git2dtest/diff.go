@@ -0,0 +1,369 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+
+package git2dtest
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.lindenii.runxiyu.org/forge/forged/internal/ipc/git2c"
+)
+
+// diffContext is the number of unchanged lines around each hunk.
+const diffContext = 3
+
+// maxDiffCells bounds the LCS table; larger files are diffed as a wholesale
+// replacement.
+const maxDiffCells = 1 << 22
+
+const eofNLMarker = "\n\\ No newline at end of file\n"
+
+type diffLine struct {
+	op      uint64
+	oldLine uint64
+	newLine uint64
+	content string
+}
+
+type diffHunk struct {
+	oldStart uint64
+	oldLines uint64
+	newStart uint64
+	newLines uint64
+	header   string
+	lines    []diffLine
+}
+
+type fileDiff struct {
+	status     uint64
+	binary     bool
+	similarity uint64
+	fromPath   string
+	toPath     string
+	from       TreeEntry // zero when absent
+	to         TreeEntry // zero when absent
+	additions  uint64
+	deletions  uint64
+	hunks      []diffHunk
+}
+
+// diffTrees compares two trees, either of which may be "" for the empty
+// tree. Only exact renames are detected.
+func (r *Repo) diffTrees(oldTree, newTree string, ignoreWhitespace bool) ([]fileDiff, error) {
+	oldFiles := make(map[string]TreeEntry)
+	newFiles := make(map[string]TreeEntry)
+	if err := r.flatten(oldTree, "", oldFiles); err != nil {
+		return nil, err
+	}
+	if err := r.flatten(newTree, "", newFiles); err != nil {
+		return nil, err
+	}
+
+	var files []fileDiff
+	added := make(map[string]string) // blob ID to path
+	for path, to := range newFiles {
+		from, ok := oldFiles[path]
+		switch {
+		case !ok:
+			added[to.ID] = path
+		case from.ID != to.ID || from.Mode != to.Mode:
+			files = append(files, fileDiff{status: git2c.DeltaModified, fromPath: path, toPath: path, from: from, to: to}) //exhaustruct:ignore
+		}
+	}
+	for path, from := range oldFiles {
+		if _, ok := newFiles[path]; ok {
+			continue
+		}
+		if toPath, ok := added[from.ID]; ok {
+			delete(added, from.ID)
+			files = append(files, fileDiff{status: git2c.DeltaRenamed, similarity: 100, fromPath: path, toPath: toPath, from: from, to: newFiles[toPath]}) //exhaustruct:ignore
+			continue
+		}
+		files = append(files, fileDiff{status: git2c.DeltaDeleted, fromPath: path, toPath: path, from: from}) //exhaustruct:ignore
+	}
+	for _, path := range added {
+		files = append(files, fileDiff{status: git2c.DeltaAdded, fromPath: path, toPath: path, to: newFiles[path]}) //exhaustruct:ignore
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].fromPath < files[j].fromPath })
+
+	for i := range files {
+		f := &files[i]
+		oldContent := r.blobContent(f.from.ID)
+		newContent := r.blobContent(f.to.ID)
+		if isBinary(oldContent) || isBinary(newContent) {
+			f.binary = true
+			continue
+		}
+		f.hunks = diffLines(splitLines(oldContent), splitLines(newContent), ignoreWhitespace)
+		for _, h := range f.hunks {
+			for _, l := range h.lines {
+				switch l.op {
+				case git2c.DiffLineAddition:
+					f.additions++
+				case git2c.DiffLineDeletion:
+					f.deletions++
+				}
+			}
+		}
+	}
+	return files, nil
+}
+
+func (r *Repo) blobContent(id string) []byte {
+	if obj := r.objects[id]; obj != nil && obj.kind == "blob" {
+		return obj.blob
+	}
+	return nil
+}
+
+// isBinary is libgit2's heuristic: a NUL byte in the first 8000 bytes.
+func isBinary(content []byte) bool {
+	return bytes.IndexByte(content[:min(len(content), 8000)], 0) >= 0
+}
+
+// splitLines splits content into lines, keeping their newlines.
+func splitLines(content []byte) []string {
+	var lines []string
+	s := string(content)
+	for s != "" {
+		i := strings.IndexByte(s, '\n')
+		if i < 0 {
+			lines = append(lines, s)
+			break
+		}
+		lines = append(lines, s[:i+1])
+		s = s[i+1:]
+	}
+	return lines
+}
+
+// diffLines computes an LCS-based line diff and groups it into hunks.
+func diffLines(a, b []string, ignoreWhitespace bool) []diffHunk {
+	key := func(s string) string {
+		if ignoreWhitespace {
+			return strings.Join(strings.Fields(s), "")
+		}
+		return s
+	}
+
+	var script []diffLine
+	if (len(a)+1)*(len(b)+1) > maxDiffCells {
+		for i, l := range a {
+			script = append(script, diffLine{op: git2c.DiffLineDeletion, oldLine: uint64(i + 1), content: l}) //exhaustruct:ignore
+		}
+		for j, l := range b {
+			script = append(script, diffLine{op: git2c.DiffLineAddition, newLine: uint64(j + 1), content: l}) //exhaustruct:ignore
+		}
+	} else {
+		// lcs[i][j] is the LCS length of a[i:] and b[j:]
+		lcs := make([][]int, len(a)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(b)+1)
+		}
+		for i := len(a) - 1; i >= 0; i-- {
+			for j := len(b) - 1; j >= 0; j-- {
+				if key(a[i]) == key(b[j]) {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else {
+					lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(a) || j < len(b) {
+			switch {
+			case i < len(a) && j < len(b) && key(a[i]) == key(b[j]):
+				script = append(script, diffLine{op: git2c.DiffLineContext, oldLine: uint64(i + 1), newLine: uint64(j + 1), content: b[j]})
+				i++
+				j++
+			case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+				script = append(script, diffLine{op: git2c.DiffLineDeletion, oldLine: uint64(i + 1), content: a[i]}) //exhaustruct:ignore
+				i++
+			default:
+				script = append(script, diffLine{op: git2c.DiffLineAddition, newLine: uint64(j + 1), content: b[j]}) //exhaustruct:ignore
+				j++
+			}
+		}
+	}
+
+	var hunks []diffHunk
+	for start := 0; start < len(script); {
+		first := start
+		for first < len(script) && script[first].op == git2c.DiffLineContext {
+			first++
+		}
+		if first == len(script) {
+			break
+		}
+		// Extend the hunk while changes are close enough that their
+		// context would overlap.
+		last := first
+		for k := first + 1; k < len(script); k++ {
+			if script[k].op == git2c.DiffLineContext {
+				continue
+			}
+			if k-last > 2*diffContext {
+				break
+			}
+			last = k
+		}
+		lo := max(first-diffContext, start)
+		hi := min(last+1+diffContext, len(script))
+		hunks = append(hunks, makeHunk(script, lo, hi))
+		start = hi
+	}
+	return hunks
+}
+
+func makeHunk(script []diffLine, lo, hi int) diffHunk {
+	// Lines before the hunk on each side
+	var oldBefore, newBefore uint64
+	for _, l := range script[:lo] {
+		if l.op != git2c.DiffLineAddition {
+			oldBefore++
+		}
+		if l.op != git2c.DiffLineDeletion {
+			newBefore++
+		}
+	}
+	var h diffHunk
+	for _, l := range script[lo:hi] {
+		if l.op != git2c.DiffLineAddition {
+			h.oldLines++
+		}
+		if l.op != git2c.DiffLineDeletion {
+			h.newLines++
+		}
+		h.lines = append(h.lines, l)
+		if !strings.HasSuffix(l.content, "\n") {
+			h.lines = append(h.lines, diffLine{op: git2c.DiffLineEOFNL, content: eofNLMarker}) //exhaustruct:ignore
+		}
+	}
+	h.oldStart, h.newStart = oldBefore+1, newBefore+1
+	if h.oldLines == 0 {
+		h.oldStart = oldBefore
+	}
+	if h.newLines == 0 {
+		h.newStart = newBefore
+	}
+	h.header = "@@ -" + hunkRange(h.oldStart, h.oldLines) + " +" + hunkRange(h.newStart, h.newLines) + " @@"
+	return h
+}
+
+func hunkRange(start, lines uint64) string {
+	if lines == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, lines)
+}
+
+func abbrev(id string) string {
+	if id == "" {
+		return "0000000"
+	}
+	return id[:7]
+}
+
+// patchText renders files as a unified diff like git diff.
+func patchText(files []fileDiff) string {
+	var sb strings.Builder
+	for _, f := range files {
+		fmt.Fprintf(&sb, "diff --git a/%s b/%s\n", f.fromPath, f.toPath)
+		switch f.status {
+		case git2c.DeltaAdded:
+			fmt.Fprintf(&sb, "new file mode %o\n", f.to.Mode)
+		case git2c.DeltaDeleted:
+			fmt.Fprintf(&sb, "deleted file mode %o\n", f.from.Mode)
+		case git2c.DeltaRenamed:
+			fmt.Fprintf(&sb, "similarity index %d%%\nrename from %s\nrename to %s\n", f.similarity, f.fromPath, f.toPath)
+		default:
+			if f.from.Mode != f.to.Mode {
+				fmt.Fprintf(&sb, "old mode %o\nnew mode %o\n", f.from.Mode, f.to.Mode)
+			}
+		}
+		if f.from.ID == f.to.ID {
+			continue
+		}
+		fmt.Fprintf(&sb, "index %s..%s", abbrev(f.from.ID), abbrev(f.to.ID))
+		if f.status == git2c.DeltaModified && f.from.Mode == f.to.Mode {
+			fmt.Fprintf(&sb, " %o", f.to.Mode)
+		}
+		sb.WriteString("\n")
+
+		fromName, toName := "a/"+f.fromPath, "b/"+f.toPath
+		if f.status == git2c.DeltaAdded {
+			fromName = "/dev/null"
+		}
+		if f.status == git2c.DeltaDeleted {
+			toName = "/dev/null"
+		}
+		if f.binary {
+			fmt.Fprintf(&sb, "Binary files %s and %s differ\n", fromName, toName)
+			continue
+		}
+		if len(f.hunks) == 0 {
+			continue
+		}
+		fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromName, toName)
+		for _, h := range f.hunks {
+			sb.WriteString(h.header + "\n")
+			for _, l := range h.lines {
+				switch l.op {
+				case git2c.DiffLineAddition:
+					sb.WriteString("+")
+				case git2c.DiffLineDeletion:
+					sb.WriteString("-")
+				case git2c.DiffLineEOFNL:
+					sb.WriteString(strings.TrimPrefix(l.content, "\n"))
+					continue
+				default:
+					sb.WriteString(" ")
+				}
+				sb.WriteString(l.content)
+				if !strings.HasSuffix(l.content, "\n") {
+					sb.WriteString("\n")
+				}
+			}
+		}
+	}
+	return sb.String()
+}
+
+// statText renders a diffstat like git diff --stat.
+func statText(files []fileDiff) string {
+	var sb strings.Builder
+	width := 0
+	for _, f := range files {
+		width = max(width, len(f.toPath))
+	}
+	var additions, deletions uint64
+	for _, f := range files {
+		additions += f.additions
+		deletions += f.deletions
+		if f.binary {
+			fmt.Fprintf(&sb, " %-*s | Bin\n", width, f.toPath)
+			continue
+		}
+		fmt.Fprintf(&sb, " %-*s | %d %s%s\n", width, f.toPath, f.additions+f.deletions,
+			strings.Repeat("+", int(f.additions)), strings.Repeat("-", int(f.deletions)))
+	}
+	fmt.Fprintf(&sb, " %d file%s changed", len(files), plural(uint64(len(files))))
+	if additions > 0 {
+		fmt.Fprintf(&sb, ", %d insertion%s(+)", additions, plural(additions))
+	}
+	if deletions > 0 {
+		fmt.Fprintf(&sb, ", %d deletion%s(-)", deletions, plural(deletions))
+	}
+	sb.WriteString("\n")
+	return sb.String()
+}
+
+func plural(n uint64) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+
+package git2dtest
+
+import (
+	"strings"
+	"time"
+)
+
+// FixtureAuthor authors the commits made by CommitFiles.
+var FixtureAuthor = Signature{
+	Name:  "Fixture Author",
+	Email: "fixture@example.org",
+	When:  time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+}
+
+// CommitFiles commits changes to branch, a short name, on top of its tip or
+// as a root commit if it doesn't exist, and returns the new commit's ID.
+// Files maps slash-separated paths to their new content; nil deletes a
+// file. Each commit is dated an hour after the repository's previous one,
+// starting from FixtureAuthor.When, so that fixtures have stable IDs.
+func (r *Repo) CommitFiles(branch, message string, files map[string][]byte) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ref := "refs/heads/" + branch
+	var parents []string
+	blobs := make(map[string]TreeEntry)
+	if tip := r.refs[ref]; tip != "" {
+		parents = []string{tip}
+		_ = r.flatten(r.objects[tip].commit.Tree, "", blobs)
+	}
+	for path, content := range files {
+		if content == nil {
+			delete(blobs, path)
+			continue
+		}
+		blobs[path] = TreeEntry{Mode: ModeBlob, Name: path, ID: r.writeBlob(content)}
+	}
+
+	r.commits++
+	sig := FixtureAuthor
+	sig.When = sig.When.Add(time.Duration(r.commits) * time.Hour)
+	id := r.writeCommit(Commit{
+		Tree:      r.buildTree(blobs, ""),
+		Parents:   parents,
+		Author:    sig,
+		Committer: sig,
+		Message:   message,
+	})
+	r.refs[ref] = id
+	return id
+}
+
+// buildTree writes the tree for the blobs under prefix, keyed by full path.
+func (r *Repo) buildTree(blobs map[string]TreeEntry, prefix string) string {
+	var entries []TreeEntry
+	subtrees := make(map[string]bool)
+	for path, e := range blobs {
+		rest, ok := strings.CutPrefix(path, prefix)
+		if !ok {
+			continue
+		}
+		if dir, _, nested := strings.Cut(rest, "/"); nested {
+			if !subtrees[dir] {
+				subtrees[dir] = true
+				entries = append(entries, TreeEntry{Mode: ModeTree, Name: dir, ID: r.buildTree(blobs, prefix+dir+"/")})
+			}
+			continue
+		}
+		entries = append(entries, TreeEntry{Mode: e.Mode, Name: rest, ID: e.ID})
+	}
+	return r.writeTree(entries)
+}
+
+// Fixture returns a small repository with some history to browse:
+//
+//   - master has four commits, touching a README, Go sources in a
+//     subdirectory, a binary file and a file without a trailing newline,
+//     and renaming one file;
+//   - feature branches off master's second commit and adds a commit;
+//   - the tag v0.1 points at master's second commit.
+func Fixture() *Repo {
+	r := NewRepo()
+	r.CommitFiles("master", "Initial commit\n", map[string][]byte{
+		"README.md": []byte("# Example\n\nAn example repository.\n"),
+		"LICENSE":   []byte("Public domain\n"),
+	})
+	second := r.CommitFiles("master", "Add the program\n\nIt prints a greeting.\n", map[string][]byte{
+		"cmd/hello/main.go": []byte("package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"hello\")\n}\n"),
+		"logo.png":          []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"),
+	})
+	r.CommitFiles("master", "Greet the world\n", map[string][]byte{
+		"cmd/hello/main.go": []byte("package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"hello, world\")\n}\n"),
+		"README.md":         []byte("# Example\n\nAn example repository.\n\nRun `go run ./cmd/hello`.\n"),
+		"notes.txt":         []byte("no trailing newline"),
+	})
+	r.CommitFiles("master", "Rename LICENSE to COPYING\n", map[string][]byte{
+		"LICENSE": nil,
+		"COPYING": []byte("Public domain\n"),
+	})
+	r.SetRef("refs/tags/v0.1", second)
+	r.SetRef("refs/heads/feature", second)
+	r.CommitFiles("feature", "Add a feature\n", map[string][]byte{
+		"feature.go": []byte("package main\n\nconst feature = true\n"),
+	})
+	return r
+}
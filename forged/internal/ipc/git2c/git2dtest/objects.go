@@ -0,0 +1,284 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+
+package git2dtest
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// File modes of tree entries.
+const (
+	ModeTree    uint32 = 0o040000
+	ModeBlob    uint32 = 0o100644
+	ModeExec    uint32 = 0o100755
+	ModeSymlink uint32 = 0o120000
+)
+
+type Signature struct {
+	Name  string
+	Email string
+	When  time.Time
+}
+
+type TreeEntry struct {
+	Mode uint32
+	Name string
+	ID   string // hex
+}
+
+type Commit struct {
+	Tree      string   // hex
+	Parents   []string // hex
+	Author    Signature
+	Committer Signature
+	Message   string
+}
+
+type object struct {
+	kind   string // "blob", "tree" or "commit"
+	blob   []byte
+	tree   []TreeEntry // in git's order
+	commit *Commit
+}
+
+// Repo is an in-memory repository. Object IDs are the same as git would
+// compute for the same content, so IDs from real repositories may be used in
+// fixtures.
+type Repo struct {
+	mu      sync.Mutex
+	objects map[string]*object
+	refs    map[string]string // full name to commit ID
+	head    string            // full name of the branch HEAD points to
+	commits int               // made by CommitFiles
+	pack    []byte            // received by cmdImportChunk
+}
+
+// NewRepo returns an empty repository whose HEAD points to master.
+func NewRepo() *Repo {
+	return &Repo{
+		objects: make(map[string]*object),
+		refs:    make(map[string]string),
+		head:    "refs/heads/master",
+	}
+}
+
+func hashObject(kind string, body []byte) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s %d\x00", kind, len(body))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// WriteBlob stores content and returns its ID.
+func (r *Repo) WriteBlob(content []byte) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.writeBlob(content)
+}
+
+func (r *Repo) writeBlob(content []byte) string {
+	id := hashObject("blob", content)
+	r.objects[id] = &object{kind: "blob", blob: bytes.Clone(content)} //exhaustruct:ignore
+	return id
+}
+
+// treeSortKey orders tree entries like git, which compares subtrees as if
+// their names ended in a slash.
+func treeSortKey(e TreeEntry) string {
+	if e.Mode == ModeTree {
+		return e.Name + "/"
+	}
+	return e.Name
+}
+
+// WriteTree stores a tree with the given entries, in any order, and returns
+// its ID. The objects the entries refer to need not exist.
+func (r *Repo) WriteTree(entries []TreeEntry) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.writeTree(entries)
+}
+
+func (r *Repo) writeTree(entries []TreeEntry) string {
+	sorted := append([]TreeEntry(nil), entries...)
+	sort.Slice(sorted, func(i, j int) bool { return treeSortKey(sorted[i]) < treeSortKey(sorted[j]) })
+	id := hashObject("tree", treeBody(sorted))
+	r.objects[id] = &object{kind: "tree", tree: sorted} //exhaustruct:ignore
+	return id
+}
+
+func treeBody(sorted []TreeEntry) []byte {
+	var body bytes.Buffer
+	for _, e := range sorted {
+		raw, _ := hex.DecodeString(e.ID)
+		fmt.Fprintf(&body, "%o %s\x00", e.Mode, e.Name)
+		body.Write(raw)
+	}
+	return body.Bytes()
+}
+
+func formatSignature(s Signature) string {
+	_, offset := s.When.Zone()
+	sign := '+'
+	if offset < 0 {
+		sign = '-'
+		offset = -offset
+	}
+	return fmt.Sprintf("%s <%s> %d %c%02d%02d", s.Name, s.Email, s.When.Unix(), sign, offset/3600, offset/60%60)
+}
+
+// WriteCommit stores c and returns its ID. A zero committer is taken to be
+// the author.
+func (r *Repo) WriteCommit(c Commit) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.writeCommit(c)
+}
+
+func (r *Repo) writeCommit(c Commit) string {
+	if c.Committer == (Signature{}) {
+		c.Committer = c.Author
+	}
+	c.Parents = append([]string(nil), c.Parents...)
+	id := hashObject("commit", commitBody(&c))
+	r.objects[id] = &object{kind: "commit", commit: &c} //exhaustruct:ignore
+	return id
+}
+
+func commitBody(c *Commit) []byte {
+	var body strings.Builder
+	fmt.Fprintf(&body, "tree %s\n", c.Tree)
+	for _, p := range c.Parents {
+		fmt.Fprintf(&body, "parent %s\n", p)
+	}
+	fmt.Fprintf(&body, "author %s\n", formatSignature(c.Author))
+	fmt.Fprintf(&body, "committer %s\n", formatSignature(c.Committer))
+	fmt.Fprintf(&body, "\n%s", c.Message)
+	return []byte(body.String())
+}
+
+// body returns an object's content as git stores it.
+func (o *object) body() []byte {
+	switch o.kind {
+	case "tree":
+		return treeBody(o.tree)
+	case "commit":
+		return commitBody(o.commit)
+	default:
+		return o.blob
+	}
+}
+
+// SetRef points the reference with the given full name, such as
+// refs/heads/master, at a commit.
+func (r *Repo) SetRef(name, id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.refs[name] = id
+}
+
+// Ref returns the commit a reference points to, or "" if it doesn't exist.
+func (r *Repo) Ref(name string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.refs[name]
+}
+
+// SetHead points HEAD at the branch with the given full name.
+func (r *Repo) SetHead(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.head = name
+}
+
+// Blob returns the content of a blob, or false if there is no such blob.
+func (r *Repo) Blob(id string) ([]byte, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	obj := r.objects[id]
+	if obj == nil || obj.kind != "blob" {
+		return nil, false
+	}
+	return bytes.Clone(obj.blob), true
+}
+
+// Commit returns a commit, or false if there is no such commit.
+func (r *Repo) Commit(id string) (Commit, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	obj := r.objects[id]
+	if obj == nil || obj.kind != "commit" {
+		return Commit{}, false
+	}
+	return *obj.commit, true
+}
+
+func (r *Repo) lookup(id, kind string) (*object, error) {
+	obj := r.objects[id]
+	if obj == nil {
+		return nil, &cmdError{class: errorClassODB, message: "object not found - no match for id (" + id + ")"} //exhaustruct:ignore
+	}
+	if obj.kind != kind {
+		return nil, &cmdError{class: errorClassInvalid, message: "the requested type does not match the type in the ODB"} //exhaustruct:ignore
+	}
+	return obj, nil
+}
+
+// treeEntry looks up a slash-separated path in a tree.
+func (r *Repo) treeEntry(treeID, path string) (TreeEntry, error) {
+	notFound := &cmdError{class: errorClassTree, message: "the path '" + path + "' does not exist in the given tree"} //exhaustruct:ignore
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	for i, part := range parts {
+		tree, err := r.lookup(treeID, "tree")
+		if err != nil {
+			return TreeEntry{}, err
+		}
+		var found *TreeEntry
+		for j := range tree.tree {
+			if tree.tree[j].Name == part {
+				found = &tree.tree[j]
+				break
+			}
+		}
+		if found == nil {
+			return TreeEntry{}, notFound
+		}
+		if i == len(parts)-1 {
+			return *found, nil
+		}
+		if found.Mode != ModeTree {
+			return TreeEntry{}, notFound
+		}
+		treeID = found.ID
+	}
+	return TreeEntry{}, notFound
+}
+
+// flatten lists the blobs in a tree and its subtrees by path.
+func (r *Repo) flatten(treeID, prefix string, out map[string]TreeEntry) error {
+	if treeID == "" {
+		return nil
+	}
+	tree, err := r.lookup(treeID, "tree")
+	if err != nil {
+		return err
+	}
+	for _, e := range tree.tree {
+		if e.Mode == ModeTree {
+			if err := r.flatten(e.ID, prefix+e.Name+"/", out); err != nil {
+				return err
+			}
+			continue
+		}
+		out[prefix+e.Name] = e
+	}
+	return nil
+}
@@ -0,0 +1,326 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+
+package git2dtest
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.lindenii.runxiyu.org/forge/forged/internal/common/bare"
+)
+
+// Pack object types
+var packTypes = map[string]byte{"commit": 1, "tree": 2, "blob": 3}
+
+var errPackDelta = errors.New("deltified pack objects are not supported")
+
+// reachable adds id and the objects reachable from it to set, except for
+// those in stop.
+func (r *Repo) reachable(id string, set, stop map[string]bool) {
+	if id == "" || set[id] || stop[id] {
+		return
+	}
+	obj := r.objects[id]
+	if obj == nil {
+		return
+	}
+	set[id] = true
+	switch obj.kind {
+	case "commit":
+		r.reachable(obj.commit.Tree, set, stop)
+		for _, p := range obj.commit.Parents {
+			r.reachable(p, set, stop)
+		}
+	case "tree":
+		for _, e := range obj.tree {
+			r.reachable(e.ID, set, stop)
+		}
+	}
+}
+
+// writePack encodes the objects in a version 2 packfile without deltas.
+func (r *Repo) writePack(ids []string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("PACK")
+	_ = binary.Write(&buf, binary.BigEndian, uint32(2))
+	_ = binary.Write(&buf, binary.BigEndian, uint32(len(ids)))
+	for _, id := range ids {
+		obj := r.objects[id]
+		body := obj.body()
+		size := len(body)
+		b := packTypes[obj.kind]<<4 | byte(size&0x0f)
+		for size >>= 4; size > 0; size >>= 7 {
+			buf.WriteByte(b | 0x80)
+			b = byte(size & 0x7f)
+		}
+		buf.WriteByte(b)
+		zw := zlib.NewWriter(&buf)
+		_, _ = zw.Write(body)
+		_ = zw.Close()
+	}
+	sum := sha1.Sum(buf.Bytes())
+	buf.Write(sum[:])
+	return buf.Bytes()
+}
+
+// readPack stores the objects in a packfile written by writePack or by
+// git without deltas.
+func (r *Repo) readPack(pack []byte) error {
+	if len(pack) < 32 || string(pack[:4]) != "PACK" {
+		return errors.New("not a packfile")
+	}
+	content, trailer := pack[:len(pack)-sha1.Size], pack[len(pack)-sha1.Size:]
+	if sum := sha1.Sum(content); !bytes.Equal(sum[:], trailer) {
+		return errors.New("pack checksum mismatch")
+	}
+	count := binary.BigEndian.Uint32(content[8:12])
+	rd := bytes.NewReader(content[12:])
+	for range count {
+		b, err := rd.ReadByte()
+		if err != nil {
+			return err
+		}
+		typ := b >> 4 & 0x07
+		// The size isn't needed, as zlib streams end by themselves.
+		for b&0x80 != 0 {
+			if b, err = rd.ReadByte(); err != nil {
+				return err
+			}
+		}
+		var kind string
+		for k, t := range packTypes {
+			if t == typ {
+				kind = k
+			}
+		}
+		if kind == "" {
+			return errPackDelta
+		}
+		zr, err := zlib.NewReader(rd)
+		if err != nil {
+			return err
+		}
+		body, err := io.ReadAll(zr)
+		if err != nil {
+			return err
+		}
+		if err := r.storeRaw(kind, body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storeRaw parses an object as git stores it and adds it to the
+// repository, failing if it would not get the same ID back.
+func (r *Repo) storeRaw(kind string, body []byte) error {
+	want := hashObject(kind, body)
+	var got string
+	switch kind {
+	case "blob":
+		got = r.writeBlob(body)
+	case "tree":
+		var entries []TreeEntry
+		for rest := body; len(rest) > 0; {
+			sp := bytes.IndexByte(rest, ' ')
+			nul := bytes.IndexByte(rest, 0)
+			if sp < 0 || nul < sp || len(rest) < nul+21 {
+				return errors.New("malformed tree")
+			}
+			mode, err := strconv.ParseUint(string(rest[:sp]), 8, 32)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, TreeEntry{Mode: uint32(mode), Name: string(rest[sp+1 : nul]), ID: hex.EncodeToString(rest[nul+1 : nul+21])})
+			rest = rest[nul+21:]
+		}
+		got = r.writeTree(entries)
+	case "commit":
+		c, err := parseCommit(string(body))
+		if err != nil {
+			return err
+		}
+		got = r.writeCommit(c)
+	}
+	if got != want {
+		delete(r.objects, got)
+		return fmt.Errorf("%s %s has headers the fake cannot represent", kind, want)
+	}
+	return nil
+}
+
+func parseCommit(body string) (Commit, error) {
+	var c Commit
+	headers, message, ok := strings.Cut(body, "\n\n")
+	if !ok {
+		return c, errors.New("malformed commit")
+	}
+	c.Message = message
+	for _, line := range strings.Split(headers, "\n") {
+		key, value, _ := strings.Cut(line, " ")
+		var err error
+		switch key {
+		case "tree":
+			c.Tree = value
+		case "parent":
+			c.Parents = append(c.Parents, value)
+		case "author":
+			c.Author, err = parseSignature(value)
+		case "committer":
+			c.Committer, err = parseSignature(value)
+		}
+		if err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+func parseSignature(s string) (Signature, error) {
+	lt := strings.LastIndexByte(s, '<')
+	gt := strings.LastIndexByte(s, '>')
+	if lt < 1 || gt < lt {
+		return Signature{}, errors.New("malformed signature")
+	}
+	fields := strings.Fields(s[gt+1:])
+	if len(fields) != 2 || len(fields[1]) != 5 {
+		return Signature{}, errors.New("malformed signature date")
+	}
+	unix, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return Signature{}, err
+	}
+	hours, _ := strconv.Atoi(fields[1][1:3])
+	minutes, _ := strconv.Atoi(fields[1][3:5])
+	offset := hours*3600 + minutes*60
+	if fields[1][0] == '-' {
+		offset = -offset
+	}
+	return Signature{
+		Name:  s[:lt-1],
+		Email: s[lt+1 : gt],
+		When:  time.Unix(unix, 0).In(time.FixedZone("", offset)),
+	}, nil
+}
+
+func (r *Repo) sortedRefs() []string {
+	names := make([]string, 0, len(r.refs))
+	for name := range r.refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *Repo) cmdPack(req *bare.Reader, w *bare.Writer) error {
+	n, err := req.ReadUint()
+	if err != nil {
+		return errProtocol
+	}
+	have := make(map[string]bool)
+	for range n {
+		raw, err := req.ReadData()
+		if err != nil {
+			return errProtocol
+		}
+		r.reachable(hex.EncodeToString(raw), have, nil)
+	}
+
+	names := r.sortedRefs()
+	want := make(map[string]bool)
+	for _, name := range names {
+		r.reachable(r.refs[name], want, have)
+	}
+	ids := make([]string, 0, len(want))
+	for id := range want {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	_ = w.WriteUint(0)
+	_ = w.WriteData([]byte(r.head))
+	_ = w.WriteUint(uint64(len(names)))
+	for _, name := range names {
+		_ = w.WriteData([]byte(name))
+		writeOID(w, r.refs[name])
+	}
+	for pack := r.writePack(ids); len(pack) > 0; {
+		chunk := min(len(pack), frameSize)
+		_ = w.WriteData(pack[:chunk])
+		pack = pack[chunk:]
+	}
+	_ = w.WriteData(nil)
+	return nil
+}
+
+func (r *Repo) cmdImportChunk(req *bare.Reader, w *bare.Writer) error {
+	offset, err := req.ReadUint()
+	if err != nil {
+		return errProtocol
+	}
+	chunk, err := req.ReadData()
+	if err != nil {
+		return errProtocol
+	}
+	if offset == 0 {
+		r.pack = nil
+	}
+	if offset != uint64(len(r.pack)) {
+		return &cmdError{status: statusImport, message: "pack chunk out of order"} //exhaustruct:ignore
+	}
+	r.pack = append(r.pack, chunk...)
+	_ = w.WriteUint(0)
+	return nil
+}
+
+func (r *Repo) cmdImportFinish(req *bare.Reader, w *bare.Writer) error {
+	var head string
+	if err := readStrings(req, &head); err != nil {
+		return err
+	}
+	n, err := req.ReadUint()
+	if err != nil {
+		return errProtocol
+	}
+	refs := make(map[string]string, n)
+	for range n {
+		var name string
+		if err := readStrings(req, &name); err != nil {
+			return err
+		}
+		raw, err := req.ReadData()
+		if err != nil {
+			return errProtocol
+		}
+		refs[name] = hex.EncodeToString(raw)
+	}
+
+	if r.pack != nil {
+		if err := r.readPack(r.pack); err != nil {
+			return &cmdError{status: statusImport, message: err.Error()} //exhaustruct:ignore
+		}
+		r.pack = nil
+	}
+	for name, id := range refs {
+		if _, err := r.lookup(id, "commit"); err != nil {
+			return fail(err, statusImport, name)
+		}
+	}
+	r.refs = refs
+	if head != "" {
+		r.head = head
+	}
+	_ = w.WriteUint(0)
+	return nil
+}
@@ -0,0 +1,195 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+
+package git2dtest
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// revparse resolves the subset of gitrevisions(7) that forged uses: HEAD,
+// full and abbreviated object IDs, full reference names and branch and tag
+// names, each followed by any number of ~<n>, ^<n> and ^{<type>}.
+func (r *Repo) revparse(spec string) (string, error) {
+	notFound := &cmdError{class: errorClassReference, message: "revspec '" + spec + "' not found"} //exhaustruct:ignore
+
+	name, suffix := spec, ""
+	if i := strings.IndexAny(spec, "~^"); i >= 0 {
+		name, suffix = spec[:i], spec[i:]
+	}
+	id := r.resolveName(name)
+	if id == "" {
+		return "", notFound
+	}
+
+	for suffix != "" {
+		op := suffix[0]
+		suffix = suffix[1:]
+		if op == '^' && strings.HasPrefix(suffix, "{") {
+			end := strings.IndexByte(suffix, '}')
+			if end < 0 {
+				return "", notFound
+			}
+			kind := suffix[1:end]
+			suffix = suffix[end+1:]
+			if kind == "" {
+				kind = "commit" // there are no tag objects to peel
+			}
+			peeled, err := r.peel(id, kind)
+			if err != nil {
+				return "", err
+			}
+			id = peeled
+			continue
+		}
+
+		digits := len(suffix) - len(strings.TrimLeft(suffix, "0123456789"))
+		n := 1
+		if digits > 0 {
+			n, _ = strconv.Atoi(suffix[:digits])
+			suffix = suffix[digits:]
+		}
+		obj, err := r.lookup(id, "commit")
+		if err != nil {
+			return "", notFound
+		}
+		switch {
+		case op == '^' && n == 0:
+		case op == '^':
+			if n > len(obj.commit.Parents) {
+				return "", notFound
+			}
+			id = obj.commit.Parents[n-1]
+		default:
+			for range n {
+				obj, err := r.lookup(id, "commit")
+				if err != nil || len(obj.commit.Parents) == 0 {
+					return "", notFound
+				}
+				id = obj.commit.Parents[0]
+			}
+		}
+	}
+	return id, nil
+}
+
+func (r *Repo) resolveName(name string) string {
+	if name == "HEAD" {
+		return r.refs[r.head]
+	}
+	for _, ref := range []string{name, "refs/" + name, "refs/tags/" + name, "refs/heads/" + name} {
+		if strings.HasPrefix(ref, "refs/") {
+			if id, ok := r.refs[ref]; ok {
+				return id
+			}
+		}
+	}
+	if len(name) < 4 || len(name) > 40 || strings.Trim(name, "0123456789abcdef") != "" {
+		return ""
+	}
+	var match string
+	for id := range r.objects {
+		if strings.HasPrefix(id, name) {
+			if match != "" {
+				return "" // ambiguous
+			}
+			match = id
+		}
+	}
+	return match
+}
+
+// peel follows commits to their trees until reaching an object of the
+// given kind.
+func (r *Repo) peel(id, kind string) (string, error) {
+	obj := r.objects[id]
+	if obj == nil {
+		return "", &cmdError{class: errorClassODB, message: "object not found - no match for id (" + id + ")"} //exhaustruct:ignore
+	}
+	if obj.kind == kind {
+		return id, nil
+	}
+	if obj.kind == "commit" && kind == "tree" {
+		return obj.commit.Tree, nil
+	}
+	return "", &cmdError{class: errorClassObject, message: "the git_object of id '" + id + "' can not be successfully peeled into a " + kind} //exhaustruct:ignore
+}
+
+// ancestors returns the commits reachable from tips, including the tips.
+func (r *Repo) ancestors(tips ...string) map[string]bool {
+	seen := make(map[string]bool)
+	stack := append([]string(nil), tips...)
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if id == "" || seen[id] {
+			continue
+		}
+		obj := r.objects[id]
+		if obj == nil || obj.kind != "commit" {
+			continue
+		}
+		seen[id] = true
+		stack = append(stack, obj.commit.Parents...)
+	}
+	return seen
+}
+
+// walk lists the commits reachable from head but not from hide, newest
+// first by commit time like GIT_SORT_TIME, stopping after limit commits
+// unless it is 0.
+func (r *Repo) walk(head, hide string, limit uint64) []string {
+	reachable := r.ancestors(head)
+	if hide != "" {
+		for id := range r.ancestors(hide) {
+			delete(reachable, id)
+		}
+	}
+	ids := make([]string, 0, len(reachable))
+	for id := range reachable {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		ti := r.objects[ids[i]].commit.Committer.When
+		tj := r.objects[ids[j]].commit.Committer.When
+		if !ti.Equal(tj) {
+			return ti.After(tj)
+		}
+		return ids[i] < ids[j]
+	})
+	if limit != 0 && uint64(len(ids)) > limit {
+		ids = ids[:limit]
+	}
+	return ids
+}
+
+// mergeBase returns the newest common ancestor of a and b that is not an
+// ancestor of another common ancestor, or "" if there is none.
+func (r *Repo) mergeBase(a, b string) string {
+	fromA := r.ancestors(a)
+	var common []string
+	for id := range r.ancestors(b) {
+		if fromA[id] {
+			common = append(common, id)
+		}
+	}
+	best := ""
+	for _, id := range common {
+		redundant := false
+		for _, other := range common {
+			if other != id && r.ancestors(other)[id] {
+				redundant = true
+				break
+			}
+		}
+		if redundant {
+			continue
+		}
+		if best == "" || r.objects[id].commit.Committer.When.After(r.objects[best].commit.Committer.When) {
+			best = id
+		}
+	}
+	return best
+}
@@ -0,0 +1,336 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+
+// Package git2dtest provides a fake git2d, speaking the same protocol over a
+// UNIX socket but backed by in-memory repositories, so that git2c and the
+// handlers built on it can be exercised without libgit2.
+//
+// It implements every command, but it is not libgit2: diffs use a plain LCS
+// line diff and only detect exact renames, and revisions are limited to what
+// forged itself asks for. Handlers also need PostgreSQL to find repositories,
+// which this package does not replace.
+package git2dtest
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"go.lindenii.runxiyu.org/forge/forged/internal/common/bare"
+)
+
+// protocolVersion must match PROTOCOL_VERSION in git2d/x.h.
+const protocolVersion = 5
+
+// Frame kinds; see git2d/x.h.
+const (
+	frameRequest = 0
+	frameCancel  = 1
+	frameCredit  = 2
+	frameData    = 0
+	frameEnd     = 1
+)
+
+// frameSize is the largest data frame sent, like FRAME_BUFFER_SIZE.
+const frameSize = 64 * 1024
+
+// replyWindow is how much of a reply may be sent ahead of the client's
+// credit, like REPLY_WINDOW.
+const replyWindow = 1 << 20
+
+// window is how much more of a reply the client will accept.
+type window struct {
+	mu        sync.Mutex
+	more      *sync.Cond
+	avail     uint64
+	cancelled bool
+}
+
+func newWindow() *window {
+	w := &window{avail: replyWindow} //exhaustruct:ignore
+	w.more = sync.NewCond(&w.mu)
+	return w
+}
+
+// reserve waits until n more bytes may be sent and takes them, or returns
+// false if the request is cancelled first.
+func (w *window) reserve(n uint64) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for w.avail < n && !w.cancelled {
+		w.more.Wait()
+	}
+	if w.cancelled {
+		return false
+	}
+	w.avail -= n
+	return true
+}
+
+func (w *window) credit(n uint64) {
+	w.mu.Lock()
+	w.avail += n
+	w.mu.Unlock()
+	w.more.Broadcast()
+}
+
+func (w *window) cancel() {
+	w.mu.Lock()
+	w.cancelled = true
+	w.mu.Unlock()
+	w.more.Broadcast()
+}
+
+// Server is a fake git2d listening on a UNIX socket in a temporary
+// directory.
+type Server struct {
+	dir      string
+	listener net.Listener
+
+	mu    sync.Mutex
+	repos map[string]*Repo
+	conns map[net.Conn]struct{}
+
+	wg sync.WaitGroup
+}
+
+// NewServer starts a server with no repositories.
+func NewServer() (*Server, error) {
+	dir, err := os.MkdirTemp("", "git2dtest")
+	if err != nil {
+		return nil, fmt.Errorf("create socket directory: %w", err)
+	}
+	listener, err := net.Listen("unix", filepath.Join(dir, "git2d.sock"))
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+	s := &Server{
+		dir:      dir,
+		listener: listener,
+		repos:    make(map[string]*Repo),
+		conns:    make(map[net.Conn]struct{}),
+	} //exhaustruct:ignore
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// SocketPath is the path to pass to git2c.
+func (s *Server) SocketPath() string {
+	return s.listener.Addr().String()
+}
+
+// AddRepo serves repo at path, which is what forged passes as the repository
+// path, replacing any repository there.
+func (s *Server) AddRepo(path string, repo *Repo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.repos[path] = repo
+}
+
+// Repo returns the repository at path, including those created by InitRepo,
+// or nil.
+func (s *Server) Repo(path string) *Repo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.repos[path]
+}
+
+// Close stops the server, closes its connections and waits for their
+// requests to finish.
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	if rerr := os.RemoveAll(s.dir); rerr != nil && err == nil {
+		err = rerr
+	}
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.session(conn)
+	}
+}
+
+// session handles one connection like git2d's session(): a version
+// handshake, then request frames, each handled concurrently and replied to
+// as the client grants credit.
+func (s *Server) session(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		_ = conn.Close()
+	}()
+
+	reader := bare.NewReader(bufio.NewReader(conn))
+	var wmu sync.Mutex
+	writer := bare.NewWriter(conn)
+
+	version, err := reader.ReadUint()
+	if err != nil {
+		return
+	}
+	if err := writer.WriteUint(protocolVersion); err != nil || version != protocolVersion {
+		return
+	}
+
+	var requests sync.WaitGroup
+	defer requests.Wait()
+	var windowsMu sync.Mutex
+	windows := make(map[uint64]*window)
+	defer func() {
+		windowsMu.Lock()
+		defer windowsMu.Unlock()
+		for _, w := range windows {
+			w.cancel()
+		}
+	}()
+	for {
+		id, err := reader.ReadUint()
+		if err != nil {
+			return
+		}
+		kind, err := reader.ReadUint()
+		if err != nil {
+			return
+		}
+		payload, err := reader.ReadData()
+		if err != nil {
+			return
+		}
+		windowsMu.Lock()
+		w := windows[id]
+		if kind == frameRequest && w == nil {
+			w = newWindow()
+			windows[id] = w
+		}
+		windowsMu.Unlock()
+		switch {
+		case kind == frameCancel && w != nil:
+			w.cancel()
+			continue
+		case kind == frameCredit && w != nil:
+			if n, err := bare.NewReader(bytes.NewReader(payload)).ReadUint(); err == nil {
+				w.credit(n)
+			}
+			continue
+		case kind != frameRequest:
+			continue
+		}
+
+		requests.Add(1)
+		go func() {
+			defer requests.Done()
+			defer func() {
+				windowsMu.Lock()
+				delete(windows, id)
+				windowsMu.Unlock()
+			}()
+			var reply bytes.Buffer
+			s.handle(bare.NewReader(bytes.NewReader(payload)), bare.NewWriter(&reply))
+
+			for data := reply.Bytes(); len(data) > 0; {
+				n := min(len(data), frameSize)
+				if !w.reserve(uint64(n)) {
+					break
+				}
+				wmu.Lock()
+				err := writeFrame(writer, id, frameData, data[:n])
+				wmu.Unlock()
+				if err != nil {
+					return
+				}
+				data = data[n:]
+			}
+			wmu.Lock()
+			_ = writeFrame(writer, id, frameEnd, nil)
+			wmu.Unlock()
+		}()
+	}
+}
+
+func writeFrame(w *bare.Writer, id, kind uint64, payload []byte) error {
+	if err := w.WriteUint(id); err != nil {
+		return err
+	}
+	if err := w.WriteUint(kind); err != nil {
+		return err
+	}
+	return w.WriteData(payload)
+}
+
+// handle decodes the request envelope and dispatches it.
+func (s *Server) handle(req *bare.Reader, w *bare.Writer) {
+	path, err := req.ReadData()
+	if err != nil {
+		writeError(w, &cmdError{status: statusEnvelope}) //exhaustruct:ignore
+		return
+	}
+	cmd, err := req.ReadUint()
+	if err != nil {
+		writeError(w, &cmdError{status: statusEnvelope}) //exhaustruct:ignore
+		return
+	}
+
+	switch cmd {
+	case cmdPing:
+		_ = w.WriteUint(0)
+		return
+	case cmdInitRepo:
+		if _, err := req.ReadData(); err != nil {
+			writeError(w, errProtocol)
+			return
+		}
+		s.mu.Lock()
+		if s.repos[string(path)] == nil {
+			s.repos[string(path)] = NewRepo()
+		}
+		s.mu.Unlock()
+		_ = w.WriteUint(0)
+		return
+	}
+
+	repo := s.Repo(string(path))
+	if repo == nil {
+		writeError(w, &cmdError{status: statusRepoOpen, class: errorClassOS, message: "failed to resolve path '" + string(path) + "': No such file or directory", input: string(path)})
+		return
+	}
+	handler, ok := commands[cmd]
+	if !ok {
+		writeError(w, &cmdError{status: statusUnknownCommand}) //exhaustruct:ignore
+		return
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if err := handler(repo, req, w); err != nil {
+		var cerr *cmdError
+		if !errors.As(err, &cerr) {
+			cerr = &cmdError{status: statusProtocol, message: err.Error()} //exhaustruct:ignore
+		}
+		writeError(w, cerr)
+	}
+}
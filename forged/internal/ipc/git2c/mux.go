@@ -0,0 +1,414 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+
+package git2c
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"go.lindenii.runxiyu.org/forge/forged/internal/common/bare"
+)
+
+// Frame kinds; see git2d/x.h. Requests are sent whole in a single frame,
+// while replies arrive as any number of data frames ended by an end frame.
+const (
+	frameRequest = 0
+	frameCancel  = 1
+	frameCredit  = 2
+	frameData    = 0
+	frameEnd     = 1
+)
+
+var (
+	errConnClosed = errors.New("git2c: connection closed")
+	errAbandoned  = errors.New("git2c: request abandoned")
+	errOverrun    = errors.New("git2c: git2d overran a reply window")
+)
+
+// replyWindow is how many bytes of a request's reply git2d may send before
+// it is granted more, and so how much of it may wait to be read; it must
+// match REPLY_WINDOW in git2d/x.h.
+const replyWindow = 1 << 20
+
+// controlTimeout bounds how long sending a cancel or credit frame may
+// take, since the context of the request concerned may already be done.
+const controlTimeout = 5 * time.Second
+
+// muxConn is a connection to git2d that carries several requests at once,
+// telling their replies apart by request ID.
+type muxConn struct {
+	conn net.Conn
+
+	wmu    sync.Mutex
+	bufw   *bufio.Writer
+	writer *bare.Writer
+
+	mu       sync.Mutex
+	nextID   uint64
+	requests map[uint64]*request
+	err      error // set once the connection has failed
+
+	// Bookkeeping for Pool, protected by the pool's mutex.
+	users    int
+	lastUsed time.Time
+}
+
+func newMuxConn(conn net.Conn) *muxConn {
+	bufw := bufio.NewWriter(conn)
+	m := &muxConn{
+		conn:     conn,
+		bufw:     bufw,
+		writer:   bare.NewWriter(bufw),
+		requests: make(map[uint64]*request),
+		lastUsed: time.Now(),
+	} //exhaustruct:ignore
+	go m.demux()
+	return m
+}
+
+// demux routes reply frames to their requests until the connection fails.
+// Frames for requests that have been abandoned are dropped. It never waits
+// for readers: git2d holds back replies that aren't being read, as their
+// windows run out.
+func (m *muxConn) demux() {
+	reader := bare.NewReader(bufio.NewReader(m.conn))
+	for {
+		id, err := reader.ReadUint()
+		if err != nil {
+			m.fail(err)
+			return
+		}
+		kind, err := reader.ReadUint()
+		if err != nil {
+			m.fail(err)
+			return
+		}
+		payload, err := reader.ReadData()
+		if err != nil {
+			m.fail(err)
+			return
+		}
+
+		m.mu.Lock()
+		req := m.requests[id]
+		if kind == frameEnd {
+			delete(m.requests, id)
+		}
+		m.mu.Unlock()
+		if req == nil {
+			continue
+		}
+
+		switch kind {
+		case frameData:
+			if err := req.push(payload); err != nil {
+				m.fail(err)
+				return
+			}
+		case frameEnd:
+			req.finish(nil)
+		}
+	}
+}
+
+// fail marks the connection as broken and fails all outstanding requests.
+func (m *muxConn) fail(err error) {
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		err = errConnClosed
+	}
+	m.mu.Lock()
+	if m.err == nil {
+		m.err = err
+	}
+	requests := m.requests
+	m.requests = make(map[uint64]*request)
+	m.mu.Unlock()
+
+	for _, req := range requests {
+		req.finish(err)
+	}
+	_ = m.conn.Close()
+}
+
+// broken reports whether the connection has failed.
+func (m *muxConn) broken() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err != nil
+}
+
+// writeFrame writes a frame, giving up at ctx's deadline.
+func (m *muxConn) writeFrame(ctx context.Context, id, kind uint64, payload []byte) error {
+	m.wmu.Lock()
+	defer m.wmu.Unlock()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = m.conn.SetWriteDeadline(deadline)
+		defer func() { _ = m.conn.SetWriteDeadline(time.Time{}) }()
+	}
+	if err := m.writer.WriteUint(id); err != nil {
+		return err
+	}
+	if err := m.writer.WriteUint(kind); err != nil {
+		return err
+	}
+	if err := m.writer.WriteData(payload); err != nil {
+		return err
+	}
+	return m.bufw.Flush()
+}
+
+// send starts a request with the given serialized payload.
+func (m *muxConn) send(ctx context.Context, payload []byte) (*request, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	if m.err != nil {
+		err := m.err
+		m.mu.Unlock()
+		return nil, err
+	}
+	m.nextID++
+	req := newRequest(m.nextID)
+	m.requests[req.id] = req
+	m.mu.Unlock()
+
+	if err := m.writeFrame(ctx, req.id, frameRequest, payload); err != nil {
+		// A partially written frame leaves the stream unusable
+		m.fail(err)
+		return nil, fmt.Errorf("sending request failed: %w", err)
+	}
+	return req, nil
+}
+
+// abandon stops delivering the reply of a request that is no longer read
+// and, unless the reply had already ended, asks git2d to stop working on
+// it.
+func (m *muxConn) abandon(req *request) {
+	m.mu.Lock()
+	_, unfinished := m.requests[req.id]
+	delete(m.requests, req.id)
+	m.mu.Unlock()
+	req.finish(errAbandoned)
+	if !unfinished {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), controlTimeout)
+	defer cancel()
+	if err := m.writeFrame(ctx, req.id, frameCancel, nil); err != nil {
+		m.fail(err)
+	}
+}
+
+// credit lets git2d send as much more of a request's reply as has been
+// read since the last credit, unless the reply has already ended.
+func (m *muxConn) credit(req *request) {
+	n := req.consumed
+	req.consumed = 0
+	m.mu.Lock()
+	_, unfinished := m.requests[req.id]
+	m.mu.Unlock()
+	if !unfinished {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), controlTimeout)
+	defer cancel()
+	// The payload is a bare uint, which is a uvarint.
+	if err := m.writeFrame(ctx, req.id, frameCredit, binary.AppendUvarint(nil, uint64(n))); err != nil { //#nosec G115
+		m.fail(err)
+	}
+}
+
+func (m *muxConn) Close() error {
+	m.fail(errConnClosed)
+	return nil
+}
+
+// request is the receiving end of one request's reply. Chunks are queued
+// as they arrive; git2d sends no more than replyWindow bytes beyond what
+// has been read, which bounds the queue.
+type request struct {
+	id uint64
+
+	mu     sync.Mutex
+	queue  [][]byte
+	queued int // bytes in queue
+	done   bool
+	err    error
+	notify chan struct{}
+
+	// Only touched by the reader.
+	cur      []byte // chunk being consumed
+	consumed int    // bytes dequeued since git2d was last credited
+}
+
+func newRequest(id uint64) *request {
+	return &request{
+		id:     id,
+		notify: make(chan struct{}, 1),
+	} //exhaustruct:ignore
+}
+
+// push queues a chunk, failing if git2d has sent more than it was allowed
+// to.
+func (r *request) push(chunk []byte) error {
+	r.mu.Lock()
+	if r.queued+len(chunk) > replyWindow {
+		r.mu.Unlock()
+		return errOverrun
+	}
+	if !r.done {
+		r.queue = append(r.queue, chunk)
+		r.queued += len(chunk)
+	}
+	r.mu.Unlock()
+	r.wake()
+	return nil
+}
+
+// finish ends the reply; the first call wins.
+func (r *request) finish(err error) {
+	r.mu.Lock()
+	if !r.done {
+		r.done = true
+		r.err = err
+	}
+	r.mu.Unlock()
+	r.wake()
+}
+
+func (r *request) wake() {
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+}
+
+// next makes sure r.cur is non-empty, waiting for the next chunk if needed.
+func (r *request) next(ctx context.Context) error {
+	for len(r.cur) == 0 {
+		r.mu.Lock()
+		switch {
+		case len(r.queue) > 0:
+			r.cur = r.queue[0]
+			r.queue[0] = nil
+			r.queue = r.queue[1:]
+			r.queued -= len(r.cur)
+			r.consumed += len(r.cur)
+			r.mu.Unlock()
+			continue
+		case r.err != nil:
+			err := r.err
+			r.mu.Unlock()
+			return err
+		case r.done:
+			r.mu.Unlock()
+			return io.EOF
+		}
+		r.mu.Unlock()
+		select {
+		case <-r.notify:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// stream lets the command methods keep treating the client as a plain
+// byte stream: whatever is written is collected into a request, which is
+// sent on the first read, and reads then return its reply until io.EOF.
+// Writing again starts a new request, abandoning any unread reply.
+//
+// Reads wait no longer than the context given to begin; when it is done,
+// the request is abandoned. Abandoning a request whose reply hasn't ended,
+// here or through Client.Close, also cancels it in git2d.
+type stream struct {
+	mux     *muxConn
+	ctx     context.Context //nolint:containedctx
+	pending bytes.Buffer
+	req     *request
+}
+
+// begin prepares for a new command run under ctx.
+func (s *stream) begin(ctx context.Context) {
+	s.reset()
+	s.pending.Reset()
+	s.ctx = ctx
+}
+
+func (s *stream) Write(p []byte) (int, error) {
+	s.reset()
+	return s.pending.Write(p)
+}
+
+func (s *stream) start() error {
+	if s.req != nil {
+		return nil
+	}
+	if s.pending.Len() == 0 {
+		return io.EOF
+	}
+	req, err := s.mux.send(s.ctx, s.pending.Bytes())
+	s.pending.Reset()
+	if err != nil {
+		return err
+	}
+	s.req = req
+	return nil
+}
+
+// next waits for reply data, cancelling the request if the context is
+// done first. Once half of the reply window has been read, git2d is
+// credited with it.
+func (s *stream) next() error {
+	if err := s.start(); err != nil {
+		return err
+	}
+	err := s.req.next(s.ctx)
+	if err != nil && s.ctx.Err() != nil && errors.Is(err, s.ctx.Err()) {
+		s.mux.abandon(s.req)
+		s.req = nil
+	}
+	if err == nil && s.req.consumed >= replyWindow/2 {
+		s.mux.credit(s.req)
+	}
+	return err
+}
+
+func (s *stream) Read(p []byte) (int, error) {
+	if err := s.next(); err != nil {
+		return 0, err
+	}
+	n := copy(p, s.req.cur)
+	s.req.cur = s.req.cur[n:]
+	return n, nil
+}
+
+func (s *stream) ReadByte() (byte, error) {
+	if err := s.next(); err != nil {
+		return 0, err
+	}
+	b := s.req.cur[0]
+	s.req.cur = s.req.cur[1:]
+	return b, nil
+}
+
+// reset abandons the current request, if any.
+func (s *stream) reset() {
+	if s.req != nil {
+		s.mux.abandon(s.req)
+		s.req = nil
+	}
+}
@@ -1,14 +1,16 @@
 // SPDX-License-Identifier: AGPL-3.0-only
 // SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
 
-// TODO: Make the C part report detailed error messages too
-
 package git2c
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	ErrUnknown                         = errors.New("git2c: unknown error")
+	ErrRepoOpen                        = errors.New("git2c: open repository failed")
 	ErrPath                            = errors.New("git2c: get tree entry by path failed")
 	ErrRevparse                        = errors.New("git2c: revparse failed")
 	ErrReadme                          = errors.New("git2c: no readme")
@@ -32,12 +34,17 @@ var (
 	ErrInitRepoSetHooksPath            = errors.New("git2c: init repo: set core.hooksPath failed")
 	ErrInitRepoSetAdvertisePushOptions = errors.New("git2c: init repo: set receive.advertisePushOptions failed")
 	ErrInitRepoMkdir                   = errors.New("git2c: init repo: create directory failed")
+	ErrPack                            = errors.New("git2c: pack failed")
+	ErrImport                          = errors.New("git2c: import failed")
+	ErrTags                            = errors.New("git2c: list tags failed")
 )
 
 func Perror(errno uint64) error {
 	switch errno {
 	case 0:
 		return nil
+	case 1:
+		return ErrRepoOpen
 	case 3:
 		return ErrPath
 	case 4:
@@ -82,6 +89,50 @@ func Perror(errno uint64) error {
 		return ErrInitRepoSetAdvertisePushOptions
 	case 24:
 		return ErrInitRepoMkdir
+	case 25:
+		return ErrPack
+	case 26:
+		return ErrImport
+	case 27:
+		return ErrTags
 	}
 	return ErrUnknown
 }
+
+// Error is a failure reported by git2d. It matches the sentinel for its
+// status with errors.Is.
+type Error struct {
+	Status uint64
+	// Class is libgit2's git_error_t for the failure, or 0 if the failure
+	// did not come from libgit2, in which case Message is empty.
+	Class   int
+	Message string
+	// Input is the path, ref, revision or object ID that the command
+	// failed on, if any.
+	Input string
+}
+
+func (e *Error) Error() string {
+	msg := Perror(e.Status).Error()
+	if e.Input != "" {
+		msg += fmt.Sprintf(" (%q)", e.Input)
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return Perror(e.Status)
+}
+
+// IsNotFound reports whether err means that the requested path, ref,
+// revision or commit does not exist.
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrPath) ||
+		errors.Is(err, ErrRevparse) ||
+		errors.Is(err, ErrRefResolve) ||
+		errors.Is(err, ErrCommitLookup) ||
+		errors.Is(err, ErrCommitTree)
+}
@@ -0,0 +1,216 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+
+package git2c
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Defaults used for zero fields of PoolConfig.
+const (
+	defaultMaxConns    = 4
+	defaultMaxIdle     = 2
+	healthCheckTimeout = 5 * time.Second
+)
+
+var errPoolClosed = errors.New("git2c: pool closed")
+
+type PoolConfig struct {
+	// Address is git2d's UNIX domain socket path, or TLSScheme followed
+	// by host:port for a git2d on another machine.
+	Address string
+	// TLS holds the client certificate presented to, and the CAs used to
+	// verify, a git2d reached over TLS.
+	TLS *tls.Config
+	// MaxConns is the number of connections beyond which clients share
+	// connections instead of dialing new ones.
+	MaxConns int
+	// MaxIdle is the number of connections without clients to keep open.
+	MaxIdle int
+	// IdleTimeout closes connections that have had no clients for this
+	// long. Zero keeps them open.
+	IdleTimeout time.Duration
+	// HealthCheckInterval is how often Run pings idle connections and
+	// drops those that don't answer. Zero disables health checks.
+	HealthCheckInterval time.Duration
+}
+
+// Pool hands out clients over a small set of git2d connections. A client
+// gets a connection of its own while one is free; once MaxConns are open,
+// clients are spread over the least busy connections, whose requests are
+// multiplexed by git2d.
+type Pool struct {
+	cfg PoolConfig
+
+	mu      sync.Mutex
+	conns   []*muxConn
+	dialing int
+	closed  bool
+}
+
+func NewPool(cfg PoolConfig) *Pool {
+	if cfg.MaxConns <= 0 {
+		cfg.MaxConns = defaultMaxConns
+	}
+	if cfg.MaxIdle < 0 {
+		cfg.MaxIdle = 0
+	} else if cfg.MaxIdle == 0 {
+		cfg.MaxIdle = defaultMaxIdle
+	}
+	return &Pool{cfg: cfg} //exhaustruct:ignore
+}
+
+// Client returns a client for issuing commands. It must be closed to
+// return its connection to the pool.
+func (p *Pool) Client(ctx context.Context) (*Client, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, errPoolClosed
+	}
+	p.removeBrokenLocked()
+	var best *muxConn
+	for _, m := range p.conns {
+		if best == nil || m.users < best.users {
+			best = m
+		}
+	}
+	if best != nil && (best.users == 0 || len(p.conns)+p.dialing >= p.cfg.MaxConns) {
+		best.users++
+		p.mu.Unlock()
+		return newClient(p.cfg.Address, best, p), nil
+	}
+	p.dialing++
+	p.mu.Unlock()
+
+	mux, err := dial(ctx, p.cfg.Address, p.cfg.TLS)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dialing--
+	if err != nil {
+		return nil, err
+	}
+	if p.closed {
+		_ = mux.Close()
+		return nil, errPoolClosed
+	}
+	mux.users = 1
+	p.conns = append(p.conns, mux)
+	return newClient(p.cfg.Address, mux, p), nil
+}
+
+func (p *Pool) release(m *muxConn) {
+	p.mu.Lock()
+	m.users--
+	m.lastUsed = time.Now()
+	var drop bool
+	if m.users == 0 && (m.broken() || p.idleCountLocked() > p.cfg.MaxIdle) {
+		p.removeLocked(m)
+		drop = true
+	}
+	p.mu.Unlock()
+
+	if drop {
+		_ = m.Close()
+	}
+}
+
+func (p *Pool) idleCountLocked() (n int) {
+	for _, m := range p.conns {
+		if m.users == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func (p *Pool) removeLocked(m *muxConn) {
+	p.conns = slices.DeleteFunc(p.conns, func(c *muxConn) bool { return c == m })
+}
+
+// removeBrokenLocked forgets connections that have failed. Their clients
+// still hold them and will see errors from their commands.
+func (p *Pool) removeBrokenLocked() {
+	p.conns = slices.DeleteFunc(p.conns, (*muxConn).broken)
+}
+
+// Run performs periodic health checks until ctx is done, then closes the
+// pool.
+func (p *Pool) Run(ctx context.Context) error {
+	defer p.Close()
+	if p.cfg.HealthCheckInterval <= 0 {
+		<-ctx.Done()
+		return nil
+	}
+	ticker := time.NewTicker(p.cfg.HealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			p.checkIdle()
+		}
+	}
+}
+
+// checkIdle closes connections that have been idle for longer than
+// IdleTimeout and pings the rest of the idle ones.
+func (p *Pool) checkIdle() {
+	now := time.Now()
+	var expired, idle []*muxConn
+	p.mu.Lock()
+	p.removeBrokenLocked()
+	for _, m := range p.conns {
+		if m.users != 0 {
+			continue
+		}
+		if p.cfg.IdleTimeout > 0 && now.Sub(m.lastUsed) > p.cfg.IdleTimeout {
+			expired = append(expired, m)
+		} else {
+			idle = append(idle, m)
+		}
+	}
+	for _, m := range expired {
+		p.removeLocked(m)
+	}
+	p.mu.Unlock()
+
+	for _, m := range expired {
+		_ = m.Close()
+	}
+	for _, m := range idle {
+		ping(m)
+	}
+}
+
+// ping fails the connection if git2d does not answer in time, so that it
+// is dropped from the pool.
+func ping(m *muxConn) {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+	if err := newClient("", m, nil).Ping(ctx); err != nil {
+		m.fail(err)
+	}
+}
+
+// Close closes all pooled connections. Clients still holding connections
+// will see errors from their commands.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	conns := p.conns
+	p.conns = nil
+	p.closed = true
+	p.mu.Unlock()
+
+	for _, m := range conns {
+		_ = m.Close()
+	}
+}
@@ -0,0 +1,411 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+
+package git2c
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"go.lindenii.runxiyu.org/forge/forged/internal/common/bare"
+)
+
+// protocolVersion is exchanged when connecting and must match
+// PROTOCOL_VERSION in git2d/x.h. Bump both whenever the encoding of any
+// request or reply changes.
+const protocolVersion = 5
+
+// envelope wraps every command sent to git2d.
+type envelope struct {
+	Repo    string
+	Command command
+}
+
+// command is the body of a request. Its union tag is the command number
+// that git2d/session.c dispatches on.
+type command interface {
+	bare.Union
+}
+
+type (
+	indexCommand   struct{}
+	treeRawCommand struct {
+		Path string
+		Rev  string // HEAD if empty
+	}
+	resolveRefCommand struct {
+		Type string // "commit", "branch", "tag", "rev" or "" for HEAD
+		Name string
+	}
+	listBranchesCommand struct{}
+	formatPatchCommand  struct {
+		Commit string
+	}
+	commitInfoCommand struct {
+		Commit    string
+		DiffFlags uint
+	}
+	mergeBaseCommand struct {
+		A string
+		B string
+	}
+	logCommand struct {
+		Spec  string
+		Limit uint
+	}
+	treeListByOIDCommand struct {
+		Tree string
+	}
+	writeTreeCommand struct {
+		Entries []treeEntryWire
+	}
+	writeBlobCommand struct {
+		Content data
+	}
+	commitTreeOIDCommand struct {
+		Commit string
+	}
+	commitCreateCommand struct {
+		Tree        string
+		Parents     []string
+		AuthorName  string
+		AuthorEmail string
+		When        int64 // unix seconds
+		TZOffset    int64 // minutes
+		Message     string
+	}
+	updateRefCommand struct {
+		Ref    string
+		Commit string
+	}
+	initRepoCommand struct {
+		HooksPath string
+	}
+	diffTreesCommand struct {
+		Old       string
+		New       string
+		Format    uint // diffFormatStructured or diffFormatPatch
+		DiffFlags uint
+	}
+	logRangeCommand struct {
+		Base  string
+		Head  string
+		Limit uint
+	}
+	pingCommand struct{}
+	packCommand struct {
+		Exclude []oid // tips whose history the receiver already has
+	}
+	importChunkCommand struct {
+		Offset uint
+		Data   data
+	}
+	importFinishCommand struct {
+		Head text
+		Refs []refWire
+	}
+	listTagsCommand struct{}
+)
+
+func (indexCommand) IsUnion()         {}
+func (treeRawCommand) IsUnion()       {}
+func (resolveRefCommand) IsUnion()    {}
+func (listBranchesCommand) IsUnion()  {}
+func (formatPatchCommand) IsUnion()   {}
+func (commitInfoCommand) IsUnion()    {}
+func (mergeBaseCommand) IsUnion()     {}
+func (logCommand) IsUnion()           {}
+func (treeListByOIDCommand) IsUnion() {}
+func (writeTreeCommand) IsUnion()     {}
+func (writeBlobCommand) IsUnion()     {}
+func (commitTreeOIDCommand) IsUnion() {}
+func (commitCreateCommand) IsUnion()  {}
+func (updateRefCommand) IsUnion()     {}
+func (initRepoCommand) IsUnion()      {}
+func (diffTreesCommand) IsUnion()     {}
+func (logRangeCommand) IsUnion()      {}
+func (pingCommand) IsUnion()          {}
+func (packCommand) IsUnion()          {}
+func (importChunkCommand) IsUnion()   {}
+func (importFinishCommand) IsUnion()  {}
+func (listTagsCommand) IsUnion()      {}
+
+const (
+	diffFormatStructured = 0
+	diffFormatPatch      = 1
+)
+
+// errorReply follows a non-zero status; see Error.
+type errorReply struct {
+	Class   uint
+	Message text
+	Input   text
+}
+
+// Replies follow a zero status; see Client.call.
+type (
+	oidReply struct {
+		ID oid
+	}
+	patchReply struct {
+		Patch text
+	}
+	indexReply struct {
+		Readme  data
+		Commits []commitWire
+	}
+	listBranchesReply struct {
+		Branches []text
+	}
+	// listTagsReply has the commit that each tag peels to.
+	listTagsReply struct {
+		Tags []refWire
+	}
+	logReply struct {
+		Commits []commitWire
+	}
+	logRangeReply struct {
+		Ahead   uint
+		Behind  uint
+		Commits []commitWire
+	}
+	treeListReply struct {
+		Entries []treeEntryWire
+	}
+	commitInfoReply struct {
+		ID             oid
+		AuthorName     text
+		AuthorEmail    text
+		AuthorWhen     int64
+		AuthorTZMin    int64
+		CommitterName  text
+		CommitterEmail text
+		CommitterWhen  int64
+		CommitterTZMin int64
+		Message        text
+		Parents        []oid
+		Diff           diffReply
+	}
+	diffReply struct {
+		Additions uint
+		Deletions uint
+		Files     []fileDiffWire
+	}
+	// packReply is followed by the pack; see PackReader.
+	packReply struct {
+		Head text
+		Refs []refWire
+	}
+)
+
+// treeRawObject is the reply to treeRawCommand.
+type treeRawObject interface {
+	bare.Union
+}
+
+type (
+	treeRawTree struct {
+		Entries []treeRawEntryWire
+	}
+	// treeRawBlob is followed by the blob's content; see Blob.
+	treeRawBlob struct {
+		ID     oid
+		Size   uint
+		Binary bool
+	}
+)
+
+func (treeRawTree) IsUnion() {}
+func (treeRawBlob) IsUnion() {}
+
+type (
+	commitWire struct {
+		ID     oid
+		Title  text
+		Author text
+		Email  text
+		Date   text
+	}
+	treeEntryWire struct {
+		Mode uint
+		Name text
+		ID   oid
+	}
+	treeRawEntryWire struct {
+		Type uint // 1 for trees, 2 for blobs
+		Mode uint
+		Size uint
+		Name text
+	}
+	fileDiffWire struct {
+		Status     uint
+		Flags      uint // bit 0: binary
+		Similarity uint
+		FromOID    oid
+		ToOID      oid
+		FromMode   uint
+		ToMode     uint
+		FromPath   text
+		ToPath     text
+		Additions  uint
+		Deletions  uint
+		Hunks      []diffHunkWire
+	}
+	diffHunkWire struct {
+		OldStart uint
+		OldLines uint
+		NewStart uint
+		NewLines uint
+		Header   text
+		Lines    []diffLineWire
+	}
+	diffLineWire struct {
+		Op      uint
+		OldLine uint
+		NewLine uint
+		Content text
+	}
+	refWire struct {
+		Name text
+		ID   oid
+	}
+)
+
+func init() {
+	bare.RegisterUnion((*command)(nil)).
+		Member(indexCommand{}, 1).
+		Member(treeRawCommand{}, 2).
+		Member(resolveRefCommand{}, 3).
+		Member(listBranchesCommand{}, 4).
+		Member(formatPatchCommand{}, 5).
+		Member(commitInfoCommand{}, 6).
+		Member(mergeBaseCommand{}, 7).
+		Member(logCommand{}, 8).
+		Member(treeListByOIDCommand{}, 9).
+		Member(writeTreeCommand{}, 10).
+		Member(writeBlobCommand{}, 11).
+		Member(commitTreeOIDCommand{}, 12).
+		Member(commitCreateCommand{}, 13).
+		Member(updateRefCommand{}, 14).
+		Member(initRepoCommand{}, 15).
+		Member(diffTreesCommand{}, 16).
+		Member(logRangeCommand{}, 17).
+		Member(pingCommand{}, 18).
+		Member(packCommand{}, 19).
+		Member(importChunkCommand{}, 20).
+		Member(importFinishCommand{}, 21).
+		Member(listTagsCommand{}, 22)
+
+	bare.RegisterUnion((*treeRawObject)(nil)).
+		Member(treeRawTree{}, 1).
+		Member(treeRawBlob{}, 2)
+
+	// Logs, trees and diffs easily exceed bare's default limit of 4096
+	// list elements, and git2d is trusted.
+	bare.MaxArrayLength(1 << 24)
+}
+
+// data is BARE data. Unlike a []byte field, which bare treats as a list of
+// u8, it is read in one go and not subject to the list length limit.
+type data []byte
+
+func (d *data) Marshal(w *bare.Writer) error {
+	return w.WriteData(*d)
+}
+
+func (d *data) Unmarshal(r *bare.Reader) error {
+	b, err := r.ReadData()
+	*d = b
+	return err
+}
+
+// text is BARE data holding text from a repository, such as a path or a
+// commit message, which unlike a BARE string need not be valid UTF-8.
+type text string
+
+func (t *text) Marshal(w *bare.Writer) error {
+	return w.WriteData([]byte(*t))
+}
+
+func (t *text) Unmarshal(r *bare.Reader) error {
+	b, err := r.ReadData()
+	*t = text(b)
+	return err
+}
+
+// oid is an object ID, sent raw and held as hex. It is empty for absent
+// objects.
+type oid string
+
+func (o *oid) Marshal(w *bare.Writer) error {
+	raw, err := hex.DecodeString(string(*o))
+	if err != nil {
+		return fmt.Errorf("decode oid hex: %w", err)
+	}
+	return w.WriteData(raw)
+}
+
+func (o *oid) Unmarshal(r *bare.Reader) error {
+	b, err := r.ReadData()
+	*o = oid(hex.EncodeToString(b))
+	return err
+}
+
+func (c commitWire) commit() Commit {
+	return Commit{
+		Hash:    string(c.ID),
+		Author:  string(c.Author),
+		Email:   string(c.Email),
+		Date:    string(c.Date),
+		Message: string(c.Title),
+	}
+}
+
+func commitsFromWire(wire []commitWire) []Commit {
+	commits := make([]Commit, 0, len(wire))
+	for _, c := range wire {
+		commits = append(commits, c.commit())
+	}
+	return commits
+}
+
+func (d diffReply) files() ([]FileDiff, DiffStats) {
+	files := make([]FileDiff, 0, len(d.Files))
+	for _, f := range d.Files {
+		hunks := make([]DiffHunk, 0, len(f.Hunks))
+		for _, h := range f.Hunks {
+			lines := make([]DiffLine, 0, len(h.Lines))
+			for _, l := range h.Lines {
+				lines = append(lines, DiffLine{
+					Op:      uint64(l.Op),
+					OldLine: uint64(l.OldLine),
+					NewLine: uint64(l.NewLine),
+					Content: string(l.Content),
+				})
+			}
+			hunks = append(hunks, DiffHunk{
+				OldStart: uint64(h.OldStart),
+				OldLines: uint64(h.OldLines),
+				NewStart: uint64(h.NewStart),
+				NewLines: uint64(h.NewLines),
+				Header:   string(h.Header),
+				Lines:    lines,
+			})
+		}
+		files = append(files, FileDiff{
+			Status:     uint64(f.Status),
+			Binary:     f.Flags&1 != 0,
+			Similarity: uint64(f.Similarity),
+			FromOID:    string(f.FromOID),
+			ToOID:      string(f.ToOID),
+			FromMode:   uint64(f.FromMode),
+			ToMode:     uint64(f.ToMode),
+			FromPath:   string(f.FromPath),
+			ToPath:     string(f.ToPath),
+			Additions:  uint64(f.Additions),
+			Deletions:  uint64(f.Deletions),
+			Hunks:      hunks,
+		})
+	}
+	return files, DiffStats{Additions: uint64(d.Additions), Deletions: uint64(d.Deletions)}
+}
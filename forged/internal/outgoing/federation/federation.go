@@ -0,0 +1,182 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+
+// Package federation verifies that accounts on other forges belong to our
+// users. A user proves this by signing a challenge with an SSH key that the
+// other forge publishes for the account; the key is checked again
+// periodically, and the account stops counting once it is no longer
+// published.
+package federation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"go.lindenii.runxiyu.org/forge/forged/internal/common/sshsig"
+	"go.lindenii.runxiyu.org/forge/forged/internal/config"
+	"go.lindenii.runxiyu.org/forge/forged/internal/database/queries"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+const (
+	// reverifyBatch is how many identities are checked at once.
+	reverifyBatch = 100
+	// checkInterval is how often identities due for checking are looked
+	// for.
+	checkInterval = time.Hour
+)
+
+// ErrNotVerified is returned by Verify when the signature isn't by any key
+// that the service publishes for the account.
+var ErrNotVerified = errors.New("signature is not by a key published for the account")
+
+// Verifier fetches the SSH keys that a service publishes for its users.
+type Verifier interface {
+	// Name is what users know the service as.
+	Name() string
+	// Keys returns the keys published for an account, or ErrNoAccount if
+	// there is no such account.
+	Keys(ctx context.Context, username string) ([]gossh.PublicKey, error)
+}
+
+// Federation is the set of services that accounts may be verified on.
+type Federation struct {
+	verifiers map[string]Verifier
+	interval  time.Duration
+	queries   *queries.Queries
+}
+
+// New returns the services in cfg, along with SourceHut and GitHub.
+func New(cfg *config.Federation, q *queries.Queries) (*Federation, error) {
+	f := &Federation{
+		verifiers: map[string]Verifier{
+			"sourcehut": SourceHut(),
+			"github":    GitHub(),
+		},
+		interval: time.Duration(cfg.ReverifyInterval) * time.Second,
+		queries:  q,
+	}
+	for name, instance := range cfg.Lindenii {
+		if _, ok := f.verifiers[name]; ok {
+			return nil, fmt.Errorf("service %s is already defined", name)
+		}
+		v, err := Lindenii(name, instance.Root)
+		if err != nil {
+			return nil, err
+		}
+		f.verifiers[name] = v
+	}
+	return f, nil
+}
+
+// Services returns the names of the services, sorted.
+func (f *Federation) Services() []string {
+	return slices.Sorted(maps.Keys(f.verifiers))
+}
+
+// Verifier returns the verifier of a service.
+func (f *Federation) Verifier(service string) (Verifier, bool) {
+	v, ok := f.verifiers[service]
+	return v, ok
+}
+
+// Verify checks that armored, the output of "ssh-keygen -Y sign -n
+// namespace", signs message with a key that the service publishes for
+// username, and returns that key.
+func Verify(ctx context.Context, v Verifier, username string, message, armored []byte, namespace string) (gossh.PublicKey, error) {
+	keys, err := v.Keys(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if sshsig.Verify(key, message, armored, namespace) == nil {
+			return key, nil
+		}
+	}
+	return nil, ErrNotVerified
+}
+
+// published reports whether key is still published for username.
+func published(ctx context.Context, v Verifier, username, keyString string) (bool, error) {
+	want, _, _, _, err := gossh.ParseAuthorizedKey([]byte(keyString))
+	if err != nil {
+		return false, err
+	}
+	keys, err := v.Keys(ctx, username)
+	if errors.Is(err, ErrNoAccount) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return slices.ContainsFunc(keys, func(k gossh.PublicKey) bool {
+		return string(k.Marshal()) == string(want.Marshal())
+	}), nil
+}
+
+// Run checks verified identities again every federation.reverify_interval
+// until ctx is done.
+func (f *Federation) Run(ctx context.Context) error {
+	if f.interval == 0 {
+		return nil
+	}
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		if err := f.reverify(ctx); err != nil && ctx.Err() == nil {
+			slog.Warn("federated identity check failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (f *Federation) reverify(ctx context.Context) error {
+	rows, err := f.queries.GetIdentitiesToReverify(ctx, queries.GetIdentitiesToReverifyParams{
+		CheckedAt: pgtype.Timestamptz{
+			Time:  time.Now().Add(-f.interval),
+			Valid: true,
+		},
+		Limit: reverifyBatch,
+	})
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		v, ok := f.verifiers[row.Service]
+		if !ok {
+			// Its service has been removed from the configuration.
+			continue
+		}
+		ok, err := published(ctx, v, row.RemoteUsername, row.KeyString)
+		if err != nil {
+			// Outages at the other forge shouldn't unverify anyone.
+			slog.Warn("could not check federated identity", "service", row.Service, "remote", row.RemoteUsername, "error", err)
+			continue
+		}
+		if ok {
+			err = f.queries.MarkIdentityVerified(ctx, queries.MarkIdentityVerifiedParams{
+				UserID:  row.UserID,
+				Service: row.Service,
+			})
+		} else {
+			slog.Info("federated identity no longer verified", "user", row.UserID, "service", row.Service, "remote", row.RemoteUsername)
+			err = f.queries.MarkIdentityUnverified(ctx, queries.MarkIdentityUnverifiedParams{
+				UserID:  row.UserID,
+				Service: row.Service,
+			})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
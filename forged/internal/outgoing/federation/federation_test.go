@@ -0,0 +1,219 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+
+package federation
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.lindenii.runxiyu.org/forge/forged/internal/config"
+	"go.lindenii.runxiyu.org/forge/forged/internal/database/dbtest"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// forge serves the keys of its accounts like another Lindenii Forge.
+type forge struct {
+	srv  *httptest.Server
+	mu   sync.Mutex
+	keys map[string]string
+	down bool
+}
+
+func newForge(t *testing.T) *forge {
+	t.Helper()
+	f := &forge{keys: map[string]string{}} //exhaustruct:ignore
+	f.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		username, ok := strings.CutPrefix(r.URL.Path, "/-/keys/")
+		keys, exists := f.keys[username]
+		switch {
+		case f.down:
+			http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+		case !ok || !exists:
+			http.NotFound(w, r)
+		default:
+			_, _ = w.Write([]byte(keys))
+		}
+	}))
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+// set publishes keys, an authorized_keys file, for username.
+func (f *forge) set(username, keys string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.keys[username] = keys
+}
+
+func (f *forge) setDown(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down = down
+}
+
+func (f *forge) verifier(t *testing.T) Verifier {
+	t.Helper()
+	v, err := Lindenii("example", f.srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// newKey returns a key in authorized_keys format, with comment.
+func newKey(t *testing.T, comment string) string {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := gossh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSuffix(string(gossh.MarshalAuthorizedKey(key)), "\n") + " " + comment
+}
+
+func TestKeys(t *testing.T) {
+	t.Parallel()
+	f := newForge(t)
+	v := f.verifier(t)
+	a, b := newKey(t, "a"), newKey(t, "b")
+	f.set("alice", a+"\nnot a key\n\n"+b+"\n")
+	f.set("bob", "")
+
+	keys, err := v.Keys(t.Context(), "alice")
+	if err != nil || len(keys) != 2 {
+		t.Fatalf("alice: got %d keys, %v", len(keys), err)
+	}
+	if keys, err := v.Keys(t.Context(), "bob"); err != nil || len(keys) != 0 {
+		t.Errorf("bob: got %d keys, %v", len(keys), err)
+	}
+	if _, err := v.Keys(t.Context(), "carol"); !errors.Is(err, ErrNoAccount) {
+		t.Errorf("carol: got %v, want ErrNoAccount", err)
+	}
+	for _, username := range []string{"", ".alice", "../alice", "alice/keys", strings.Repeat("a", maxUsernameLength+1)} {
+		if _, err := v.Keys(t.Context(), username); !errors.Is(err, ErrUsername) {
+			t.Errorf("%q: got %v, want ErrUsername", username, err)
+		}
+	}
+	f.setDown(true)
+	if _, err := v.Keys(t.Context(), "alice"); err == nil || errors.Is(err, ErrNoAccount) {
+		t.Errorf("during an outage: got %v", err)
+	}
+}
+
+func TestPublished(t *testing.T) {
+	t.Parallel()
+	f := newForge(t)
+	v := f.verifier(t)
+	a, b := newKey(t, "laptop"), newKey(t, "desktop")
+	f.set("alice", a+"\n")
+
+	tests := []struct {
+		name      string
+		username  string
+		keyString string
+		published bool
+	}{
+		{"published", "alice", a, true},
+		// Comments are not part of the key.
+		{"other comment", "alice", strings.TrimSuffix(a, "laptop") + "phone", true},
+		{"other key", "alice", b, false},
+		{"no account", "bob", a, false},
+	}
+	for _, tt := range tests {
+		ok, err := published(t.Context(), v, tt.username, tt.keyString)
+		if err != nil || ok != tt.published {
+			t.Errorf("%s: published = %v, %v, want %v", tt.name, ok, err, tt.published)
+		}
+	}
+	if _, err := published(t.Context(), v, "alice", "not a key"); err == nil {
+		t.Error("unparseable key string: got no error")
+	}
+	f.setDown(true)
+	if _, err := published(t.Context(), v, "alice", a); err == nil {
+		t.Error("during an outage: got no error")
+	}
+}
+
+func TestReverify(t *testing.T) {
+	t.Parallel()
+	db, q := dbtest.New(t)
+	f := newForge(t)
+	fed, err := New(&config.Federation{
+		ReverifyInterval: 60,
+		Lindenii:         map[string]config.LindeniiInstance{"example": {Root: f.srv.URL}},
+	}, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each of alice, bob and carol verified their account on the other
+	// forge by its key a day ago.
+	keys := map[string]string{}
+	for _, name := range []string{"alice", "bob", "carol"} {
+		keys[name] = newKey(t, name)
+		_, err := db.Exec(t.Context(), `
+			WITH u AS (INSERT INTO users (username, type) VALUES ($1, 'registered') RETURNING id)
+			INSERT INTO federated_identities (user_id, service, remote_username, key_string, checked_at)
+			SELECT id, 'example', $1, $2, NOW() - INTERVAL '1 day' FROM u`, name, keys[name])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	verified := func(name string) bool {
+		var ok bool
+		err := db.QueryRow(t.Context(), `
+			SELECT verified_at IS NOT NULL FROM federated_identities WHERE service = 'example' AND remote_username = $1`, name).Scan(&ok)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+
+	// alice's key is still published, bob's has been replaced, and carol's
+	// account is gone.
+	f.set("alice", keys["alice"]+"\n")
+	f.set("bob", newKey(t, "bob-new")+"\n")
+	if err := fed.reverify(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if !verified("alice") || verified("bob") || verified("carol") {
+		t.Errorf("after checking: alice %v, bob %v, carol %v", verified("alice"), verified("bob"), verified("carol"))
+	}
+
+	// Outages don't unverify anyone, and leave them to be checked again.
+	if _, err := db.Exec(t.Context(), `UPDATE federated_identities SET checked_at = NOW() - INTERVAL '1 day'`); err != nil {
+		t.Fatal(err)
+	}
+	f.setDown(true)
+	if err := fed.reverify(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if !verified("alice") {
+		t.Error("alice is unverified by an outage")
+	}
+	var due int
+	if err := db.QueryRow(t.Context(), `SELECT COUNT(*) FROM federated_identities WHERE checked_at < NOW() - INTERVAL '1 hour'`).Scan(&due); err != nil || due != 3 {
+		t.Errorf("%d identities still due after an outage, %v", due, err)
+	}
+
+	// Once the other forge is back, keys removed meanwhile are noticed.
+	f.setDown(false)
+	f.set("alice", "")
+	if err := fed.reverify(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if verified("alice") {
+		t.Error("alice is still verified after the key was removed")
+	}
+}
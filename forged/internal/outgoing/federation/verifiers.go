@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+
+package federation
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+const (
+	requestTimeout = 10 * time.Second
+	maxKeysSize    = 1 << 20
+	// maxUsernameLength is longer than any of the services allow.
+	maxUsernameLength = 64
+)
+
+var (
+	// ErrNoAccount is returned when a service has no such account.
+	ErrNoAccount = errors.New("no such account")
+	// ErrUsername is returned for usernames that no service allows, which
+	// are rejected before they are put in URLs.
+	ErrUsername = errors.New("invalid username")
+)
+
+var client = &http.Client{Timeout: requestTimeout} //exhaustruct:ignore
+
+// keysEndpoint is a service that serves the keys of each user as an
+// authorized_keys file.
+type keysEndpoint struct {
+	name string
+	url  func(username string) string
+}
+
+func (k keysEndpoint) Name() string {
+	return k.name
+}
+
+func (k keysEndpoint) Keys(ctx context.Context, username string) ([]gossh.PublicKey, error) {
+	if !validUsername(username) {
+		return nil, ErrUsername
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.url(username), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, ErrNoAccount
+	default:
+		return nil, fmt.Errorf("%s: status %s", k.name, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxKeysSize))
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []gossh.PublicKey
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(nil, maxKeysSize)
+	for scanner.Scan() {
+		// Lines that aren't keys are skipped rather than failing the
+		// whole account.
+		if key, _, _, _, err := gossh.ParseAuthorizedKey(scanner.Bytes()); err == nil {
+			keys = append(keys, key)
+		}
+	}
+	return keys, scanner.Err()
+}
+
+func validUsername(username string) bool {
+	if username == "" || len(username) > maxUsernameLength || username[0] == '.' {
+		return false
+	}
+	for _, c := range username {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// SourceHut verifies accounts on sr.ht.
+func SourceHut() Verifier {
+	return keysEndpoint{
+		name: "SourceHut",
+		url:  func(username string) string { return "https://meta.sr.ht/~" + username + ".keys" },
+	}
+}
+
+// GitHub verifies accounts on github.com.
+func GitHub() Verifier {
+	return keysEndpoint{
+		name: "GitHub",
+		url:  func(username string) string { return "https://github.com/" + username + ".keys" },
+	}
+}
+
+// Lindenii verifies accounts on another Lindenii Forge served at root.
+func Lindenii(name, root string) (Verifier, error) {
+	u, err := url.Parse(root)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("service %s: invalid root %q", name, root)
+	}
+	root = strings.TrimSuffix(root, "/")
+	return keysEndpoint{
+		name: u.Host,
+		url:  func(username string) string { return root + "/-/keys/" + username },
+	}, nil
+}
@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+
+// Package mail sends mail from the forge through an SMTP relay.
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"go.lindenii.runxiyu.org/forge/forged/internal/config"
+)
+
+// ErrDisabled is returned by Send when no relay is configured.
+var ErrDisabled = errors.New("no SMTP relay configured")
+
+var errHeaderInjection = errors.New("header value contains a line break")
+
+type Sender struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func New(cfg *config.SMTP) *Sender {
+	s := &Sender{
+		addr: cfg.Addr,
+		from: cfg.From,
+	} //exhaustruct:ignore
+	if cfg.Username != "" {
+		host, _, _ := net.SplitHostPort(cfg.Addr)
+		s.auth = smtp.PlainAuth("", cfg.Username, cfg.Password, host)
+	}
+	return s
+}
+
+// Enabled reports whether a relay is configured.
+func (s *Sender) Enabled() bool {
+	return s.addr != ""
+}
+
+// Send sends a plain text message to a single recipient.
+func (s *Sender) Send(to, subject, body string) error {
+	if !s.Enabled() {
+		return ErrDisabled
+	}
+	if strings.ContainsAny(to, "\r\n") || strings.ContainsAny(subject, "\r\n") {
+		return errHeaderInjection
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: <%s@%s>\r\n", rand.Text(), s.domain())
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	if err := smtp.SendMail(s.addr, s.auth, s.from, []string{to}, msg.Bytes()); err != nil {
+		return fmt.Errorf("send mail: %w", err)
+	}
+	return nil
+}
+
+func (s *Sender) domain() string {
+	if _, domain, ok := strings.Cut(s.from, "@"); ok {
+		return domain
+	}
+	return "localhost"
+}
@@ -0,0 +1,265 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+
+// Package oauth logs users in through external identity providers with the
+// OAuth 2.0 authorization code flow and PKCE, learning who they are from an
+// OpenID Connect ID token or the provider's userinfo endpoint.
+package oauth
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.lindenii.runxiyu.org/forge/forged/internal/config"
+)
+
+const (
+	requestTimeout = 10 * time.Second
+	maxResponse    = 1 << 20
+)
+
+var (
+	// ErrIDToken is returned when an ID token isn't for this login.
+	ErrIDToken = errors.New("oauth: ID token is invalid for this login")
+	// ErrNoSubject is returned when the provider doesn't say who the
+	// user is.
+	ErrNoSubject = errors.New("oauth: provider did not identify the user")
+)
+
+// Provider is a configured identity provider.
+type Provider struct {
+	cfg         config.OAuthProvider
+	redirectURI string
+	client      *http.Client
+}
+
+// Identity is who a provider says a user is.
+type Identity struct {
+	// Subject is the user's stable ID at the provider.
+	Subject string
+	// Username is what the user is called at the provider, which may
+	// change or be empty.
+	Username string
+}
+
+// Login is what must be kept between sending the user to the provider and
+// their return.
+type Login struct {
+	State    string
+	Verifier string
+	Nonce    string
+}
+
+// New checks a provider's configuration. root is the forge's web root,
+// which the provider redirects back to.
+func New(name string, cfg *config.OAuthProvider, root string) (*Provider, error) {
+	for _, u := range []string{cfg.AuthURL, cfg.TokenURL} {
+		if _, err := url.ParseRequestURI(u); err != nil {
+			return nil, fmt.Errorf("provider %s: invalid endpoint %q", name, u)
+		}
+	}
+	if cfg.Issuer == "" && cfg.UserinfoURL == "" {
+		return nil, fmt.Errorf("provider %s: either issuer or userinfo_url must be set", name)
+	}
+	if cfg.ClientID == "" || cfg.SubjectClaim == "" {
+		return nil, fmt.Errorf("provider %s: client_id and subject_claim must be set", name)
+	}
+	p := &Provider{
+		cfg:         *cfg,
+		redirectURI: strings.TrimSuffix(root, "/") + "/-/login/oauth/" + url.PathEscape(name) + "/callback",
+		client:      &http.Client{Timeout: requestTimeout}, //exhaustruct:ignore
+	}
+	return p, nil
+}
+
+// Name is what users know the provider as.
+func (p *Provider) Name() string {
+	return p.cfg.Name
+}
+
+// CreateAccounts is whether logging in with an identity that isn't linked
+// to an account creates one.
+func (p *Provider) CreateAccounts() bool {
+	return p.cfg.CreateAccounts
+}
+
+// NewLogin returns random values for a new login.
+func NewLogin() Login {
+	return Login{
+		State: rand.Text(),
+		// PKCE verifiers must be at least 43 characters long.
+		Verifier: rand.Text() + rand.Text(),
+		Nonce:    rand.Text(),
+	}
+}
+
+// AuthURL returns where to send the user to log in.
+func (p *Provider) AuthURL(login Login) string {
+	challenge := sha256.Sum256([]byte(login.Verifier))
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.cfg.ClientID)
+	v.Set("redirect_uri", p.redirectURI)
+	v.Set("state", login.State)
+	v.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	v.Set("code_challenge_method", "S256")
+	if p.cfg.Scopes != "" {
+		v.Set("scope", p.cfg.Scopes)
+	}
+	if p.cfg.Issuer != "" {
+		v.Set("nonce", login.Nonce)
+	}
+	sep := "?"
+	if strings.Contains(p.cfg.AuthURL, "?") {
+		sep = "&"
+	}
+	return p.cfg.AuthURL + sep + v.Encode()
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Exchange redeems the code that the provider sent the user back with, and
+// returns who the user is.
+func (p *Provider) Exchange(ctx context.Context, code string, login Login) (Identity, error) {
+	var id Identity
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.redirectURI)
+	form.Set("client_id", p.cfg.ClientID)
+	form.Set("client_secret", p.cfg.ClientSecret)
+	form.Set("code_verifier", login.Verifier)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return id, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var token tokenResponse
+	if err := p.do(req, &token); err != nil {
+		if token.Error != "" {
+			return id, fmt.Errorf("token endpoint: %s: %s", token.Error, token.ErrorDescription)
+		}
+		return id, fmt.Errorf("token endpoint: %w", err)
+	}
+
+	claims := map[string]any{}
+	if p.cfg.Issuer != "" {
+		if claims, err = p.checkIDToken(token.IDToken, login.Nonce); err != nil {
+			return id, err
+		}
+	}
+	if p.cfg.UserinfoURL != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.cfg.UserinfoURL, nil)
+		if err != nil {
+			return id, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+		info := map[string]any{}
+		if err := p.do(req, &info); err != nil {
+			return id, fmt.Errorf("userinfo endpoint: %w", err)
+		}
+		// Userinfo must be about the user that the ID token is about.
+		if sub, ok := claims["sub"]; ok && fmt.Sprint(info["sub"]) != fmt.Sprint(sub) {
+			return id, ErrIDToken
+		}
+		for k, v := range info {
+			claims[k] = v
+		}
+	}
+
+	id.Subject = claimString(claims, p.cfg.SubjectClaim)
+	id.Username = claimString(claims, p.cfg.UsernameClaim)
+	if id.Subject == "" {
+		return id, ErrNoSubject
+	}
+	return id, nil
+}
+
+// do sends a request and decodes the JSON response into v, which is
+// filled in even when the status is an error, for OAuth error responses.
+func (p *Provider) do(req *http.Request, v any) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponse))
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	// Numeric IDs must not become floats.
+	dec.UseNumber()
+	decodeErr := dec.Decode(v)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return decodeErr
+}
+
+// checkIDToken returns the claims of an ID token after checking that it is
+// for this login. Its signature isn't checked, which OpenID Connect permits
+// as it came straight from the token endpoint.
+func (p *Provider) checkIDToken(raw, nonce string) (map[string]any, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, ErrIDToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrIDToken
+	}
+	claims := map[string]any{}
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	if err := dec.Decode(&claims); err != nil {
+		return nil, ErrIDToken
+	}
+
+	audOK := false
+	switch aud := claims["aud"].(type) {
+	case string:
+		audOK = aud == p.cfg.ClientID
+	case []any:
+		for _, a := range aud {
+			audOK = audOK || a == p.cfg.ClientID
+		}
+	}
+	expClaim, _ := claims["exp"].(json.Number)
+	exp, err := expClaim.Int64()
+	if err != nil || time.Now().Unix() >= exp {
+		return nil, ErrIDToken
+	}
+	if claims["iss"] != p.cfg.Issuer || !audOK || claims["nonce"] != nonce {
+		return nil, ErrIDToken
+	}
+	return claims, nil
+}
+
+func claimString(claims map[string]any, name string) string {
+	switch v := claims[name].(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	}
+	return ""
+}
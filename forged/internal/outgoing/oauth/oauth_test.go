@@ -0,0 +1,167 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+
+package oauth
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"go.lindenii.runxiyu.org/forge/forged/internal/config"
+	"go.lindenii.runxiyu.org/forge/forged/internal/outgoing/oauth/oauthtest"
+)
+
+const root = "https://forge.example.org/"
+
+func newProvider(t *testing.T, idp *oauthtest.Server, edit func(*config.OAuthProvider)) *Provider {
+	t.Helper()
+	cfg := idp.Config()
+	if edit != nil {
+		edit(&cfg)
+	}
+	p, err := New("test", &cfg, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// login goes through the provider like a user would, returning the code
+// and state it sends the user back with.
+func login(t *testing.T, idp *oauthtest.Server, p *Provider, l Login) (code, state string) {
+	t.Helper()
+	callback, err := idp.Authorize(p.AuthURL(l))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(callback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.TrimSuffix(root, "/") + "/-/login/oauth/test/callback"; u.Scheme+"://"+u.Host+u.Path != want {
+		t.Fatalf("sent back to %s, want %s", callback, want)
+	}
+	return u.Query().Get("code"), u.Query().Get("state")
+}
+
+func TestExchange(t *testing.T) {
+	t.Parallel()
+	idp := oauthtest.NewServer()
+	t.Cleanup(idp.Close)
+	idp.LogIn(oauthtest.User{Subject: "1234", Username: "alice"})
+
+	tests := []struct {
+		name string
+		edit func(*config.OAuthProvider)
+	}{
+		{"OpenID Connect with userinfo", nil},
+		{"ID token only", func(c *config.OAuthProvider) { c.UserinfoURL = "" }},
+		{"userinfo only", func(c *config.OAuthProvider) { c.Issuer = "" }},
+	}
+	for _, tt := range tests {
+		p := newProvider(t, idp, tt.edit)
+		l := NewLogin()
+		code, state := login(t, idp, p, l)
+		if state != l.State {
+			t.Errorf("%s: sent back with state %q, want %q", tt.name, state, l.State)
+		}
+		id, err := p.Exchange(t.Context(), code, l)
+		if err != nil || id != (Identity{Subject: "1234", Username: "alice"}) {
+			t.Errorf("%s: got %+v, %v", tt.name, id, err)
+		}
+		// Codes are single-use.
+		if _, err := p.Exchange(t.Context(), code, l); err == nil {
+			t.Errorf("%s: a code was redeemed twice", tt.name)
+		}
+	}
+}
+
+func TestExchangePKCE(t *testing.T) {
+	t.Parallel()
+	idp := oauthtest.NewServer()
+	t.Cleanup(idp.Close)
+	idp.LogIn(oauthtest.User{Subject: "1234", Username: "alice"})
+	p := newProvider(t, idp, nil)
+
+	// Someone who intercepted the code can't redeem it without the
+	// verifier, which never left the forge.
+	l := NewLogin()
+	code, _ := login(t, idp, p, l)
+	stolen := l
+	stolen.Verifier = NewLogin().Verifier
+	if _, err := p.Exchange(t.Context(), code, stolen); err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Errorf("exchanging with the wrong verifier: got %v", err)
+	}
+}
+
+func TestIDToken(t *testing.T) {
+	t.Parallel()
+	idp := oauthtest.NewServer()
+	t.Cleanup(idp.Close)
+	idp.LogIn(oauthtest.User{Subject: "1234", Username: "alice"})
+	p := newProvider(t, idp, func(c *config.OAuthProvider) { c.UserinfoURL = "" })
+	userinfo := newProvider(t, idp, nil)
+
+	tests := []struct {
+		name string
+		edit func(claims map[string]any)
+		ok   bool
+	}{
+		{"valid", func(map[string]any) {}, true},
+		{"several audiences", func(c map[string]any) { c["aud"] = []string{"other", oauthtest.ClientID} }, true},
+		{"other audience", func(c map[string]any) { c["aud"] = "other" }, false},
+		{"other audiences", func(c map[string]any) { c["aud"] = []string{"other"} }, false},
+		{"no audience", func(c map[string]any) { delete(c, "aud") }, false},
+		{"other issuer", func(c map[string]any) { c["iss"] = "https://evil.example.org" }, false},
+		{"expired", func(c map[string]any) { c["exp"] = time.Now().Add(-time.Minute).Unix() }, false},
+		{"no expiry", func(c map[string]any) { delete(c, "exp") }, false},
+		{"other nonce", func(c map[string]any) { c["nonce"] = "replayed" }, false},
+		{"no nonce", func(c map[string]any) { delete(c, "nonce") }, false},
+	}
+	for _, tt := range tests {
+		idp.EditClaims(tt.edit)
+		l := NewLogin()
+		code, _ := login(t, idp, p, l)
+		_, err := p.Exchange(t.Context(), code, l)
+		if tt.ok && err != nil || !tt.ok && !errors.Is(err, ErrIDToken) {
+			t.Errorf("%s: got %v", tt.name, err)
+		}
+	}
+
+	// Userinfo must be about whom the ID token is about.
+	idp.EditClaims(func(c map[string]any) { c["sub"] = "5678" })
+	l := NewLogin()
+	code, _ := login(t, idp, userinfo, l)
+	if _, err := userinfo.Exchange(t.Context(), code, l); !errors.Is(err, ErrIDToken) {
+		t.Errorf("userinfo about someone else: got %v", err)
+	}
+
+	idp.EditClaims(func(c map[string]any) { c["sub"] = "" })
+	l = NewLogin()
+	code, _ = login(t, idp, p, l)
+	if _, err := p.Exchange(t.Context(), code, l); !errors.Is(err, ErrNoSubject) {
+		t.Errorf("no subject: got %v", err)
+	}
+}
+
+func TestIDTokenMalformed(t *testing.T) {
+	t.Parallel()
+	p, err := New("test", &config.OAuthProvider{
+		Issuer:       "https://id.example.org",
+		AuthURL:      "https://id.example.org/authorize",
+		TokenURL:     "https://id.example.org/token",
+		ClientID:     oauthtest.ClientID,
+		SubjectClaim: "sub",
+	}, root) //exhaustruct:ignore
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, raw := range []string{"", "a.b", "a.!!.c", "a." + "bm90IGpzb24" + ".c", "a.b.c.d"} {
+		if _, err := p.checkIDToken(raw, "nonce"); !errors.Is(err, ErrIDToken) {
+			t.Errorf("%q: got %v", raw, err)
+		}
+	}
+}
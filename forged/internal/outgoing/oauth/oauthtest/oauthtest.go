@@ -0,0 +1,242 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+
+// Package oauthtest provides a fake OpenID Connect identity provider, so
+// that logins through package oauth can be exercised without a real one.
+//
+// It checks what a strict provider would: the client's ID and secret, the
+// redirect URI, and the PKCE verifier against the challenge. Its ID tokens
+// are not signed, as forged doesn't check their signatures.
+package oauthtest
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.lindenii.runxiyu.org/forge/forged/internal/config"
+)
+
+// The provider knows one client only.
+const (
+	ClientID     = "forge"
+	ClientSecret = "forge-secret"
+)
+
+// User is someone the provider can log in.
+type User struct {
+	Subject  string
+	Username string
+}
+
+// Server is a fake identity provider.
+type Server struct {
+	srv *httptest.Server
+
+	mu     sync.Mutex
+	user   User
+	codes  map[string]grant
+	tokens map[string]User
+	claims func(map[string]any)
+}
+
+// grant is what an authorization code was issued for.
+type grant struct {
+	user        User
+	redirectURI string
+	challenge   string
+	nonce       string
+}
+
+// NewServer starts a provider, which logs nobody in until told to with
+// LogIn.
+func NewServer() *Server {
+	s := &Server{
+		codes:  map[string]grant{},
+		tokens: map[string]User{},
+	} //exhaustruct:ignore
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /authorize", s.authorize)
+	mux.HandleFunc("POST /token", s.token)
+	mux.HandleFunc("GET /userinfo", s.userinfo)
+	s.srv = httptest.NewServer(mux)
+	return s
+}
+
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Config returns the configuration of a provider that is this one.
+func (s *Server) Config() config.OAuthProvider {
+	return config.OAuthProvider{
+		Name:          "Test",
+		Issuer:        s.srv.URL,
+		AuthURL:       s.srv.URL + "/authorize",
+		TokenURL:      s.srv.URL + "/token",
+		UserinfoURL:   s.srv.URL + "/userinfo",
+		ClientID:      ClientID,
+		ClientSecret:  ClientSecret,
+		Scopes:        "openid profile",
+		SubjectClaim:  "sub",
+		UsernameClaim: "preferred_username",
+	} //exhaustruct:ignore
+}
+
+// LogIn makes the provider log in user at its authorization endpoint, as
+// if they had entered their credentials there.
+func (s *Server) LogIn(user User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.user = user
+}
+
+// EditClaims makes the provider pass the claims of each ID token it issues
+// through edit first, to issue invalid ones.
+func (s *Server) EditClaims(edit func(claims map[string]any)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.claims = edit
+}
+
+// Authorize visits authURL, where the client sent the user, and returns
+// where the provider sends them back to.
+func (s *Server) Authorize(authURL string) (string, error) {
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	} //exhaustruct:ignore
+	resp, err := client.Get(authURL) //nolint:noctx
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusFound {
+		return "", fmt.Errorf("authorize: status %s", resp.Status)
+	}
+	return resp.Header.Get("Location"), nil
+}
+
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	switch {
+	case q.Get("response_type") != "code":
+		http.Error(w, "unsupported response_type", http.StatusBadRequest)
+		return
+	case q.Get("client_id") != ClientID:
+		http.Error(w, "unknown client", http.StatusBadRequest)
+		return
+	case q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "":
+		http.Error(w, "PKCE is required", http.StatusBadRequest)
+		return
+	}
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || !redirect.IsAbs() {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	code := rand.Text()
+	s.codes[code] = grant{
+		user:        s.user,
+		redirectURI: q.Get("redirect_uri"),
+		challenge:   q.Get("code_challenge"),
+		nonce:       q.Get("nonce"),
+	}
+	s.mu.Unlock()
+
+	v := redirect.Query()
+	v.Set("code", code)
+	v.Set("state", q.Get("state"))
+	redirect.RawQuery = v.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, "invalid_request")
+		return
+	}
+	if r.PostForm.Get("client_id") != ClientID || r.PostForm.Get("client_secret") != ClientSecret {
+		tokenError(w, "invalid_client")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, "unsupported_grant_type")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Codes are single-use, even if redeeming them fails.
+	g, ok := s.codes[r.PostForm.Get("code")]
+	delete(s.codes, r.PostForm.Get("code"))
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || r.PostForm.Get("redirect_uri") != g.redirectURI ||
+		base64.RawURLEncoding.EncodeToString(verifier[:]) != g.challenge {
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	claims := map[string]any{
+		"iss":                s.srv.URL,
+		"aud":                ClientID,
+		"sub":                g.user.Subject,
+		"preferred_username": g.user.Username,
+		"iat":                time.Now().Unix(),
+		"exp":                time.Now().Add(time.Hour).Unix(),
+	}
+	if g.nonce != "" {
+		claims["nonce"] = g.nonce
+	}
+	if s.claims != nil {
+		s.claims(claims)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		tokenError(w, "server_error")
+		return
+	}
+	accessToken := rand.Text()
+	s.tokens[accessToken] = g.user
+	writeJSON(w, http.StatusOK, map[string]string{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"id_token": base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." +
+			base64.RawURLEncoding.EncodeToString(payload) + ".",
+	})
+}
+
+func (s *Server) userinfo(w http.ResponseWriter, r *http.Request) {
+	accessToken, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	s.mu.Lock()
+	user, known := s.tokens[accessToken]
+	s.mu.Unlock()
+	if !ok || !known {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{
+		"sub":                user.Subject,
+		"preferred_username": user.Username,
+	})
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
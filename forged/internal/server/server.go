@@ -4,25 +4,33 @@ import (
 	"context"
 	"fmt"
 
+	"go.lindenii.runxiyu.org/forge/forged/internal/common/argon2id"
 	"go.lindenii.runxiyu.org/forge/forged/internal/config"
 	"go.lindenii.runxiyu.org/forge/forged/internal/database"
 	"go.lindenii.runxiyu.org/forge/forged/internal/database/queries"
 	"go.lindenii.runxiyu.org/forge/forged/internal/global"
 	"go.lindenii.runxiyu.org/forge/forged/internal/incoming/hooks"
 	"go.lindenii.runxiyu.org/forge/forged/internal/incoming/lmtp"
+	"go.lindenii.runxiyu.org/forge/forged/internal/incoming/pprof"
 	"go.lindenii.runxiyu.org/forge/forged/internal/incoming/ssh"
 	"go.lindenii.runxiyu.org/forge/forged/internal/incoming/web"
+	"go.lindenii.runxiyu.org/forge/forged/internal/outgoing/federation"
+	"go.lindenii.runxiyu.org/forge/forged/internal/outgoing/mail"
+	"go.lindenii.runxiyu.org/forge/forged/internal/outgoing/oauth"
+	"go.lindenii.runxiyu.org/forge/forged/internal/storage"
+	"go.lindenii.runxiyu.org/forge/forged/internal/viewcache"
 	"golang.org/x/sync/errgroup"
 )
 
 type Server struct {
 	config config.Config
 
-	database   database.Database
-	hookServer *hooks.Server
-	lmtpServer *lmtp.Server
-	webServer  *web.Server
-	sshServer  *ssh.Server
+	database    database.Database
+	hookServer  *hooks.Server
+	lmtpServer  *lmtp.Server
+	webServer   *web.Server
+	sshServer   *ssh.Server
+	pprofServer *pprof.Server
 
 	global global.Global
 }
@@ -41,10 +49,54 @@ func New(configPath string) (server *Server, err error) {
 	server.global.ForgeTitle = server.config.General.Title
 	server.global.Config = &server.config
 	server.global.Queries = queries
+	switch server.config.General.Registration {
+	case config.RegistrationOpen, config.RegistrationInvite, config.RegistrationClosed:
+	default:
+		return server, fmt.Errorf("unknown registration mode %q", server.config.General.Registration)
+	}
+	auth := server.config.Auth
+	// Argon2 needs at least 8 KiB of memory per thread.
+	if auth.Argon2Iterations == 0 || auth.Argon2Parallelism == 0 || auth.Argon2Memory < 8*uint32(auth.Argon2Parallelism) {
+		return server, fmt.Errorf("invalid Argon2id parameters m=%d,t=%d,p=%d", auth.Argon2Memory, auth.Argon2Iterations, auth.Argon2Parallelism)
+	}
+	server.global.PasswordParams = &argon2id.Params{
+		Memory:      auth.Argon2Memory,
+		Iterations:  auth.Argon2Iterations,
+		Parallelism: auth.Argon2Parallelism,
+		SaltLength:  argon2id.DefaultParams.SaltLength,
+		KeyLength:   argon2id.DefaultParams.KeyLength,
+	}
+	server.global.Mail = mail.New(&server.config.SMTP)
+	server.global.OAuth = make(map[string]*oauth.Provider, len(server.config.OAuth))
+	for name, cfg := range server.config.OAuth {
+		server.global.OAuth[name], err = oauth.New(name, &cfg, server.config.Web.Root)
+		if err != nil {
+			return server, fmt.Errorf("set up OAuth: %w", err)
+		}
+	}
+	server.global.Federation, err = federation.New(&server.config.Federation, queries)
+	if err != nil {
+		return server, fmt.Errorf("set up federation: %w", err)
+	}
+	// Both are stored in federated_identities by service name.
+	for _, name := range server.global.Federation.Services() {
+		if _, ok := server.global.OAuth[name]; ok {
+			return server, fmt.Errorf("%s is both an OAuth provider and a federation service", name)
+		}
+	}
+	server.global.Storage, err = storage.New(&server.config.Git)
+	if err != nil {
+		return server, fmt.Errorf("set up storage nodes: %w", err)
+	}
+	server.global.ViewCache, err = viewcache.New(&server.config.ViewCache, queries)
+	if err != nil {
+		return server, fmt.Errorf("set up view cache: %w", err)
+	}
 
 	server.hookServer = hooks.New(&server.global)
 	server.lmtpServer = lmtp.New(&server.global)
 	server.webServer = web.New(&server.global)
+	server.pprofServer = pprof.New(&server.global)
 	server.sshServer, err = ssh.New(&server.global)
 	if err != nil {
 		return server, fmt.Errorf("create SSH server: %w", err)
@@ -68,10 +120,20 @@ func (server *Server) Run(ctx context.Context) (err error) {
 	// TODO: neater way to do this for transactions in querypool?
 	server.global.DB = &server.database
 
+	err = server.global.Storage.Sync(gctx, server.global.Queries)
+	if err != nil {
+		return fmt.Errorf("sync storage nodes: %w", err)
+	}
+
 	g.Go(func() error { return server.hookServer.Run(gctx) })
 	g.Go(func() error { return server.lmtpServer.Run(gctx) })
 	g.Go(func() error { return server.webServer.Run(gctx) })
+	g.Go(func() error { return server.webServer.PruneSessions(gctx) })
 	g.Go(func() error { return server.sshServer.Run(gctx) })
+	g.Go(func() error { return server.pprofServer.Run(gctx) })
+	g.Go(func() error { return server.global.Storage.Run(gctx) })
+	g.Go(func() error { return server.global.ViewCache.Run(gctx) })
+	g.Go(func() error { return server.global.Federation.Run(gctx) })
 
 	err = g.Wait()
 	if err != nil {
@@ -85,3 +147,20 @@ func (server *Server) Run(ctx context.Context) (err error) {
 
 	return nil
 }
+
+// MigrateRepo moves a repository to another storage node while the rest of
+// the forge keeps running, and returns the path of the copy left on the old
+// node.
+func (server *Server) MigrateRepo(ctx context.Context, repoID int64, node string) (string, error) {
+	db, err := database.Open(ctx, server.config.DB.Conn)
+	if err != nil {
+		return "", fmt.Errorf("open database: %w", err)
+	}
+	defer db.Close()
+
+	q := queries.New(&db)
+	if err := server.global.Storage.Sync(ctx, q); err != nil {
+		return "", fmt.Errorf("sync storage nodes: %w", err)
+	}
+	return server.global.Storage.Migrate(ctx, &db, q, repoID, node, server.config.Hooks.Execs)
+}
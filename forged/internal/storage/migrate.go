@@ -0,0 +1,134 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/jackc/pgx/v5"
+	"go.lindenii.runxiyu.org/forge/forged/internal/database"
+	"go.lindenii.runxiyu.org/forge/forged/internal/database/queries"
+	"go.lindenii.runxiyu.org/forge/forged/internal/ipc/git2c"
+)
+
+// unlockedRounds is how many times Migrate copies a repository before
+// locking it; each round after the first only copies what changed during
+// the previous one, leaving little for the locked round.
+const unlockedRounds = 2
+
+var (
+	errSameNode        = errors.New("repository is already on that storage node")
+	errConcurrentMove  = errors.New("repository was moved by someone else during migration")
+	errDestinationNode = errors.New("cannot migrate to a draining storage node")
+)
+
+// Migrate copies a repository to another node and switches it there while
+// it remains available: it copies the repository while it may still
+// change, then locks its row, copies whatever changed in the meantime and
+// switches the mapping in the same transaction. The only writes forged
+// makes to repositories are their creation, which is done before their rows
+// are committed and thus before Migrate can see them; anything that writes
+// to existing repositories must hold their rows FOR SHARE while doing so,
+// or the final copy may miss its writes.
+//
+// The copy on the old node is left in place, as requests that looked up
+// the mapping just before the switch may still be reading it. It is
+// recorded in retired_repo_copies, and its path returned, so that it can
+// be removed later. A repository moved back to a node it once left is
+// copied over what it left there, which then stops being recorded.
+func (s *Storage) Migrate(ctx context.Context, db *database.Database, q *queries.Queries, repoID int64, to, hooksPath string) (string, error) {
+	fromName, err := q.GetRepoStorageNode(ctx, repoID)
+	if err != nil {
+		return "", fmt.Errorf("get storage node of repo %d: %w", repoID, err)
+	}
+	if fromName == to {
+		return "", errSameNode
+	}
+	from, err := s.Node(fromName)
+	if err != nil {
+		return "", err
+	}
+	dest, err := s.Node(to)
+	if err != nil {
+		return "", err
+	}
+	if dest.Draining {
+		return "", errDestinationNode
+	}
+	srcPath, destPath := from.RepoPath(repoID), dest.RepoPath(repoID)
+
+	src, err := from.Client(ctx)
+	if err != nil {
+		return "", fmt.Errorf("connect to %q: %w", from.Name, err)
+	}
+	defer func() { _ = src.Close() }()
+	dst, err := dest.Client(ctx)
+	if err != nil {
+		return "", fmt.Errorf("connect to %q: %w", dest.Name, err)
+	}
+	defer func() { _ = dst.Close() }()
+
+	if err := dst.InitRepo(ctx, destPath, hooksPath); err != nil {
+		return "", fmt.Errorf("init repo on %q: %w", dest.Name, err)
+	}
+
+	var have []string
+	for round := range unlockedRounds {
+		if have, err = copyRepo(ctx, src, dst, srcPath, destPath, have); err != nil {
+			return "", err
+		}
+		slog.Info("copied repo", "repo", repoID, "from", from.Name, "to", dest.Name, "round", round+1)
+	}
+
+	tx, err := db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return "", fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	txq := q.WithTx(tx)
+
+	current, err := txq.LockRepoStorageNode(ctx, repoID)
+	if err != nil {
+		return "", fmt.Errorf("lock repo %d: %w", repoID, err)
+	}
+	if current != fromName {
+		return "", errConcurrentMove
+	}
+	if _, err = copyRepo(ctx, src, dst, srcPath, destPath, have); err != nil {
+		return "", err
+	}
+	if err := txq.SetRepoStorageNode(ctx, queries.SetRepoStorageNodeParams{ID: repoID, StorageNode: to}); err != nil {
+		return "", fmt.Errorf("set storage node of repo %d: %w", repoID, err)
+	}
+	if err := txq.RetireRepoCopy(ctx, queries.RetireRepoCopyParams{StorageNode: fromName, Path: srcPath}); err != nil {
+		return "", fmt.Errorf("record old copy of repo %d: %w", repoID, err)
+	}
+	if err := txq.UnretireRepoCopy(ctx, queries.UnretireRepoCopyParams{StorageNode: to, Path: destPath}); err != nil {
+		return "", fmt.Errorf("unrecord new copy of repo %d: %w", repoID, err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return "", fmt.Errorf("commit tx: %w", err)
+	}
+	return srcPath, nil
+}
+
+// copyRepo copies the objects and references of src's repository that dst
+// doesn't have, given the IDs it was last copied with, and returns the IDs
+// dst now has.
+func copyRepo(ctx context.Context, src, dst *git2c.Client, srcPath, dstPath string, have []string) ([]string, error) {
+	pack, err := src.Pack(ctx, srcPath, have)
+	if err != nil {
+		return nil, fmt.Errorf("pack: %w", err)
+	}
+	if err := dst.Import(ctx, dstPath, pack); err != nil {
+		return nil, fmt.Errorf("import: %w", err)
+	}
+	for _, ref := range pack.Refs {
+		have = append(have, ref.ID)
+	}
+	return have, nil
+}
@@ -0,0 +1,103 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+
+package storage
+
+import (
+	"slices"
+	"testing"
+
+	"go.lindenii.runxiyu.org/forge/forged/internal/config"
+	"go.lindenii.runxiyu.org/forge/forged/internal/database"
+	"go.lindenii.runxiyu.org/forge/forged/internal/database/dbtest"
+	"go.lindenii.runxiyu.org/forge/forged/internal/ipc/git2c/git2dtest"
+)
+
+func newServer(t *testing.T) *git2dtest.Server {
+	t.Helper()
+	srv, err := git2dtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = srv.Close() })
+	return srv
+}
+
+// retiredCopies lists retired_repo_copies as node:path.
+func retiredCopies(t *testing.T, db *database.Database) []string {
+	t.Helper()
+	rows, err := db.Query(t.Context(), "SELECT storage_node || ':' || path FROM retired_repo_copies ORDER BY 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var copies []string
+	for rows.Next() {
+		var c string
+		if err := rows.Scan(&c); err != nil {
+			t.Fatal(err)
+		}
+		copies = append(copies, c)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return copies
+}
+
+// TestMigrateRoundTrip moves a repository away from a node and back, which
+// must not leave its live copy recorded as retired.
+func TestMigrateRoundTrip(t *testing.T) {
+	t.Parallel()
+	db, q := dbtest.New(t)
+	ctx := t.Context()
+	a, b := newServer(t), newServer(t)
+
+	//exhaustruct:ignore
+	s, err := New(&config.Git{
+		RepoDir: "/a",
+		Socket:  a.SocketPath(),
+		Nodes:   map[string]config.StorageNode{"b": {RepoDir: "/b", Socket: b.SocketPath()}}, //exhaustruct:ignore
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	if err := s.Sync(ctx, q); err != nil {
+		t.Fatal(err)
+	}
+
+	var groupID, repoID int64
+	if err := db.QueryRow(ctx, "INSERT INTO groups (name) VALUES ('g') RETURNING id").Scan(&groupID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow(ctx, "INSERT INTO repos (group_id, name, contrib_requirements, storage_node) VALUES ($1, 'r', 'open', $2) RETURNING id",
+		groupID, DefaultNode).Scan(&repoID); err != nil {
+		t.Fatal(err)
+	}
+	nodeA, _ := s.Node(DefaultNode)
+	nodeB, _ := s.Node("b")
+	pathA, pathB := nodeA.RepoPath(repoID), nodeB.RepoPath(repoID)
+	repo := git2dtest.Fixture()
+	a.AddRepo(pathA, repo)
+
+	if old, err := s.Migrate(ctx, db, q, repoID, "b", ""); err != nil || old != pathA {
+		t.Fatalf("migrate to b: %q, %v", old, err)
+	}
+	if got, want := retiredCopies(t, db), []string{DefaultNode + ":" + pathA}; !slices.Equal(got, want) {
+		t.Errorf("after moving to b, retired %v, want %v", got, want)
+	}
+	if b.Repo(pathB).Ref("refs/heads/master") != repo.Ref("refs/heads/master") {
+		t.Error("b's copy lacks master")
+	}
+
+	if old, err := s.Migrate(ctx, db, q, repoID, DefaultNode, ""); err != nil || old != pathB {
+		t.Fatalf("migrate back: %q, %v", old, err)
+	}
+	if got, want := retiredCopies(t, db), []string{"b:" + pathB}; !slices.Equal(got, want) {
+		t.Errorf("after moving back, retired %v, want %v", got, want)
+	}
+	node, err := q.GetRepoStorageNode(ctx, repoID)
+	if err != nil || node != DefaultNode {
+		t.Errorf("repo is on %q, %v", node, err)
+	}
+}
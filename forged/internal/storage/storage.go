@@ -0,0 +1,205 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+
+// Package storage maps repositories to storage nodes, each a git2d
+// instance with its own repository directory, places new repositories on
+// them and moves repositories between them.
+package storage
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"go.lindenii.runxiyu.org/forge/forged/internal/config"
+	"go.lindenii.runxiyu.org/forge/forged/internal/database/queries"
+	"go.lindenii.runxiyu.org/forge/forged/internal/ipc/git2c"
+	"golang.org/x/sync/errgroup"
+)
+
+// DefaultNode names the node configured directly in the git block.
+const DefaultNode = "default"
+
+var (
+	ErrUnknownNode     = errors.New("unknown storage node")
+	errNoNodeAvailable = errors.New("every storage node is draining")
+)
+
+// Node is a git2d instance and the directory its repositories are in, as
+// seen by that git2d.
+type Node struct {
+	Name    string
+	RepoDir string
+	// Weight scales the share of new repositories placed on the node.
+	Weight uint
+	// Draining nodes get no new repositories.
+	Draining bool
+
+	pool *git2c.Pool
+}
+
+// RepoPath is the path of a repository on the node.
+func (n *Node) RepoPath(repoID int64) string {
+	return filepath.Join(n.RepoDir, fmt.Sprintf("%d.git", repoID))
+}
+
+// Client returns a client for the node's git2d, which must be closed.
+func (n *Node) Client(ctx context.Context) (*git2c.Client, error) {
+	return n.pool.Client(ctx)
+}
+
+// Storage is the set of configured storage nodes.
+type Storage struct {
+	nodes map[string]*Node
+	names []string // sorted, so that placement ties are broken consistently
+}
+
+// New sets up connection pools for the default node and those in cfg's
+// nodes block.
+func New(cfg *config.Git) (*Storage, error) {
+	s := &Storage{nodes: make(map[string]*Node)} //exhaustruct:ignore
+
+	nodes := map[string]config.StorageNode{DefaultNode: {
+		RepoDir:  cfg.RepoDir,
+		Socket:   cfg.Socket,
+		TLSCert:  cfg.TLSCert,
+		TLSKey:   cfg.TLSKey,
+		TLSCA:    cfg.TLSCA,
+		Weight:   cfg.Weight,
+		Draining: cfg.Draining,
+	}}
+	for name, nc := range cfg.Nodes {
+		if name == DefaultNode {
+			return nil, fmt.Errorf("storage node %q is the git block itself", name)
+		}
+		nodes[name] = nc
+	}
+	for name, nc := range nodes {
+		var tlsConfig *tls.Config
+		if strings.HasPrefix(nc.Socket, git2c.TLSScheme) {
+			var err error
+			tlsConfig, err = git2c.LoadTLSConfig(nc.TLSCert, nc.TLSKey, nc.TLSCA)
+			if err != nil {
+				return nil, fmt.Errorf("storage node %q: %w", name, err)
+			}
+		}
+		weight := nc.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		s.nodes[name] = &Node{
+			Name:     name,
+			RepoDir:  nc.RepoDir,
+			Weight:   weight,
+			Draining: nc.Draining,
+			pool: git2c.NewPool(git2c.PoolConfig{
+				Address:             nc.Socket,
+				TLS:                 tlsConfig,
+				MaxConns:            cfg.MaxConns,
+				MaxIdle:             cfg.MaxIdle,
+				IdleTimeout:         time.Duration(cfg.IdleTimeout) * time.Second,
+				HealthCheckInterval: time.Duration(cfg.HealthCheckInterval) * time.Second,
+			}),
+		}
+		s.names = append(s.names, name)
+	}
+	slices.Sort(s.names)
+	return s, nil
+}
+
+// Node returns the node with the given name.
+func (s *Storage) Node(name string) (*Node, error) {
+	n, ok := s.nodes[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownNode, name)
+	}
+	return n, nil
+}
+
+// Client returns a client for the node holding a repository, which must be
+// closed, and the repository's path on that node.
+func (s *Storage) Client(ctx context.Context, node string, repoID int64) (*git2c.Client, string, error) {
+	n, err := s.Node(node)
+	if err != nil {
+		return nil, "", err
+	}
+	client, err := n.Client(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	return client, n.RepoPath(repoID), nil
+}
+
+// Sync records the configured nodes in the database, so that repositories
+// may be placed on them, and warns about repositories on nodes that are no
+// longer configured.
+func (s *Storage) Sync(ctx context.Context, q *queries.Queries) error {
+	for _, name := range s.names {
+		if err := q.InsertStorageNode(ctx, name); err != nil {
+			return fmt.Errorf("insert storage node %q: %w", name, err)
+		}
+	}
+	counts, err := q.CountReposByStorageNode(ctx)
+	if err != nil {
+		return fmt.Errorf("count repos by storage node: %w", err)
+	}
+	for _, c := range counts {
+		if _, ok := s.nodes[c.StorageNode]; !ok {
+			slog.Warn("repos are on an unconfigured storage node", "node", c.StorageNode, "repos", c.Count)
+		}
+	}
+	return nil
+}
+
+// Place chooses the node for a new repository: of those not draining, the
+// one with the fewest repositories for its weight.
+func (s *Storage) Place(ctx context.Context, q *queries.Queries) (*Node, error) {
+	rows, err := q.CountReposByStorageNode(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("count repos by storage node: %w", err)
+	}
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.StorageNode] = row.Count
+	}
+
+	var best *Node
+	var bestLoad float64
+	for _, name := range s.names {
+		n := s.nodes[name]
+		if n.Draining {
+			continue
+		}
+		load := float64(counts[name]) / float64(n.Weight)
+		if best == nil || load < bestLoad {
+			best, bestLoad = n, load
+		}
+	}
+	if best == nil {
+		return nil, errNoNodeAvailable
+	}
+	return best, nil
+}
+
+// Run performs the health checks of every node's connection pool until ctx
+// is done.
+func (s *Storage) Run(ctx context.Context) error {
+	g, gctx := errgroup.WithContext(ctx)
+	for _, n := range s.nodes {
+		g.Go(func() error { return n.pool.Run(gctx) })
+	}
+	return g.Wait()
+}
+
+// Close closes every node's connection pool.
+func (s *Storage) Close() {
+	for _, n := range s.nodes {
+		n.pool.Close()
+	}
+}
@@ -0,0 +1,110 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+
+package viewcache
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"go.lindenii.runxiyu.org/forge/forged/internal/database/queries"
+)
+
+type pgBacking struct {
+	q *queries.Queries
+}
+
+func (b *pgBacking) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := b.q.GetCachedView(ctx, key)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (b *pgBacking) Put(ctx context.Context, key string, value []byte) error {
+	return b.q.PutCachedView(ctx, queries.PutCachedViewParams{Key: key, Value: value})
+}
+
+func (b *pgBacking) Prune(ctx context.Context, before time.Time) error {
+	return b.q.PruneCachedViews(ctx, pgtype.Timestamptz{Time: before, Valid: true}) //exhaustruct:ignore
+}
+
+// diskBacking keeps each view in a file named by its key, under a
+// subdirectory named by the key's first two characters. Modification times
+// record when views were last used.
+type diskBacking struct {
+	dir string
+}
+
+func (b *diskBacking) path(key string) string {
+	return filepath.Join(b.dir, key[:2], key)
+}
+
+func (b *diskBacking) Get(_ context.Context, key string) ([]byte, bool, error) {
+	path := b.path(key)
+	value, err := os.ReadFile(path) //#nosec G304
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+	return value, true, nil
+}
+
+// Put writes to a temporary file first so that concurrent readers never
+// see a partial view.
+func (b *diskBacking) Put(_ context.Context, key string, value []byte) error {
+	path := b.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+	if _, err := f.Write(value); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (b *diskBacking) Prune(ctx context.Context, before time.Time) error {
+	return filepath.WalkDir(b.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil //nolint:nilerr // removed concurrently
+		}
+		if info.ModTime().Before(before) {
+			_ = os.Remove(path)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,189 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+
+// Package viewcache caches expensive views of repositories, such as logs,
+// trees and diffs, keyed by the commits and trees they show. As these never
+// change, entries need no invalidation other than eviction; only the
+// resolution of branches to commits expires, see [Cache.Resolve].
+package viewcache
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"go.lindenii.runxiyu.org/forge/forged/internal/common/cmap"
+	"go.lindenii.runxiyu.org/forge/forged/internal/config"
+	"go.lindenii.runxiyu.org/forge/forged/internal/database/queries"
+)
+
+// Metrics are published through expvar, which the pprof listener serves at
+// /debug/vars.
+var metrics = expvar.NewMap("view_cache")
+
+// Backing is a larger, slower store behind the in-memory cache.
+type Backing interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Put(ctx context.Context, key string, value []byte) error
+	// Prune removes entries unused since before.
+	Prune(ctx context.Context, before time.Time) error
+}
+
+type entry struct {
+	key   string
+	value any
+	size  int64
+}
+
+// Cache is an LRU of views bounded by their encoded size, optionally backed
+// by a [Backing].
+type Cache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     list.List // front is most recently used
+	size    int64
+	maxSize int64
+
+	backing Backing
+	maxAge  time.Duration
+
+	refs   cmap.Map[refKey, resolved]
+	refTTL time.Duration
+}
+
+// New creates a cache as configured. Queries is only used for PostgreSQL
+// backing.
+func New(cfg *config.ViewCache, q *queries.Queries) (*Cache, error) {
+	c := &Cache{
+		entries: make(map[string]*list.Element),
+		maxSize: cfg.MaxSize,
+		maxAge:  time.Duration(cfg.MaxAge) * time.Second,
+		refTTL:  time.Duration(cfg.RefTTL) * time.Second,
+	} //exhaustruct:ignore
+	switch cfg.Backing {
+	case "", "none":
+	case "postgres":
+		c.backing = &pgBacking{q: q}
+	case "disk":
+		if cfg.Dir == "" {
+			return nil, fmt.Errorf("view cache: disk backing needs a dir")
+		}
+		c.backing = &diskBacking{dir: cfg.Dir}
+	default:
+		return nil, fmt.Errorf("view cache: unknown backing %q", cfg.Backing)
+	}
+	return c, nil
+}
+
+// Key identifies a view of a repository. Parts should be resolved object
+// IDs and whatever else the view depends on, never branch names.
+func Key(repoID int64, view string, parts ...string) string {
+	return fmt.Sprintf("%d\x00%s\x00%s", repoID, view, strings.Join(parts, "\x00"))
+}
+
+// Get returns the view stored under key, computing and storing it if
+// there is none. Views must survive a round trip through encoding/json.
+func Get[T any](ctx context.Context, c *Cache, key string, compute func() (T, error)) (T, error) {
+	if v, ok := c.load(key); ok {
+		if t, ok := v.(T); ok {
+			metrics.Add("hits", 1)
+			return t, nil
+		}
+	}
+
+	if c.backing != nil {
+		raw, ok, err := c.backing.Get(ctx, backingKey(key))
+		if err != nil {
+			slog.Warn("view cache backing get failed", "error", err)
+		} else if ok {
+			var t T
+			if err := json.Unmarshal(raw, &t); err == nil {
+				metrics.Add("backing_hits", 1)
+				c.store(key, t, int64(len(raw)))
+				return t, nil
+			}
+		}
+	}
+
+	metrics.Add("misses", 1)
+	t, err := compute()
+	if err != nil {
+		return t, err
+	}
+	raw, err := json.Marshal(t)
+	if err != nil {
+		return t, fmt.Errorf("encode view: %w", err)
+	}
+	c.store(key, t, int64(len(raw)))
+	if c.backing != nil {
+		if err := c.backing.Put(ctx, backingKey(key), raw); err != nil {
+			slog.Warn("view cache backing put failed", "error", err)
+		}
+	}
+	return t, nil
+}
+
+func backingKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *Cache) load(key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return el.Value.(*entry).value, true
+}
+
+func (c *Cache) store(key string, value any, size int64) {
+	if size > c.maxSize {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.size -= el.Value.(*entry).size
+		c.lru.Remove(el)
+	}
+	c.entries[key] = c.lru.PushFront(&entry{key: key, value: value, size: size})
+	c.size += size
+	for c.size > c.maxSize {
+		el := c.lru.Back()
+		e := el.Value.(*entry)
+		c.lru.Remove(el)
+		delete(c.entries, e.key)
+		c.size -= e.size
+		metrics.Add("evictions", 1)
+	}
+}
+
+// Run prunes the backing store hourly until ctx is done.
+func (c *Cache) Run(ctx context.Context) error {
+	if c.backing == nil || c.maxAge == 0 {
+		return nil
+	}
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		if err := c.backing.Prune(ctx, time.Now().Add(-c.maxAge)); err != nil && ctx.Err() == nil {
+			slog.Warn("view cache prune failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
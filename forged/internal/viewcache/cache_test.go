@@ -0,0 +1,112 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+
+package viewcache
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"go.lindenii.runxiyu.org/forge/forged/internal/config"
+)
+
+func newTestCache(t *testing.T, maxSize int64) *Cache {
+	t.Helper()
+	//exhaustruct:ignore
+	c, err := New(&config.ViewCache{MaxSize: maxSize}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// keys lists the cached keys, most recently used first.
+func (c *Cache) keys() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var keys []string
+	for el := c.lru.Front(); el != nil; el = el.Next() {
+		keys = append(keys, el.Value.(*entry).key)
+	}
+	return keys
+}
+
+func TestEviction(t *testing.T) {
+	t.Parallel()
+	// Each step is "key=size" to store or "key" to load; sizes are bytes.
+	tests := []struct {
+		name  string
+		steps []string
+		want  []string
+	}{
+		{"fits", []string{"a=3", "b=3", "c=4"}, []string{"c", "b", "a"}},
+		{"evicts oldest", []string{"a=4", "b=4", "c=4"}, []string{"c", "b"}},
+		{"load refreshes", []string{"a=4", "b=4", "a", "c=4"}, []string{"c", "a"}},
+		{"evicts several", []string{"a=3", "b=3", "c=3", "d=9"}, []string{"d"}},
+		{"too large", []string{"a=3", "b=11"}, []string{"a"}},
+		{"exactly full", []string{"a=10"}, []string{"a"}},
+		{"replace", []string{"a=4", "b=4", "a=6"}, []string{"a", "b"}},
+		{"replace grows", []string{"a=4", "b=4", "a=7"}, []string{"a"}},
+		{"missing", []string{"a=4", "x"}, []string{"a"}},
+	}
+	for _, tt := range tests {
+		c := newTestCache(t, 10)
+		for _, step := range tt.steps {
+			if key, size, ok := strings.Cut(step, "="); ok {
+				n, err := strconv.ParseInt(size, 10, 64)
+				if err != nil {
+					t.Fatal(err)
+				}
+				c.store(key, key, n)
+			} else {
+				c.load(key)
+			}
+		}
+		if got := c.keys(); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: cached %v, want %v", tt.name, got, tt.want)
+		}
+		var size int64
+		for _, el := range c.entries {
+			size += el.Value.(*entry).size
+		}
+		if size != c.size || c.size > c.maxSize {
+			t.Errorf("%s: size %d, entries total %d, max %d", tt.name, c.size, size, c.maxSize)
+		}
+	}
+}
+
+func TestGet(t *testing.T) {
+	t.Parallel()
+	c := newTestCache(t, 1<<20)
+	ctx := t.Context()
+	calls := 0
+	compute := func() ([]string, error) {
+		calls++
+		return []string{"a", "b"}, nil
+	}
+	for range 2 {
+		got, err := Get(ctx, c, Key(1, "log", "abc"), compute)
+		if err != nil || !slices.Equal(got, []string{"a", "b"}) {
+			t.Fatalf("Get = %v, %v", got, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("computed %d times, want once", calls)
+	}
+
+	errCompute := errors.New("compute failed")
+	for range 2 {
+		if _, err := Get(ctx, c, Key(1, "log", "def"), func() ([]string, error) {
+			calls++
+			return nil, errCompute
+		}); !errors.Is(err, errCompute) {
+			t.Errorf("Get = %v, want %v", err, errCompute)
+		}
+	}
+	if calls != 3 {
+		t.Errorf("failed views were cached")
+	}
+}
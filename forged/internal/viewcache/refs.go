@@ -0,0 +1,38 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+
+package viewcache
+
+import (
+	"context"
+	"time"
+)
+
+type refKey struct {
+	repoID  int64
+	refType string
+	refName string
+}
+
+type resolved struct {
+	oid string
+	at  time.Time
+}
+
+// Resolve returns the commit a ref of a repository points to, remembering
+// it until the configured ref_ttl passes. Nothing tells the cache about
+// pushes, so views of a branch may be that far behind it.
+func (c *Cache) Resolve(ctx context.Context, repoID int64, refType, refName string, resolve func(context.Context) (string, error)) (string, error) {
+	key := refKey{repoID: repoID, refType: refType, refName: refName}
+	if r, ok := c.refs.Load(key); ok && time.Since(r.at) < c.refTTL {
+		metrics.Add("ref_hits", 1)
+		return r.oid, nil
+	}
+	metrics.Add("ref_misses", 1)
+	oid, err := resolve(ctx)
+	if err != nil {
+		return "", err
+	}
+	c.refs.Store(key, resolved{oid: oid, at: time.Now()})
+	return oid, nil
+}
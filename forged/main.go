@@ -7,6 +7,9 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
+	"os"
+	"strconv"
 
 	"go.lindenii.runxiyu.org/forge/forged/internal/server"
 )
@@ -17,6 +20,10 @@ func main() {
 		"/etc/lindenii/forge.scfg",
 		"path to configuration file",
 	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-config path] [migrate-repo <repo id> <storage node>]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
 	s, err := server.New(*configPath)
@@ -24,5 +31,32 @@ func main() {
 		panic(err)
 	}
 
-	panic(s.Run(context.Background()))
+	switch flag.Arg(0) {
+	case "":
+		panic(s.Run(context.Background()))
+	case "migrate-repo":
+		migrateRepo(s, flag.Args()[1:])
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// migrateRepo moves a repository to another storage node.
+func migrateRepo(s *server.Server, args []string) {
+	if len(args) != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	repoID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid repo id %q\n", args[0])
+		os.Exit(2)
+	}
+	oldPath, err := s.MigrateRepo(context.Background(), repoID, args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "migrate repo %d: %v\n", repoID, err)
+		os.Exit(1)
+	}
+	fmt.Printf("repo %d is now on %s; its old copy at %s is recorded in retired_repo_copies and can be removed\n", repoID, args[1], oldPath)
 }
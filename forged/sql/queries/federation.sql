@@ -0,0 +1,42 @@
+-- name: InsertOAuthLogin :exec
+INSERT INTO oauth_logins (state_hash, provider, code_verifier, nonce, link_user, expires_at) VALUES ($1, $2, $3, $4, $5, $6);
+
+-- name: TakeOAuthLogin :one
+DELETE FROM oauth_logins WHERE state_hash = $1 AND expires_at > NOW()
+RETURNING provider, code_verifier, nonce, link_user;
+
+-- name: PruneOAuthLogins :execrows
+DELETE FROM oauth_logins WHERE expires_at <= NOW();
+
+-- name: GetFederatedUser :one
+SELECT user_id FROM federated_identities WHERE service = $1 AND remote_subject = $2;
+
+-- name: InsertFederatedIdentity :exec
+INSERT INTO federated_identities (user_id, service, remote_username, remote_subject) VALUES ($1, $2, $3, $4);
+
+-- name: UpdateFederatedUsername :exec
+UPDATE federated_identities SET remote_username = $3 WHERE service = $1 AND remote_subject = $2;
+
+-- name: GetFederatedIdentitiesByUser :many
+SELECT service, remote_username, verified_at IS NOT NULL AS verified, remote_subject IS NOT NULL AS has_subject FROM federated_identities WHERE user_id = $1 ORDER BY service;
+
+-- name: DeleteFederatedIdentity :exec
+DELETE FROM federated_identities WHERE user_id = $1 AND service = $2;
+
+-- name: InsertFederatedUser :one
+INSERT INTO users (username, type) VALUES ($1, 'federated') RETURNING id;
+
+-- name: UpsertVerifiedIdentity :exec
+INSERT INTO federated_identities (user_id, service, remote_username, key_string) VALUES ($1, $2, $3, $4)
+ON CONFLICT (user_id, service) DO UPDATE
+SET remote_username = EXCLUDED.remote_username, key_string = EXCLUDED.key_string, verified_at = NOW(), checked_at = NOW();
+
+-- name: GetIdentitiesToReverify :many
+SELECT user_id, service, remote_username, key_string::text AS key_string FROM federated_identities
+WHERE key_string IS NOT NULL AND checked_at < $1 ORDER BY checked_at LIMIT $2;
+
+-- name: MarkIdentityVerified :exec
+UPDATE federated_identities SET verified_at = NOW(), checked_at = NOW() WHERE user_id = $1 AND service = $2;
+
+-- name: MarkIdentityUnverified :exec
+UPDATE federated_identities SET verified_at = NULL, checked_at = NOW() WHERE user_id = $1 AND service = $2;
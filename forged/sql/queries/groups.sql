@@ -45,3 +45,19 @@ SELECT name, COALESCE(description, '') FROM repos WHERE group_id = $1;
 
 -- name: GetSubgroups :many
 SELECT name, COALESCE(description, '') FROM groups WHERE parent_group = $1;
+
+-- name: GetSubgroupsPage :many
+SELECT name, COALESCE(description, '') AS description
+FROM groups
+WHERE parent_group IS NOT DISTINCT FROM sqlc.narg(parent_group)::BIGINT
+	AND name > sqlc.arg(after)::TEXT
+ORDER BY name
+LIMIT sqlc.arg(max_count)::INT;
+
+-- name: GetReposInGroupPage :many
+SELECT name, COALESCE(description, '') AS description
+FROM repos
+WHERE group_id = sqlc.arg(group_id)
+	AND name > sqlc.arg(after)::TEXT
+ORDER BY name
+LIMIT sqlc.arg(max_count)::INT;
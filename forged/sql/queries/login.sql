@@ -2,7 +2,56 @@
 SELECT id, COALESCE(password_hash, '') FROM users WHERE username = $1;
 
 -- name: InsertSession :exec
-INSERT INTO sessions (user_id, token_hash, expires_at) VALUES ($1, $2, $3);
+INSERT INTO sessions (user_id, token_hash, expires_at, user_agent) VALUES ($1, $2, $3, $4);
 
 -- name: GetUserFromSession :one
-SELECT user_id, COALESCE(username, '') FROM users u JOIN sessions s ON u.id = s.user_id WHERE s.token_hash = $1;
+SELECT user_id, COALESCE(username, ''), expires_at FROM users u JOIN sessions s ON u.id = s.user_id WHERE s.token_hash = $1;
+
+-- name: DeleteSessionByToken :exec
+DELETE FROM sessions WHERE token_hash = $1;
+
+-- name: GetSessionsByUser :many
+SELECT session_id, created_at, expires_at, COALESCE(user_agent, '') AS user_agent, token_hash = $2 AS current
+FROM sessions WHERE user_id = $1 AND expires_at > NOW() ORDER BY created_at DESC;
+
+-- name: DeleteSession :exec
+DELETE FROM sessions WHERE session_id = $1 AND user_id = $2;
+
+-- name: PruneSessions :execrows
+DELETE FROM sessions WHERE expires_at <= NOW();
+
+-- name: InsertLoginAttempt :exec
+INSERT INTO login_attempts (username, user_id, ip, outcome) VALUES ($1, $2, $3, $4);
+
+-- name: InsertLoginAttemptByUser :exec
+INSERT INTO login_attempts (username, user_id, ip, outcome)
+SELECT COALESCE(username, ''), id, $2, $3 FROM users WHERE id = $1;
+
+-- name: LockLoginUsername :exec
+SELECT pg_advisory_xact_lock(1, hashtext(sqlc.arg(username)::text));
+
+-- name: LockLoginNetwork :exec
+SELECT pg_advisory_xact_lock(2, hashtext(sqlc.arg(network)::cidr::text));
+
+-- name: ReserveLoginAttempt :one
+INSERT INTO login_attempts (username, ip, outcome) VALUES ($1, $2, 'checking') RETURNING id;
+
+-- name: SettleLoginAttempt :exec
+UPDATE login_attempts SET user_id = $2, outcome = $3 WHERE id = $1;
+
+-- name: DeleteLoginAttempt :exec
+DELETE FROM login_attempts WHERE id = $1;
+
+-- name: GetUsernameLoginFailures :one
+SELECT COUNT(*) AS failures, MAX(attempted_at)::timestamptz AS last_failure
+FROM login_attempts
+WHERE username = $1 AND outcome NOT IN ('success', 'throttled') AND attempted_at > $2
+AND attempted_at > COALESCE((SELECT MAX(attempted_at) FROM login_attempts WHERE username = $1 AND outcome = 'success'), '-infinity');
+
+-- name: GetNetworkLoginFailures :one
+SELECT COUNT(*) AS failures, MAX(attempted_at)::timestamptz AS last_failure
+FROM login_attempts
+WHERE ip <<= sqlc.arg(network)::cidr AND outcome NOT IN ('success', 'throttled') AND attempted_at > sqlc.arg(since);
+
+-- name: PruneLoginAttempts :execrows
+DELETE FROM login_attempts WHERE attempted_at < $1;
@@ -0,0 +1,44 @@
+-- name: GetMergeRequestsPage :many
+WITH RECURSIVE group_paths(id, path) AS (
+	SELECT id, ARRAY[name]::TEXT[] FROM groups WHERE parent_group IS NULL
+	UNION ALL
+	SELECT g.id, gp.path || g.name FROM groups g JOIN group_paths gp ON g.parent_group = gp.id
+)
+SELECT
+	mr.repo_local_id,
+	mr.title,
+	mr.status::TEXT AS status,
+	u.username AS creator,
+	gp.path::TEXT[] AS source_group_path,
+	sr.name AS source_repo_name,
+	mr.source_ref,
+	mr.destination_branch
+FROM merge_requests mr
+JOIN repos sr ON sr.id = mr.source_repo
+JOIN group_paths gp ON gp.id = sr.group_id
+LEFT JOIN users u ON u.id = mr.creator
+WHERE mr.repo_id = sqlc.arg(repo_id)
+	AND mr.repo_local_id < sqlc.arg(before)::BIGINT
+ORDER BY mr.repo_local_id DESC
+LIMIT sqlc.arg(max_count)::INT;
+
+-- name: GetMergeRequest :one
+WITH RECURSIVE group_paths(id, path) AS (
+	SELECT id, ARRAY[name]::TEXT[] FROM groups WHERE parent_group IS NULL
+	UNION ALL
+	SELECT g.id, gp.path || g.name FROM groups g JOIN group_paths gp ON g.parent_group = gp.id
+)
+SELECT
+	mr.repo_local_id,
+	mr.title,
+	mr.status::TEXT AS status,
+	u.username AS creator,
+	gp.path::TEXT[] AS source_group_path,
+	sr.name AS source_repo_name,
+	mr.source_ref,
+	mr.destination_branch
+FROM merge_requests mr
+JOIN repos sr ON sr.id = mr.source_repo
+JOIN group_paths gp ON gp.id = sr.group_id
+LEFT JOIN users u ON u.id = mr.creator
+WHERE mr.repo_id = $1 AND mr.repo_local_id = $2;
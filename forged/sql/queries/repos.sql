@@ -1,9 +1,33 @@
 -- name: InsertRepo :one
-INSERT INTO repos (group_id, name, description, contrib_requirements)
-VALUES ($1, $2, $3, $4)
+INSERT INTO repos (group_id, name, description, contrib_requirements, storage_node)
+VALUES ($1, $2, $3, $4, $5)
 RETURNING id;
 
 -- name: GetRepoByGroupAndName :one
-SELECT id, name, COALESCE(description, '') AS description
+SELECT id, name, COALESCE(description, '') AS description, storage_node
 FROM repos
 WHERE group_id = $1 AND name = $2;
+
+-- name: GetRepoByIDForUser :one
+WITH RECURSIVE ancestors(id, parent_group, path) AS (
+	SELECT g.id, g.parent_group, ARRAY[g.name]::TEXT[]
+	FROM groups g JOIN repos r ON r.group_id = g.id
+	WHERE r.id = $1
+	UNION ALL
+	SELECT g.id, g.parent_group, g.name || a.path
+	FROM groups g JOIN ancestors a ON g.id = a.parent_group
+)
+SELECT
+	r.group_id,
+	(SELECT a.path FROM ancestors a WHERE a.parent_group IS NULL)::TEXT[] AS group_path,
+	r.name,
+	COALESCE(r.description, '') AS description,
+	r.storage_node,
+	EXISTS (
+		SELECT 1
+		FROM user_group_roles ugr
+		WHERE ugr.user_id = $2
+			AND ugr.group_id = r.group_id
+	) AS has_role
+FROM repos r
+WHERE r.id = $1;
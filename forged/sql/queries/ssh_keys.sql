@@ -0,0 +1,30 @@
+-- name: GetSSHPublicKeysByUser :many
+SELECT id, key_string, COALESCE(comment, '') AS comment, created_at, last_used_at
+FROM ssh_public_keys WHERE user_id = $1 ORDER BY created_at;
+
+-- name: GetSSHPublicKeyOwner :one
+SELECT k.user_id, u.type::text AS user_type
+FROM ssh_public_keys k JOIN users u ON u.id = k.user_id
+WHERE k.key_string = $1;
+
+-- name: InsertSSHPublicKey :exec
+INSERT INTO ssh_public_keys (user_id, key_string, comment) VALUES ($1, $2, $3);
+
+-- name: DeleteSSHPublicKey :exec
+DELETE FROM ssh_public_keys WHERE id = $1 AND user_id = $2;
+
+-- name: TouchSSHPublicKey :exec
+UPDATE ssh_public_keys SET last_used_at = NOW() WHERE key_string = $1;
+
+-- name: ReassignMergeRequests :exec
+UPDATE merge_requests SET creator = sqlc.arg(to_user) WHERE creator = sqlc.arg(from_user);
+
+-- name: ReassignSSHPublicKeys :exec
+UPDATE ssh_public_keys SET user_id = sqlc.arg(to_user) WHERE user_id = sqlc.arg(from_user);
+
+-- name: DeletePubkeyOnlyUser :exec
+DELETE FROM users WHERE id = $1 AND type = 'pubkey_only';
+
+-- name: GetSSHPublicKeysByUsername :many
+SELECT k.key_string FROM ssh_public_keys k JOIN users u ON u.id = k.user_id
+WHERE u.username = $1 ORDER BY k.created_at;
@@ -0,0 +1,21 @@
+-- name: InsertStorageNode :exec
+INSERT INTO storage_nodes (name) VALUES ($1) ON CONFLICT DO NOTHING;
+
+-- name: CountReposByStorageNode :many
+SELECT storage_node, count(*) FROM repos GROUP BY storage_node;
+
+-- name: GetRepoStorageNode :one
+SELECT storage_node FROM repos WHERE id = $1;
+
+-- name: LockRepoStorageNode :one
+SELECT storage_node FROM repos WHERE id = $1 FOR UPDATE;
+
+-- name: SetRepoStorageNode :exec
+UPDATE repos SET storage_node = $2 WHERE id = $1;
+
+-- name: RetireRepoCopy :exec
+INSERT INTO retired_repo_copies (storage_node, path) VALUES ($1, $2)
+ON CONFLICT (storage_node, path) DO UPDATE SET retired_at = NOW();
+
+-- name: UnretireRepoCopy :exec
+DELETE FROM retired_repo_copies WHERE storage_node = $1 AND path = $2;
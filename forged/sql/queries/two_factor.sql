@@ -0,0 +1,81 @@
+-- name: UserHasSecondFactor :one
+SELECT EXISTS (SELECT 1 FROM totp_secrets WHERE user_id = $1 AND confirmed)
+	OR EXISTS (SELECT 1 FROM webauthn_credentials WHERE user_id = $1) AS has_second_factor;
+
+-- name: UserOwnsGroups :one
+SELECT EXISTS (SELECT 1 FROM user_group_roles WHERE user_id = $1 AND role = 'owner') AS owns_groups;
+
+-- name: GetTOTPSecret :one
+SELECT secret, confirmed, last_step FROM totp_secrets WHERE user_id = $1;
+
+-- name: UpsertTOTPSecret :exec
+INSERT INTO totp_secrets (user_id, secret) VALUES ($1, $2)
+ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, last_step = 0
+WHERE NOT totp_secrets.confirmed;
+
+-- name: ConfirmTOTPSecret :execrows
+UPDATE totp_secrets SET confirmed = TRUE, last_step = $2 WHERE user_id = $1 AND NOT confirmed;
+
+-- name: UseTOTPStep :execrows
+UPDATE totp_secrets SET last_step = $2 WHERE user_id = $1 AND confirmed AND last_step < $2;
+
+-- name: DeleteTOTPSecret :exec
+DELETE FROM totp_secrets WHERE user_id = $1;
+
+-- name: CountRecoveryCodes :one
+SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1;
+
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM recovery_codes WHERE user_id = $1;
+
+-- name: InsertRecoveryCode :exec
+INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2);
+
+-- name: UseRecoveryCode :execrows
+DELETE FROM recovery_codes WHERE user_id = $1 AND code_hash = $2;
+
+-- name: GetWebAuthnCredentials :many
+SELECT id, credential_id, name, created_at, last_used_at
+FROM webauthn_credentials WHERE user_id = $1 ORDER BY created_at;
+
+-- name: GetWebAuthnCredential :one
+SELECT id, public_key, sign_count FROM webauthn_credentials WHERE user_id = $1 AND credential_id = $2;
+
+-- name: InsertWebAuthnCredential :exec
+INSERT INTO webauthn_credentials (user_id, credential_id, public_key, sign_count, name) VALUES ($1, $2, $3, $4, $5);
+
+-- name: UpdateWebAuthnSignCount :exec
+UPDATE webauthn_credentials SET sign_count = $2, last_used_at = NOW() WHERE id = $1;
+
+-- name: DeleteWebAuthnCredential :exec
+DELETE FROM webauthn_credentials WHERE id = $1 AND user_id = $2;
+
+-- name: UpsertWebAuthnRegistration :exec
+INSERT INTO webauthn_registrations (user_id, challenge, name, expires_at) VALUES ($1, $2, $3, $4)
+ON CONFLICT (user_id) DO UPDATE SET challenge = EXCLUDED.challenge, name = EXCLUDED.name, expires_at = EXCLUDED.expires_at;
+
+-- name: TakeWebAuthnRegistration :one
+DELETE FROM webauthn_registrations WHERE user_id = $1 AND expires_at > NOW() RETURNING challenge, name;
+
+-- name: InsertPendingLogin :exec
+INSERT INTO pending_logins (token_hash, user_id, challenge, expires_at) VALUES ($1, $2, $3, $4);
+
+-- name: GetPendingLogin :one
+SELECT user_id, challenge FROM pending_logins WHERE token_hash = $1 AND expires_at > NOW();
+
+-- name: CountPendingLoginAttempt :one
+-- The challenge is replaced with next_challenge as it is returned, so that
+-- each is only ever checked once.
+UPDATE pending_logins p SET attempts = p.attempts + 1, challenge = sqlc.arg(next_challenge)
+FROM (SELECT token_hash, challenge FROM pending_logins WHERE token_hash = sqlc.arg(token_hash) FOR UPDATE) old
+WHERE p.token_hash = old.token_hash AND p.expires_at > NOW()
+RETURNING p.user_id, old.challenge, p.attempts;
+
+-- name: DeletePendingLogin :exec
+DELETE FROM pending_logins WHERE token_hash = $1;
+
+-- name: PrunePendingLogins :execrows
+DELETE FROM pending_logins WHERE expires_at <= NOW();
+
+-- name: PruneWebAuthnRegistrations :execrows
+DELETE FROM webauthn_registrations WHERE expires_at <= NOW();
@@ -0,0 +1,63 @@
+-- name: InsertUser :one
+INSERT INTO users (username, type, password_hash) VALUES ($1, 'registered', $2) RETURNING id;
+
+-- name: UseInvite :one
+UPDATE invites SET used_at = NOW() WHERE code_hash = $1 AND used_at IS NULL RETURNING created_by;
+
+-- name: InsertInvite :exec
+INSERT INTO invites (code_hash, created_by) VALUES ($1, $2);
+
+-- name: GetUserProfile :one
+SELECT id, COALESCE(username, '') AS username, COALESCE(display_name, '') AS display_name, type::text AS type, created_at
+FROM users WHERE id = $1;
+
+-- name: GetReposByUser :many
+WITH RECURSIVE group_paths(id, path) AS (
+	SELECT id, ARRAY[name]::TEXT[] FROM groups WHERE parent_group IS NULL
+	UNION ALL
+	SELECT g.id, gp.path || g.name FROM groups g JOIN group_paths gp ON g.parent_group = gp.id
+)
+SELECT gp.path::TEXT[] AS group_path, r.name, COALESCE(r.description, '') AS description
+FROM repos r
+JOIN group_paths gp ON gp.id = r.group_id
+JOIN user_group_roles ugr ON ugr.group_id = r.group_id
+WHERE ugr.user_id = $1
+ORDER BY gp.path, r.name;
+
+-- name: GetMergeRequestsByUser :many
+WITH RECURSIVE group_paths(id, path) AS (
+	SELECT id, ARRAY[name]::TEXT[] FROM groups WHERE parent_group IS NULL
+	UNION ALL
+	SELECT g.id, gp.path || g.name FROM groups g JOIN group_paths gp ON g.parent_group = gp.id
+)
+SELECT gp.path::TEXT[] AS group_path, r.name AS repo_name, mr.repo_local_id, mr.title, mr.status::text AS status
+FROM merge_requests mr
+JOIN repos r ON r.id = mr.repo_id
+JOIN group_paths gp ON gp.id = r.group_id
+WHERE mr.creator = $1
+ORDER BY mr.id DESC;
+
+-- name: UpdateDisplayName :exec
+UPDATE users SET display_name = NULLIF($2::TEXT, '') WHERE id = $1;
+
+-- name: UpdatePasswordHash :exec
+UPDATE users SET password_hash = $2 WHERE id = $1;
+
+-- name: RehashPassword :exec
+UPDATE users SET password_hash = sqlc.arg(new_hash) WHERE id = $1 AND password_hash = sqlc.arg(old_hash);
+
+-- name: GetUserEmails :many
+SELECT id, email, verified FROM user_emails WHERE user_id = $1 ORDER BY email;
+
+-- name: InsertUserEmail :exec
+INSERT INTO user_emails (user_id, email, token_hash) VALUES ($1, $2, $3)
+ON CONFLICT (user_id, email) DO UPDATE SET token_hash = EXCLUDED.token_hash, created_at = NOW()
+WHERE NOT user_emails.verified;
+
+-- name: VerifyUserEmail :one
+UPDATE user_emails SET verified = TRUE, token_hash = NULL
+WHERE token_hash = $1 AND created_at > NOW() - INTERVAL '1 day'
+RETURNING user_id, email;
+
+-- name: DeleteUserEmail :exec
+DELETE FROM user_emails WHERE id = $1 AND user_id = $2;
@@ -0,0 +1,9 @@
+-- name: GetCachedView :one
+UPDATE view_cache SET accessed_at = NOW() WHERE key = $1 RETURNING value;
+
+-- name: PutCachedView :exec
+INSERT INTO view_cache (key, value) VALUES ($1, $2)
+ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, accessed_at = NOW();
+
+-- name: PruneCachedViews :exec
+DELETE FROM view_cache WHERE accessed_at < $1;
@@ -14,6 +14,12 @@ CREATE TABLE groups (
 );
 CREATE INDEX ggroups_parent_idx ON groups(parent_group);
 
+-- git2d instances holding repositories. Their addresses are in the config,
+-- which may name nodes that no repos have been placed on yet.
+CREATE TABLE storage_nodes (
+	name TEXT PRIMARY KEY
+);
+
 DO $$ BEGIN
 	CREATE TYPE contrib_requirement AS ENUM ('closed','registered_user','federated','ssh_pubkey','open');
 	-- closed means only those with direct access; each layer adds that level of user
@@ -24,11 +30,22 @@ CREATE TABLE repos (
 	name TEXT NOT NULL,
 	description TEXT,
 	contrib_requirements contrib_requirement NOT NULL,
+	storage_node TEXT NOT NULL REFERENCES storage_nodes(name) ON DELETE RESTRICT,
 	UNIQUE(group_id, name)
 	-- The filesystem path can be derived from the repo ID.
-	-- The config has repo_dir, then we can do repo_dir/<id>.git
+	-- The storage node has a repo_dir, then we can do repo_dir/<id>.git
 );
 CREATE INDEX grepos_group_idx ON repos(group_id);
+CREATE INDEX grepos_storage_node_idx ON repos(storage_node);
+
+-- Copies left on a repository's old node when it was migrated, as requests
+-- may still have been using them; they can be removed once those are done.
+CREATE TABLE retired_repo_copies (
+	storage_node TEXT NOT NULL REFERENCES storage_nodes(name) ON DELETE CASCADE,
+	path TEXT NOT NULL,
+	retired_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (storage_node, path)
+);
 
 CREATE TABLE mailing_lists (
 	id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
@@ -58,13 +75,37 @@ CREATE TABLE users (
 	username TEXT UNIQUE, -- NULL when, for example, pubkey_only
 	type user_type NOT NULL,
 	password_hash TEXT,
+	display_name TEXT,
 	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
 
+CREATE TABLE user_emails (
+	id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	email TEXT NOT NULL,
+	verified BOOLEAN NOT NULL DEFAULT FALSE,
+	token_hash BYTEA UNIQUE, -- of the verification link; NULL once verified
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(), -- when the link was sent
+	UNIQUE(user_id, email)
+);
+CREATE INDEX guser_emails_user_idx ON user_emails(user_id);
+-- Anyone may claim an address, but only one account may prove it.
+CREATE UNIQUE INDEX guser_emails_verified_uniq ON user_emails(email) WHERE verified;
+
+CREATE TABLE invites (
+	code_hash BYTEA PRIMARY KEY,
+	created_by BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	used_at TIMESTAMPTZ
+);
+
 CREATE TABLE ssh_public_keys (
 	id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
 	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
-	key_string TEXT NOT NULL,
+	key_string TEXT NOT NULL, -- type and base64 blob, as in authorized_keys
+	comment TEXT,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	last_used_at TIMESTAMPTZ,
 	CONSTRAINT unique_key_string EXCLUDE USING HASH (key_string WITH =) -- because apparently some haxxor like using rsa16384 keys which are too long for a simple UNIQUE constraint :D
 );
 CREATE INDEX gssh_keys_user_idx ON ssh_public_keys(user_id);
@@ -74,9 +115,75 @@ CREATE TABLE sessions (
 	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
 	token_hash BYTEA UNIQUE NOT NULL,
 	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
-	expires_at TIMESTAMPTZ NOT NULL
+	expires_at TIMESTAMPTZ NOT NULL,
+	user_agent TEXT
 );
 CREATE INDEX gsessions_user_idx   ON sessions(user_id);
+CREATE INDEX gsessions_expires_idx ON sessions(expires_at);
+
+-- Audit log of logins, which failed ones are also counted from to slow down
+-- password guessing from each address and against each username. Attempts
+-- are 'checking' while their password is checked, and count as failed until
+-- they are settled, in case they never are.
+DO $$ BEGIN
+	CREATE TYPE login_outcome AS ENUM ('success','bad_password','no_user','no_password','bad_second_factor','throttled','checking');
+EXCEPTION WHEN duplicate_object THEN END $$;
+CREATE TABLE login_attempts (
+	id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+	username TEXT NOT NULL, -- as entered, whether or not such a user exists
+	user_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+	ip INET, -- NULL when unknown, as over a UNIX socket without reverse_proxy
+	outcome login_outcome NOT NULL,
+	attempted_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX glogin_attempts_username_idx ON login_attempts(username, attempted_at);
+CREATE INDEX glogin_attempts_ip_idx ON login_attempts USING GIST (ip inet_ops);
+CREATE INDEX glogin_attempts_attempted_idx ON login_attempts(attempted_at);
+
+-- Second factors. Users with a confirmed TOTP secret or a security key must
+-- present one after their password; recovery codes stand in for either.
+CREATE TABLE totp_secrets (
+	user_id BIGINT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+	secret BYTEA NOT NULL,
+	confirmed BOOLEAN NOT NULL DEFAULT FALSE, -- once a code from it was entered
+	last_step BIGINT NOT NULL DEFAULT 0 -- of the last code accepted, so codes can't be replayed
+);
+
+CREATE TABLE recovery_codes (
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	code_hash BYTEA NOT NULL,
+	PRIMARY KEY(user_id, code_hash)
+);
+
+CREATE TABLE webauthn_credentials (
+	id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	credential_id BYTEA UNIQUE NOT NULL,
+	public_key BYTEA NOT NULL, -- COSE_Key
+	sign_count BIGINT NOT NULL,
+	name TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	last_used_at TIMESTAMPTZ
+);
+CREATE INDEX gwebauthn_credentials_user_idx ON webauthn_credentials(user_id);
+
+-- Security key registrations in progress.
+CREATE TABLE webauthn_registrations (
+	user_id BIGINT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+	challenge BYTEA NOT NULL,
+	name TEXT NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL
+);
+
+-- Logins whose password was right, awaiting a second factor.
+CREATE TABLE pending_logins (
+	token_hash BYTEA PRIMARY KEY,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	challenge BYTEA NOT NULL, -- for security keys
+	attempts INT NOT NULL DEFAULT 0,
+	expires_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX gpending_logins_expires_idx ON pending_logins(expires_at);
 
 DO $$ BEGIN
 	CREATE TYPE group_role AS ENUM ('owner'); -- just owner for now, might need to rethink ACL altogether later; might consider using a join table if we need it to be dynamic, but enum suffices for now
@@ -93,10 +200,26 @@ CREATE TABLE federated_identities (
 	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE RESTRICT,
 	service TEXT NOT NULL, -- might need to constrain
 	remote_username TEXT NOT NULL,
+	remote_subject TEXT, -- stable ID at OAuth providers, where usernames may change
+	key_string TEXT, -- SSH key that the other forge publishes for the account, for those verified that way
+	verified_at TIMESTAMPTZ DEFAULT NOW(), -- NULL once the key is no longer published
+	checked_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
 	PRIMARY KEY(user_id, service),
-	UNIQUE(service, remote_username)
+	UNIQUE(service, remote_username),
+	UNIQUE(service, remote_subject)
 );
 
+-- OAuth logins in progress, keyed by their state parameter.
+CREATE TABLE oauth_logins (
+	state_hash BYTEA PRIMARY KEY,
+	provider TEXT NOT NULL,
+	code_verifier TEXT NOT NULL,
+	nonce TEXT NOT NULL,
+	link_user BIGINT REFERENCES users(id) ON DELETE CASCADE, -- when linking from settings rather than logging in
+	expires_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX goauth_logins_expires_idx ON oauth_logins(expires_at);
+
 CREATE TABLE ticket_trackers (
 	id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
 	group_id BIGINT NOT NULL REFERENCES groups(id) ON DELETE RESTRICT,
@@ -219,3 +342,12 @@ CREATE TRIGGER before_insert_merge_request
 BEFORE INSERT ON merge_requests
 FOR EACH ROW
 EXECUTE FUNCTION assign_repo_local_id();
+
+-- Backing store of the git view cache when configured to use PostgreSQL.
+-- Keys are hashes of the repo, view and object IDs; it is safe to truncate.
+CREATE UNLOGGED TABLE view_cache (
+	key TEXT PRIMARY KEY,
+	value BYTEA NOT NULL,
+	accessed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX gview_cache_accessed_idx ON view_cache(accessed_at);
@@ -0,0 +1,61 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+
+// Security keys can only be used through JavaScript. Forms with a
+// data-webauthn attribute of "create" or "get" hold the server's options in
+// data-webauthn-options; submitting one asks the browser for a credential
+// and sends it back in the form's hidden fields, base64url-encoded.
+
+"use strict";
+
+(function () {
+	function decode(s) {
+		const bin = atob(s.replace(/-/g, "+").replace(/_/g, "/"));
+		return Uint8Array.from(bin, (c) => c.charCodeAt(0));
+	}
+
+	function encode(buf) {
+		const bin = String.fromCharCode(...new Uint8Array(buf));
+		return btoa(bin).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+	}
+
+	for (const form of document.querySelectorAll("form[data-webauthn]")) {
+		const status = form.querySelector(".webauthn-status");
+		if (!window.PublicKeyCredential) {
+			status.textContent = "Your browser does not support security keys.";
+			continue;
+		}
+		form.addEventListener("submit", async (event) => {
+			event.preventDefault();
+			const options = JSON.parse(form.dataset.webauthnOptions);
+			options.challenge = decode(options.challenge);
+			for (const c of options.allowCredentials || options.excludeCredentials || []) {
+				c.id = decode(c.id);
+			}
+
+			let cred;
+			try {
+				if (form.dataset.webauthn === "create") {
+					options.user.id = decode(options.user.id);
+					cred = await navigator.credentials.create({ publicKey: options });
+				} else {
+					cred = await navigator.credentials.get({ publicKey: options });
+				}
+			} catch (err) {
+				status.textContent = err.message;
+				return;
+			}
+
+			const fields = form.elements;
+			fields.client_data.value = encode(cred.response.clientDataJSON);
+			if (form.dataset.webauthn === "create") {
+				fields.attestation.value = encode(cred.response.attestationObject);
+			} else {
+				fields.credential_id.value = encode(cred.rawId);
+				fields.authenticator_data.value = encode(cred.response.authenticatorData);
+				fields.signature.value = encode(cred.response.signature);
+			}
+			form.submit();
+		});
+	}
+})();
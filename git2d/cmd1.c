@@ -12,7 +12,7 @@ int cmd_index(git_repository *repo, struct bare_writer *writer)
 	git_object *obj = NULL;
 	int err = git_revparse_single(&obj, repo, "HEAD^{tree}");
 	if (err != 0) {
-		bare_put_uint(writer, 4);
+		write_error(writer, 4, "HEAD");
 		return -1;
 	}
 	git_tree *tree = (git_tree *) obj;
@@ -22,13 +22,13 @@ int cmd_index(git_repository *repo, struct bare_writer *writer)
 	git_tree_entry *entry = NULL;
 	err = git_tree_entry_bypath(&entry, tree, "README.md");
 	if (err != 0) {
-		bare_put_uint(writer, 5);
+		write_error(writer, 5, "README.md");
 		git_tree_free(tree);
 		return -1;
 	}
 	git_otype objtype = git_tree_entry_type(entry);
 	if (objtype != GIT_OBJECT_BLOB) {
-		bare_put_uint(writer, 6);
+		write_error(writer, 6, "README.md");
 		git_tree_entry_free(entry);
 		git_tree_free(tree);
 		return -1;
@@ -36,7 +36,7 @@ int cmd_index(git_repository *repo, struct bare_writer *writer)
 	git_object *obj2 = NULL;
 	err = git_tree_entry_to_object(&obj2, repo, entry);
 	if (err != 0) {
-		bare_put_uint(writer, 7);
+		write_error(writer, 7, NULL);
 		git_tree_entry_free(entry);
 		git_tree_free(tree);
 		return -1;
@@ -44,22 +44,19 @@ int cmd_index(git_repository *repo, struct bare_writer *writer)
 	git_blob *blob = (git_blob *) obj2;
 	const void *content = git_blob_rawcontent(blob);
 	if (content == NULL) {
-		bare_put_uint(writer, 8);
+		write_error(writer, 8, NULL);
 		git_blob_free(blob);
 		git_tree_entry_free(entry);
 		git_tree_free(tree);
 		return -1;
 	}
-	bare_put_uint(writer, 0);
-	bare_put_data(writer, content, git_blob_rawsize(blob));
-
 	/* Commits */
 
 	/* TODO BUG: This might be a different commit from the displayed README due to races */
 
 	git_revwalk *walker = NULL;
 	if (git_revwalk_new(&walker, repo) != 0) {
-		bare_put_uint(writer, 9);
+		write_error(writer, 9, NULL);
 		git_blob_free(blob);
 		git_tree_entry_free(entry);
 		git_tree_free(tree);
@@ -67,7 +64,7 @@ int cmd_index(git_repository *repo, struct bare_writer *writer)
 	}
 
 	if (git_revwalk_push_head(walker) != 0) {
-		bare_put_uint(writer, 9);
+		write_error(writer, 9, NULL);
 		git_revwalk_free(walker);
 		git_blob_free(blob);
 		git_tree_entry_free(entry);
@@ -75,46 +72,22 @@ int cmd_index(git_repository *repo, struct bare_writer *writer)
 		return -1;
 	}
 
-	int count = 0;
-	git_oid oid;
-	while (count < 3 && git_revwalk_next(&oid, walker) == 0) {
-		git_commit *commit = NULL;
-		if (git_commit_lookup(&commit, repo, &oid) != 0)
-			break;
-
-		const char *msg = git_commit_summary(commit);
-		const git_signature *author = git_commit_author(commit);
-
-		/* ID */
-		bare_put_data(writer, oid.id, GIT_OID_RAWSZ);
-
-		/* Title */
-		size_t msg_len = msg ? strlen(msg) : 0;
-		bare_put_data(writer, (const uint8_t *)(msg ? msg : ""), msg_len);
-
-		/* Author's name */
-		const char *author_name = author ? author->name : "";
-		bare_put_data(writer, (const uint8_t *)author_name, strlen(author_name));
-
-		/* Author's email */
-		const char *author_email = author ? author->email : "";
-		bare_put_data(writer, (const uint8_t *)author_email, strlen(author_email));
-
-		/* Author's date */
-		/* TODO: Pass the integer instead of a string */
-		time_t time = git_commit_time(commit);
-		char timebuf[64];
-		struct tm *tm = localtime(&time);
-		if (tm)
-			strftime(timebuf, sizeof(timebuf), "%Y-%m-%d %H:%M:%S", tm);
-		else
-			strcpy(timebuf, "unknown");
-		bare_put_data(writer, (const uint8_t *)timebuf, strlen(timebuf));
-
-		git_commit_free(commit);
-		count++;
+	git_oid *oids = NULL;
+	size_t count = 0;
+	if (collect_revwalk(walker, 3, &oids, &count) != 0) {
+		write_error(writer, 9, NULL);
+		git_revwalk_free(walker);
+		git_blob_free(blob);
+		git_tree_entry_free(entry);
+		git_tree_free(tree);
+		return -1;
 	}
 
+	bare_put_uint(writer, 0);
+	bare_put_data(writer, content, git_blob_rawsize(blob));
+	write_commit_list(repo, oids, count, writer);
+	free(oids);
+
 	git_revwalk_free(walker);
 	git_blob_free(blob);
 	git_tree_entry_free(entry);
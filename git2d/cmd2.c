@@ -11,21 +11,32 @@ int cmd_treeraw(git_repository *repo, struct bare_reader *reader, struct bare_wr
 	char path[4096] = { 0 };
 	int err = bare_get_data(reader, (uint8_t *) path, sizeof(path) - 1);
 	if (err != BARE_ERROR_NONE) {
-		bare_put_uint(writer, 11);
+		write_error(writer, 11, NULL);
 		return -1;
 	}
 	path[sizeof(path) - 1] = '\0';
 
-	/* HEAD^{tree} */
+	/* Revision, empty for HEAD */
+	char rev[256] = { 0 };
+	err = bare_get_data(reader, (uint8_t *) rev, sizeof(rev) - 1);
+	if (err != BARE_ERROR_NONE) {
+		write_error(writer, 11, NULL);
+		return -1;
+	}
+
+	/* <rev>^{tree} */
+	char spec[300];
+	snprintf(spec, sizeof(spec), "%s^{tree}", rev[0] ? rev : "HEAD");
 	git_object *head_obj = NULL;
-	err = git_revparse_single(&head_obj, repo, "HEAD^{tree}");
+	err = git_revparse_single(&head_obj, repo, spec);
 	if (err != 0) {
-		bare_put_uint(writer, 4);
-		return -1;
+		write_error(writer, 4, rev[0] ? rev : "HEAD");
+		return 0;
 	}
 	git_tree *tree = (git_tree *) head_obj;
 
 	/* Path in tree */
+	int ret = 0;
 	git_tree_entry *entry = NULL;
 	git_otype objtype;
 	if (strlen(path) == 0) {
@@ -34,7 +45,7 @@ int cmd_treeraw(git_repository *repo, struct bare_reader *reader, struct bare_wr
 	} else {
 		err = git_tree_entry_bypath(&entry, tree, path);
 		if (err != 0) {
-			bare_put_uint(writer, 3);
+			write_error(writer, 3, path);
 			git_tree_free(tree);
 			return 0;
 		}
@@ -49,7 +60,7 @@ int cmd_treeraw(git_repository *repo, struct bare_reader *reader, struct bare_wr
 		} else {
 			err = git_tree_entry_to_object(&tree_obj, repo, entry);
 			if (err != 0) {
-				bare_put_uint(writer, 7);
+				write_error(writer, 7, NULL);
 				goto cleanup;
 			}
 		}
@@ -94,19 +105,32 @@ int cmd_treeraw(git_repository *repo, struct bare_reader *reader, struct bare_wr
 		git_object *blob_obj = NULL;
 		err = git_tree_entry_to_object(&blob_obj, repo, entry);
 		if (err != 0) {
-			bare_put_uint(writer, 7);
+			write_error(writer, 7, NULL);
 			goto cleanup;
 		}
 		git_blob *blob = (git_blob *) blob_obj;
-		const void *content = git_blob_rawcontent(blob);
+		const uint8_t *content = git_blob_rawcontent(blob);
 		if (content == NULL) {
-			bare_put_uint(writer, 8);
+			write_error(writer, 8, NULL);
 			git_blob_free(blob);
 			goto cleanup;
 		}
+		size_t size = (size_t)git_blob_rawsize(blob);
 		bare_put_uint(writer, 0);
 		bare_put_uint(writer, 2);
-		bare_put_data(writer, content, git_blob_rawsize(blob));
+		bare_put_data(writer, git_blob_id(blob)->id, GIT_OID_RAWSZ);
+		bare_put_uint(writer, (uint64_t)size);
+		bare_put_bool(writer, git_blob_is_binary(blob));
+		/* Content in chunks, terminated by an empty one */
+		for (size_t off = 0; off < size; off += BLOB_CHUNK_SIZE) {
+			size_t n = size - off < BLOB_CHUNK_SIZE ? size - off : BLOB_CHUNK_SIZE;
+			if (bare_put_data(writer, content + off, n) != BARE_ERROR_NONE) {
+				git_blob_free(blob);
+				ret = -1;
+				goto cleanup;
+			}
+		}
+		bare_put_data(writer, (const uint8_t *)"", 0);
 		git_blob_free(blob);
 	} else {
 		/* Unknown */
@@ -117,5 +141,5 @@ int cmd_treeraw(git_repository *repo, struct bare_reader *reader, struct bare_wr
 	if (entry != NULL)
 		git_tree_entry_free(entry);
 	git_tree_free(tree);
-	return 0;
+	return ret;
 }
@@ -5,47 +5,27 @@
 
 #include "x.h"
 
-static int append_buf(char **data, size_t *len, size_t *cap, const char *src, size_t n)
-{
-	if (n == 0)
-		return 0;
-	size_t need = *len + n;
-	if (need > *cap) {
-		size_t newcap = *cap ? *cap * 2 : 256;
-		while (newcap < need)
-			newcap *= 2;
-		char *p = (char *)realloc(*data, newcap);
-		if (!p)
-			return -1;
-		*data = p;
-		*cap = newcap;
-	}
-	memcpy(*data + *len, src, n);
-	*len += n;
-	return 0;
-}
-
 int cmd_commit_tree_oid(git_repository *repo, struct bare_reader *reader, struct bare_writer *writer)
 {
 	char hex[64] = { 0 };
 	if (bare_get_data(reader, (uint8_t *) hex, sizeof(hex) - 1) != BARE_ERROR_NONE) {
-		bare_put_uint(writer, 11);
+		write_error(writer, 11, NULL);
 		return -1;
 	}
 	git_oid oid;
 	if (git_oid_fromstr(&oid, hex) != 0) {
-		bare_put_uint(writer, 14);
+		write_error(writer, 14, hex);
 		return -1;
 	}
 	git_commit *commit = NULL;
 	if (git_commit_lookup(&commit, repo, &oid) != 0) {
-		bare_put_uint(writer, 14);
+		write_error(writer, 14, hex);
 		return -1;
 	}
 	git_tree *tree = NULL;
 	if (git_commit_tree(&tree, commit) != 0) {
 		git_commit_free(commit);
-		bare_put_uint(writer, 14);
+		write_error(writer, 14, hex);
 		return -1;
 	}
 	const git_oid *toid = git_tree_id(tree);
@@ -60,39 +40,39 @@ int cmd_commit_create(git_repository *repo, struct bare_reader *reader, struct b
 {
 	char treehex[64] = { 0 };
 	if (bare_get_data(reader, (uint8_t *) treehex, sizeof(treehex) - 1) != BARE_ERROR_NONE) {
-		bare_put_uint(writer, 11);
+		write_error(writer, 11, NULL);
 		return -1;
 	}
 	git_oid tree_oid;
 	if (git_oid_fromstr(&tree_oid, treehex) != 0) {
-		bare_put_uint(writer, 15);
+		write_error(writer, 15, NULL);
 		return -1;
 	}
 	uint64_t pcnt = 0;
 	if (bare_get_uint(reader, &pcnt) != BARE_ERROR_NONE) {
-		bare_put_uint(writer, 11);
+		write_error(writer, 11, NULL);
 		return -1;
 	}
 	git_commit **parents = NULL;
 	if (pcnt > 0) {
 		parents = (git_commit **) calloc(pcnt, sizeof(git_commit *));
 		if (!parents) {
-			bare_put_uint(writer, 15);
+			write_error(writer, 15, NULL);
 			return -1;
 		}
 		for (uint64_t i = 0; i < pcnt; i++) {
 			char phex[64] = { 0 };
 			if (bare_get_data(reader, (uint8_t *) phex, sizeof(phex) - 1) != BARE_ERROR_NONE) {
-				bare_put_uint(writer, 11);
+				write_error(writer, 11, NULL);
 				goto fail;
 			}
 			git_oid poid;
 			if (git_oid_fromstr(&poid, phex) != 0) {
-				bare_put_uint(writer, 15);
+				write_error(writer, 15, NULL);
 				goto fail;
 			}
 			if (git_commit_lookup(&parents[i], repo, &poid) != 0) {
-				bare_put_uint(writer, 15);
+				write_error(writer, 15, NULL);
 				goto fail;
 			}
 		}
@@ -100,38 +80,38 @@ int cmd_commit_create(git_repository *repo, struct bare_reader *reader, struct b
 	char aname[512] = { 0 };
 	char aemail[512] = { 0 };
 	if (bare_get_data(reader, (uint8_t *) aname, sizeof(aname) - 1) != BARE_ERROR_NONE) {
-		bare_put_uint(writer, 11);
+		write_error(writer, 11, NULL);
 		goto fail;
 	}
 	if (bare_get_data(reader, (uint8_t *) aemail, sizeof(aemail) - 1) != BARE_ERROR_NONE) {
-		bare_put_uint(writer, 11);
+		write_error(writer, 11, NULL);
 		goto fail;
 	}
 	int64_t when = 0;
 	int64_t tzoff = 0;
 	if (bare_get_i64(reader, &when) != BARE_ERROR_NONE) {
-		bare_put_uint(writer, 11);
+		write_error(writer, 11, NULL);
 		goto fail;
 	}
 	if (bare_get_i64(reader, &tzoff) != BARE_ERROR_NONE) {
-		bare_put_uint(writer, 11);
+		write_error(writer, 11, NULL);
 		goto fail;
 	}
 	char *message = NULL;
 	{
 		uint64_t msz = 0;
 		if (bare_get_uint(reader, &msz) != BARE_ERROR_NONE) {
-			bare_put_uint(writer, 11);
+			write_error(writer, 11, NULL);
 			goto fail;
 		}
 		message = (char *)malloc(msz + 1);
 		if (!message) {
-			bare_put_uint(writer, 15);
+			write_error(writer, 15, NULL);
 			goto fail;
 		}
 		if (bare_get_fixed_data(reader, (uint8_t *) message, msz) != BARE_ERROR_NONE) {
 			free(message);
-			bare_put_uint(writer, 11);
+			write_error(writer, 11, NULL);
 			goto fail;
 		}
 		message[msz] = '\0';
@@ -139,14 +119,14 @@ int cmd_commit_create(git_repository *repo, struct bare_reader *reader, struct b
 	git_signature *sig = NULL;
 	if (git_signature_new(&sig, aname, aemail, (git_time_t) when, (int)tzoff) != 0) {
 		free(message);
-		bare_put_uint(writer, 19);
+		write_error(writer, 19, NULL);
 		goto fail;
 	}
 	git_tree *tree = NULL;
 	if (git_tree_lookup(&tree, repo, &tree_oid) != 0) {
 		git_signature_free(sig);
 		free(message);
-		bare_put_uint(writer, 19);
+		write_error(writer, 19, NULL);
 		goto fail;
 	}
 	git_oid out;
@@ -156,7 +136,7 @@ int cmd_commit_create(git_repository *repo, struct bare_reader *reader, struct b
 	git_signature_free(sig);
 	free(message);
 	if (rc != 0) {
-		bare_put_uint(writer, 19);
+		write_error(writer, 19, NULL);
 		goto fail;
 	}
 	bare_put_uint(writer, 0);
@@ -183,23 +163,23 @@ int cmd_update_ref(git_repository *repo, struct bare_reader *reader, struct bare
 	char refname[4096] = { 0 };
 	char commithex[64] = { 0 };
 	if (bare_get_data(reader, (uint8_t *) refname, sizeof(refname) - 1) != BARE_ERROR_NONE) {
-		bare_put_uint(writer, 11);
+		write_error(writer, 11, NULL);
 		return -1;
 	}
 	if (bare_get_data(reader, (uint8_t *) commithex, sizeof(commithex) - 1)
 	    != BARE_ERROR_NONE) {
-		bare_put_uint(writer, 11);
+		write_error(writer, 11, NULL);
 		return -1;
 	}
 	git_oid oid;
 	if (git_oid_fromstr(&oid, commithex) != 0) {
-		bare_put_uint(writer, 18);
+		write_error(writer, 18, commithex);
 		return -1;
 	}
 	git_reference *out = NULL;
 	int rc = git_reference_create(&out, repo, refname, &oid, 1, NULL);
 	if (rc != 0) {
-		bare_put_uint(writer, 18);
+		write_error(writer, 18, refname);
 		return -1;
 	}
 	git_reference_free(out);
@@ -211,17 +191,71 @@ int cmd_commit_info(git_repository *repo, struct bare_reader *reader, struct bar
 {
 	char hex[64] = { 0 };
 	if (bare_get_data(reader, (uint8_t *) hex, sizeof(hex) - 1) != BARE_ERROR_NONE) {
-		bare_put_uint(writer, 11);
+		write_error(writer, 11, NULL);
+		return -1;
+	}
+	uint64_t diff_flags = 0;
+	if (bare_get_uint(reader, &diff_flags) != BARE_ERROR_NONE) {
+		write_error(writer, 11, NULL);
 		return -1;
 	}
+	git_diff_options diffopts;
+	diff_options_from_flags(&diffopts, diff_flags);
 	git_oid oid;
 	if (git_oid_fromstr(&oid, hex) != 0) {
-		bare_put_uint(writer, 14);
+		write_error(writer, 14, hex);
 		return -1;
 	}
 	git_commit *commit = NULL;
 	if (git_commit_lookup(&commit, repo, &oid) != 0) {
-		bare_put_uint(writer, 14);
+		write_error(writer, 14, hex);
+		return -1;
+	}
+
+	/* Structured diff, prepared first as it can fail */
+	uint32_t pcnt = git_commit_parentcount(commit);
+	git_tree *tree = NULL;
+	if (git_commit_tree(&tree, commit) != 0) {
+		git_commit_free(commit);
+		write_error(writer, 15, NULL);
+		return -1;
+	}
+	git_diff *diff = NULL;
+	if (pcnt == 0) {
+		if (git_diff_tree_to_tree(&diff, repo, NULL, tree, &diffopts) != 0) {
+			git_tree_free(tree);
+			git_commit_free(commit);
+			write_error(writer, 15, NULL);
+			return -1;
+		}
+	} else {
+		git_commit *parent = NULL;
+		git_tree *ptree = NULL;
+		if (git_commit_parent(&parent, commit, 0) != 0 || git_commit_tree(&ptree, parent) != 0) {
+			if (parent) git_commit_free(parent);
+			git_tree_free(tree);
+			git_commit_free(commit);
+			write_error(writer, 15, NULL);
+			return -1;
+		}
+		if (git_diff_tree_to_tree(&diff, repo, ptree, tree, &diffopts) != 0) {
+			git_tree_free(ptree);
+			git_commit_free(parent);
+			git_tree_free(tree);
+			git_commit_free(commit);
+			write_error(writer, 15, NULL);
+			return -1;
+		}
+		git_tree_free(ptree);
+		git_commit_free(parent);
+	}
+
+	git_diff_stats *stats = NULL;
+	if (prepare_diff_files(diff, &stats) != 0) {
+		git_diff_free(diff);
+		git_tree_free(tree);
+		git_commit_free(commit);
+		write_error(writer, 15, NULL);
 		return -1;
 	}
 
@@ -258,7 +292,6 @@ int cmd_commit_info(git_repository *repo, struct bare_reader *reader, struct bar
 	/* Message */
 	bare_put_data(writer, (const uint8_t *)message, strlen(message));
 	/* Parents */
-	uint32_t pcnt = git_commit_parentcount(commit);
 	bare_put_uint(writer, (uint64_t)pcnt);
 	for (uint32_t i = 0; i < pcnt; i++) {
 		const git_commit *p = NULL;
@@ -272,129 +305,12 @@ int cmd_commit_info(git_repository *repo, struct bare_reader *reader, struct bar
 		}
 	}
 
-	/* Structured diff */
-	git_tree *tree = NULL;
-	if (git_commit_tree(&tree, commit) != 0) {
+	if (write_diff_files(diff, stats, writer) != 0) {
+		git_diff_free(diff);
+		git_tree_free(tree);
 		git_commit_free(commit);
-		bare_put_uint(writer, 15);
 		return -1;
 	}
-	git_diff *diff = NULL;
-	if (pcnt == 0) {
-		if (git_diff_tree_to_tree(&diff, repo, NULL, tree, NULL) != 0) {
-			git_tree_free(tree);
-			git_commit_free(commit);
-			bare_put_uint(writer, 15);
-			return -1;
-		}
-	} else {
-		git_commit *parent = NULL;
-		git_tree *ptree = NULL;
-		if (git_commit_parent(&parent, commit, 0) != 0 || git_commit_tree(&ptree, parent) != 0) {
-			if (parent) git_commit_free(parent);
-			git_tree_free(tree);
-			git_commit_free(commit);
-			bare_put_uint(writer, 15);
-			return -1;
-		}
-		if (git_diff_tree_to_tree(&diff, repo, ptree, tree, NULL) != 0) {
-			git_tree_free(ptree);
-			git_commit_free(parent);
-			git_tree_free(tree);
-			git_commit_free(commit);
-			bare_put_uint(writer, 15);
-			return -1;
-		}
-		git_tree_free(ptree);
-		git_commit_free(parent);
-	}
-
-	size_t files = git_diff_num_deltas(diff);
-	bare_put_uint(writer, (uint64_t)files);
-    for (size_t i = 0; i < files; i++) {
-		git_patch *patch = NULL;
-		if (git_patch_from_diff(&patch, diff, i) != 0) {
-			/* empty diff */
-			bare_put_uint(writer, 0);
-			bare_put_uint(writer, 0);
-			bare_put_data(writer, (const uint8_t *)"", 0);
-			bare_put_data(writer, (const uint8_t *)"", 0);
-			bare_put_uint(writer, 0);
-			continue;
-		}
-		const git_diff_delta *delta = git_patch_get_delta(patch);
-		uint32_t from_mode = delta ? delta->old_file.mode : 0;
-		uint32_t to_mode = delta ? delta->new_file.mode : 0;
-		const char *from_path = (delta && delta->old_file.path) ? delta->old_file.path : "";
-		const char *to_path = (delta && delta->new_file.path) ? delta->new_file.path : "";
-		bare_put_uint(writer, (uint64_t)from_mode);
-		bare_put_uint(writer, (uint64_t)to_mode);
-		bare_put_data(writer, (const uint8_t *)from_path, strlen(from_path));
-		bare_put_data(writer, (const uint8_t *)to_path, strlen(to_path));
-
-		size_t hunks = git_patch_num_hunks(patch);
-		uint64_t chunk_count = 0;
-		for (size_t h = 0; h < hunks; h++) {
-			const git_diff_hunk *hunk = NULL;
-			size_t lines = 0;
-			if (git_patch_get_hunk(&hunk, &lines, patch, h) != 0) continue;
-			int prev = -2;
-			for (size_t ln = 0; ln < lines; ln++) {
-				const git_diff_line *line = NULL;
-				if (git_patch_get_line_in_hunk(&line, patch, h, ln) != 0 || !line) continue;
-				int op = 0;
-				if (line->origin == '+') op = 1;
-				else if (line->origin == '-') op = 2;
-				else op = 0;
-				if (op != prev) { chunk_count++; prev = op; }
-			}
-		}
-		bare_put_uint(writer, chunk_count);
-        for (size_t h = 0; h < hunks; h++) {
-            const git_diff_hunk *hunk = NULL;
-            size_t lines = 0;
-            if (git_patch_get_hunk(&hunk, &lines, patch, h) != 0) continue;
-            int prev = -2;
-            struct {
-                char *data;
-                size_t len;
-                size_t cap;
-            } buf = {0};
-            for (size_t ln = 0; ln < lines; ln++) {
-                const git_diff_line *line = NULL;
-                if (git_patch_get_line_in_hunk(&line, patch, h, ln) != 0 || !line) continue;
-                int op = 0;
-                if (line->origin == '+') op = 1;
-                else if (line->origin == '-') op = 2;
-                else op = 0;
-                if (prev == -2) prev = op;
-                if (op != prev) {
-                    bare_put_uint(writer, (uint64_t)prev);
-                    bare_put_data(writer, (const uint8_t *)buf.data, buf.len);
-                    free(buf.data);
-                    buf.data = NULL; buf.len = 0; buf.cap = 0;
-                    prev = op;
-                }
-                if (line->content && line->content_len > 0) {
-                    if (append_buf(&buf.data, &buf.len, &buf.cap, line->content, line->content_len) != 0) {
-                        free(buf.data);
-                        git_patch_free(patch);
-                        git_diff_free(diff);
-                        git_tree_free(tree);
-                        git_commit_free(commit);
-                        bare_put_uint(writer, 15);
-                        return -1;
-                    }
-                }
-            }
-            if (prev != -2) {
-                bare_put_uint(writer, (uint64_t)prev);
-                bare_put_data(writer, (const uint8_t *)buf.data, buf.len);
-                free(buf.data);
-            }
-        }
-        git_patch_free(patch);
-    }
 
 	git_diff_free(diff);
 	git_tree_free(tree);
@@ -44,34 +44,37 @@ int cmd_format_patch(git_repository *repo, struct bare_reader *reader, struct ba
 {
 	char hex[64] = { 0 };
 	if (bare_get_data(reader, (uint8_t *) hex, sizeof(hex) - 1) != BARE_ERROR_NONE) {
-		bare_put_uint(writer, 11);
+		write_error(writer, 11, NULL);
 		return -1;
 	}
 	git_oid oid;
 	if (git_oid_fromstr(&oid, hex) != 0) {
-		bare_put_uint(writer, 14);
+		write_error(writer, 14, hex);
 		return -1;
 	}
 
 	git_commit *commit = NULL;
 	if (git_commit_lookup(&commit, repo, &oid) != 0) {
-		bare_put_uint(writer, 14);
+		write_error(writer, 14, hex);
 		return -1;
 	}
 
 	git_tree *tree = NULL;
 	if (git_commit_tree(&tree, commit) != 0) {
 		git_commit_free(commit);
-		bare_put_uint(writer, 14);
+		write_error(writer, 14, hex);
 		return -1;
 	}
 
+	git_diff_options diffopts;
+	diff_options_from_flags(&diffopts, 0);
+
 	git_diff *diff = NULL;
 	if (git_commit_parentcount(commit) == 0) {
-		if (git_diff_tree_to_tree(&diff, repo, NULL, tree, NULL) != 0) {
+		if (git_diff_tree_to_tree(&diff, repo, NULL, tree, &diffopts) != 0) {
 			git_tree_free(tree);
 			git_commit_free(commit);
-			bare_put_uint(writer, 15);
+			write_error(writer, 15, NULL);
 			return -1;
 		}
 	} else {
@@ -82,15 +85,15 @@ int cmd_format_patch(git_repository *repo, struct bare_reader *reader, struct ba
 				git_commit_free(parent);
 			git_tree_free(tree);
 			git_commit_free(commit);
-			bare_put_uint(writer, 15);
+			write_error(writer, 15, NULL);
 			return -1;
 		}
-		if (git_diff_tree_to_tree(&diff, repo, ptree, tree, NULL) != 0) {
+		if (git_diff_tree_to_tree(&diff, repo, ptree, tree, &diffopts) != 0) {
 			git_tree_free(ptree);
 			git_commit_free(parent);
 			git_tree_free(tree);
 			git_commit_free(commit);
-			bare_put_uint(writer, 15);
+			write_error(writer, 15, NULL);
 			return -1;
 		}
 		git_tree_free(ptree);
@@ -102,7 +105,7 @@ int cmd_format_patch(git_repository *repo, struct bare_reader *reader, struct ba
 		git_diff_free(diff);
 		git_tree_free(tree);
 		git_commit_free(commit);
-		bare_put_uint(writer, 15);
+		write_error(writer, 15, NULL);
 		return -1;
 	}
 
@@ -112,7 +115,7 @@ int cmd_format_patch(git_repository *repo, struct bare_reader *reader, struct ba
 		git_diff_free(diff);
 		git_tree_free(tree);
 		git_commit_free(commit);
-		bare_put_uint(writer, 15);
+		write_error(writer, 15, NULL);
 		return -1;
 	}
 
@@ -147,7 +150,7 @@ int cmd_format_patch(git_repository *repo, struct bare_reader *reader, struct ba
 		git_diff_free(diff);
 		git_tree_free(tree);
 		git_commit_free(commit);
-		bare_put_uint(writer, 15);
+		write_error(writer, 15, NULL);
 		return -1;
 	}
 	size_t off = 0;
@@ -187,25 +190,25 @@ int cmd_merge_base(git_repository *repo, struct bare_reader *reader, struct bare
 	char hex1[64] = { 0 };
 	char hex2[64] = { 0 };
 	if (bare_get_data(reader, (uint8_t *) hex1, sizeof(hex1) - 1) != BARE_ERROR_NONE) {
-		bare_put_uint(writer, 11);
+		write_error(writer, 11, NULL);
 		return -1;
 	}
 	if (bare_get_data(reader, (uint8_t *) hex2, sizeof(hex2) - 1) != BARE_ERROR_NONE) {
-		bare_put_uint(writer, 11);
+		write_error(writer, 11, NULL);
 		return -1;
 	}
 	git_oid a, b, out;
 	if (git_oid_fromstr(&a, hex1) != 0 || git_oid_fromstr(&b, hex2) != 0) {
-		bare_put_uint(writer, 17);
+		write_error(writer, 17, NULL);
 		return -1;
 	}
 	int rc = git_merge_base(&out, repo, &a, &b);
 	if (rc == GIT_ENOTFOUND) {
-		bare_put_uint(writer, 16);
+		write_error(writer, 16, NULL);
 		return -1;
 	}
 	if (rc != 0) {
-		bare_put_uint(writer, 17);
+		write_error(writer, 17, NULL);
 		return -1;
 	}
 	bare_put_uint(writer, 0);
@@ -218,11 +221,11 @@ int cmd_log(git_repository *repo, struct bare_reader *reader, struct bare_writer
 	char spec[4096] = { 0 };
 	uint64_t limit = 0;
 	if (bare_get_data(reader, (uint8_t *) spec, sizeof(spec) - 1) != BARE_ERROR_NONE) {
-		bare_put_uint(writer, 11);
+		write_error(writer, 11, NULL);
 		return -1;
 	}
 	if (bare_get_uint(reader, &limit) != BARE_ERROR_NONE) {
-		bare_put_uint(writer, 11);
+		write_error(writer, 11, NULL);
 		return -1;
 	}
 
@@ -230,7 +233,7 @@ int cmd_log(git_repository *repo, struct bare_reader *reader, struct bare_writer
 	if (spec[0] == '\0')
 		strcpy(spec, "HEAD");
 	if (git_revparse_single(&obj, repo, spec) != 0) {
-		bare_put_uint(writer, 4);
+		write_error(writer, 4, spec);
 		return -1;
 	}
 	git_commit *start = (git_commit *) obj;
@@ -238,39 +241,372 @@ int cmd_log(git_repository *repo, struct bare_reader *reader, struct bare_writer
 	git_revwalk *walk = NULL;
 	if (git_revwalk_new(&walk, repo) != 0) {
 		git_commit_free(start);
-		bare_put_uint(writer, 9);
+		write_error(writer, 9, NULL);
 		return -1;
 	}
 	git_revwalk_sorting(walk, GIT_SORT_TIME);
 	git_revwalk_push(walk, git_commit_id(start));
 	git_commit_free(start);
 
+	git_oid *oids = NULL;
+	size_t count = 0;
+	int rc = collect_revwalk(walk, limit, &oids, &count);
+	git_revwalk_free(walk);
+	if (rc != 0) {
+		write_error(writer, 9, NULL);
+		return -1;
+	}
+
 	bare_put_uint(writer, 0);
+	write_commit_list(repo, oids, count, writer);
+	free(oids);
+	return 0;
+}
+
+int collect_revwalk(git_revwalk *walk, uint64_t limit, git_oid **oids_out, size_t *count_out)
+{
+	git_oid *oids = NULL;
+	size_t count = 0, cap = 0;
 	git_oid oid;
-	uint64_t count = 0;
-	while ((limit == 0 || count < limit)
-	       && git_revwalk_next(&oid, walk) == 0) {
-		git_commit *c = NULL;
-		if (git_commit_lookup(&c, repo, &oid) != 0)
-			break;
-		const char *msg = git_commit_summary(c);
-		const git_signature *author = git_commit_author(c);
-		time_t t = git_commit_time(c);
-		char timebuf[64];
-		struct tm *tm = localtime(&t);
-		if (tm)
-			strftime(timebuf, sizeof(timebuf), "%Y-%m-%d %H:%M:%S", tm);
-		else
-			strcpy(timebuf, "unknown");
+	while ((limit == 0 || count < limit) && git_revwalk_next(&oid, walk) == 0) {
+		if (request_cancelled()) {
+			free(oids);
+			return -1;
+		}
+		if (count == cap) {
+			size_t newcap = cap ? cap * 2 : 64;
+			git_oid *p = (git_oid *) realloc(oids, newcap * sizeof(git_oid));
+			if (!p) {
+				free(oids);
+				return -1;
+			}
+			oids = p;
+			cap = newcap;
+		}
+		git_oid_cpy(&oids[count++], &oid);
+	}
+	*oids_out = oids;
+	*count_out = count;
+	return 0;
+}
 
-		bare_put_data(writer, oid.id, GIT_OID_RAWSZ);
+void write_commit_list(git_repository *repo, const git_oid *oids, size_t count, struct bare_writer *writer)
+{
+	bare_put_uint(writer, (uint64_t)count);
+	for (size_t i = 0; i < count; i++) {
+		git_commit *c = NULL;
+		const char *msg = NULL;
+		const git_signature *author = NULL;
+		/* TODO: Pass the integer instead of a string */
+		char timebuf[64] = "unknown";
+		if (git_commit_lookup(&c, repo, &oids[i]) == 0) {
+			msg = git_commit_summary(c);
+			author = git_commit_author(c);
+			time_t t = git_commit_time(c);
+			struct tm *tm = localtime(&t);
+			if (tm)
+				strftime(timebuf, sizeof(timebuf), "%Y-%m-%d %H:%M:%S", tm);
+		}
+		bare_put_data(writer, oids[i].id, GIT_OID_RAWSZ);
 		bare_put_data(writer, (const uint8_t *)(msg ? msg : ""), msg ? strlen(msg) : 0);
 		bare_put_data(writer, (const uint8_t *)(author && author->name ? author->name : ""), author && author->name ? strlen(author->name) : 0);
 		bare_put_data(writer, (const uint8_t *)(author && author->email ? author->email : ""), author && author->email ? strlen(author->email) : 0);
 		bare_put_data(writer, (const uint8_t *)timebuf, strlen(timebuf));
-		git_commit_free(c);
-		count++;
+		if (c)
+			git_commit_free(c);
 	}
+}
+
+/* Aborts diffs whose request has been cancelled */
+static int diff_progress(const git_diff *diff_so_far, const char *old_path, const char *new_path, void *payload)
+{
+	(void)diff_so_far;
+	(void)old_path;
+	(void)new_path;
+	(void)payload;
+	return request_cancelled() ? -1 : 0;
+}
+
+void diff_options_from_flags(git_diff_options *opts, uint64_t flags)
+{
+	git_diff_options_init(opts, GIT_DIFF_OPTIONS_VERSION);
+	opts->progress_cb = diff_progress;
+	if (flags & DIFF_FLAG_IGNORE_WHITESPACE)
+		opts->flags |= GIT_DIFF_IGNORE_WHITESPACE;
+}
+
+static int line_op(const git_diff_line *line)
+{
+	switch (line->origin) {
+	case GIT_DIFF_LINE_ADDITION:
+		return 1;
+	case GIT_DIFF_LINE_DELETION:
+		return 2;
+	case GIT_DIFF_LINE_CONTEXT_EOFNL:
+	case GIT_DIFF_LINE_ADD_EOFNL:
+	case GIT_DIFF_LINE_DEL_EOFNL:
+		return 3;
+	default:
+		return 0;
+	}
+}
+
+static void write_oid_data(struct bare_writer *writer, const git_diff_file *file)
+{
+	if (file->flags & GIT_DIFF_FLAG_VALID_ID && !git_oid_is_zero(&file->id))
+		bare_put_data(writer, file->id.id, GIT_OID_RAWSZ);
+	else
+		bare_put_data(writer, (const uint8_t *)"", 0);
+}
+
+/*
+ * Detects renames and copies on the diff in place and computes its stats,
+ * for write_diff_files. This is everything that can fail, so callers do it
+ * before writing their status.
+ */
+int prepare_diff_files(git_diff *diff, git_diff_stats **stats_out)
+{
+	git_diff_find_options findopts;
+	git_diff_find_options_init(&findopts, GIT_DIFF_FIND_OPTIONS_VERSION);
+	findopts.flags = GIT_DIFF_FIND_RENAMES | GIT_DIFF_FIND_COPIES;
+	if (git_diff_find_similar(diff, &findopts) != 0)
+		return -1;
+	return git_diff_get_stats(stats_out, diff);
+}
+
+/*
+ * Writes the total lines added and deleted, then a count-prefixed list of
+ * file diffs. For each file: delta status, flags (bit 0: binary),
+ * similarity, old and new blob IDs (empty when absent), modes, paths, lines
+ * added and deleted, then hunks. Each hunk has its old/new ranges and header
+ * followed by its lines, each with an op (0 context, 1 added, 2 deleted,
+ * 3 end-of-file newline marker), old and new line numbers (0 when the line
+ * doesn't exist on that side) and content.
+ *
+ * The diff and stats come from prepare_diff_files; the stats are freed.
+ * The status has already been written, so a failure only ends the reply
+ * early.
+ */
+int write_diff_files(git_diff *diff, git_diff_stats *stats, struct bare_writer *writer)
+{
+	bare_put_uint(writer, (uint64_t)git_diff_stats_insertions(stats));
+	bare_put_uint(writer, (uint64_t)git_diff_stats_deletions(stats));
+	git_diff_stats_free(stats);
+
+	size_t files = git_diff_num_deltas(diff);
+	bare_put_uint(writer, (uint64_t)files);
+	for (size_t i = 0; i < files; i++) {
+		if (request_cancelled())
+			return -1;
+		const git_diff_delta *delta = git_diff_get_delta(diff, i);
+		git_patch *patch = NULL;
+		if (git_patch_from_diff(&patch, diff, i) != 0)
+			patch = NULL;
+
+		const char *from_path = delta->old_file.path ? delta->old_file.path : "";
+		const char *to_path = delta->new_file.path ? delta->new_file.path : "";
+		uint64_t flags = 0;
+		if (delta->flags & GIT_DIFF_FLAG_BINARY)
+			flags |= 1;
+
+		bare_put_uint(writer, (uint64_t)delta->status);
+		bare_put_uint(writer, flags);
+		bare_put_uint(writer, (uint64_t)delta->similarity);
+		write_oid_data(writer, &delta->old_file);
+		write_oid_data(writer, &delta->new_file);
+		bare_put_uint(writer, (uint64_t)delta->old_file.mode);
+		bare_put_uint(writer, (uint64_t)delta->new_file.mode);
+		bare_put_data(writer, (const uint8_t *)from_path, strlen(from_path));
+		bare_put_data(writer, (const uint8_t *)to_path, strlen(to_path));
+
+		size_t additions = 0, deletions = 0;
+		if (patch)
+			git_patch_line_stats(NULL, &additions, &deletions, patch);
+		bare_put_uint(writer, (uint64_t)additions);
+		bare_put_uint(writer, (uint64_t)deletions);
+
+		size_t hunks = patch ? git_patch_num_hunks(patch) : 0;
+		bare_put_uint(writer, (uint64_t)hunks);
+		for (size_t h = 0; h < hunks; h++) {
+			const git_diff_hunk *hunk = NULL;
+			size_t lines = 0;
+			if (git_patch_get_hunk(&hunk, &lines, patch, h) != 0) {
+				git_patch_free(patch);
+				return -1;
+			}
+			size_t header_len = strnlen(hunk->header, hunk->header_len);
+			while (header_len > 0 && hunk->header[header_len - 1] == '\n')
+				header_len--;
+			bare_put_uint(writer, (uint64_t)hunk->old_start);
+			bare_put_uint(writer, (uint64_t)hunk->old_lines);
+			bare_put_uint(writer, (uint64_t)hunk->new_start);
+			bare_put_uint(writer, (uint64_t)hunk->new_lines);
+			bare_put_data(writer, (const uint8_t *)hunk->header, header_len);
+			bare_put_uint(writer, (uint64_t)lines);
+			for (size_t ln = 0; ln < lines; ln++) {
+				const git_diff_line *line = NULL;
+				if (git_patch_get_line_in_hunk(&line, patch, h, ln) != 0 || !line) {
+					git_patch_free(patch);
+					return -1;
+				}
+				bare_put_uint(writer, (uint64_t)line_op(line));
+				bare_put_uint(writer, line->old_lineno > 0 ? (uint64_t)line->old_lineno : 0);
+				bare_put_uint(writer, line->new_lineno > 0 ? (uint64_t)line->new_lineno : 0);
+				bare_put_data(writer, (const uint8_t *)line->content, line->content_len);
+			}
+		}
+		git_patch_free(patch);
+	}
+	return 0;
+}
+
+static int tree_from_spec(git_tree **out, git_repository *repo, const char *spec)
+{
+	*out = NULL;
+	if (spec[0] == '\0')
+		return 0;
+	git_object *obj = NULL;
+	if (git_revparse_single(&obj, repo, spec) != 0)
+		return -1;
+	int rc = git_object_peel((git_object **) out, obj, GIT_OBJECT_TREE);
+	git_object_free(obj);
+	return rc;
+}
+
+int cmd_diff_trees(git_repository *repo, struct bare_reader *reader, struct bare_writer *writer)
+{
+	char old_spec[64] = { 0 };
+	char new_spec[64] = { 0 };
+	uint64_t format = 0;
+	if (bare_get_data(reader, (uint8_t *) old_spec, sizeof(old_spec) - 1) != BARE_ERROR_NONE) {
+		write_error(writer, 11, NULL);
+		return -1;
+	}
+	if (bare_get_data(reader, (uint8_t *) new_spec, sizeof(new_spec) - 1) != BARE_ERROR_NONE) {
+		write_error(writer, 11, NULL);
+		return -1;
+	}
+	if (bare_get_uint(reader, &format) != BARE_ERROR_NONE) {
+		write_error(writer, 11, NULL);
+		return -1;
+	}
+	uint64_t diff_flags = 0;
+	if (bare_get_uint(reader, &diff_flags) != BARE_ERROR_NONE) {
+		write_error(writer, 11, NULL);
+		return -1;
+	}
+
+	/* An empty old side diffs against the empty tree */
+	git_tree *old_tree = NULL;
+	git_tree *new_tree = NULL;
+	if (tree_from_spec(&old_tree, repo, old_spec) != 0) {
+		write_error(writer, 4, old_spec);
+		return -1;
+	}
+	if (new_spec[0] == '\0' || tree_from_spec(&new_tree, repo, new_spec) != 0) {
+		git_tree_free(old_tree);
+		write_error(writer, 4, new_spec);
+		return -1;
+	}
+
+	git_diff_options diffopts;
+	diff_options_from_flags(&diffopts, diff_flags);
+	git_diff *diff = NULL;
+	if (git_diff_tree_to_tree(&diff, repo, old_tree, new_tree, &diffopts) != 0) {
+		git_tree_free(new_tree);
+		git_tree_free(old_tree);
+		write_error(writer, 15, NULL);
+		return -1;
+	}
+
+	int rc = 0;
+	if (format == 1) {
+		git_buf patch = { 0 };
+		if (git_diff_to_buf(&patch, diff, GIT_DIFF_FORMAT_PATCH) != 0) {
+			write_error(writer, 15, NULL);
+			rc = -1;
+		} else {
+			bare_put_uint(writer, 0);
+			bare_put_data(writer, (const uint8_t *)patch.ptr, patch.size);
+		}
+		git_buf_dispose(&patch);
+	} else {
+		git_diff_stats *stats = NULL;
+		if (prepare_diff_files(diff, &stats) != 0) {
+			write_error(writer, 15, NULL);
+			rc = -1;
+		} else {
+			bare_put_uint(writer, 0);
+			rc = write_diff_files(diff, stats, writer);
+		}
+	}
+
+	git_diff_free(diff);
+	git_tree_free(new_tree);
+	git_tree_free(old_tree);
+	return rc;
+}
+
+int cmd_log_range(git_repository *repo, struct bare_reader *reader, struct bare_writer *writer)
+{
+	char base_hex[64] = { 0 };
+	char head_hex[64] = { 0 };
+	uint64_t limit = 0;
+	if (bare_get_data(reader, (uint8_t *) base_hex, sizeof(base_hex) - 1) != BARE_ERROR_NONE) {
+		write_error(writer, 11, NULL);
+		return -1;
+	}
+	if (bare_get_data(reader, (uint8_t *) head_hex, sizeof(head_hex) - 1) != BARE_ERROR_NONE) {
+		write_error(writer, 11, NULL);
+		return -1;
+	}
+	if (bare_get_uint(reader, &limit) != BARE_ERROR_NONE) {
+		write_error(writer, 11, NULL);
+		return -1;
+	}
+
+	git_oid base, head;
+	if (git_oid_fromstr(&base, base_hex) != 0) {
+		write_error(writer, 4, base_hex);
+		return -1;
+	}
+	if (git_oid_fromstr(&head, head_hex) != 0) {
+		write_error(writer, 4, head_hex);
+		return -1;
+	}
+
+	size_t ahead = 0, behind = 0;
+	if (git_graph_ahead_behind(&ahead, &behind, repo, &head, &base) != 0) {
+		write_error(writer, 9, NULL);
+		return -1;
+	}
+
+	git_revwalk *walk = NULL;
+	if (git_revwalk_new(&walk, repo) != 0) {
+		write_error(writer, 9, NULL);
+		return -1;
+	}
+	git_revwalk_sorting(walk, GIT_SORT_TIME);
+	if (git_revwalk_push(walk, &head) != 0 || git_revwalk_hide(walk, &base) != 0) {
+		git_revwalk_free(walk);
+		write_error(writer, 9, NULL);
+		return -1;
+	}
+
+	/* The count is sent before the commits, so collect them first */
+	git_oid *oids = NULL;
+	size_t count = 0;
+	int rc = collect_revwalk(walk, limit, &oids, &count);
 	git_revwalk_free(walk);
+	if (rc != 0) {
+		write_error(writer, 9, NULL);
+		return -1;
+	}
+
+	bare_put_uint(writer, 0);
+	bare_put_uint(writer, (uint64_t)ahead);
+	bare_put_uint(writer, (uint64_t)behind);
+	write_commit_list(repo, oids, count, writer);
+	free(oids);
 	return 0;
 }
@@ -10,7 +10,7 @@ int cmd_init_repo(const char *path, struct bare_reader *reader, struct bare_writ
 	char hooks[4096] = { 0 };
 	if (bare_get_data(reader, (uint8_t *) hooks, sizeof(hooks) - 1) != BARE_ERROR_NONE) {
 		fprintf(stderr, "init_repo: protocol error reading hooks for path '%s'\n", path);
-		bare_put_uint(writer, 11);
+		write_error(writer, 11, NULL);
 		return -1;
 	}
 
@@ -18,7 +18,7 @@ int cmd_init_repo(const char *path, struct bare_reader *reader, struct bare_writ
 
 	if (mkdir(path, 0700) != 0 && errno != EEXIST) {
 		fprintf(stderr, "init_repo: mkdir failed for '%s': %s\n", path, strerror(errno));
-		bare_put_uint(writer, 24);
+		write_error(writer, 24, path);
 		return -1;
 	}
 
@@ -29,7 +29,7 @@ int cmd_init_repo(const char *path, struct bare_reader *reader, struct bare_writ
 	if (git_repository_init_ext(&repo, path, &opts) != 0) {
 		const git_error *ge = git_error_last();
 		fprintf(stderr, "init_repo: git_repository_init_ext failed: %s (klass=%d)\n", ge && ge->message ? ge->message : "(no message)", ge ? ge->klass : 0);
-		bare_put_uint(writer, 20);
+		write_error(writer, 20, path);
 		return -1;
 	}
 	git_config *cfg = NULL;
@@ -37,7 +37,7 @@ int cmd_init_repo(const char *path, struct bare_reader *reader, struct bare_writ
 		git_repository_free(repo);
 		const git_error *ge = git_error_last();
 		fprintf(stderr, "init_repo: open config failed: %s (klass=%d)\n", ge && ge->message ? ge->message : "(no message)", ge ? ge->klass : 0);
-		bare_put_uint(writer, 21);
+		write_error(writer, 21, NULL);
 		return -1;
 	}
 	if (git_config_set_string(cfg, "core.hooksPath", hooks) != 0) {
@@ -45,7 +45,7 @@ int cmd_init_repo(const char *path, struct bare_reader *reader, struct bare_writ
 		git_repository_free(repo);
 		const git_error *ge = git_error_last();
 		fprintf(stderr, "init_repo: set hooksPath failed: %s (klass=%d) hooks='%s'\n", ge && ge->message ? ge->message : "(no message)", ge ? ge->klass : 0, hooks);
-		bare_put_uint(writer, 22);
+		write_error(writer, 22, hooks);
 		return -1;
 	}
 	if (git_config_set_bool(cfg, "receive.advertisePushOptions", 1) != 0) {
@@ -53,7 +53,7 @@ int cmd_init_repo(const char *path, struct bare_reader *reader, struct bare_writ
 		git_repository_free(repo);
 		const git_error *ge = git_error_last();
 		fprintf(stderr, "init_repo: set advertisePushOptions failed: %s (klass=%d)\n", ge && ge->message ? ge->message : "(no message)", ge ? ge->klass : 0);
-		bare_put_uint(writer, 23);
+		write_error(writer, 23, NULL);
 		return -1;
 	}
 	git_config_free(cfg);
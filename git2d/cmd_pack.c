@@ -0,0 +1,393 @@
+/*-
+ * SPDX-License-Identifier: AGPL-3.0-only
+ * SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+ */
+
+#include "x.h"
+
+#include <fcntl.h>
+
+/*
+ * Copying a repository to another git2d: cmd_pack sends its references
+ * and a packfile of their objects, which the receiver's cmd_import_chunk
+ * writes to a temporary file in the repository, one request per chunk,
+ * and cmd_import_finish then indexes before setting the references.
+ */
+
+typedef struct {
+	char *name;
+	git_oid oid;
+} pack_ref_t;
+
+static void free_refs(pack_ref_t * refs, size_t count)
+{
+	for (size_t i = 0; i < count; i++)
+		free(refs[i].name);
+	free(refs);
+}
+
+/* Collects the direct references of repo, skipping symbolic ones */
+static int collect_refs(git_repository * repo, pack_ref_t ** refs_out, size_t *count_out)
+{
+	git_reference_iterator *it = NULL;
+	if (git_reference_iterator_new(&it, repo) != 0)
+		return -1;
+
+	pack_ref_t *refs = NULL;
+	size_t count = 0, cap = 0;
+	git_reference *ref = NULL;
+	int err;
+	while ((err = git_reference_next(&ref, it)) == 0) {
+		if (git_reference_type(ref) != GIT_REFERENCE_DIRECT) {
+			git_reference_free(ref);
+			continue;
+		}
+		if (count == cap) {
+			cap = cap ? cap * 2 : 64;
+			pack_ref_t *grown = realloc(refs, cap * sizeof(*refs));
+			if (grown == NULL) {
+				git_reference_free(ref);
+				err = -1;
+				break;
+			}
+			refs = grown;
+		}
+		refs[count].name = strdup(git_reference_name(ref));
+		git_oid_cpy(&refs[count].oid, git_reference_target(ref));
+		git_reference_free(ref);
+		if (refs[count].name == NULL) {
+			err = -1;
+			break;
+		}
+		count++;
+	}
+	git_reference_iterator_free(it);
+
+	if (err != GIT_ITEROVER) {
+		free_refs(refs, count);
+		return -1;
+	}
+	*refs_out = refs;
+	*count_out = count;
+	return 0;
+}
+
+/* Adds the object a reference points to and everything reachable from it */
+static int pack_add_tip(git_packbuilder * pb, git_revwalk * walk, git_repository * repo, const git_oid * oid, int *pushed)
+{
+	git_object *obj = NULL;
+	int err = git_object_lookup(&obj, repo, oid, GIT_OBJECT_ANY);
+	if (err != 0)
+		return err;
+
+	switch (git_object_type(obj)) {
+	case GIT_OBJECT_COMMIT:
+		err = git_revwalk_push(walk, oid);
+		*pushed = 1;
+		break;
+	case GIT_OBJECT_TAG:{
+			git_object *target = NULL;
+			err = git_packbuilder_insert(pb, oid, NULL);
+			if (err == 0)
+				err = git_tag_peel(&target, (git_tag *) obj);
+			if (err == 0) {
+				err = pack_add_tip(pb, walk, repo, git_object_id(target), pushed);
+				git_object_free(target);
+			}
+			break;
+		}
+	default:
+		err = git_packbuilder_insert_recur(pb, oid, NULL);
+		break;
+	}
+	git_object_free(obj);
+	return err;
+}
+
+static int pack_chunk(void *buf, size_t size, void *payload)
+{
+	struct bare_writer *writer = payload;
+	const uint8_t *p = buf;
+	while (size > 0) {
+		if (request_cancelled())
+			return -1;
+		size_t n = size < BLOB_CHUNK_SIZE ? size : BLOB_CHUNK_SIZE;
+		if (bare_put_data(writer, p, n) != BARE_ERROR_NONE)
+			return -1;
+		p += n;
+		size -= n;
+	}
+	return 0;
+}
+
+int cmd_pack(git_repository *repo, struct bare_reader *reader, struct bare_writer *writer)
+{
+	int ret = -1;
+	git_revwalk *walk = NULL;
+	git_packbuilder *pb = NULL;
+	pack_ref_t *refs = NULL;
+	size_t count = 0;
+
+	uint64_t nexclude = 0;
+	if (bare_get_uint(reader, &nexclude) != BARE_ERROR_NONE) {
+		write_error(writer, 11, NULL);
+		return -1;
+	}
+	if (git_revwalk_new(&walk, repo) != 0 || git_packbuilder_new(&pb, repo) != 0) {
+		write_error(writer, 25, NULL);
+		goto out;
+	}
+	for (uint64_t i = 0; i < nexclude; i++) {
+		uint64_t len = 0;
+		git_oid oid;
+		if (bare_get_uint(reader, &len) != BARE_ERROR_NONE || len != GIT_OID_RAWSZ
+		    || bare_get_fixed_data(reader, oid.id, GIT_OID_RAWSZ) != BARE_ERROR_NONE) {
+			write_error(writer, 11, NULL);
+			goto out;
+		}
+		/* Tips the receiver already has; those gone from here don't matter */
+		if (git_revwalk_hide(walk, &oid) != 0)
+			git_error_clear();
+	}
+
+	if (collect_refs(repo, &refs, &count) != 0) {
+		write_error(writer, 25, NULL);
+		goto out;
+	}
+	int pushed = 0;
+	for (size_t i = 0; i < count; i++) {
+		if (pack_add_tip(pb, walk, repo, &refs[i].oid, &pushed) != 0) {
+			write_error(writer, 25, refs[i].name);
+			goto out;
+		}
+	}
+	if (pushed && git_packbuilder_insert_walk(pb, walk) != 0) {
+		write_error(writer, 25, NULL);
+		goto out;
+	}
+
+	const char *head = "";
+	git_reference *head_ref = NULL;
+	if (git_reference_lookup(&head_ref, repo, "HEAD") == 0 && git_reference_type(head_ref) == GIT_REFERENCE_SYMBOLIC)
+		head = git_reference_symbolic_target(head_ref);
+
+	bare_put_uint(writer, 0);
+	bare_put_data(writer, (const uint8_t *)head, strlen(head));
+	git_reference_free(head_ref);
+	bare_put_uint(writer, count);
+	for (size_t i = 0; i < count; i++) {
+		bare_put_data(writer, (const uint8_t *)refs[i].name, strlen(refs[i].name));
+		bare_put_data(writer, refs[i].oid.id, GIT_OID_RAWSZ);
+	}
+
+	/* The pack follows as data chunks terminated by an empty one, like blobs */
+	if (git_packbuilder_foreach(pb, pack_chunk, writer) != 0)
+		goto out;
+	bare_put_data(writer, (const uint8_t *)"", 0);
+	ret = 0;
+
+ out:
+	free_refs(refs, count);
+	git_packbuilder_free(pb);
+	git_revwalk_free(walk);
+	return ret;
+}
+
+static void import_path(git_repository *repo, char *buf, size_t size)
+{
+	snprintf(buf, size, "%s%s", git_repository_path(repo), IMPORT_PACK_NAME);
+}
+
+int cmd_import_chunk(git_repository *repo, struct bare_reader *reader, struct bare_writer *writer)
+{
+	uint64_t offset = 0, len = 0;
+	if (bare_get_uint(reader, &offset) != BARE_ERROR_NONE || bare_get_uint(reader, &len) != BARE_ERROR_NONE || len > IMPORT_CHUNK_MAX_SIZE) {
+		write_error(writer, 11, NULL);
+		return -1;
+	}
+	uint8_t *buf = malloc(len ? len : 1);
+	if (buf == NULL) {
+		write_error(writer, 26, NULL);
+		return -1;
+	}
+	if (bare_get_fixed_data(reader, buf, len) != BARE_ERROR_NONE) {
+		free(buf);
+		write_error(writer, 11, NULL);
+		return -1;
+	}
+
+	char path[4096];
+	import_path(repo, path, sizeof(path));
+	int fd = open(path, O_WRONLY | O_CREAT | O_CLOEXEC | (offset == 0 ? O_TRUNC : 0), 0600);
+	if (fd < 0) {
+		fprintf(stderr, "import: open '%s' failed: %s\n", path, strerror(errno));
+		free(buf);
+		write_error(writer, 26, path);
+		return -1;
+	}
+
+	/* Chunks must arrive in order; anything else means one was lost */
+	struct stat st;
+	int ok = fstat(fd, &st) == 0 && (uint64_t)st.st_size == offset;
+	for (uint64_t done = 0; ok && done < len;) {
+		ssize_t n = pwrite(fd, buf + done, len - done, (off_t) (offset + done));
+		if (n < 0 && errno == EINTR)
+			continue;
+		if (n <= 0) {
+			fprintf(stderr, "import: write '%s' failed: %s\n", path, strerror(errno));
+			ok = 0;
+			break;
+		}
+		done += n;
+	}
+	close(fd);
+	free(buf);
+
+	if (!ok) {
+		write_error(writer, 26, path);
+		return -1;
+	}
+	bare_put_uint(writer, 0);
+	return 0;
+}
+
+/* Reads BARE data as a NUL-terminated string, or returns NULL */
+static char *get_text(struct bare_reader *reader)
+{
+	uint64_t len = 0;
+	if (bare_get_uint(reader, &len) != BARE_ERROR_NONE || len > 65536)
+		return NULL;
+	char *s = malloc(len + 1);
+	if (s == NULL)
+		return NULL;
+	if (bare_get_fixed_data(reader, (uint8_t *) s, len) != BARE_ERROR_NONE) {
+		free(s);
+		return NULL;
+	}
+	s[len] = '\0';
+	return s;
+}
+
+static int compare_refs(const void *a, const void *b)
+{
+	return strcmp(((const pack_ref_t *)a)->name, ((const pack_ref_t *)b)->name);
+}
+
+/* Indexes the imported pack into repo's object database, if there is one */
+static int index_import(git_repository *repo, const char *path)
+{
+	int fd = open(path, O_RDONLY | O_CLOEXEC);
+	if (fd < 0)
+		return errno == ENOENT ? 0 : -1;
+
+	git_odb *odb = NULL;
+	git_odb_writepack *wp = NULL;
+	if (git_repository_odb(&odb, repo) != 0 || git_odb_write_pack(&wp, odb, NULL, NULL) != 0) {
+		git_odb_free(odb);
+		close(fd);
+		return -1;
+	}
+
+	git_indexer_progress stats;
+	memset(&stats, 0, sizeof(stats));
+	uint8_t buf[BLOB_CHUNK_SIZE];
+	int err = 0;
+	for (;;) {
+		ssize_t n = read(fd, buf, sizeof(buf));
+		if (n < 0 && errno == EINTR)
+			continue;
+		if (n < 0) {
+			err = -1;
+			break;
+		}
+		if (n == 0)
+			break;
+		if ((err = wp->append(wp, buf, n, &stats)) != 0)
+			break;
+	}
+	if (err == 0)
+		err = wp->commit(wp, &stats);
+
+	wp->free(wp);
+	git_odb_free(odb);
+	close(fd);
+	if (err == 0)
+		unlink(path);
+	return err;
+}
+
+int cmd_import_finish(git_repository *repo, struct bare_reader *reader, struct bare_writer *writer)
+{
+	int ret = -1;
+	pack_ref_t *refs = NULL;
+	size_t count = 0;
+	pack_ref_t *old = NULL;
+	size_t old_count = 0;
+
+	char *head = get_text(reader);
+	uint64_t n = 0;
+	if (head == NULL || bare_get_uint(reader, &n) != BARE_ERROR_NONE) {
+		write_error(writer, 11, NULL);
+		goto out;
+	}
+	refs = calloc(n ? n : 1, sizeof(*refs));
+	if (refs == NULL) {
+		write_error(writer, 26, NULL);
+		goto out;
+	}
+	for (; count < n; count++) {
+		uint64_t len = 0;
+		refs[count].name = get_text(reader);
+		if (refs[count].name == NULL || bare_get_uint(reader, &len) != BARE_ERROR_NONE || len != GIT_OID_RAWSZ
+		    || bare_get_fixed_data(reader, refs[count].oid.id, GIT_OID_RAWSZ) != BARE_ERROR_NONE) {
+			count++;
+			write_error(writer, 11, NULL);
+			goto out;
+		}
+	}
+
+	char path[4096];
+	import_path(repo, path, sizeof(path));
+	if (index_import(repo, path) != 0) {
+		write_error(writer, 26, path);
+		goto out;
+	}
+
+	for (size_t i = 0; i < count; i++) {
+		git_reference *ref = NULL;
+		if (git_reference_create(&ref, repo, refs[i].name, &refs[i].oid, 1, "forge: import") != 0) {
+			write_error(writer, 26, refs[i].name);
+			goto out;
+		}
+		git_reference_free(ref);
+	}
+
+	/* References deleted from the source since an earlier import */
+	qsort(refs, count, sizeof(*refs), compare_refs);
+	if (collect_refs(repo, &old, &old_count) != 0) {
+		write_error(writer, 26, NULL);
+		goto out;
+	}
+	for (size_t i = 0; i < old_count; i++) {
+		if (bsearch(&old[i], refs, count, sizeof(*refs), compare_refs) != NULL)
+			continue;
+		if (git_reference_remove(repo, old[i].name) != 0) {
+			write_error(writer, 26, old[i].name);
+			goto out;
+		}
+	}
+
+	if (head[0] != '\0' && git_repository_set_head(repo, head) != 0) {
+		write_error(writer, 26, head);
+		goto out;
+	}
+
+	bare_put_uint(writer, 0);
+	ret = 0;
+
+ out:
+	free(head);
+	free_refs(refs, count);
+	free_refs(old, old_count);
+	return ret;
+}
@@ -15,11 +15,11 @@ int cmd_resolve_ref(git_repository *repo, struct bare_reader *reader, struct bar
 	char type[32] = { 0 };
 	char name[4096] = { 0 };
 	if (bare_get_data(reader, (uint8_t *) type, sizeof(type) - 1) != BARE_ERROR_NONE) {
-		bare_put_uint(writer, 11);
+		write_error(writer, 11, NULL);
 		return -1;
 	}
 	if (bare_get_data(reader, (uint8_t *) name, sizeof(name) - 1) != BARE_ERROR_NONE) {
-		bare_put_uint(writer, 11);
+		write_error(writer, 11, NULL);
 		return -1;
 	}
 
@@ -30,7 +30,7 @@ int cmd_resolve_ref(git_repository *repo, struct bare_reader *reader, struct bar
 		git_object *obj = NULL;
 		err = git_revparse_single(&obj, repo, "HEAD^{commit}");
 		if (err != 0) {
-			bare_put_uint(writer, 12);
+			write_error(writer, 12, "HEAD");
 			return -1;
 		}
 		git_commit *c = (git_commit *) obj;
@@ -39,7 +39,7 @@ int cmd_resolve_ref(git_repository *repo, struct bare_reader *reader, struct bar
 	} else if (strcmp(type, "commit") == 0) {
 		err = git_oid_fromstr(&oid, name);
 		if (err != 0) {
-			bare_put_uint(writer, 12);
+			write_error(writer, 12, name);
 			return -1;
 		}
 	} else if (strcmp(type, "branch") == 0) {
@@ -48,7 +48,7 @@ int cmd_resolve_ref(git_repository *repo, struct bare_reader *reader, struct bar
 		git_object *obj = NULL;
 		err = git_revparse_single(&obj, repo, fullref);
 		if (err != 0) {
-			bare_put_uint(writer, 12);
+			write_error(writer, 12, name);
 			return -1;
 		}
 		git_commit *c = (git_commit *) obj;
@@ -60,14 +60,26 @@ int cmd_resolve_ref(git_repository *repo, struct bare_reader *reader, struct bar
 		git_object *obj = NULL;
 		err = git_revparse_single(&obj, repo, spec);
 		if (err != 0) {
-			bare_put_uint(writer, 12);
+			write_error(writer, 12, name);
+			return -1;
+		}
+		git_commit *c = (git_commit *) obj;
+		git_oid_cpy(&oid, git_commit_id(c));
+		git_commit_free(c);
+	} else if (strcmp(type, "rev") == 0) {
+		char spec[4608];
+		snprintf(spec, sizeof(spec), "%s^{commit}", name);
+		git_object *obj = NULL;
+		err = git_revparse_single(&obj, repo, spec);
+		if (err != 0) {
+			write_error(writer, 12, name);
 			return -1;
 		}
 		git_commit *c = (git_commit *) obj;
 		git_oid_cpy(&oid, git_commit_id(c));
 		git_commit_free(c);
 	} else {
-		bare_put_uint(writer, 12);
+		write_error(writer, 12, name);
 		return -1;
 	}
 
@@ -80,7 +92,7 @@ int cmd_list_branches(git_repository *repo, struct bare_writer *writer)
 	git_branch_iterator *it = NULL;
 	int err = git_branch_iterator_new(&it, repo, GIT_BRANCH_LOCAL);
 	if (err != 0) {
-		bare_put_uint(writer, 13);
+		write_error(writer, 13, NULL);
 		return -1;
 	}
 	size_t count = 0;
@@ -94,7 +106,7 @@ int cmd_list_branches(git_repository *repo, struct bare_writer *writer)
 
 	err = git_branch_iterator_new(&it, repo, GIT_BRANCH_LOCAL);
 	if (err != 0) {
-		bare_put_uint(writer, 13);
+		write_error(writer, 13, NULL);
 		return -1;
 	}
 
@@ -111,3 +123,53 @@ int cmd_list_branches(git_repository *repo, struct bare_writer *writer)
 	git_branch_iterator_free(it);
 	return 0;
 }
+
+/* Peels a tag reference to a commit, failing for tags of anything else */
+static int peel_tag(git_commit **out, git_reference *ref)
+{
+	git_object *obj = NULL;
+	if (git_reference_peel(&obj, ref, GIT_OBJECT_COMMIT) != 0)
+		return -1;
+	*out = (git_commit *) obj;
+	return 0;
+}
+
+int cmd_list_tags(git_repository *repo, struct bare_writer *writer)
+{
+	git_reference_iterator *it = NULL;
+	if (git_reference_iterator_glob_new(&it, repo, "refs/tags/*") != 0) {
+		write_error(writer, 27, NULL);
+		return -1;
+	}
+	size_t count = 0;
+	git_reference *ref;
+	git_commit *commit;
+	while (git_reference_next(&ref, it) == 0) {
+		if (peel_tag(&commit, ref) == 0) {
+			count++;
+			git_commit_free(commit);
+		}
+		git_reference_free(ref);
+	}
+	git_reference_iterator_free(it);
+
+	if (git_reference_iterator_glob_new(&it, repo, "refs/tags/*") != 0) {
+		write_error(writer, 27, NULL);
+		return -1;
+	}
+
+	bare_put_uint(writer, 0);
+	bare_put_uint(writer, count);
+	while (count > 0 && git_reference_next(&ref, it) == 0) {
+		if (peel_tag(&commit, ref) == 0) {
+			const char *name = git_reference_shorthand(ref);
+			bare_put_data(writer, (const uint8_t *)name, strlen(name));
+			write_oid(writer, git_commit_id(commit));
+			git_commit_free(commit);
+			count--;
+		}
+		git_reference_free(ref);
+	}
+	git_reference_iterator_free(it);
+	return 0;
+}
@@ -9,17 +9,17 @@ int cmd_tree_list_by_oid(git_repository *repo, struct bare_reader *reader, struc
 {
 	char hex[64] = { 0 };
 	if (bare_get_data(reader, (uint8_t *) hex, sizeof(hex) - 1) != BARE_ERROR_NONE) {
-		bare_put_uint(writer, 11);
+		write_error(writer, 11, NULL);
 		return -1;
 	}
 	git_oid oid;
 	if (git_oid_fromstr(&oid, hex) != 0) {
-		bare_put_uint(writer, 4);
+		write_error(writer, 4, hex);
 		return -1;
 	}
 	git_tree *tree = NULL;
 	if (git_tree_lookup(&tree, repo, &oid) != 0) {
-		bare_put_uint(writer, 4);
+		write_error(writer, 4, hex);
 		return -1;
 	}
 	size_t count = git_tree_entrycount(tree);
@@ -42,31 +42,33 @@ int cmd_write_tree(git_repository *repo, struct bare_reader *reader, struct bare
 {
 	uint64_t count = 0;
 	if (bare_get_uint(reader, &count) != BARE_ERROR_NONE) {
-		bare_put_uint(writer, 11);
+		write_error(writer, 11, NULL);
 		return -1;
 	}
 	git_treebuilder *bld = NULL;
 	if (git_treebuilder_new(&bld, repo, NULL) != 0) {
-		bare_put_uint(writer, 15);
+		write_error(writer, 15, NULL);
 		return -1;
 	}
 	for (uint64_t i = 0; i < count; i++) {
 		uint64_t mode = 0;
 		if (bare_get_uint(reader, &mode) != BARE_ERROR_NONE) {
 			git_treebuilder_free(bld);
-			bare_put_uint(writer, 11);
+			write_error(writer, 11, NULL);
 			return -1;
 		}
 		char name[4096] = { 0 };
 		if (bare_get_data(reader, (uint8_t *) name, sizeof(name) - 1) != BARE_ERROR_NONE) {
 			git_treebuilder_free(bld);
-			bare_put_uint(writer, 11);
+			write_error(writer, 11, NULL);
 			return -1;
 		}
 		uint8_t idraw[GIT_OID_RAWSZ] = { 0 };
-		if (bare_get_fixed_data(reader, idraw, GIT_OID_RAWSZ) != BARE_ERROR_NONE) {
+		uint64_t idlen = 0;
+		if (bare_get_uint(reader, &idlen) != BARE_ERROR_NONE || idlen != GIT_OID_RAWSZ
+		    || bare_get_fixed_data(reader, idraw, GIT_OID_RAWSZ) != BARE_ERROR_NONE) {
 			git_treebuilder_free(bld);
-			bare_put_uint(writer, 11);
+			write_error(writer, 11, NULL);
 			return -1;
 		}
 		git_oid id;
@@ -74,14 +76,14 @@ int cmd_write_tree(git_repository *repo, struct bare_reader *reader, struct bare
 		git_filemode_t fm = (git_filemode_t) mode;
 		if (git_treebuilder_insert(NULL, bld, name, &id, fm) != 0) {
 			git_treebuilder_free(bld);
-			bare_put_uint(writer, 15);
+			write_error(writer, 15, NULL);
 			return -1;
 		}
 	}
 	git_oid out;
 	if (git_treebuilder_write(&out, bld) != 0) {
 		git_treebuilder_free(bld);
-		bare_put_uint(writer, 15);
+		write_error(writer, 15, NULL);
 		return -1;
 	}
 	git_treebuilder_free(bld);
@@ -94,23 +96,23 @@ int cmd_blob_write(git_repository *repo, struct bare_reader *reader, struct bare
 {
 	uint64_t sz = 0;
 	if (bare_get_uint(reader, &sz) != BARE_ERROR_NONE) {
-		bare_put_uint(writer, 11);
+		write_error(writer, 11, NULL);
 		return -1;
 	}
 	uint8_t *data = (uint8_t *) malloc(sz);
 	if (!data) {
-		bare_put_uint(writer, 15);
+		write_error(writer, 15, NULL);
 		return -1;
 	}
 	if (bare_get_fixed_data(reader, data, sz) != BARE_ERROR_NONE) {
 		free(data);
-		bare_put_uint(writer, 11);
+		write_error(writer, 11, NULL);
 		return -1;
 	}
 	git_oid oid;
 	if (git_blob_create_frombuffer(&oid, repo, data, sz) != 0) {
 		free(data);
-		bare_put_uint(writer, 15);
+		write_error(writer, 15, NULL);
 		return -1;
 	}
 	free(data);
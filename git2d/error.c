@@ -0,0 +1,25 @@
+/*-
+ * SPDX-License-Identifier: AGPL-3.0-only
+ * SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+ */
+
+#include "x.h"
+
+void write_error(struct bare_writer *writer, uint64_t status, const char *input)
+{
+	const git_error *ge = git_error_last();
+	int klass = 0;
+	const char *message = "";
+	if (ge != NULL && ge->klass != GIT_ERROR_NONE) {
+		klass = ge->klass;
+		if (ge->message != NULL)
+			message = ge->message;
+	}
+	if (input == NULL)
+		input = "";
+
+	bare_put_uint(writer, status);
+	bare_put_uint(writer, (uint64_t)klass);
+	bare_put_data(writer, (const uint8_t *)message, strlen(message));
+	bare_put_data(writer, (const uint8_t *)input, strlen(input));
+}
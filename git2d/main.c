@@ -3,23 +3,10 @@
  * SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
  */
 
-/*
- * TODO: Pool repositories (and take care of thread safety)
- * libgit2 has a nice builtin per-repo cache that we could utilize this way.
- */
-
 #include "x.h"
 
-int main(int argc, char **argv)
+static int unix_listen(const char *path)
 {
-	if (argc != 2) {
-		errx(1, "provide one argument: the socket path");
-	}
-
-	signal(SIGPIPE, SIG_IGN);
-
-	git_libgit2_init();
-
 	int sock;
 	if ((sock = socket(AF_UNIX, SOCK_STREAM | SOCK_CLOEXEC, 0)) < 0)
 		err(1, "socket");
@@ -27,13 +14,15 @@ int main(int argc, char **argv)
 	struct sockaddr_un addr;
 	memset(&addr, 0, sizeof(addr));
 	addr.sun_family = AF_UNIX;
-	strcpy(addr.sun_path, argv[1]);
+	if (strlen(path) >= sizeof(addr.sun_path))
+		errx(1, "%s: socket path too long", path);
+	strcpy(addr.sun_path, path);
 
 	umask(0077);
 
 	if (bind(sock, (struct sockaddr *)&addr, sizeof(struct sockaddr_un))) {
 		if (errno == EADDRINUSE) {
-			unlink(argv[1]);
+			unlink(path);
 			if (bind(sock, (struct sockaddr *)&addr, sizeof(struct sockaddr_un)))
 				err(1, "bind");
 		} else {
@@ -43,6 +32,55 @@ int main(int argc, char **argv)
 
 	listen(sock, 128);
 
+	return sock;
+}
+
+static void usage(void)
+{
+	errx(1, "usage: git2d socket_path\n"
+	     "       git2d -c cert -k key -a client_ca " TLS_SCHEME "host:port");
+}
+
+int main(int argc, char **argv)
+{
+	const char *cert = NULL, *key = NULL, *client_ca = NULL;
+	int ch;
+	while ((ch = getopt(argc, argv, "a:c:k:")) != -1) {
+		switch (ch) {
+		case 'a':
+			client_ca = optarg;
+			break;
+		case 'c':
+			cert = optarg;
+			break;
+		case 'k':
+			key = optarg;
+			break;
+		default:
+			usage();
+		}
+	}
+	argc -= optind;
+	argv += optind;
+
+	if (argc != 1)
+		usage();
+
+	signal(SIGPIPE, SIG_IGN);
+
+	git_libgit2_init();
+
+	SSL_CTX *tls = NULL;
+	int sock;
+	if (strncmp(argv[0], TLS_SCHEME, strlen(TLS_SCHEME)) == 0) {
+		if (cert == NULL || key == NULL || client_ca == NULL)
+			usage();
+		tls = tls_server_ctx(cert, key, client_ca);
+		sock = tls_listen(argv[0] + strlen(TLS_SCHEME));
+	} else {
+		sock = unix_listen(argv[0]);
+	}
+
 	pthread_attr_t pthread_attr;
 
 	if (pthread_attr_init(&pthread_attr) != 0)
@@ -66,6 +104,16 @@ int main(int argc, char **argv)
 			continue;
 		}
 
+		if (tls != NULL) {
+			int plain = tls_wrap(tls, *conn, &pthread_attr);
+			if (plain == -1) {
+				close(*conn);
+				free(conn);
+				continue;
+			}
+			*conn = plain;
+		}
+
 		pthread_t thread;
 
 		if (pthread_create(&thread, &pthread_attr, session, (void *)conn) != 0) {
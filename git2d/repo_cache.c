@@ -0,0 +1,90 @@
+/*-
+ * SPDX-License-Identifier: AGPL-3.0-only
+ * SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+ */
+
+#include "x.h"
+
+/*
+ * Repositories are opened once and their handles reused, so that libgit2's
+ * per-repository object and ref caches survive across requests. A handle
+ * is only ever used by one request at a time: requests take an idle handle
+ * out of the cache and put it back when done, and concurrent requests for
+ * the same repository simply open more handles.
+ *
+ * Idle handles are kept most-recently-used first; beyond
+ * REPO_CACHE_MAX_IDLE the least recently used are freed.
+ */
+
+struct repo_cache_entry {
+	char *path;
+	git_repository *repo;
+	struct repo_cache_entry *next;
+};
+
+static pthread_mutex_t repo_cache_lock = PTHREAD_MUTEX_INITIALIZER;
+static struct repo_cache_entry *repo_cache_idle = NULL;
+static size_t repo_cache_count = 0;
+
+int repo_cache_get(git_repository **out, const char *path)
+{
+	struct repo_cache_entry **link, *entry = NULL;
+
+	pthread_mutex_lock(&repo_cache_lock);
+	for (link = &repo_cache_idle; *link != NULL; link = &(*link)->next) {
+		if (strcmp((*link)->path, path) == 0) {
+			entry = *link;
+			*link = entry->next;
+			repo_cache_count--;
+			break;
+		}
+	}
+	pthread_mutex_unlock(&repo_cache_lock);
+
+	if (entry != NULL) {
+		*out = entry->repo;
+		free(entry->path);
+		free(entry);
+		return 0;
+	}
+
+	return git_repository_open_ext(out, path, GIT_REPOSITORY_OPEN_NO_SEARCH | GIT_REPOSITORY_OPEN_BARE | GIT_REPOSITORY_OPEN_NO_DOTGIT, NULL);
+}
+
+void repo_cache_put(const char *path, git_repository *repo)
+{
+	struct repo_cache_entry *entry = malloc(sizeof(*entry));
+	if (entry == NULL) {
+		git_repository_free(repo);
+		return;
+	}
+	entry->path = strdup(path);
+	if (entry->path == NULL) {
+		free(entry);
+		git_repository_free(repo);
+		return;
+	}
+	entry->repo = repo;
+
+	struct repo_cache_entry *evicted = NULL;
+
+	pthread_mutex_lock(&repo_cache_lock);
+	entry->next = repo_cache_idle;
+	repo_cache_idle = entry;
+	repo_cache_count++;
+	if (repo_cache_count > REPO_CACHE_MAX_IDLE) {
+		struct repo_cache_entry **link = &repo_cache_idle;
+		while ((*link)->next != NULL)
+			link = &(*link)->next;
+		evicted = *link;
+		*link = NULL;
+		repo_cache_count--;
+	}
+	pthread_mutex_unlock(&repo_cache_lock);
+
+	if (evicted != NULL) {
+		git_repository_free(evicted->repo);
+		free(evicted->path);
+		free(evicted);
+	}
+}
@@ -8,8 +8,22 @@
 bare_error conn_read(void *buffer, void *dst, uint64_t sz)
 {
 	conn_io_t *io = buffer;
-	ssize_t rsz = read(io->fd, dst, sz);
-	return (rsz == (ssize_t) sz) ? BARE_ERROR_NONE : BARE_ERROR_READ_FAILED;
+	uint8_t *data = dst;
+	uint64_t total = 0;
+
+	while (total < sz) {
+		ssize_t rsz = read(io->fd, data + total, sz - total);
+		if (rsz < 0) {
+			if (errno == EINTR)
+				continue;
+			return BARE_ERROR_READ_FAILED;
+		}
+		if (rsz == 0)
+			break;
+		total += rsz;
+	}
+
+	return (total == sz) ? BARE_ERROR_NONE : BARE_ERROR_READ_FAILED;
 }
 
 bare_error conn_write(void *buffer, const void *src, uint64_t sz)
@@ -32,3 +46,101 @@ bare_error conn_write(void *buffer, const void *src, uint64_t sz)
 
 	return (total == sz) ? BARE_ERROR_NONE : BARE_ERROR_WRITE_FAILED;
 }
+
+bare_error mem_read(void *buffer, void *dst, uint64_t sz)
+{
+	mem_reader_t *mem = buffer;
+	if (sz > mem->len - mem->pos)
+		return BARE_ERROR_READ_FAILED;
+	memcpy(dst, mem->data + mem->pos, sz);
+	mem->pos += sz;
+	return BARE_ERROR_NONE;
+}
+
+/*
+ * Send one frame. Frames from concurrent requests are interleaved on the
+ * connection, so each is written whole under the connection's write lock.
+ */
+static bare_error frame_send(conn_t * conn, uint64_t id, uint64_t kind, const uint8_t * data, uint64_t sz)
+{
+	conn_io_t io = {.fd = conn->fd };
+	struct bare_writer writer = {
+		.buffer = &io,
+		.write = conn_write,
+	};
+	bare_error err;
+
+	pthread_mutex_lock(&conn->write_lock);
+	err = bare_put_uint(&writer, id);
+	if (err == BARE_ERROR_NONE)
+		err = bare_put_uint(&writer, kind);
+	if (err == BARE_ERROR_NONE)
+		err = bare_put_data(&writer, data, sz);
+	pthread_mutex_unlock(&conn->write_lock);
+
+	return err;
+}
+
+/*
+ * Wait until the client will accept sz more bytes of the request's reply
+ * and take them from its window. Returns false if the request has been
+ * cancelled instead.
+ */
+static bool frame_reserve(request_t * req, uint64_t sz)
+{
+	conn_t *conn = req->conn;
+
+	pthread_mutex_lock(&conn->lock);
+	while (req->window < sz && !req->cancelled)
+		pthread_cond_wait(&req->credit, &conn->lock);
+	bool ok = !req->cancelled;
+	if (ok)
+		req->window -= sz;
+	pthread_mutex_unlock(&conn->lock);
+
+	return ok;
+}
+
+static bare_error frame_flush(frame_writer_t * fw)
+{
+	if (fw->failed)
+		return BARE_ERROR_WRITE_FAILED;
+	if (!frame_reserve(fw->req, fw->len)) {
+		fw->failed = true;
+		return BARE_ERROR_WRITE_FAILED;
+	}
+	if (fw->len == 0)
+		return BARE_ERROR_NONE;
+	if (frame_send(fw->req->conn, fw->req->id, FRAME_DATA, fw->buf, fw->len) != BARE_ERROR_NONE)
+		fw->failed = true;
+	fw->len = 0;
+	return fw->failed ? BARE_ERROR_WRITE_FAILED : BARE_ERROR_NONE;
+}
+
+bare_error frame_write(void *buffer, const void *src, uint64_t sz)
+{
+	frame_writer_t *fw = buffer;
+	const uint8_t *data = src;
+
+	while (sz > 0) {
+		if (fw->failed)
+			return BARE_ERROR_WRITE_FAILED;
+		uint64_t n = FRAME_BUFFER_SIZE - fw->len;
+		if (n > sz)
+			n = sz;
+		memcpy(fw->buf + fw->len, data, n);
+		fw->len += n;
+		data += n;
+		sz -= n;
+		if (fw->len == FRAME_BUFFER_SIZE && frame_flush(fw) != BARE_ERROR_NONE)
+			return BARE_ERROR_WRITE_FAILED;
+	}
+
+	return BARE_ERROR_NONE;
+}
+
+bare_error frame_end(frame_writer_t * fw)
+{
+	frame_flush(fw);
+	return frame_send(fw->req->conn, fw->req->id, FRAME_END, NULL, 0);
+}
@@ -5,139 +5,376 @@
 
 #include "x.h"
 
-void *session(void *_conn)
+/* The request being handled by the current thread */
+static pthread_key_t current_request;
+static pthread_once_t current_request_once = PTHREAD_ONCE_INIT;
+
+static void current_request_init(void)
 {
-	int conn = *(int *)_conn;
-	free((int *)_conn);
+	if (pthread_key_create(&current_request, NULL) != 0)
+		err(1, "pthread_key_create");
+}
 
-	int err;
+int request_cancelled(void)
+{
+	request_t *req = pthread_getspecific(current_request);
+	if (req == NULL)
+		return 0;
 
-	conn_io_t io = {.fd = conn };
-	struct bare_reader reader = {
-		.buffer = &io,
-		.read = conn_read,
+	pthread_mutex_lock(&req->conn->lock);
+	int cancelled = req->cancelled;
+	pthread_mutex_unlock(&req->conn->lock);
+
+	return cancelled;
+}
+
+static void conn_unref(conn_t * conn)
+{
+	pthread_mutex_lock(&conn->lock);
+	int refs = --conn->refs;
+	pthread_mutex_unlock(&conn->lock);
+
+	if (refs == 0) {
+		close(conn->fd);
+		pthread_mutex_destroy(&conn->write_lock);
+		pthread_mutex_destroy(&conn->lock);
+		free(conn);
+	}
+}
+
+static void conn_cancel(conn_t * conn, uint64_t id)
+{
+	pthread_mutex_lock(&conn->lock);
+	for (request_t * req = conn->requests; req != NULL; req = req->next) {
+		if (req->id == id) {
+			req->cancelled = 1;
+			pthread_cond_broadcast(&req->credit);
+		}
+	}
+	pthread_mutex_unlock(&conn->lock);
+}
+
+/* Cancels every request once the client has hung up */
+static void conn_cancel_all(conn_t * conn)
+{
+	pthread_mutex_lock(&conn->lock);
+	for (request_t * req = conn->requests; req != NULL; req = req->next) {
+		req->cancelled = 1;
+		pthread_cond_broadcast(&req->credit);
+	}
+	pthread_mutex_unlock(&conn->lock);
+}
+
+static void conn_credit(conn_t * conn, uint64_t id, const uint8_t * payload, uint64_t len)
+{
+	mem_reader_t mem = {
+		.data = payload,
+		.len = len,
+		.pos = 0,
 	};
-	struct bare_writer writer = {
-		.buffer = &io,
-		.write = conn_write,
+	struct bare_reader reader = {
+		.buffer = &mem,
+		.read = mem_read,
 	};
+	uint64_t credit;
+	if (bare_get_uint(&reader, &credit) != BARE_ERROR_NONE)
+		return;
+
+	pthread_mutex_lock(&conn->lock);
+	for (request_t * req = conn->requests; req != NULL; req = req->next) {
+		if (req->id == id && req->window <= UINT64_MAX - credit) {
+			req->window += credit;
+			pthread_cond_broadcast(&req->credit);
+		}
+	}
+	pthread_mutex_unlock(&conn->lock);
+}
+
+static void handle_request(struct bare_reader *reader, struct bare_writer *writer)
+{
+	int err;
+
+	/* Don't report errors left over from this thread's previous request */
+	git_error_clear();
 
 	/* Repo path */
 	char path[4096] = { 0 };
-	err = bare_get_data(&reader, (uint8_t *) path, sizeof(path) - 1);
+	err = bare_get_data(reader, (uint8_t *) path, sizeof(path) - 1);
 	if (err != BARE_ERROR_NONE) {
-		goto close;
+		write_error(writer, 2, NULL);
+		return;
 	}
 	path[sizeof(path) - 1] = '\0';
 	fprintf(stderr, "session: path='%s'\n", path);
 
-	/* Command */
+	/* Command, the union tag of the request in git2c/protocol.go */
 	uint64_t cmd = 0;
-	err = bare_get_uint(&reader, &cmd);
+	err = bare_get_uint(reader, &cmd);
 	if (err != BARE_ERROR_NONE) {
-		bare_put_uint(&writer, 2);
-		goto close;
+		write_error(writer, 2, NULL);
+		return;
 	}
 	fprintf(stderr, "session: cmd=%llu\n", (unsigned long long)cmd);
 
+	/* Health check; does not touch any repo */
+	if (cmd == 18) {
+		bare_put_uint(writer, 0);
+		return;
+	}
+
 	/* Repo init does not require opening an existing repo so let's just do it here */
 	if (cmd == 15) {
 		fprintf(stderr, "session: handling init for '%s'\n", path);
-		if (cmd_init_repo(path, &reader, &writer) != 0) {
-		}
-		goto close;
+		cmd_init_repo(path, reader, writer);
+		return;
 	}
 
 	git_repository *repo = NULL;
-	err = git_repository_open_ext(&repo, path, GIT_REPOSITORY_OPEN_NO_SEARCH | GIT_REPOSITORY_OPEN_BARE | GIT_REPOSITORY_OPEN_NO_DOTGIT, NULL);
+	err = repo_cache_get(&repo, path);
 	if (err != 0) {
-		bare_put_uint(&writer, 1);
-		goto close;
+		write_error(writer, 1, path);
+		return;
 	}
 	switch (cmd) {
 	case 1:
-		err = cmd_index(repo, &writer);
-		if (err != 0)
-			goto free_repo;
+		err = cmd_index(repo, writer);
 		break;
 	case 2:
-		err = cmd_treeraw(repo, &reader, &writer);
-		if (err != 0)
-			goto free_repo;
+		err = cmd_treeraw(repo, reader, writer);
 		break;
 	case 3:
-		err = cmd_resolve_ref(repo, &reader, &writer);
-		if (err != 0)
-			goto free_repo;
+		err = cmd_resolve_ref(repo, reader, writer);
 		break;
 	case 4:
-		err = cmd_list_branches(repo, &writer);
-		if (err != 0)
-			goto free_repo;
+		err = cmd_list_branches(repo, writer);
 		break;
 	case 5:
-		err = cmd_format_patch(repo, &reader, &writer);
-		if (err != 0)
-			goto free_repo;
+		err = cmd_format_patch(repo, reader, writer);
 		break;
-case 6:
-	err = cmd_commit_info(repo, &reader, &writer);
-		if (err != 0)
-			goto free_repo;
+	case 6:
+		err = cmd_commit_info(repo, reader, writer);
 		break;
 	case 7:
-		err = cmd_merge_base(repo, &reader, &writer);
-		if (err != 0)
-			goto free_repo;
+		err = cmd_merge_base(repo, reader, writer);
 		break;
 	case 8:
-		err = cmd_log(repo, &reader, &writer);
-		if (err != 0)
-			goto free_repo;
+		err = cmd_log(repo, reader, writer);
 		break;
 	case 9:
-		err = cmd_tree_list_by_oid(repo, &reader, &writer);
-		if (err != 0)
-			goto free_repo;
+		err = cmd_tree_list_by_oid(repo, reader, writer);
 		break;
 	case 10:
-		err = cmd_write_tree(repo, &reader, &writer);
-		if (err != 0)
-			goto free_repo;
+		err = cmd_write_tree(repo, reader, writer);
 		break;
 	case 11:
-		err = cmd_blob_write(repo, &reader, &writer);
-		if (err != 0)
-			goto free_repo;
+		err = cmd_blob_write(repo, reader, writer);
 		break;
 	case 12:
-		err = cmd_commit_tree_oid(repo, &reader, &writer);
-		if (err != 0)
-			goto free_repo;
+		err = cmd_commit_tree_oid(repo, reader, writer);
 		break;
 	case 13:
-		err = cmd_commit_create(repo, &reader, &writer);
-		if (err != 0)
-			goto free_repo;
+		err = cmd_commit_create(repo, reader, writer);
 		break;
 	case 14:
-		err = cmd_update_ref(repo, &reader, &writer);
-		if (err != 0)
-			goto free_repo;
+		err = cmd_update_ref(repo, reader, writer);
+		break;
+	case 16:
+		err = cmd_diff_trees(repo, reader, writer);
+		break;
+	case 17:
+		err = cmd_log_range(repo, reader, writer);
+		break;
+	case 19:
+		err = cmd_pack(repo, reader, writer);
+		break;
+	case 20:
+		err = cmd_import_chunk(repo, reader, writer);
+		break;
+	case 21:
+		err = cmd_import_finish(repo, reader, writer);
+		break;
+	case 22:
+		err = cmd_list_tags(repo, writer);
 		break;
-	case 0:
-		bare_put_uint(&writer, 3);
-		goto free_repo;
 	default:
-		bare_put_uint(&writer, 3);
-		goto free_repo;
+		write_error(writer, 3, NULL);
+		err = -1;
+		break;
 	}
+	if (err != 0)
+		fprintf(stderr, "session: cmd=%llu failed\n", (unsigned long long)cmd);
 
- free_repo:
-	git_repository_free(repo);
+	repo_cache_put(path, repo);
+}
+
+static void *request_worker(void *_req)
+{
+	request_t *req = _req;
+	conn_t *conn = req->conn;
+
+	pthread_setspecific(current_request, req);
+
+	mem_reader_t mem = {
+		.data = req->payload,
+		.len = req->len,
+		.pos = 0,
+	};
+	struct bare_reader reader = {
+		.buffer = &mem,
+		.read = mem_read,
+	};
+	frame_writer_t fw = {
+		.req = req,
+		.failed = false,
+		.len = 0,
+	};
+	struct bare_writer writer = {
+		.buffer = &fw,
+		.write = frame_write,
+	};
+
+	/*
+	 * A failed command just ends its reply early; the client sees a
+	 * truncated reply but the connection stays usable.
+	 */
+	handle_request(&reader, &writer);
+	frame_end(&fw);
+
+	pthread_setspecific(current_request, NULL);
+
+	pthread_mutex_lock(&conn->lock);
+	for (request_t ** link = &conn->requests; *link != NULL; link = &(*link)->next) {
+		if (*link == req) {
+			*link = req->next;
+			break;
+		}
+	}
+	pthread_mutex_unlock(&conn->lock);
+
+	conn_unref(conn);
+	pthread_cond_destroy(&req->credit);
+	free(req->payload);
+	free(req);
+
+	return NULL;
+}
+
+/*
+ * Each connection has one thread reading request frames, which hands every
+ * request to a thread of its own so that a slow command, or a reply that
+ * the client is slow to read, does not hold up the others sharing the
+ * connection. The connection is closed once the
+ * client has hung up and all of its requests have finished.
+ */
+void *session(void *_conn)
+{
+	int fd = *(int *)_conn;
+	free((int *)_conn);
+
+	conn_t *conn = malloc(sizeof(*conn));
+	if (conn == NULL) {
+		close(fd);
+		return NULL;
+	}
+	conn->fd = fd;
+	conn->refs = 1;
+	conn->requests = NULL;
+	pthread_mutex_init(&conn->write_lock, NULL);
+	pthread_mutex_init(&conn->lock, NULL);
+
+	pthread_once(&current_request_once, current_request_init);
+
+	pthread_attr_t attr;
+	pthread_attr_init(&attr);
+	pthread_attr_setdetachstate(&attr, PTHREAD_CREATE_DETACHED);
+
+	conn_io_t io = {.fd = fd };
+	struct bare_reader reader = {
+		.buffer = &io,
+		.read = conn_read,
+	};
+	struct bare_writer writer = {
+		.buffer = &io,
+		.write = conn_write,
+	};
+
+	uint64_t version = 0;
+	if (bare_get_uint(&reader, &version) != BARE_ERROR_NONE)
+		goto done;
+	if (bare_put_uint(&writer, PROTOCOL_VERSION) != BARE_ERROR_NONE)
+		goto done;
+	if (version != PROTOCOL_VERSION) {
+		fprintf(stderr, "session: client speaks protocol version %llu, expected %d\n", (unsigned long long)version, PROTOCOL_VERSION);
+		goto done;
+	}
+
+	for (;;) {
+		uint64_t id, kind, len;
+		if (bare_get_uint(&reader, &id) != BARE_ERROR_NONE)
+			break;
+		if (bare_get_uint(&reader, &kind) != BARE_ERROR_NONE)
+			break;
+		if (bare_get_uint(&reader, &len) != BARE_ERROR_NONE)
+			break;
+		if (len > REQUEST_MAX_SIZE) {
+			fprintf(stderr, "session: request of %llu bytes is too large\n", (unsigned long long)len);
+			break;
+		}
+
+		uint8_t *payload = malloc(len ? len : 1);
+		if (payload == NULL)
+			break;
+		if (conn_read(&io, payload, len) != BARE_ERROR_NONE) {
+			free(payload);
+			break;
+		}
+
+		if (kind == FRAME_CANCEL) {
+			free(payload);
+			conn_cancel(conn, id);
+			continue;
+		}
+		if (kind == FRAME_CREDIT) {
+			conn_credit(conn, id, payload, len);
+			free(payload);
+			continue;
+		}
+		if (kind != FRAME_REQUEST) {
+			free(payload);
+			continue;
+		}
+
+		request_t *req = malloc(sizeof(*req));
+		if (req == NULL) {
+			free(payload);
+			break;
+		}
+		req->conn = conn;
+		req->id = id;
+		req->payload = payload;
+		req->len = len;
+		req->cancelled = 0;
+		req->window = REPLY_WINDOW;
+		pthread_cond_init(&req->credit, NULL);
+
+		pthread_mutex_lock(&conn->lock);
+		conn->refs++;
+		req->next = conn->requests;
+		conn->requests = req;
+		pthread_mutex_unlock(&conn->lock);
+
+		pthread_t thread;
+		if (pthread_create(&thread, &attr, request_worker, req) != 0) {
+			warn("pthread_create");
+			request_worker(req);
+		}
+	}
 
- close:
-	close(conn);
+ done:
+	pthread_attr_destroy(&attr);
+	/* Nobody is left to read replies or send credit for them */
+	conn_cancel_all(conn);
+	conn_unref(conn);
 
 	return NULL;
 }
@@ -0,0 +1,259 @@
+/*-
+ * SPDX-License-Identifier: AGPL-3.0-only
+ * SPDX-FileCopyrightText: Copyright (c) 2025 Runxi Yu <https://runxiyu.org>
+ */
+
+#include "x.h"
+
+#include <fcntl.h>
+#include <netdb.h>
+#include <poll.h>
+
+/*
+ * A TLS connection is relayed by a thread of its own, which owns the SSL
+ * object and shuttles plaintext to one end of a socketpair. session()
+ * handles the other end exactly like a UNIX domain socket connection; this
+ * keeps OpenSSL away from its reader thread and request workers, which
+ * would otherwise use one SSL object concurrently.
+ */
+typedef struct {
+	SSL_CTX *ctx;
+	int net;		/* TCP connection to the client */
+	int plain;		/* our end of the socketpair */
+} tls_conn_t;
+
+static void tls_errx(const char *what)
+{
+	ERR_print_errors_fp(stderr);
+	errx(1, "%s", what);
+}
+
+SSL_CTX *tls_server_ctx(const char *cert, const char *key, const char *client_ca)
+{
+	SSL_CTX *ctx = SSL_CTX_new(TLS_server_method());
+	if (ctx == NULL)
+		tls_errx("SSL_CTX_new");
+
+	if (SSL_CTX_set_min_proto_version(ctx, TLS1_3_VERSION) != 1)
+		tls_errx("SSL_CTX_set_min_proto_version");
+	if (SSL_CTX_use_certificate_chain_file(ctx, cert) != 1)
+		tls_errx(cert);
+	if (SSL_CTX_use_PrivateKey_file(ctx, key, SSL_FILETYPE_PEM) != 1)
+		tls_errx(key);
+	if (SSL_CTX_check_private_key(ctx) != 1)
+		tls_errx("certificate and private key do not match");
+
+	/* Only forged instances holding a certificate from client_ca may connect */
+	if (SSL_CTX_load_verify_locations(ctx, client_ca, NULL) != 1)
+		tls_errx(client_ca);
+	STACK_OF(X509_NAME) * names = SSL_load_client_CA_file(client_ca);
+	if (names == NULL)
+		tls_errx(client_ca);
+	SSL_CTX_set_client_CA_list(ctx, names);
+	SSL_CTX_set_verify(ctx, SSL_VERIFY_PEER | SSL_VERIFY_FAIL_IF_NO_PEER_CERT, NULL);
+
+	return ctx;
+}
+
+int tls_listen(const char *address)
+{
+	const char *colon = strrchr(address, ':');
+	if (colon == NULL)
+		errx(1, "%s: missing port", address);
+
+	char host[256];
+	const char *start = address;
+	size_t len = colon - address;
+	if (len >= 2 && address[0] == '[' && address[len - 1] == ']') {
+		start++;
+		len -= 2;
+	}
+	if (len >= sizeof(host))
+		errx(1, "%s: host too long", address);
+	memcpy(host, start, len);
+	host[len] = '\0';
+
+	struct addrinfo hints;
+	memset(&hints, 0, sizeof(hints));
+	hints.ai_family = AF_UNSPEC;
+	hints.ai_socktype = SOCK_STREAM;
+	hints.ai_flags = AI_PASSIVE;
+
+	struct addrinfo *res;
+	int gai = getaddrinfo(len > 0 ? host : NULL, colon + 1, &hints, &res);
+	if (gai != 0)
+		errx(1, "%s: %s", address, gai_strerror(gai));
+
+	int sock = -1;
+	for (struct addrinfo * ai = res; ai != NULL; ai = ai->ai_next) {
+		sock = socket(ai->ai_family, ai->ai_socktype | SOCK_CLOEXEC, ai->ai_protocol);
+		if (sock < 0)
+			continue;
+		int one = 1;
+		setsockopt(sock, SOL_SOCKET, SO_REUSEADDR, &one, sizeof(one));
+		if (bind(sock, ai->ai_addr, ai->ai_addrlen) == 0 && listen(sock, 128) == 0)
+			break;
+		close(sock);
+		sock = -1;
+	}
+	freeaddrinfo(res);
+	if (sock < 0)
+		err(1, "%s", address);
+
+	return sock;
+}
+
+/*
+ * Notes in events what the last SSL_read or SSL_write, which returned ret,
+ * is waiting for. Returns 0 if it failed or the client closed the
+ * connection instead.
+ */
+static int tls_want(SSL * ssl, int ret, short *events)
+{
+	switch (SSL_get_error(ssl, ret)) {
+	case SSL_ERROR_WANT_READ:
+		*events |= POLLIN;
+		return 1;
+	case SSL_ERROR_WANT_WRITE:
+		*events |= POLLOUT;
+		return 1;
+	default:
+		return 0;
+	}
+}
+
+static void tls_relay_loop(SSL * ssl, int net, int plain)
+{
+	uint8_t in[TLS_RELAY_BUFFER_SIZE];	/* from the client to session() */
+	uint8_t out[TLS_RELAY_BUFFER_SIZE];	/* from session() to the client */
+	size_t in_len = 0, in_off = 0, out_len = 0;
+
+	for (;;) {
+		short net_events = 0, plain_events = 0;
+		int progress = 0;
+
+		if (in_off == in_len) {
+			int n = SSL_read(ssl, in, sizeof(in));
+			if (n > 0) {
+				in_off = 0;
+				in_len = n;
+				progress = 1;
+			} else if (!tls_want(ssl, n, &net_events)) {
+				return;
+			}
+		}
+		if (in_off < in_len) {
+			ssize_t n = write(plain, in + in_off, in_len - in_off);
+			if (n >= 0) {
+				in_off += n;
+				progress = 1;
+			} else if (errno == EAGAIN || errno == EWOULDBLOCK) {
+				plain_events |= POLLOUT;
+			} else {
+				return;
+			}
+		}
+
+		if (out_len == 0) {
+			ssize_t n = read(plain, out, sizeof(out));
+			if (n > 0) {
+				out_len = n;
+				progress = 1;
+			} else if (n < 0 && (errno == EAGAIN || errno == EWOULDBLOCK)) {
+				plain_events |= POLLIN;
+			} else {
+				return;	/* session() closed the connection */
+			}
+		}
+		if (out_len > 0) {
+			/* Retried with the same arguments until it completes */
+			int n = SSL_write(ssl, out, out_len);
+			if (n > 0) {
+				out_len = 0;
+				progress = 1;
+			} else if (!tls_want(ssl, n, &net_events)) {
+				return;
+			}
+		}
+
+		if (progress)
+			continue;
+
+		struct pollfd fds[2] = {
+			{.fd = net_events ? net : -1,.events = net_events },
+			{.fd = plain_events ? plain : -1,.events = plain_events },
+		};
+		if (poll(fds, 2, -1) < 0 && errno != EINTR)
+			return;
+	}
+}
+
+static void *tls_relay(void *_tc)
+{
+	tls_conn_t *tc = _tc;
+
+	ERR_clear_error();
+
+	SSL *ssl = SSL_new(tc->ctx);
+	if (ssl == NULL || SSL_set_fd(ssl, tc->net) != 1) {
+		ERR_print_errors_fp(stderr);
+		goto done;
+	}
+
+	/* Don't let clients that never finish the handshake hold a thread */
+	struct timeval timeout = {.tv_sec = TLS_HANDSHAKE_TIMEOUT };
+	setsockopt(tc->net, SOL_SOCKET, SO_RCVTIMEO, &timeout, sizeof(timeout));
+	setsockopt(tc->net, SOL_SOCKET, SO_SNDTIMEO, &timeout, sizeof(timeout));
+
+	if (SSL_accept(ssl) != 1) {
+		fprintf(stderr, "tls: handshake failed\n");
+		ERR_print_errors_fp(stderr);
+		goto done;
+	}
+
+	if (fcntl(tc->net, F_SETFL, fcntl(tc->net, F_GETFL) | O_NONBLOCK) < 0 || fcntl(tc->plain, F_SETFL, fcntl(tc->plain, F_GETFL) | O_NONBLOCK) < 0) {
+		warn("fcntl");
+		goto done;
+	}
+
+	tls_relay_loop(ssl, tc->net, tc->plain);
+	SSL_shutdown(ssl);
+
+ done:
+	SSL_free(ssl);
+	close(tc->net);
+	close(tc->plain);
+	free(tc);
+	return NULL;
+}
+
+int tls_wrap(SSL_CTX * ctx, int net, pthread_attr_t * attr)
+{
+	int pair[2];
+	if (socketpair(AF_UNIX, SOCK_STREAM | SOCK_CLOEXEC, 0, pair) != 0) {
+		warn("socketpair");
+		return -1;
+	}
+
+	tls_conn_t *tc = malloc(sizeof(*tc));
+	if (tc == NULL) {
+		warn("malloc");
+		goto fail;
+	}
+	tc->ctx = ctx;
+	tc->net = net;
+	tc->plain = pair[0];
+
+	pthread_t thread;
+	if (pthread_create(&thread, attr, tls_relay, tc) != 0) {
+		warn("pthread_create");
+		free(tc);
+		goto fail;
+	}
+	return pair[1];
+
+ fail:
+	close(pair[0]);
+	close(pair[1]);
+	return -1;
+}
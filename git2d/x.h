@@ -10,6 +10,8 @@
 #include <errno.h>
 #include <git2.h>
 #include <git2/buffer.h>
+#include <openssl/err.h>
+#include <openssl/ssl.h>
 #include <pthread.h>
 #include <signal.h>
 #include <sys/socket.h>
@@ -30,16 +32,147 @@ typedef struct {
 bare_error conn_read(void *buffer, void *dst, uint64_t sz);
 bare_error conn_write(void *buffer, const void *src, uint64_t sz);
 
+/*
+ * Upon connecting, the client sends its protocol version as a uint and the
+ * server answers with its own, closing the connection if they differ. Bump
+ * this with protocolVersion in forged/internal/ipc/git2c/protocol.go
+ * whenever the encoding of any request or reply changes.
+ */
+#define PROTOCOL_VERSION 5
+
+/*
+ * After the handshake, both sides exchange frames of (uint request ID, uint
+ * kind, data payload). The client sends FRAME_REQUEST frames, each
+ * carrying a whole request, and FRAME_CANCEL frames (with an empty payload)
+ * for requests whose replies it no longer wants; replies come back as any
+ * number of FRAME_DATA frames followed by a FRAME_END, and may be
+ * interleaved with those of other requests on the same connection.
+ *
+ * Each reply may have at most REPLY_WINDOW bytes of data frames sent but
+ * not yet read by the client. As the client reads, it sends FRAME_CREDIT
+ * frames, whose payload is a uint count of further bytes it will accept,
+ * so that a reply that isn't being read holds up only its own request.
+ */
+#define FRAME_REQUEST 0
+#define FRAME_CANCEL 1
+#define FRAME_CREDIT 2
+#define FRAME_DATA 0
+#define FRAME_END 1
+
+#define REPLY_WINDOW (1024 * 1024)
+
+/* Reply data is buffered into frames of at most this many bytes */
+#define FRAME_BUFFER_SIZE (64 * 1024)
+
+/* Largest request payload accepted */
+#define REQUEST_MAX_SIZE (256 * 1024 * 1024)
+
+struct request;
+
+typedef struct {
+	int fd;
+	pthread_mutex_t write_lock;
+	pthread_mutex_t lock;	/* protects refs and requests */
+	int refs;
+	struct request *requests;	/* in progress */
+} conn_t;
+
+typedef struct request {
+	conn_t *conn;
+	uint64_t id;
+	uint8_t *payload;
+	uint64_t len;
+	int cancelled;		/* protected by conn->lock */
+	uint64_t window;	/* reply bytes that may be sent, likewise */
+	pthread_cond_t credit;	/* signalled when window grows or on cancel */
+	struct request *next;
+} request_t;
+
+typedef struct {
+	request_t *req;
+	bool failed;
+	uint64_t len;
+	uint8_t buf[FRAME_BUFFER_SIZE];
+} frame_writer_t;
+
+typedef struct {
+	const uint8_t *data;
+	uint64_t len;
+	uint64_t pos;
+} mem_reader_t;
+
+bare_error mem_read(void *buffer, void *dst, uint64_t sz);
+bare_error frame_write(void *buffer, const void *src, uint64_t sz);
+bare_error frame_end(frame_writer_t * fw);
+
+/*
+ * Whether the client has cancelled the request being handled by the
+ * calling thread. Long-running commands check this to stop early; writes
+ * to a cancelled request's reply fail regardless.
+ */
+int request_cancelled(void);
+
+/*
+ * Writes a non-zero status followed by the class and message of the last
+ * libgit2 error on this thread, if any, and the input that caused it, which
+ * may be NULL.
+ */
+void write_error(struct bare_writer *writer, uint64_t status, const char *input);
+
 void *session(void *_conn);
 
+/*
+ * Addresses with this prefix, followed by host:port, are served over TCP
+ * with TLS, requiring client certificates; any other address is a UNIX
+ * domain socket path.
+ */
+#define TLS_SCHEME "tcp+tls://"
+
+/* Seconds allowed for a client to complete the TLS handshake */
+#define TLS_HANDSHAKE_TIMEOUT 10
+
+/* Plaintext is relayed in chunks of at most this many bytes each way */
+#define TLS_RELAY_BUFFER_SIZE (16 * 1024)
+
+SSL_CTX *tls_server_ctx(const char *cert, const char *key, const char *client_ca);
+int tls_listen(const char *address);
+
+/*
+ * Starts relaying the TLS connection net, which it then owns, and returns
+ * the file descriptor to hand to session(), or -1 if that failed and net
+ * is still the caller's.
+ */
+int tls_wrap(SSL_CTX * ctx, int net, pthread_attr_t * attr);
+
+/* Idle repository handles kept open across requests */
+#define REPO_CACHE_MAX_IDLE 64
+
+int repo_cache_get(git_repository ** out, const char *path);
+void repo_cache_put(const char *path, git_repository * repo);
+
 int cmd_index(git_repository * repo, struct bare_writer *writer);
 int cmd_treeraw(git_repository * repo, struct bare_reader *reader, struct bare_writer *writer);
 
 int cmd_resolve_ref(git_repository * repo, struct bare_reader *reader, struct bare_writer *writer);
 int cmd_list_branches(git_repository * repo, struct bare_writer *writer);
+int cmd_list_tags(git_repository * repo, struct bare_writer *writer);
 int cmd_format_patch(git_repository * repo, struct bare_reader *reader, struct bare_writer *writer);
 int cmd_merge_base(git_repository * repo, struct bare_reader *reader, struct bare_writer *writer);
 int cmd_log(git_repository * repo, struct bare_reader *reader, struct bare_writer *writer);
+int cmd_diff_trees(git_repository * repo, struct bare_reader *reader, struct bare_writer *writer);
+int cmd_log_range(git_repository * repo, struct bare_reader *reader, struct bare_writer *writer);
+
+/* Blobs are sent in chunks of at most this many bytes */
+#define BLOB_CHUNK_SIZE (64 * 1024)
+
+/* Flags accepted by commands that produce diffs */
+#define DIFF_FLAG_IGNORE_WHITESPACE 1
+
+void diff_options_from_flags(git_diff_options * opts, uint64_t flags);
+int collect_revwalk(git_revwalk * walk, uint64_t limit, git_oid ** oids_out, size_t *count_out);
+void write_commit_list(git_repository * repo, const git_oid * oids, size_t count, struct bare_writer *writer);
+int prepare_diff_files(git_diff * diff, git_diff_stats ** stats_out);
+int write_diff_files(git_diff * diff, git_diff_stats * stats, struct bare_writer *writer);
 
 int cmd_tree_list_by_oid(git_repository * repo, struct bare_reader *reader, struct bare_writer *writer);
 int cmd_write_tree(git_repository * repo, struct bare_reader *reader, struct bare_writer *writer);
@@ -52,4 +185,14 @@ int cmd_commit_info(git_repository * repo, struct bare_reader *reader, struct ba
 
 int cmd_init_repo(const char *path, struct bare_reader *reader, struct bare_writer *writer);
 
+/* Name of the file in a repository that an imported pack is written to */
+#define IMPORT_PACK_NAME "forge-import.pack"
+
+/* Largest chunk of an imported pack accepted in one request */
+#define IMPORT_CHUNK_MAX_SIZE (16 * 1024 * 1024)
+
+int cmd_pack(git_repository * repo, struct bare_reader *reader, struct bare_writer *writer);
+int cmd_import_chunk(git_repository * repo, struct bare_reader *reader, struct bare_writer *writer);
+int cmd_import_finish(git_repository * repo, struct bare_reader *reader, struct bare_writer *writer);
+
 #endif				// X_H